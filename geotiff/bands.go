@@ -0,0 +1,318 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// MultiRaster holds bands of the same size, such as the red, green, blue and
+// near infrared bands of an image or a stack of derived products.
+type MultiRaster struct {
+	w, h  int
+	Bands []*Raster
+	MinZ  []float32 // smallest value of each band, 9999 when it holds only nodata
+	MaxZ  []float32 // largest value of each band, -9999 when it holds only nodata
+}
+
+func NewMultiRaster(width, height, bands int) *MultiRaster {
+	m := &MultiRaster{
+		w:     width,
+		h:     height,
+		Bands: make([]*Raster, bands),
+		MinZ:  make([]float32, bands),
+		MaxZ:  make([]float32, bands),
+	}
+	for i := range m.Bands {
+		m.Bands[i] = NewRaster(width, height)
+	}
+	return m
+}
+
+func (m *MultiRaster) Width() int {
+	return m.w
+}
+
+func (m *MultiRaster) Height() int {
+	return m.h
+}
+
+func (m *MultiRaster) NumBands() int {
+	return len(m.Bands)
+}
+
+// Band returns band i, counting from 0, or nil if there is no such band
+func (m *MultiRaster) Band(i int) *Raster {
+	if i < 0 || i >= len(m.Bands) {
+		return nil
+	}
+	return m.Bands[i]
+}
+
+// layout describes how the samples of an image are split into strips or tiles
+type layout struct {
+	width, height           int
+	blockWidth, blockHeight int // tile size, or image width and rows per strip
+	across, down            int // blocks in each plane
+	tiled                   bool
+	samplesPerPixel         int
+	planar                  uint
+	offsets, byteCounts     []uint
+	compression, predictor  uint
+	nodata                  float64
+	sample                  *sampleDecoder
+}
+
+// planes is the number of separately stored planes, one per sample for
+// PlanarConfiguration=2
+func (l *layout) planes() int {
+	if l.planar == pcPlanar {
+		return l.samplesPerPixel
+	}
+	return 1
+}
+
+// sameValue returns the value of a tag holding one value per sample, such as
+// BitsPerSample, all samples must have the same value
+func (d *decoder) sameValue(tag int, defaultValue uint) (uint, error) {
+	values, err := d.IntegerArrayValue(tag)
+	if err != nil {
+		if _, ok := err.(TagNotFound); ok {
+			return defaultValue, nil
+		}
+		return 0, err
+	}
+	if len(values) == 0 {
+		return defaultValue, nil
+	}
+	for _, v := range values[1:] {
+		if v != values[0] {
+			return 0, UnsupportedError(fmt.Sprintf("%s differs between samples: %v", TiffTags[tag], values))
+		}
+	}
+	return values[0], nil
+}
+
+func (d *decoder) layout() (*layout, error) {
+	imageWidth, e1 := d.IntegerValue(tImageWidth)
+	imageLength, e2 := d.IntegerValue(tImageLength)
+	samplesPerPixel, e3 := d.IntegerValue(tSamplesPerPixel, uint(1))
+	bitsPerSample, e4 := d.sameValue(tBitsPerSample, 1)
+	sampleFormat, e5 := d.sameValue(tSampleFormat, smplUint)
+	compression, e6 := d.IntegerValue(tCompression, uint(cNone))
+	planar, e7 := d.IntegerValue(tPlanarConfiguration, uint(pcChunky))
+	predictor, e8 := d.IntegerValue(tPredictor, uint(prNone))
+	nodata, e9 := d.noData()
+	if e := checkFailure(e1, e2, e3, e4, e5, e6, e7, e8, e9, checkPredictor(predictor, sampleFormat, bitsPerSample)); e != nil {
+		return nil, e
+	}
+	if planar != pcChunky && planar != pcPlanar {
+		return nil, UnsupportedError(fmt.Sprintf("PlanarConfiguration %v", planar))
+	}
+	if samplesPerPixel == 0 {
+		return nil, GeneralIssue("SamplesPerPixel is 0")
+	}
+	sample, err := newSampleDecoder(d.byteOrder, sampleFormat, bitsPerSample)
+	if err != nil {
+		return nil, err
+	}
+	l := &layout{
+		width:           int(imageWidth),
+		height:          int(imageLength),
+		samplesPerPixel: int(samplesPerPixel),
+		planar:          planar,
+		compression:     compression,
+		predictor:       predictor,
+		nodata:          nodata,
+		sample:          sample,
+	}
+
+	if _, e := d.TagFor(tTileWidth); e == nil {
+		tileWidth, e1 := d.IntegerValue(tTileWidth)
+		tileLength, e2 := d.IntegerValue(tTileLength)
+		offsets, e3 := d.IntegerArrayValue(tTileOffsets)
+		byteCounts, e4 := d.IntegerArrayValue(tTileByteCounts)
+		if e := checkFailure(e1, e2, e3, e4); e != nil {
+			return nil, e
+		}
+		l.tiled = true
+		l.blockWidth, l.blockHeight = int(tileWidth), int(tileLength)
+		l.offsets, l.byteCounts = offsets, byteCounts
+	} else {
+		rowsPerStrip, e1 := d.IntegerValue(tRowsPerStrip, imageLength)
+		offsets, e2 := d.IntegerArrayValue(tStripOffsets)
+		byteCounts, e3 := d.IntegerArrayValue(tStripByteCounts)
+		if e := checkFailure(e1, e2, e3); e != nil {
+			return nil, e
+		}
+		if rowsPerStrip == 0 || rowsPerStrip > imageLength {
+			rowsPerStrip = imageLength
+		}
+		l.blockWidth, l.blockHeight = int(imageWidth), int(rowsPerStrip)
+		l.offsets, l.byteCounts = offsets, byteCounts
+	}
+	if l.blockWidth <= 0 || l.blockHeight <= 0 {
+		return nil, GeneralIssue(fmt.Sprintf("block size %dx%d", l.blockWidth, l.blockHeight))
+	}
+	l.across = (l.width + l.blockWidth - 1) / l.blockWidth
+	l.down = (l.height + l.blockHeight - 1) / l.blockHeight
+	if n := l.across * l.down * l.planes(); len(l.offsets) < n || len(l.byteCounts) < n {
+		return nil, GeneralIssue(fmt.Sprintf("%d offsets and %d byte counts for %d blocks", len(l.offsets), len(l.byteCounts), n))
+	}
+	return l, nil
+}
+
+// readBlock reads, decompresses and unpredicts a strip or tile of w x h pixels
+// with spp samples each. Blocks with no bytes are sparse and return nil.
+func (d *decoder) readBlock(l *layout, index, w, h, spp int) ([]byte, error) {
+	if l.byteCounts[index] == 0 {
+		return nil, nil
+	}
+	p := make([]byte, l.byteCounts[index])
+	if _, err := d.reader.ReadAt(p, int64(l.offsets[index])); err != nil {
+		return nil, err
+	}
+	n := w * h * spp * l.sample.size
+	p, err := d.decompress(p, l.compression, w, h, n)
+	if err != nil {
+		return nil, err
+	}
+	unpredict(p[:n], d.byteOrder, l.predictor, w*spp, spp, l.sample.size)
+	return p, nil
+}
+
+// readBands decodes the given bands, counting from 0, within the pixel window
+// win or the whole image when win is nil. Only the strips or tiles that
+// intersect the window are read. Nodata samples and sparse blocks are set to
+// -9999.
+func (d *decoder) readBands(bands []int, win *image.Rectangle) (*MultiRaster, error) {
+	l, err := d.layout()
+	if err != nil {
+		return nil, err
+	}
+	full := image.Rect(0, 0, l.width, l.height)
+	if win == nil {
+		win = &full
+	} else if !win.In(full) || win.Empty() {
+		return nil, GeneralIssue(fmt.Sprintf("window %v is not within the image %v", *win, full))
+	}
+	if len(bands) == 0 {
+		return nil, GeneralIssue("no bands to read")
+	}
+	for _, b := range bands {
+		if b < 0 || b >= l.samplesPerPixel {
+			return nil, GeneralIssue(fmt.Sprintf("band %d out of range, the image has %d", b, l.samplesPerPixel))
+		}
+	}
+	m := NewMultiRaster(win.Dx(), win.Dy(), len(bands))
+	minZ := make([]float64, len(bands))
+	maxZ := make([]float64, len(bands))
+	for i := range bands {
+		minZ[i], maxZ[i] = math.MaxFloat64, -math.MaxFloat64
+	}
+
+	// chunky blocks hold every sample of a pixel, planar blocks one sample
+	// and each requested band is read from its own plane
+	type target struct{ band, plane, sample int }
+	var targets [][]target
+	spp := 1
+	if l.planar == pcPlanar {
+		for i, b := range bands {
+			targets = append(targets, []target{{i, b, 0}})
+		}
+	} else {
+		spp = l.samplesPerPixel
+		var t []target
+		for i, b := range bands {
+			t = append(t, target{i, 0, b})
+		}
+		targets = append(targets, t)
+	}
+
+	size := l.sample.size
+	for _, plane := range targets {
+		for by := win.Min.Y / l.blockHeight; by <= (win.Max.Y-1)/l.blockHeight; by++ {
+			for bx := win.Min.X / l.blockWidth; bx <= (win.Max.X-1)/l.blockWidth; bx++ {
+				x0, y0 := bx*l.blockWidth, by*l.blockHeight
+				h := l.blockHeight
+				if !l.tiled && y0+h > l.height {
+					h = l.height - y0
+				}
+				index := (plane[0].plane*l.down+by)*l.across + bx
+				p, err := d.readBlock(l, index, l.blockWidth, h, spp)
+				if err != nil {
+					return nil, err
+				}
+				// the part of the block inside the window
+				part := image.Rect(x0, y0, x0+l.blockWidth, y0+h).Intersect(*win)
+				for y := part.Min.Y; y < part.Max.Y; y++ {
+					for x := part.Min.X; x < part.Max.X; x++ {
+						for _, t := range plane {
+							raster := m.Bands[t.band]
+							if p == nil {
+								raster.SetValue(y-win.Min.Y, x-win.Min.X, -9999.0)
+								continue
+							}
+							i := (((y-y0)*l.blockWidth+x-x0)*spp + t.sample) * size
+							v := l.sample.read(p[i : i+size])
+							if l.sample.isNodata(v, l.nodata) {
+								raster.SetValue(y-win.Min.Y, x-win.Min.X, -9999.0)
+								continue
+							}
+							minZ[t.band] = math.Min(minZ[t.band], v)
+							maxZ[t.band] = math.Max(maxZ[t.band], v)
+							raster.SetValue(y-win.Min.Y, x-win.Min.X, float32(v))
+						}
+					}
+				}
+			}
+		}
+	}
+	for i := range bands {
+		if minZ[i] > maxZ[i] {
+			m.MinZ[i], m.MaxZ[i] = 9999.0, -9999.0
+		} else {
+			m.MinZ[i], m.MaxZ[i] = float32(minZ[i]), float32(maxZ[i])
+		}
+	}
+	if d.IsGeotiff() {
+		if crs, err := d.CRS(); err == nil {
+			for _, b := range m.Bands {
+				b.CRS = crs
+			}
+		}
+	}
+	return m, nil
+}
+
+// NumBands is the number of samples per pixel
+func (d *decoder) NumBands() int {
+	n, err := d.IntegerValue(tSamplesPerPixel, uint(1))
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// BandPoints decodes band i, counting from 0, like Points does for single
+// band images.
+func (d *decoder) BandPoints(band int) (*Raster, float32, float32, error) {
+	m, err := d.readBands([]int{band}, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return m.Bands[0], m.MinZ[0], m.MaxZ[0], nil
+}
+
+// Bands decodes every band of the image
+func (d *decoder) Bands() (*MultiRaster, error) {
+	bands := make([]int, d.NumBands())
+	for i := range bands {
+		bands[i] = i
+	}
+	return d.readBands(bands, nil)
+}
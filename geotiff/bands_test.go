@@ -0,0 +1,77 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testBands(w, h, n int) *MultiRaster {
+	m := NewMultiRaster(w, h, n)
+	for b, raster := range m.Bands {
+		for row := 0; row < h; row++ {
+			for col := 0; col < w; col++ {
+				raster.SetValue(row, col, float32(b*1000+row*w+col))
+			}
+		}
+	}
+	m.Bands[1].SetValue(0, 0, -9999.0)
+	return m
+}
+
+func TestBands(t *testing.T) {
+	bounds := &Bounds{MinX: 0, MaxX: 1, MinY: 0, MaxY: 1}
+	options := []*Options{
+		{RowsPerStrip: 5},
+		{RowsPerStrip: 5, PlanarSeparate: true},
+		{Compression: Deflate, Predictor: 2, TileWidth: 16, TileLength: 16},
+		{Compression: LZW, Predictor: 3, TileWidth: 16, TileLength: 16, PlanarSeparate: true},
+		{Compression: LZW, Predictor: 3, RowsPerStrip: 3},
+	}
+	bands := testBands(21, 17, 4)
+
+	for i, opts := range options {
+		f := &memFile{}
+		if err := EncodeBands(f, bands, bounds, opts); err != nil {
+			t.Fatalf("options %d: EncodeBands: %v", i, err)
+		}
+		tif, err := NewDecoder(bytes.NewReader(f.data))
+		if err != nil {
+			t.Fatalf("options %d: NewDecoder: %v", i, err)
+		}
+		if n := tif.NumBands(); n != 4 {
+			t.Fatalf("options %d: %d bands", i, n)
+		}
+		if _, _, _, err := tif.Points(); err == nil {
+			t.Errorf("options %d: Points should refuse a multi-band image", i)
+		}
+		got, err := tif.Bands()
+		if err != nil {
+			t.Fatalf("options %d: Bands: %v", i, err)
+		}
+		for b := range bands.Bands {
+			for j, v := range bands.Band(b).Data {
+				if got.Band(b).Data[j] != v {
+					t.Fatalf("options %d: band %d value %d is %v, expected %v", i, b, j, got.Band(b).Data[j], v)
+				}
+			}
+		}
+		if got.MinZ[1] != 1001 || got.MaxZ[3] != 3000+21*17-1 {
+			t.Errorf("options %d: min/max %v/%v", i, got.MinZ, got.MaxZ)
+		}
+
+		band, minZ, maxZ, err := tif.BandPoints(2)
+		if err != nil {
+			t.Fatalf("options %d: BandPoints: %v", i, err)
+		}
+		if band.ValueAt(16, 20) != 2000+21*17-1 || minZ != 2000 || maxZ != 2000+21*17-1 {
+			t.Errorf("options %d: band 2 ends with %v, min/max %v/%v", i, band.ValueAt(16, 20), minZ, maxZ)
+		}
+		if _, _, _, err := tif.BandPoints(4); err == nil {
+			t.Errorf("options %d: expected an error for band 4", i)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+type bigTiffEntry struct {
+	tag, fieldType uint16
+	count          uint64
+	data           []byte
+}
+
+// buildBigTiff lays out a single strip, uncompressed float32 BigTIFF
+func buildBigTiff(order binary.ByteOrder, w, h int, values []float32) []byte {
+	pixels := make([]byte, 4*len(values))
+	for i, v := range values {
+		order.PutUint32(pixels[4*i:], math.Float32bits(v))
+	}
+	u16 := func(v ...uint16) []byte {
+		b := make([]byte, 2*len(v))
+		for i, e := range v {
+			order.PutUint16(b[2*i:], e)
+		}
+		return b
+	}
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		order.PutUint64(b, v)
+		return b
+	}
+	f64 := func(v ...float64) []byte {
+		b := make([]byte, 8*len(v))
+		for i, e := range v {
+			order.PutUint64(b[8*i:], math.Float64bits(e))
+		}
+		return b
+	}
+	entries := []bigTiffEntry{
+		{tImageWidth, dtShort, 1, u16(uint16(w))},
+		{tImageLength, dtShort, 1, u16(uint16(h))},
+		{tBitsPerSample, dtShort, 1, u16(32)},
+		{tCompression, dtShort, 1, u16(cNone)},
+		{tStripOffsets, dtLong8, 1, nil}, // filled in below
+		{tSamplesPerPixel, dtShort, 1, u16(1)},
+		{tRowsPerStrip, dtShort, 1, u16(uint16(h))},
+		{tStripByteCounts, dtLong8, 1, u64(uint64(len(pixels)))},
+		{tSampleFormat, dtShort, 1, u16(smplFloat)},
+		{tModelPixelScaleTag, dtDouble, 3, f64(0.5, 0.25, 0)},
+		{tModelTiepointTag, dtDouble, 6, f64(0, 0, 0, 10, 20, 0)},
+		{tGeoKeys, dtShort, 8, u16(1, 1, 0, 1, gkGTModelTypeGeoKey, 0, 1, 2)},
+	}
+	ifdStart := uint64(16)
+	dataStart := ifdStart + 8 + uint64(len(entries))*bigIfdLen + 8
+	var extra []byte
+	offsets := make([]uint64, len(entries))
+	for i, e := range entries {
+		if len(e.data) > 8 {
+			offsets[i] = dataStart + uint64(len(extra))
+			extra = append(extra, e.data...)
+		}
+	}
+	entries[4].data = u64(dataStart + uint64(len(extra)))
+
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString(leBigHeader)
+	} else {
+		buf.WriteString(beBigHeader)
+	}
+	buf.Write(u16(8, 0))
+	buf.Write(u64(ifdStart))
+	buf.Write(u64(uint64(len(entries))))
+	for i, e := range entries {
+		buf.Write(u16(e.tag, e.fieldType))
+		buf.Write(u64(e.count))
+		value := make([]byte, 8)
+		if len(e.data) > 8 {
+			order.PutUint64(value, offsets[i])
+		} else {
+			copy(value, e.data)
+		}
+		buf.Write(value)
+	}
+	buf.Write(u64(0))
+	buf.Write(extra)
+	buf.Write(pixels)
+	return buf.Bytes()
+}
+
+func TestBigTiff(t *testing.T) {
+	w, h := 5, 3
+	values := make([]float32, w*h)
+	for i := range values {
+		values[i] = float32(i) * 1.5
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		tif, err := NewDecoder(bytes.NewReader(buildBigTiff(order, w, h, values)))
+		if err != nil {
+			t.Fatalf("%v: NewDecoder: %v", order, err)
+		}
+		b, err := tif.Bounds()
+		if err != nil {
+			t.Fatalf("%v: Bounds: %v", order, err)
+		}
+		if b.MinX != 10 || b.MaxX != 12.5 || b.MaxY != 20 || b.MinY != 19.25 {
+			t.Errorf("%v: bounds %v", order, b)
+		}
+		raster, _, maxZ, err := tif.Points()
+		if err != nil {
+			t.Fatalf("%v: Points: %v", order, err)
+		}
+		for i, v := range values {
+			if raster.Data[i] != v {
+				t.Errorf("%v: value %d is %v, expected %v", order, i, raster.Data[i], v)
+			}
+		}
+		if maxZ != values[len(values)-1] {
+			t.Errorf("%v: maxZ %v", order, maxZ)
+		}
+		if len(tif.Directories()) != 1 {
+			t.Errorf("%v: %d directories", order, len(tif.Directories()))
+		}
+	}
+}
+
+func TestIsTiff(t *testing.T) {
+	signatures := [][]byte{LittleSignature, BigSignature, LittleBigTiffSignature, BigBigTiffSignature, []byte("LASF")}
+	big := []bool{false, false, true, true, false}
+	for i, sig := range signatures {
+		_, isBig, err := isTiff(sig)
+		if (err != nil) != (i == 4) {
+			t.Errorf("isTiff(%v) error %v", sig, err)
+		}
+		if isBig != big[i] {
+			t.Errorf("isTiff(%v) BigTIFF %v, expected %v", sig, isBig, big[i])
+		}
+	}
+}
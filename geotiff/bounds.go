@@ -14,7 +14,8 @@ import (
 	"github.com/golang/geo/s2"
 )
 
-// Bounding box in degrees lat, lng
+// Bounding box in the units of the image's CRS: degrees of longitude (x) and
+// latitude (y) for geographic systems, projected units otherwise
 
 type Bounds struct {
 	MinX    float64
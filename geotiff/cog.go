@@ -0,0 +1,290 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A cloud optimized GeoTIFF is a tiled GeoTIFF laid out for HTTP range
+// requests: every IFD comes before the image data, the full resolution image
+// is followed by overviews of decreasing size and the tiles of the smallest
+// overview are stored first. GDAL records the layout in a "ghost area" of
+// KEY=VALUE lines right after the header, see
+// https://gdal.org/drivers/raster/cog.html
+
+const (
+	cogTileSize      = 512 // default tile size of a COG
+	cogUntiledLimit  = 512 // larger images must be tiled and should have overviews
+	ghostAreaPrefix  = "GDAL_STRUCTURAL_METADATA_SIZE="
+	ghostAreaHeading = len(ghostAreaPrefix) + len("000000 bytes\n")
+	cogMetadata      = "LAYOUT=IFDS_BEFORE_DATA\n" +
+		"BLOCK_ORDER=ROW_MAJOR\n" +
+		"BLOCK_LEADER=SIZE_AS_UINT4\n" +
+		"BLOCK_TRAILER=LAST_4_BYTES_REPEATED\n" +
+		"KNOWN_INCOMPATIBLE_EDITION=NO\n"
+)
+
+// ghostArea formats the structural metadata written after the header
+func ghostArea(metadata string) string {
+	return fmt.Sprintf("%s%06d bytes\n%s", ghostAreaPrefix, len(metadata), metadata)
+}
+
+// blockTrailer repeats the last 4 bytes of a block, readers use it to check
+// that the block was not modified
+func blockTrailer(b []byte) []byte {
+	trailer := make([]byte, 4)
+	if len(b) >= 4 {
+		copy(trailer, b[len(b)-4:])
+	} else {
+		copy(trailer[4-len(b):], b)
+	}
+	return trailer
+}
+
+// halve averages each 2x2 block of pixels of r into the next overview level,
+// nodata pixels are left out of the average
+func halve(r *Raster) *Raster {
+	o := NewRaster((r.w+1)/2, (r.h+1)/2)
+	for row := 0; row < o.h; row++ {
+		for col := 0; col < o.w; col++ {
+			sum, n := 0.0, 0
+			for y := 2 * row; y < 2*row+2 && y < r.h; y++ {
+				for x := 2 * col; x < 2*col+2 && x < r.w; x++ {
+					if v := r.ValueAt(y, x); v != -9999.0 {
+						sum += float64(v)
+						n++
+					}
+				}
+			}
+			if n == 0 {
+				o.SetValue(row, col, -9999.0)
+			} else {
+				o.SetValue(row, col, float32(sum/float64(n)))
+			}
+		}
+	}
+	return o
+}
+
+func halveBands(m *MultiRaster) *MultiRaster {
+	o := &MultiRaster{
+		w:     (m.w + 1) / 2,
+		h:     (m.h + 1) / 2,
+		Bands: make([]*Raster, len(m.Bands)),
+		MinZ:  make([]float32, len(m.Bands)),
+		MaxZ:  make([]float32, len(m.Bands)),
+	}
+	for i, b := range m.Bands {
+		o.Bands[i] = halve(b)
+	}
+	return o
+}
+
+// COGReport is the outcome of ValidateCOG. The file is a valid cloud
+// optimized GeoTIFF when there are no Errors, Warnings point out departures
+// from recommended practice.
+type COGReport struct {
+	Tiled          bool              // the full resolution image and every overview are tiled
+	Overviews      int               // number of overviews of the full resolution image
+	IFDsBeforeData bool              // every IFD is stored before the first image block
+	TilesOrdered   bool              // blocks are row major, smallest overview first
+	Metadata       map[string]string // GDAL ghost area, nil when the file has none
+	Errors         []string
+	Warnings       []string
+}
+
+func (r *COGReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *COGReport) errorf(format string, a ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, a...))
+}
+
+func (r *COGReport) warnf(format string, a ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, a...))
+}
+
+// readGhostArea parses the GDAL structural metadata at offset, it returns nil
+// and a zero length when there is none
+func readGhostArea(f TiffReader, offset int64) (map[string]string, int64, error) {
+	heading := make([]byte, ghostAreaHeading)
+	if _, err := f.ReadAt(heading, offset); err != nil || !bytes.HasPrefix(heading, []byte(ghostAreaPrefix)) {
+		return nil, 0, nil
+	}
+	size, err := strconv.Atoi(string(heading[len(ghostAreaPrefix) : len(ghostAreaPrefix)+6]))
+	if err != nil || size < 0 {
+		return nil, 0, GeneralIssue(fmt.Sprintf("bad ghost area heading %q", heading))
+	}
+	p := make([]byte, size)
+	if _, err := f.ReadAt(p, offset+int64(ghostAreaHeading)); err != nil {
+		return nil, 0, fmt.Errorf("Error reading ghost area %v", err)
+	}
+	metadata := make(map[string]string)
+	for _, line := range strings.Split(string(p), "\n") {
+		if kv := strings.SplitN(strings.TrimSpace(line), "=", 2); len(kv) == 2 {
+			metadata[kv[0]] = kv[1]
+		}
+	}
+	return metadata, int64(ghostAreaHeading + size), nil
+}
+
+// blockOffsets returns the offsets and byte counts of the strips or tiles
+func (d *decoder) blockOffsets() (offsets, byteCounts []uint, tiled bool, err error) {
+	offsetTag, countTag := tStripOffsets, tStripByteCounts
+	if _, e := d.TagFor(tTileWidth); e == nil {
+		offsetTag, countTag, tiled = tTileOffsets, tTileByteCounts, true
+	}
+	offsets, e1 := d.IntegerArrayValue(offsetTag)
+	byteCounts, e2 := d.IntegerArrayValue(countTag)
+	if e := checkFailure(e1, e2); e != nil {
+		return nil, nil, false, e
+	}
+	if len(offsets) != len(byteCounts) {
+		return nil, nil, false, GeneralIssue(fmt.Sprintf("%d offsets and %d byte counts", len(offsets), len(byteCounts)))
+	}
+	return offsets, byteCounts, tiled, nil
+}
+
+// ValidateCOG checks that f is a cloud optimized GeoTIFF, following the rules
+// of GDAL's validate_cloud_optimized_geotiff.py. The error is only set when f
+// cannot be read as a TIFF, layout problems are listed in the report.
+func ValidateCOG(f TiffReader) (*COGReport, error) {
+	d, next, err := readFirstIfd(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.readDirectories(next); err != nil {
+		return nil, err
+	}
+	r := &COGReport{Tiled: true, IFDsBeforeData: true, TilesOrdered: true}
+
+	headerLen := int64(8)
+	if d.bigTiff {
+		headerLen = 16
+	}
+	metadata, ghostLen, err := readGhostArea(f, headerLen)
+	if err != nil {
+		return nil, err
+	}
+	r.Metadata = metadata
+	if metadata != nil && metadata["KNOWN_INCOMPATIBLE_EDITION"] == "YES" {
+		r.errorf("the file was modified after it was written as a COG")
+	}
+	if want := headerLen + ghostLen + (headerLen+ghostLen)&1; d.ifdOffset != want {
+		r.errorf("the first IFD is at byte %d instead of %d, right after the header", d.ifdOffset, want)
+	}
+
+	images := append([]*decoder{d}, d.overviews()...)
+	r.Overviews = len(images) - 1
+	for i, dir := range d.dirs[1:] {
+		if dir.ifdOffset < d.dirs[i].ifdOffset {
+			r.errorf("IFD %d at byte %d comes before IFD %d at byte %d", i+1, dir.ifdOffset, i, d.dirs[i].ifdOffset)
+		}
+		if dir.parent != d {
+			r.warnf("IFD %d is neither an overview nor a mask of the first image", i+1)
+		}
+	}
+
+	lastIfd := int64(0)
+	for _, dir := range d.dirs {
+		if dir.ifdOffset > lastIfd {
+			lastIfd = dir.ifdOffset
+		}
+	}
+	firstBlocks := make([]uint, len(images))
+	prevWidth, prevHeight := uint(0), uint(0)
+	for i, dir := range images {
+		name := "the full resolution image"
+		if i > 0 {
+			name = fmt.Sprintf("overview %d", i)
+		}
+		width, e1 := dir.IntegerValue(tImageWidth)
+		height, e2 := dir.IntegerValue(tImageLength)
+		offsets, byteCounts, tiled, e3 := dir.blockOffsets()
+		if e := checkFailure(e1, e2, e3); e != nil {
+			return nil, fmt.Errorf("Error reading %s: %v", name, e)
+		}
+		if !tiled {
+			r.Tiled = false
+			if i > 0 {
+				r.errorf("%s is not tiled", name)
+			} else if width > cogUntiledLimit || height > cogUntiledLimit {
+				r.errorf("%s is %dx%d but is not tiled", name, width, height)
+			}
+		}
+		if i == 0 && r.Overviews == 0 && (width > cogUntiledLimit || height > cogUntiledLimit) {
+			r.warnf("%s is %dx%d, internal overviews are recommended", name, width, height)
+		}
+		if i > 0 && (width > prevWidth || height > prevHeight) {
+			r.errorf("%s is %dx%d, larger than the previous image at %dx%d", name, width, height, prevWidth, prevHeight)
+		}
+		prevWidth, prevHeight = width, height
+
+		last := uint(0)
+		for j, off := range offsets {
+			if byteCounts[j] == 0 {
+				continue // sparse
+			}
+			if firstBlocks[i] == 0 {
+				firstBlocks[i] = off
+			}
+			if int64(off) < lastIfd && r.IFDsBeforeData {
+				r.IFDsBeforeData = false
+				r.errorf("block %d of %s at byte %d comes before the IFD at byte %d", j, name, off, lastIfd)
+			}
+			if off < last && r.TilesOrdered {
+				r.TilesOrdered = false
+				r.errorf("blocks of %s are not in row major order, block %d is at byte %d", name, j, off)
+			}
+			last = off
+			if metadata != nil {
+				if err := checkLeaderTrailer(r, dir, metadata, name, j, off, byteCounts[j]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	// the smallest overview comes first and the full resolution image last
+	for i := len(images) - 2; i >= 0; i-- {
+		if firstBlocks[i] != 0 && firstBlocks[i+1] != 0 && firstBlocks[i] < firstBlocks[i+1] {
+			r.TilesOrdered = false
+			if i == 0 {
+				r.errorf("the data of the full resolution image should follow the data of its overviews")
+			} else {
+				r.errorf("the data of overview %d should follow the data of overview %d", i, i+1)
+			}
+		}
+	}
+	return r, nil
+}
+
+// checkLeaderTrailer compares the leader and trailer GDAL writes around each
+// block with the block
+func checkLeaderTrailer(r *COGReport, d *decoder, metadata map[string]string, name string, index int, offset, byteCount uint) error {
+	if metadata["BLOCK_LEADER"] == "SIZE_AS_UINT4" && offset >= 4 {
+		leader := make([]byte, 4)
+		if _, err := d.reader.ReadAt(leader, int64(offset)-4); err != nil {
+			return fmt.Errorf("Error reading block leader %v", err)
+		}
+		if n := d.byteOrder.Uint32(leader); uint(n) != byteCount {
+			r.errorf("the leader of block %d of %s holds %d instead of its size %d", index, name, n, byteCount)
+		}
+	}
+	if metadata["BLOCK_TRAILER"] == "LAST_4_BYTES_REPEATED" && byteCount >= 4 {
+		p := make([]byte, 8)
+		if _, err := d.reader.ReadAt(p, int64(offset+byteCount)-4); err != nil {
+			return fmt.Errorf("Error reading block trailer %v", err)
+		}
+		if !bytes.Equal(p[:4], p[4:]) {
+			r.errorf("the trailer of block %d of %s does not repeat its last 4 bytes", index, name)
+		}
+	}
+	return nil
+}
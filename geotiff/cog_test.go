@@ -0,0 +1,127 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCOG(t *testing.T) {
+	raster := testRaster(100, 70)
+	raster.SetValue(1, 1, -9999.0)
+	bounds := &Bounds{MinX: 0, MaxX: 100, MinY: 0, MaxY: 70}
+	f := &memFile{}
+	if err := Encode(f, raster, bounds, &Options{COG: true, TileWidth: 16, TileLength: 16, Compression: Deflate, Predictor: 3}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ValidateCOG(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || len(report.Warnings) != 0 {
+		t.Fatalf("errors %v, warnings %v", report.Errors, report.Warnings)
+	}
+	if !report.Tiled || !report.IFDsBeforeData || !report.TilesOrdered || report.Overviews != 3 {
+		t.Errorf("report %+v", report)
+	}
+	if report.Metadata["LAYOUT"] != "IFDS_BEFORE_DATA" || report.Metadata["BLOCK_LEADER"] != "SIZE_AS_UINT4" {
+		t.Errorf("ghost area %v", report.Metadata)
+	}
+
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, _, err := tif.Points()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range raster.Data {
+		if got.Data[i] != v {
+			t.Fatalf("value %d is %v, expected %v", i, got.Data[i], v)
+		}
+	}
+	dirs := tif.Directories()
+	sizes := [][2]int{{100, 70}, {50, 35}, {25, 18}, {13, 9}}
+	if len(dirs) != len(sizes) {
+		t.Fatalf("%d directories", len(dirs))
+	}
+	for i, dir := range dirs {
+		w, h, err := dir.PixelDimensions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(w) != sizes[i][0] || int(h) != sizes[i][1] || dir.IsOverview() != (i > 0) {
+			t.Errorf("directory %d is %vx%v, overview %v", i, w, h, dir.IsOverview())
+		}
+	}
+	overview, _, _, err := dirs[1].Points()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the nodata pixel is left out of the average
+	avg := func(v ...float32) float32 {
+		var sum float32
+		for _, x := range v {
+			sum += x
+		}
+		return sum / float32(len(v))
+	}
+	if v, want := overview.ValueAt(0, 0), avg(raster.ValueAt(0, 0), raster.ValueAt(0, 1), raster.ValueAt(1, 0)); v != want {
+		t.Errorf("overview starts with %v, expected %v", v, want)
+	}
+	if v, want := overview.ValueAt(3, 49), avg(raster.ValueAt(6, 98), raster.ValueAt(6, 99), raster.ValueAt(7, 98), raster.ValueAt(7, 99)); v != want {
+		t.Errorf("overview value %v, expected %v", v, want)
+	}
+	res, err := tif.Resolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o, err := tif.Overview(4.5 * res); err != nil || o != dirs[2] {
+		t.Errorf("overview for 4.5 times the resolution: %v", err)
+	}
+
+	// edits after writing are caught by the ghost area
+	edited := append([]byte(nil), f.data...)
+	i := bytes.Index(edited, []byte("KNOWN_INCOMPATIBLE_EDITION=NO\n"))
+	copy(edited[i:], "KNOWN_INCOMPATIBLE_EDITION=YES")
+	edited[len(edited)-5] ^= 0xff
+	if report, err := ValidateCOG(bytes.NewReader(edited)); err != nil || len(report.Errors) != 2 {
+		t.Errorf("edited file: %v, %v", report, err)
+	}
+}
+
+func TestValidateCOG(t *testing.T) {
+	raster := testRaster(600, 20)
+	bounds := &Bounds{MinX: 0, MaxX: 600, MinY: 0, MaxY: 20}
+	tests := []struct {
+		opts     *Options
+		valid    bool
+		warnings int
+	}{
+		{&Options{RowsPerStrip: 8}, false, 1},
+		{&Options{TileWidth: 256, TileLength: 256}, true, 1},
+		{&Options{COG: true}, true, 0},
+	}
+	for i, tc := range tests {
+		f := &memFile{}
+		if err := Encode(f, raster, bounds, tc.opts); err != nil {
+			t.Fatalf("test %d: Encode: %v", i, err)
+		}
+		report, err := ValidateCOG(bytes.NewReader(f.data))
+		if err != nil {
+			t.Fatalf("test %d: ValidateCOG: %v", i, err)
+		}
+		if report.Valid() != tc.valid || len(report.Warnings) != tc.warnings || report.Tiled != (i > 0) {
+			t.Errorf("test %d: errors %v, warnings %v", i, report.Errors, report.Warnings)
+		}
+	}
+
+	if _, err := ValidateCOG(strings.NewReader("not a tiff")); err == nil {
+		t.Error("expected an error for a file that is not a TIFF")
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/image/tiff/lzw"
+)
+
+// Block describes the strip or tile handed to a Decompressor
+type Block struct {
+	Tiff      Tiff             // directory the block belongs to, for tags such as JPEGTables
+	ByteOrder binary.ByteOrder // byte order of the file and of the returned samples
+	Width     int              // width of the block in pixels
+	Height    int              // height of the block in pixels
+}
+
+// Decompressor expands one compressed strip or tile into its samples, in the
+// layout and byte order they would have if the file was uncompressed.
+type Decompressor func(data []byte, block *Block) ([]byte, error)
+
+var (
+	decompressorsLock sync.RWMutex
+	decompressors     = map[int]Decompressor{
+		cNone:       noneDecompress,
+		cLZW:        lzwDecompress,
+		cDeflate:    deflateDecompress,
+		cDeflateOld: deflateDecompress,
+		cPackBits:   packBitsDecompress,
+		cJPEG:       jpegDecompress,
+		cZSTD:       zstdDecompress,
+		cLERC:       lercDecompress,
+	}
+	// names of the registered compressions, taking precedence over
+	// CompressionTypes which is not modified once the package is loaded
+	decompressorNames = map[int]string{}
+)
+
+// RegisterDecompressor makes a decompressor available for strips and tiles
+// with the TIFF compression code compression, replacing any previous one.
+// The name is used when describing the Compression tag.
+func RegisterDecompressor(compression int, name string, fn Decompressor) {
+	decompressorsLock.Lock()
+	defer decompressorsLock.Unlock()
+	decompressors[compression] = fn
+	if name != "" {
+		decompressorNames[compression] = name
+	}
+}
+
+// compressionName returns the name of a TIFF compression code, registered or
+// from CompressionTypes
+func compressionName(compression int) (string, bool) {
+	decompressorsLock.RLock()
+	defer decompressorsLock.RUnlock()
+	return compressionNameLocked(compression)
+}
+
+func compressionNameLocked(compression int) (string, bool) {
+	if name, ok := decompressorNames[compression]; ok {
+		return name, true
+	}
+	name, ok := CompressionTypes[compression]
+	return name, ok
+}
+
+// decompressorFor returns the decompressor registered for compression
+func decompressorFor(compression uint) (Decompressor, error) {
+	decompressorsLock.RLock()
+	defer decompressorsLock.RUnlock()
+	if fn, ok := decompressors[int(compression)]; ok {
+		return fn, nil
+	}
+	if name, ok := compressionNameLocked(int(compression)); ok {
+		return nil, UnsupportedError(fmt.Sprintf("compression %v (%s)", compression, name))
+	}
+	return nil, UnsupportedError(fmt.Sprintf("compression %v", compression))
+}
+
+// decompress expands a strip or tile of width x height pixels and checks
+// the result holds at least n bytes
+func (d *decoder) decompress(p []byte, compression uint, width, height, n int) ([]byte, error) {
+	fn, err := decompressorFor(compression)
+	if err != nil {
+		return nil, err
+	}
+	p, err = fn(p, &Block{Tiff: d, ByteOrder: d.byteOrder, Width: width, Height: height})
+	if err != nil {
+		return nil, err
+	}
+	if len(p) < n {
+		name, _ := compressionName(int(compression))
+		return nil, GeneralIssue(fmt.Sprintf("%s block decompressed to %d bytes, expected %d", name, len(p), n))
+	}
+	return p, nil
+}
+
+func noneDecompress(data []byte, block *Block) ([]byte, error) {
+	return data, nil
+}
+
+func lzwDecompress(data []byte, block *Block) ([]byte, error) {
+	return ioutil.ReadAll(lzw.NewReader(bytes.NewReader(data), lzw.MSB, 8))
+}
+
+func deflateDecompress(data []byte, block *Block) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// packBitsDecompress expands Macintosh PackBits run length encoding (page 42
+// of the spec)
+func packBitsDecompress(data []byte, block *Block) ([]byte, error) {
+	out := make([]byte, 0, 2*len(data))
+	for i := 0; i < len(data); {
+		n := int(int8(data[i]))
+		i++
+		switch {
+		case n >= 0:
+			// copy the next n+1 bytes literally
+			if i+n+1 > len(data) {
+				return nil, GeneralIssue("PackBits literal run past the end of the block")
+			}
+			out = append(out, data[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			// repeat the next byte 1-n times, -128 is a no-op
+			if i >= len(data) {
+				return nil, GeneralIssue("PackBits repeat run past the end of the block")
+			}
+			for j := 0; j < 1-n; j++ {
+				out = append(out, data[i])
+			}
+			i++
+		}
+	}
+	return out, nil
+}
+
+// jpegDecompress decodes a JPEG strip or tile. Tables shared by all blocks
+// are kept in the JPEGTables tag, an abbreviated JPEG stream that is spliced
+// in front of the block. Grayscale blocks return one sample per pixel, any
+// other color space is converted to 8 bit RGB.
+func jpegDecompress(data []byte, block *Block) ([]byte, error) {
+	stream := data
+	if ifd, err := block.Tiff.TagFor(tJPEGTables); err == nil {
+		tables := ifd.Bytes()
+		if len(tables) > 4 && len(data) > 2 {
+			// drop the EOI of the tables and the SOI of the block
+			stream = make([]byte, 0, len(tables)+len(data)-4)
+			stream = append(stream, tables[:len(tables)-2]...)
+			stream = append(stream, data[2:]...)
+		}
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if gray, ok := img.(*image.Gray); ok {
+		out := make([]byte, 0, b.Dx()*b.Dy())
+		for y := 0; y < b.Dy(); y++ {
+			out = append(out, gray.Pix[y*gray.Stride:y*gray.Stride+b.Dx()]...)
+		}
+		return out, nil
+	}
+	out := make([]byte, 0, 3*b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			out = append(out, byte(r>>8), byte(g>>8), byte(bl>>8))
+		}
+	}
+	return out, nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func zstdDecompress(data []byte, block *Block) ([]byte, error) {
+	zstdOnce.Do(func() {
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	if zstdErr != nil {
+		return nil, zstdErr
+	}
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+// lercDecompress decodes a LERC block as written by GDAL, the LercParameters
+// tag says whether the Lerc2 blob was deflated or zstd compressed as well.
+// Pixels the blob marks as invalid are returned as NaN for floating point
+// data and 0 otherwise.
+func lercDecompress(data []byte, block *Block) ([]byte, error) {
+	if params, err := block.Tiff.IntegerArrayValue(tLercParameters); err == nil && len(params) > 1 {
+		var err error
+		switch params[1] {
+		case lercNone:
+		case lercDeflate:
+			data, err = deflateDecompress(data, block)
+		case lercZSTD:
+			data, err = zstdDecompress(data, block)
+		default:
+			err = UnsupportedError(fmt.Sprintf("LERC additional compression %v", params[1]))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	img, err := decodeLerc2(data)
+	if err != nil {
+		return nil, err
+	}
+	if img.width != block.Width {
+		return nil, GeneralIssue(fmt.Sprintf("LERC blob is %d pixels wide, expected %d", img.width, block.Width))
+	}
+	return img.samples(block.ByteOrder), nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// setShortTag overwrites the value of a SHORT entry of the first IFD of a
+// little endian classic TIFF, as written by Encode
+func setShortTag(t *testing.T, data []byte, tag, value uint16) {
+	ifd := binary.LittleEndian.Uint32(data[4:])
+	n := int(binary.LittleEndian.Uint16(data[ifd:]))
+	for i := 0; i < n; i++ {
+		e := data[int(ifd)+2+i*ifdLen:]
+		if binary.LittleEndian.Uint16(e) == tag {
+			binary.LittleEndian.PutUint16(e[8:], value)
+			return
+		}
+	}
+	t.Fatalf("tag %v not found", tag)
+}
+
+func TestUnregisteredCompression(t *testing.T) {
+	raster := testRaster(12, 10)
+	f := &memFile{}
+	bounds := &Bounds{MinX: 10, MaxX: 11.2, MinY: 20, MaxY: 21}
+	if err := Encode(f, raster, bounds, &Options{RowsPerStrip: 4}); err != nil {
+		t.Fatal(err)
+	}
+	setShortTag(t, f.data, tCompression, 65000)
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := tif.Points(); err == nil {
+		t.Fatal("expected an error for an unregistered compression")
+	} else if _, ok := err.(UnsupportedError); !ok {
+		t.Errorf("expected an UnsupportedError, got %T: %v", err, err)
+	}
+
+	RegisterDecompressor(65000, "Test", func(data []byte, block *Block) ([]byte, error) {
+		if block.Width != 12 || block.Height > 4 {
+			t.Errorf("block is %dx%d", block.Width, block.Height)
+		}
+		return data, nil
+	})
+	defer func() {
+		decompressorsLock.Lock()
+		delete(decompressors, 65000)
+		delete(decompressorNames, 65000)
+		decompressorsLock.Unlock()
+	}()
+	if name, ok := compressionName(65000); !ok || name != "Test" {
+		t.Errorf("compression 65000 is named %q", name)
+	}
+	if _, ok := CompressionTypes[65000]; ok {
+		t.Error("CompressionTypes modified by RegisterDecompressor")
+	}
+	got, _, _, err := tif.Points()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range raster.Data {
+		if got.Data[i] != v {
+			t.Fatalf("value %d is %v, expected %v", i, got.Data[i], v)
+		}
+	}
+}
+
+// TestRegisterConcurrently registers decompressors while tags are described,
+// for the race detector
+func TestRegisterConcurrently(t *testing.T) {
+	defer func() {
+		decompressorsLock.Lock()
+		delete(decompressors, 65001)
+		delete(decompressorNames, 65001)
+		decompressorsLock.Unlock()
+	}()
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			RegisterDecompressor(65001, "Concurrent", noneDecompress)
+		}
+		close(done)
+	}()
+	tag := &Ifd{tag: tCompression, intValue: 65001}
+	for i := 0; i < 100; i++ {
+		if name, ok := tag.ValueName(); ok && name != "Concurrent" {
+			t.Errorf("compression 65001 is named %q", name)
+		}
+		decompressorFor(65001)
+	}
+	<-done
+}
+
+func TestPackBits(t *testing.T) {
+	// example from page 42 of the spec
+	packed := []byte{0xFE, 0xAA, 0x02, 0x80, 0x00, 0x2A, 0xFD, 0xAA, 0x03, 0x80, 0x00, 0x2A, 0x22, 0xF7, 0xAA}
+	unpacked := []byte{0xAA, 0xAA, 0xAA, 0x80, 0x00, 0x2A, 0xAA, 0xAA, 0xAA, 0xAA, 0x80, 0x00, 0x2A, 0x22,
+		0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	got, err := packBitsDecompress(packed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, unpacked) {
+		t.Errorf("got % x", got)
+	}
+	if _, err := packBitsDecompress([]byte{0x05, 0x01}, nil); err == nil {
+		t.Error("expected an error for a truncated run")
+	}
+}
+
+func TestZstd(t *testing.T) {
+	in := bytes.Repeat([]byte("elevation"), 500)
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := zstdDecompress(enc.EncodeAll(in, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Errorf("round trip of %d bytes yielded %d bytes", len(in), len(got))
+	}
+}
+
+// splitJPEG moves the quantization and Huffman tables of a JPEG stream into
+// an abbreviated tables stream, the way TIFF writers fill JPEGTables
+func splitJPEG(stream []byte) (tables, image []byte) {
+	tables = []byte{0xFF, 0xD8}
+	image = []byte{0xFF, 0xD8}
+	for i := 2; i < len(stream); {
+		marker := stream[i+1]
+		length := int(binary.BigEndian.Uint16(stream[i+2:]))
+		if marker == 0xDA {
+			// start of scan, the rest is entropy coded data
+			image = append(image, stream[i:]...)
+			break
+		}
+		if marker == 0xDB || marker == 0xC4 {
+			tables = append(tables, stream[i:i+2+length]...)
+		} else {
+			image = append(image, stream[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+	return append(tables, 0xFF, 0xD9), image
+}
+
+func TestJpeg(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 16, 8))
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i / 8 * 16)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, gray, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	tables, data := splitJPEG(buf.Bytes())
+	d := &decoder{ifd: map[uint16]*Ifd{tJPEGTables: {tag: tJPEGTables, fieldType: dtUndefined, count: uint32(len(tables)), value: tables}}}
+	got, err := jpegDecompress(data, &Block{Tiff: d, Width: 16, Height: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(gray.Pix) {
+		t.Fatalf("%d bytes, expected %d", len(got), len(gray.Pix))
+	}
+	for i, v := range gray.Pix {
+		if diff := int(got[i]) - int(v); diff > 2 || diff < -2 {
+			t.Fatalf("pixel %d is %v, expected %v", i, got[i], v)
+		}
+	}
+}
+
+// lercHeader writes a Lerc2 version 3 header and an empty mask
+func lercHeader(buf *bytes.Buffer, w, h, numValid, dataType int, maxZError, zMin, zMax float64) {
+	buf.WriteString("Lerc2 ")
+	binary.Write(buf, binary.LittleEndian, []int32{3, 0, int32(h), int32(w), int32(numValid), 8, 0, int32(dataType)})
+	binary.Write(buf, binary.LittleEndian, []float64{maxZError, zMin, zMax})
+}
+
+// finishLerc fills in the blob size
+func finishLerc(buf *bytes.Buffer) []byte {
+	p := buf.Bytes()
+	binary.LittleEndian.PutUint32(p[30:], uint32(len(p)))
+	return p
+}
+
+func TestLercTiles(t *testing.T) {
+	// 4x3 float32, a single micro block of values quantized from 10
+	var buf bytes.Buffer
+	lercHeader(&buf, 4, 3, 12, lercFloat, 0.005, 10, 10.11)
+	binary.Write(&buf, binary.LittleEndian, int32(0)) // mask
+	buf.WriteByte(0)                                  // not one sweep
+	buf.WriteByte(1)                                  // bit stuffed micro block
+	binary.Write(&buf, binary.LittleEndian, float32(10))
+	buf.Write([]byte{2<<6 | 4, 12}) // 12 values of 4 bits
+	buf.Write([]byte{0x10, 0x32, 0x54, 0x76, 0x98, 0xba})
+	img, err := decodeLerc2(finishLerc(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := img.samples(binary.BigEndian)
+	for k := 0; k < 12; k++ {
+		want := float32(math.Min(10+float64(k)*0.01, 10.11))
+		if got := math.Float32frombits(binary.BigEndian.Uint32(samples[4*k:])); got != want {
+			t.Errorf("value %d is %v, expected %v", k, got, want)
+		}
+	}
+}
+
+func TestLercMask(t *testing.T) {
+	// 3x2 int16 stored in one sweep, the middle column is invalid
+	var buf bytes.Buffer
+	lercHeader(&buf, 3, 2, 4, lercShort, 0.5, -300, 700)
+	rle := []byte{1, 0, 0xb4, 0x00, 0x80} // one literal byte 10110100, end
+	binary.Write(&buf, binary.LittleEndian, int32(len(rle)))
+	buf.Write(rle)
+	buf.WriteByte(1) // one sweep
+	binary.Write(&buf, binary.LittleEndian, []int16{-300, 5, 6, 700})
+	img, err := decodeLerc2(finishLerc(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := img.samples(binary.LittleEndian)
+	want := []int16{-300, 0, 5, 6, 0, 700}
+	for i, v := range want {
+		if got := int16(binary.LittleEndian.Uint16(samples[2*i:])); got != v {
+			t.Errorf("value %d is %v, expected %v", i, got, v)
+		}
+	}
+}
+
+func TestLercHuffman(t *testing.T) {
+	// 2x2 bytes, delta Huffman coded with codes 0, 10, 110 and 111
+	var buf bytes.Buffer
+	lercHeader(&buf, 2, 2, 4, lercByte, 0.5, 0, 3)
+	binary.Write(&buf, binary.LittleEndian, int32(0)) // mask
+	buf.Write([]byte{0, lercDeltaHuffman})            // not one sweep, delta Huffman
+	binary.Write(&buf, binary.LittleEndian, []int32{3, 256, 0, 4})
+	buf.Write([]byte{2<<6 | 2, 4, 1 | 2<<2 | 3<<4 | 3<<6}) // code lengths 1, 2, 3, 3
+	binary.Write(&buf, binary.LittleEndian, uint32(0x0b7)<<23)
+	binary.Write(&buf, binary.LittleEndian, uint32(0x56)<<24) // deltas 0, 1, 1, 2
+	img, err := decodeLerc2(finishLerc(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{0, 1, 1, 3}
+	for i, v := range want {
+		if img.values[i] != v {
+			t.Errorf("value %d is %v, expected %v", i, img.values[i], v)
+		}
+	}
+}
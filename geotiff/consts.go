@@ -11,6 +11,12 @@ const (
 	beHeader = "MM\x00\x2A" // Header for big-endian files.
 
 	ifdLen = 12 // Length of an IFD entry in bytes.
+
+	// BigTIFF, see http://www.awaresystems.be/imaging/tiff/bigtiff.html
+	leBigHeader = "II\x2B\x00" // Header for little-endian BigTIFF files.
+	beBigHeader = "MM\x00\x2B" // Header for big-endian BigTIFF files.
+
+	bigIfdLen = 20 // Length of a BigTIFF IFD entry in bytes.
 )
 
 const (
@@ -40,6 +46,10 @@ const (
 	dtSrational = 10
 	dtFloat     = 11
 	dtDouble    = 12
+	dtIFD       = 13
+	dtLong8     = 16 // BigTIFF
+	dtSlong8    = 17 // BigTIFF
+	dtIFD8      = 18 // BigTIFF
 )
 
 const (
@@ -55,10 +65,15 @@ const (
 	DtSrational = 10
 	DtFloat     = 11
 	DtDouble    = 12
+	DtIFD       = 13
+	DtLong8     = 16
+	DtSlong8    = 17
+	DtIFD8      = 18
 )
 
 // The length of one instance of each data type in bytes.
-var lengths = [...]uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+// Types 14 and 15 are unassigned.
+var lengths = [...]uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8, 4, 0, 0, 8, 8, 8}
 
 // Tags (see p. 28-41 of the spec).
 const (
@@ -78,6 +93,8 @@ const (
 	tTileOffsets    = 324
 	tTileByteCounts = 325
 
+	tJPEGTables = 347
+
 	tNewSubfileType      = 254
 	tSubfileType         = 255
 	tThreshholding       = 263
@@ -125,6 +142,8 @@ const (
 
 	tGDALMetadata = 42112
 	tGDALNodata   = 42113
+
+	tLercParameters = 50674 // LERC version and additional compression
 )
 
 // Compression types (defined in various places in the spec and supplements).
@@ -139,6 +158,15 @@ const (
 	cDeflate    = 8 // zlib compression.
 	cPackBits   = 32773
 	cDeflateOld = 32946 // Superseded by cDeflate.
+	cLERC       = 34887 // Limited Error Raster Compression, see https://github.com/Esri/lerc
+	cZSTD       = 50000 // Zstandard, registered by GDAL.
+)
+
+// Values for the additional compression in tLercParameters
+const (
+	lercNone    = 0
+	lercDeflate = 1
+	lercZSTD    = 2
 )
 
 // CompressionType describes the type of compression used in Options for writer.
@@ -147,6 +175,7 @@ type CompressionType int
 const (
 	Uncompressed CompressionType = iota
 	Deflate
+	LZW
 )
 
 // optToCompression returns the compression type constant from the TIFF spec that
@@ -155,6 +184,8 @@ func (c CompressionType) optToCompression() uint32 {
 	switch c {
 	case Deflate:
 		return cDeflate
+	case LZW:
+		return cLZW
 	}
 	return cNone
 }
@@ -171,10 +202,24 @@ const (
 	pCIELab      = 8
 )
 
+// Bits of the tNewSubfileType tag (page 36 of the spec).
+const (
+	sfReducedImage = 1 // reduced resolution version of another image, an overview
+	sfPage         = 2 // single page of a multi-page image
+	sfMask         = 4 // transparency mask for another image
+)
+
 // Values for the tPredictor tag (page 64-65 of the spec).
 const (
-	prNone       = 1
-	prHorizontal = 2
+	prNone          = 1
+	prHorizontal    = 2
+	prFloatingPoint = 3 // Adobe Photoshop TIFF Technical Note 3
+)
+
+// Values for the tPlanarConfiguration tag (page 38 of the spec).
+const (
+	pcChunky = 1 // samples of a pixel are stored together
+	pcPlanar = 2 // each sample is stored in its own plane
 )
 
 // Values for the tResolutionUnit tag (page 18).
@@ -264,7 +309,9 @@ var CompressionTypes map[int]string = map[int]string{
 	cJPEG:       "JPEG",
 	cDeflate:    "Deflate",
 	cPackBits:   "Packbits",
-	cDeflateOld: "Old Deflate"}
+	cDeflateOld: "Old Deflate",
+	cLERC:       "LERC",
+	cZSTD:       "ZSTD"}
 
 var PhotoMetricInterpretation map[int]string = map[int]string{
 	pWhiteIsZero: "White Is Zero",
@@ -303,6 +350,8 @@ var TiffTags map[int]string = map[int]string{
 	tTileOffsets:    "TileOffsets",
 	tTileByteCounts: "TileByteCounts",
 
+	tJPEGTables: "JPEGTables",
+
 	tNewSubfileType:      "NewSubfileType",
 	tSubfileType:         "SubfileType",
 	tThreshholding:       "Threshholding",
@@ -348,7 +397,9 @@ var TiffTags map[int]string = map[int]string{
 	tModelTransformationTag: "ModelTransformationTag",
 
 	tGDALMetadata: "GDALMetadata",
-	tGDALNodata:   "GDALNodata"}
+	tGDALNodata:   "GDALNodata",
+
+	tLercParameters: "LercParameters"}
 
 var DataTypes map[int]string = map[int]string{
 	dtByte:      "Byte",
@@ -362,7 +413,11 @@ var DataTypes map[int]string = map[int]string{
 	dtSlong:     "SignedLong",
 	dtSrational: "SignedRational",
 	dtFloat:     "Float",
-	dtDouble:    "Double"}
+	dtDouble:    "Double",
+	dtIFD:       "IFD",
+	dtLong8:     "Long8",
+	dtSlong8:    "SignedLong8",
+	dtIFD8:      "IFD8"}
 
 var GeoKeys map[int]string = map[int]string{
 	1024: "GTModelTypeGeoKey",
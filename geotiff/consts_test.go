@@ -7,13 +7,13 @@ package geotiff
 import "testing"
 
 func TestOptToCompression(t *testing.T) {
-	vals := []CompressionType{Deflate, Uncompressed}
-	answers := []uint32{cDeflate, cNone}
+	vals := []CompressionType{Deflate, LZW, Uncompressed}
+	answers := []uint32{cDeflate, cLZW, cNone}
 
 	for i, v := range vals {
 		answer := CompressionType.optToCompression(v)
 		if answer != answers[i] {
-			t.Errorf("compression type %v, returned %v, expected %v", Deflate, answer, answers[i])
+			t.Errorf("compression type %v, returned %v, expected %v", v, answer, answers[i])
 		}
 	}
 }
@@ -0,0 +1,284 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// ContourOptions choose the levels of Contours, either the fixed Levels or
+// every Interval from Base
+type ContourOptions struct {
+	Interval float64
+	Base     float64
+	Levels   []float64
+}
+
+// levels returns the contour levels between min and max
+func (o *ContourOptions) levels(min, max float64) ([]float64, error) {
+	if len(o.Levels) > 0 {
+		return o.Levels, nil
+	}
+	if !(o.Interval > 0) {
+		return nil, GeneralIssue(fmt.Sprintf("contours: interval %v without fixed levels", o.Interval))
+	}
+	first, last := math.Ceil((min-o.Base)/o.Interval), math.Floor((max-o.Base)/o.Interval)
+	if last-first > 10000 {
+		return nil, GeneralIssue(fmt.Sprintf("contours: %v levels of %v from %v to %v", last-first+1, o.Interval, min, max))
+	}
+	var levels []float64
+	for k := first; k <= last; k++ {
+		levels = append(levels, o.Base+k*o.Interval)
+	}
+	return levels, nil
+}
+
+// Contour is a line of constant elevation, Points are x, y in the units of the
+// bounds of the raster it was traced in. The first and last points of a
+// closed contour are the same.
+type Contour struct {
+	Level  float64
+	Points [][2]float64
+	Closed bool
+}
+
+// AsWkt returns the contour as a LINESTRING
+func (c *Contour) AsWkt() string {
+	points := make([]string, len(c.Points))
+	for i, p := range c.Points {
+		points[i] = fmt.Sprintf("%.7f %.7f", p[0], p[1])
+	}
+	return "LINESTRING (" + strings.Join(points, ", ") + ")"
+}
+
+// marchingSegments lists the edges joined by the contour segments of each
+// case of a cell, the case has a bit set for each corner above the level:
+// 8 top left, 4 top right, 2 bottom right, 1 bottom left. The saddles, 5 and
+// 10, are resolved by the mean of the corners.
+var marchingSegments = [16][][2]cellEdge{
+	1:  {{left, bottom}},
+	2:  {{bottom, right}},
+	3:  {{left, right}},
+	4:  {{top, right}},
+	6:  {{top, bottom}},
+	7:  {{left, top}},
+	8:  {{left, top}},
+	9:  {{top, bottom}},
+	11: {{top, right}},
+	12: {{left, right}},
+	13: {{bottom, right}},
+	14: {{left, bottom}},
+}
+
+type cellEdge int
+
+const (
+	top cellEdge = iota
+	right
+	bottom
+	left
+)
+
+// Contours traces the lines of constant elevation of r by marching squares
+// over the centers of its pixels, georeferenced by bounds. Cells with a
+// nodata corner are left out, breaking the lines around them.
+func Contours(r *Raster, bounds *Bounds, opts *ContourOptions) ([]*Contour, error) {
+	if opts == nil {
+		return nil, GeneralIssue("contours: missing options")
+	}
+	if r == nil || r.w < 2 || r.h < 2 {
+		return nil, GeneralIssue("contours: the raster is smaller than 2x2")
+	}
+	valid := func(v float32) bool {
+		return v != noData && !math.IsNaN(float64(v))
+	}
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range r.Data {
+		if valid(v) {
+			min, max = math.Min(min, float64(v)), math.Max(max, float64(v))
+		}
+	}
+	if min > max {
+		return nil, nil
+	}
+	levels, err := opts.levels(min, max)
+	if err != nil {
+		return nil, err
+	}
+	dx, dy := bounds.Xspan()/float64(r.w), bounds.Yspan()/float64(r.h)
+
+	var contours []*Contour
+	for _, level := range levels {
+		// pixels at the level are raised a little so that lines cross edges
+		// between their ends, joining the segments of neighboring cells
+		nudge := 1e-9 * math.Max(1, math.Abs(level))
+		at := func(row, col int) float64 {
+			if v := float64(r.ValueAt(row, col)); v != level {
+				return v
+			}
+			return level + nudge
+		}
+		// the crossings on the edges between pixel centers, keyed by the edge:
+		// 2*pixel for the edge to the east, 2*pixel+1 for the edge to the south
+		points := map[int][2]float64{}
+		crossing := func(row, col int, south bool) int {
+			row2, col2, key := row, col+1, 2*(row*r.w+col)
+			if south {
+				row2, col2, key = row+1, col, key+1
+			}
+			if _, ok := points[key]; !ok {
+				a, b := at(row, col), at(row2, col2)
+				t := (level - a) / (b - a)
+				c, rr := float64(col)+t*float64(col2-col), float64(row)+t*float64(row2-row)
+				points[key] = [2]float64{bounds.MinX + (c+0.5)*dx, bounds.MaxY - (rr+0.5)*dy}
+			}
+			return key
+		}
+		var segments [][2]int
+		for row := 0; row+1 < r.h; row++ {
+			for col := 0; col+1 < r.w; col++ {
+				if !valid(r.ValueAt(row, col)) || !valid(r.ValueAt(row, col+1)) || !valid(r.ValueAt(row+1, col+1)) || !valid(r.ValueAt(row+1, col)) {
+					continue
+				}
+				tl, tr, br, bl := at(row, col), at(row, col+1), at(row+1, col+1), at(row+1, col)
+				index := 0
+				for i, v := range []float64{tl, tr, br, bl} {
+					if v > level {
+						index |= 8 >> uint(i)
+					}
+				}
+				pairs := marchingSegments[index]
+				if index == 5 || index == 10 {
+					// lines around the corners unlike the center
+					above := (tl+tr+br+bl)/4 > level
+					if (index == 5) == above {
+						pairs = [][2]cellEdge{{left, top}, {bottom, right}}
+					} else {
+						pairs = [][2]cellEdge{{left, bottom}, {top, right}}
+					}
+				}
+				for _, pair := range pairs {
+					var s [2]int
+					for i, e := range pair {
+						switch e {
+						case top:
+							s[i] = crossing(row, col, false)
+						case right:
+							s[i] = crossing(row, col+1, true)
+						case bottom:
+							s[i] = crossing(row+1, col, false)
+						case left:
+							s[i] = crossing(row, col, true)
+						}
+					}
+					segments = append(segments, s)
+				}
+			}
+		}
+		for _, line := range joinSegments(segments) {
+			c := &Contour{Level: level, Closed: len(line) > 2 && line[0] == line[len(line)-1]}
+			length := 0.0
+			for i, key := range line {
+				c.Points = append(c.Points, points[key])
+				if i > 0 {
+					length += math.Hypot(c.Points[i][0]-c.Points[i-1][0], c.Points[i][1]-c.Points[i-1][1])
+				}
+			}
+			// the loop around a pixel at the level is not a line
+			if length > 1e-6*dx {
+				contours = append(contours, c)
+			}
+		}
+	}
+	return contours, nil
+}
+
+// joinSegments chains segments sharing an end into lines, an end is shared by
+// the segments of at most two cells
+func joinSegments(segments [][2]int) [][]int {
+	ends := map[int][]int{}
+	for i, s := range segments {
+		ends[s[0]] = append(ends[s[0]], i)
+		ends[s[1]] = append(ends[s[1]], i)
+	}
+	used := make([]bool, len(segments))
+	// next returns the far end of an unused segment at key
+	next := func(key int) (int, bool) {
+		for _, i := range ends[key] {
+			if !used[i] {
+				used[i] = true
+				if segments[i][0] == key {
+					return segments[i][1], true
+				}
+				return segments[i][0], true
+			}
+		}
+		return 0, false
+	}
+	var lines [][]int
+	for i, s := range segments {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		line := []int{s[0], s[1]}
+		for key, ok := next(s[1]); ok; key, ok = next(key) {
+			line = append(line, key)
+		}
+		var head []int
+		for key, ok := next(s[0]); ok; key, ok = next(key) {
+			head = append(head, key)
+		}
+		for j := len(head) - 1; j >= 0; j-- {
+			line = append([]int{head[j]}, line...)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// WriteContoursGeoJSON writes contours as a GeoJSON FeatureCollection of
+// LineStrings with their level as the elevation property
+func WriteContoursGeoJSON(w io.Writer, contours []*Contour) error {
+	type geometry struct {
+		Type        string       `json:"type"`
+		Coordinates [][2]float64 `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string             `json:"type"`
+		Geometry   geometry           `json:"geometry"`
+		Properties map[string]float64 `json:"properties"`
+	}
+	collection := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+	for _, c := range contours {
+		collection.Features = append(collection.Features, feature{
+			Type:       "Feature",
+			Geometry:   geometry{Type: "LineString", Coordinates: c.Points},
+			Properties: map[string]float64{"elevation": c.Level},
+		})
+	}
+	return json.NewEncoder(w).Encode(collection)
+}
+
+// ContoursAsWkt returns the contours of each level as a MULTILINESTRING,
+// keyed by level
+func ContoursAsWkt(contours []*Contour) map[float64]string {
+	lines := map[float64][]string{}
+	for _, c := range contours {
+		lines[c.Level] = append(lines[c.Level], strings.TrimPrefix(c.AsWkt(), "LINESTRING "))
+	}
+	wkt := map[float64]string{}
+	for level, l := range lines {
+		wkt[level] = "MULTILINESTRING (" + strings.Join(l, ", ") + ")"
+	}
+	return wkt
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+// cone returns a 21x21 raster of a cone peaking at 100 in its center
+func cone() *Raster {
+	r := NewRaster(21, 21)
+	for row := 0; row < 21; row++ {
+		for col := 0; col < 21; col++ {
+			r.SetValue(row, col, float32(100-10*math.Hypot(float64(row-10), float64(col-10))))
+		}
+	}
+	return r
+}
+
+func TestContours(t *testing.T) {
+	// pixel centers from 0.5 to 20.5, the peak at 10.5, 10.5
+	bounds := &Bounds{MinX: 0, MaxX: 21, MinY: 0, MaxY: 21}
+	contours, err := Contours(cone(), bounds, &ContourOptions{Interval: 25, Base: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// levels 5, 30, 55 and 80 are circles of radius 9.5, 7, 4.5 and 2, the
+	// circle of -20 is cut into four arcs by the edges of the raster
+	if len(contours) != 8 {
+		t.Fatalf("%d contours", len(contours))
+	}
+	for _, c := range contours[:4] {
+		if c.Level != -20 || c.Closed {
+			t.Fatalf("level %v arc", c.Level)
+		}
+	}
+	contours = contours[4:]
+	if len(contours) != 4 {
+		t.Fatalf("%d contours", len(contours))
+	}
+	for _, c := range contours {
+		if !c.Closed || c.Points[0] != c.Points[len(c.Points)-1] {
+			t.Errorf("level %v is not closed", c.Level)
+		}
+		radius := (100 - c.Level) / 10
+		for _, p := range c.Points {
+			if d := math.Hypot(p[0]-10.5, p[1]-10.5); math.Abs(d-radius) > 0.1 {
+				t.Fatalf("level %v: point %v at %v from the peak", c.Level, p, d)
+			}
+		}
+	}
+
+	// a nodata pixel opens the outer circle
+	r := cone()
+	r.SetValue(10, 1, noData)
+	contours, err = Contours(r, bounds, &ContourOptions{Levels: []float64{5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contours) != 1 || contours[0].Closed {
+		t.Errorf("%d contours around nodata", len(contours))
+	}
+	if _, err := Contours(r, bounds, &ContourOptions{}); err == nil {
+		t.Error("contours without levels")
+	}
+}
+
+func TestContoursOutput(t *testing.T) {
+	contours, err := Contours(cone(), &Bounds{MinX: 10, MaxX: 10.21, MinY: 45, MaxY: 45.21}, &ContourOptions{Levels: []float64{50, 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := WriteContoursGeoJSON(&b, contours); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates [][2]float64
+			}
+			Properties map[string]float64
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	// the peak is a single pixel at 100, not a line
+	if doc.Type != "FeatureCollection" || len(doc.Features) != 1 || doc.Features[0].Properties["elevation"] != 50 {
+		t.Fatalf("%s", b.String())
+	}
+	for _, f := range doc.Features {
+		lon, lat := f.Geometry.Coordinates[0][0], f.Geometry.Coordinates[0][1]
+		if f.Geometry.Type != "LineString" || lon < 10 || lon > 10.21 || lat < 45 || lat > 45.21 {
+			t.Errorf("feature %v", f)
+		}
+	}
+	wkt := ContoursAsWkt(contours)
+	if s := wkt[50]; !strings.HasPrefix(s, "MULTILINESTRING ((10.") || strings.Count(s, "(") != 2 {
+		t.Errorf("wkt %s", s)
+	}
+	if s := contours[0].AsWkt(); !strings.HasPrefix(s, "LINESTRING (") {
+		t.Errorf("wkt %s", s)
+	}
+}
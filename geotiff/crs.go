@@ -0,0 +1,470 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Values of the GTModelTypeGeoKey
+const (
+	ModelTypeProjected  = 1
+	ModelTypeGeographic = 2
+	ModelTypeGeocentric = 3
+)
+
+// userDefined is the GeoKey value of parameters given by other keys
+const userDefined = 32767
+
+// Ellipsoid is the figure of the earth a datum is based on
+type Ellipsoid struct {
+	Code          int     // EPSG ellipsoid code, 0 when user defined
+	SemiMajor     float64 // meters
+	InvFlattening float64 // 0 for a sphere
+}
+
+// SemiMinor is the polar radius in meters
+func (e Ellipsoid) SemiMinor() float64 {
+	if e.InvFlattening == 0 {
+		return e.SemiMajor
+	}
+	return e.SemiMajor * (1 - 1/e.InvFlattening)
+}
+
+// CRS is the coordinate reference system described by the GeoKeys of a
+// GeoTIFF or of a LAS file
+type CRS struct {
+	ModelType      int    // ModelTypeProjected, ModelTypeGeographic or ModelTypeGeocentric
+	EPSG           int    // EPSG code of the system, 0 when user defined
+	Name           string // from the EPSG tables or the citation keys
+	GeographicEPSG int    // EPSG code of the geographic system, the CRS itself unless projected
+	Datum          int    // EPSG geodetic datum code
+	Ellipsoid      Ellipsoid
+	PrimeMeridian  float64 // longitude of the prime meridian, degrees east of Greenwich
+	AngularUnits   int     // EPSG unit code of angles, 9102 for degrees
+	LinearUnits    int     // EPSG unit code of projected coordinates, 9001 for meters
+	LinearUnitSize float64 // meters per linear unit
+	Projection     int     // ProjectionGeoKey code of a projected system
+	CoordTrans     int     // ProjCoordTransGeoKey code, the projection method
+	// Parameters of the projection method keyed by GeoKey, from
+	// ProjStdParallel1GeoKey (3078) to ProjStraightVertPoleLongGeoKey (3095).
+	// Angles are in AngularUnits and distances in LinearUnits.
+	Parameters map[int]float64
+	// ToWGS84 holds the Helmert parameters of a TOWGS84 clause of WKT, nil
+	// when the shift of the datum comes from its EPSG code
+	ToWGS84 []float64
+}
+
+func (c *CRS) IsProjected() bool {
+	return c.ModelType == ModelTypeProjected
+}
+
+func (c *CRS) IsGeographic() bool {
+	return c.ModelType == ModelTypeGeographic
+}
+
+func (c *CRS) String() string {
+	if c.EPSG != 0 {
+		return fmt.Sprintf("EPSG:%d %s", c.EPSG, c.Name)
+	}
+	return fmt.Sprintf("user-defined %s %s", GTModelTypeGeoKey[c.ModelType], c.Name)
+}
+
+// crsTolerance is the relative difference below which parameters are equal
+const crsTolerance = 1e-9
+
+func sameValue(a, b float64) bool {
+	return a == b || math.Abs(a-b) <= crsTolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// Equal reports whether c and o describe the same system. Two systems with
+// EPSG codes are equal when their codes are, otherwise the datum, ellipsoid,
+// units and projection parameters are compared.
+func (c *CRS) Equal(o *CRS) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	if c.ModelType != o.ModelType {
+		return false
+	}
+	if c.EPSG != 0 && o.EPSG != 0 {
+		return c.EPSG == o.EPSG
+	}
+	if c.Datum != 0 && o.Datum != 0 && c.Datum != o.Datum {
+		return false
+	}
+	if !sameValue(c.Ellipsoid.SemiMajor, o.Ellipsoid.SemiMajor) || !sameValue(c.Ellipsoid.InvFlattening, o.Ellipsoid.InvFlattening) ||
+		!sameValue(c.PrimeMeridian, o.PrimeMeridian) || c.AngularUnits != o.AngularUnits {
+		return false
+	}
+	if !c.IsProjected() {
+		return true
+	}
+	if c.CoordTrans != o.CoordTrans || !sameValue(c.LinearUnitSize, o.LinearUnitSize) {
+		return false
+	}
+	for k, v := range c.Parameters {
+		if !sameValue(v, o.Parameters[k]) {
+			return false
+		}
+	}
+	for k, v := range o.Parameters {
+		if _, ok := c.Parameters[k]; !ok && v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ellipsoids holds the semi-major axis and inverse flattening of the EPSG
+// ellipsoids named in GeogEllipsoidGeoKey, and of WGS 72
+var ellipsoids = map[int][2]float64{
+	7001: {6377563.396, 299.3249646},
+	7002: {6377340.189, 299.3249646},
+	7003: {6378160, 298.25},
+	7004: {6377397.155, 299.1528128},
+	7005: {6377492.018, 299.1528128},
+	7006: {6377483.865, 299.1528128},
+	7008: {6378206.4, 294.9786982},
+	7009: {6378450.047548896, 294.978684677},
+	7010: {6378300.789, 293.4663155},
+	7011: {6378249.2, 293.4660213},
+	7012: {6378249.145, 293.465},
+	7013: {6378249.145, 293.4663077},
+	7014: {6378249.2, 293.46598},
+	7015: {6377276.345, 300.8017},
+	7016: {6377298.556, 300.8017},
+	7017: {6377299.151, 300.8017255},
+	7018: {6377304.063, 300.8017},
+	7019: {6378137, 298.257222101},
+	7020: {6378200, 298.3},
+	7022: {6378388, 297},
+	7023: {6378160, 298.25},
+	7024: {6378245, 298.3},
+	7030: {6378137, 298.257223563},
+	7034: {6378249.144808011, 293.466307656},
+	7043: {6378135, 298.26},
+}
+
+// gcsDefinitions gives the datum and ellipsoid of geographic systems whose
+// codes do not follow the GCSE_ pattern, datum = code+2000 and ellipsoid =
+// code+3000
+var gcsDefinitions = map[int]struct {
+	name             string
+	datum, ellipsoid int
+}{
+	4202: {"GCS_AGD66", 6202, 7003},
+	4203: {"GCS_AGD84", 6203, 7003},
+	4230: {"GCS_ED50", 6230, 7022},
+	4267: {"GCS_NAD27", 6267, 7008},
+	4269: {"GCS_NAD83", 6269, 7019},
+	4283: {"GCS_GDA94", 6283, 7019},
+	4289: {"GCS_Amersfoort", 6289, 7004},
+	4322: {"GCS_WGS_72", 6322, 7043},
+	4324: {"GCS_WGS_72BE", 6324, 7043},
+	4326: {"GCS_WGS_84", 6326, 7030},
+}
+
+// linearUnits is the size in meters of the EPSG linear units
+var linearUnits = map[int]float64{
+	9001: 1,
+	9002: 0.3048,
+	9003: 1200.0 / 3937,
+	9004: 0.3048122530,
+	9005: 0.3047972654,
+	9006: 0.3047995,
+	9007: 0.201168,
+	9008: 0.201166195164,
+	9009: 0.20116765,
+	9010: 20.1166195164,
+	9011: 20.116765,
+	9012: 0.914398415,
+	9013: 0.914398530744,
+	9014: 1.8288,
+	9015: 1852,
+}
+
+// geographic systems of the projected systems in ProjectionCSTypeGeoKey
+var pcsDatums = map[string]int{
+	"WGS84":   4326,
+	"WGS72":   4322,
+	"WGS72BE": 4324,
+	"NAD83":   4269,
+	"NAD27":   4267,
+	"ED50":    4230,
+	"GDA94":   4283,
+	"AGD66":   4202,
+	"AGD84":   4203,
+}
+
+// utmZone matches the names of UTM systems in ProjectionCSTypeGeoKey, the
+// Australian MGA and AMG grids are UTM zones of the southern hemisphere
+var utmZone = regexp.MustCompile(`^(\S+) (UTM|MGA|AMG) Zone (\d+)([NS]?)$`)
+
+func setEllipsoid(c *CRS, code int) {
+	c.Ellipsoid = Ellipsoid{Code: code}
+	if e, ok := ellipsoids[code]; ok {
+		c.Ellipsoid.SemiMajor, c.Ellipsoid.InvFlattening = e[0], e[1]
+	}
+}
+
+// lookupGeographic fills the datum and ellipsoid of a geographic system
+func lookupGeographic(c *CRS, code int) error {
+	name, known := GeographicTypeGeoKey[code]
+	def, defined := gcsDefinitions[code]
+	switch {
+	case defined:
+		c.Datum = def.datum
+		setEllipsoid(c, def.ellipsoid)
+		if !known {
+			name = def.name
+		}
+	case known && code >= 4001 && code <= 4035:
+		// GCSE_ systems are only known by their ellipsoid
+		c.Datum = code + 2000
+		setEllipsoid(c, code+3000)
+	default:
+		return UnsupportedError(fmt.Sprintf("EPSG:%d geographic system", code))
+	}
+	c.GeographicEPSG = code
+	if c.ModelType == ModelTypeGeographic {
+		c.EPSG, c.Name = code, name
+	}
+	c.PrimeMeridian = 0
+	c.AngularUnits = 9102
+	return nil
+}
+
+// LookupEPSG builds the CRS of an EPSG code listed in the
+// ProjectionCSTypeGeoKey or GeographicTypeGeoKey tables. The parameters of
+// projected systems are known for UTM zones and for the WGS 84 Web and World
+// Mercator systems.
+func LookupEPSG(code int) (*CRS, error) {
+	if code == epsgWebMercator || code == epsgWorldMercator {
+		return worldMercator(code), nil
+	}
+	if _, ok := ProjectionCSTypeGeoKey[code]; !ok {
+		c := &CRS{ModelType: ModelTypeGeographic}
+		if err := lookupGeographic(c, code); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	name := ProjectionCSTypeGeoKey[code]
+	c := &CRS{ModelType: ModelTypeProjected, EPSG: code, Name: name, LinearUnits: 9001, LinearUnitSize: 1}
+	m := utmZone.FindStringSubmatch(name)
+	if m == nil {
+		return nil, UnsupportedError(fmt.Sprintf("EPSG:%d %s, only UTM parameters are known", code, name))
+	}
+	if gcs, ok := pcsDatums[m[1]]; ok {
+		if err := lookupGeographic(c, gcs); err != nil {
+			return nil, err
+		}
+	}
+	zone, _ := strconv.Atoi(m[3])
+	south := m[4] == "S" || m[2] != "UTM"
+	c.Projection = 16000 + zone
+	falseNorthing := 0.0
+	if south {
+		c.Projection += 100
+		falseNorthing = 10000000
+	}
+	c.CoordTrans = ctTransverseMercator
+	c.Parameters = map[int]float64{
+		gkProjNatOriginLatGeoKey:     0,
+		gkProjNatOriginLongGeoKey:    float64(zone*6 - 183),
+		gkProjScaleAtNatOriginGeoKey: 0.9996,
+		gkProjFalseEastingGeoKey:     500000,
+		gkProjFalseNorthingGeoKey:    falseNorthing,
+	}
+	return c, nil
+}
+
+// worldMercator builds EPSG:3857, the spherical Mercator of web maps, or
+// EPSG:3395, both on WGS 84
+func worldMercator(code int) *CRS {
+	c := &CRS{ModelType: ModelTypeProjected, EPSG: code, Name: "WGS 84 / World Mercator", LinearUnits: 9001, LinearUnitSize: 1}
+	if code == epsgWebMercator {
+		c.Name = "WGS 84 / Pseudo-Mercator"
+	}
+	lookupGeographic(c, 4326)
+	c.CoordTrans = ctMercator
+	c.Parameters = map[int]float64{
+		gkProjNatOriginLatGeoKey:     0,
+		gkProjNatOriginLongGeoKey:    0,
+		gkProjScaleAtNatOriginGeoKey: 1,
+		gkProjFalseEastingGeoKey:     0,
+		gkProjFalseNorthingGeoKey:    0,
+	}
+	return c
+}
+
+// esriPEString starts the WKT that GDAL writes to citation keys
+const esriPEString = "ESRI PE String = "
+
+// geoKeyReader reads the values of GeoKeys stored in the key directory or in
+// the GeoDoubleParams and GeoAsciiParams tags
+type geoKeyReader struct {
+	keys    map[uint16]*GeoKey
+	doubles []float64
+	asciis  []byte
+}
+
+func (r *geoKeyReader) code(key int) int {
+	if k, ok := r.keys[uint16(key)]; ok && k.Location == 0 {
+		return int(k.Value)
+	}
+	return 0
+}
+
+func (r *geoKeyReader) double(key int) (float64, bool) {
+	k, ok := r.keys[uint16(key)]
+	if !ok {
+		return 0, false
+	}
+	switch k.Location {
+	case 0:
+		return float64(k.Value), true
+	case tGeoDoubles:
+		if int(k.Value) < len(r.doubles) {
+			return r.doubles[k.Value], true
+		}
+	}
+	return 0, false
+}
+
+// citation returns an ASCII key without the | terminator
+func (r *geoKeyReader) citation(key int) string {
+	k, ok := r.keys[uint16(key)]
+	if !ok || k.Location != tGeoAscii || int(k.Value)+int(k.Count) > len(r.asciis) {
+		return ""
+	}
+	return strings.TrimRight(string(r.asciis[k.Value:int(k.Value)+int(k.Count)]), "|\x00 ")
+}
+
+// NewCRS builds the coordinate reference system described by GeoKeys, with
+// the values of the GeoDoubleParams and GeoAsciiParams tags. EPSG codes are
+// expanded with LookupEPSG and user defined keys override them.
+func NewCRS(keys map[uint16]*GeoKey, doubles []float64, asciis []byte) (*CRS, error) {
+	r := &geoKeyReader{keys: keys, doubles: doubles, asciis: asciis}
+	c := &CRS{ModelType: r.code(gkGTModelTypeGeoKey)}
+	pcs, gcs := r.code(gkProjectedCSTypeGeoKey), r.code(gkGeographicTypeGeoKey)
+	if c.ModelType == 0 {
+		switch {
+		case pcs != 0:
+			c.ModelType = ModelTypeProjected
+		case gcs != 0:
+			c.ModelType = ModelTypeGeographic
+		default:
+			return nil, GeneralIssue("GeoKeys do not define a model type")
+		}
+	}
+	if c.ModelType != ModelTypeProjected && c.ModelType != ModelTypeGeographic && c.ModelType != ModelTypeGeocentric {
+		return nil, UnsupportedError(fmt.Sprintf("model type %d", c.ModelType))
+	}
+
+	if c.ModelType == ModelTypeProjected && pcs != 0 && pcs != userDefined {
+		if base, err := LookupEPSG(pcs); err == nil {
+			*c = *base
+		} else {
+			// the parameters are left to the user defined keys
+			c.EPSG, c.Name = pcs, ProjectionCSTypeGeoKey[pcs]
+		}
+	}
+	if c.GeographicEPSG == 0 && gcs != 0 && gcs != userDefined {
+		if err := lookupGeographic(c, gcs); err != nil {
+			c.GeographicEPSG = gcs
+			if c.ModelType == ModelTypeGeographic {
+				c.EPSG = gcs
+			}
+		}
+	}
+
+	// user defined geographic parameters
+	if v := r.code(gkGeogGeodeticDatumGeoKey); v != 0 && v != userDefined {
+		c.Datum = v
+	}
+	if v := r.code(gkGeogEllipsoidGeoKey); v != 0 && v != userDefined {
+		setEllipsoid(c, v)
+	}
+	if a, ok := r.double(gkGeogSemiMajorAxisGeoKey); ok {
+		c.Ellipsoid.SemiMajor = a
+		if f, ok := r.double(gkGeogInvFlatteningGeoKey); ok {
+			c.Ellipsoid.InvFlattening = f
+		} else if b, ok := r.double(gkGeogSemiMinorAxisGeoKey); ok && b != a {
+			c.Ellipsoid.InvFlattening = a / (a - b)
+		}
+	}
+	if v, ok := r.double(gkGeogPrimeMeridianLongGeoKey); ok {
+		c.PrimeMeridian = v
+	} else if r.code(gkGeogPrimeMeridianGeoKey) == 8902 { // PM_Lisbon
+		c.PrimeMeridian = -9.131906111
+	}
+	if v := r.code(gkGeogAngularUnitsGeoKey); v != 0 && v != userDefined {
+		c.AngularUnits = v
+	}
+	if c.AngularUnits == 0 {
+		c.AngularUnits = 9102
+	}
+
+	// user defined projection
+	if c.ModelType == ModelTypeProjected {
+		if v := r.code(gkProjectionGeoKey); v != 0 && v != userDefined {
+			c.Projection = v
+		}
+		if v := r.code(gkProjCoordTransGeoKey); v != 0 {
+			c.CoordTrans = v
+		}
+		if v := r.code(gkProjLinearUnitsGeoKey); v != 0 && v != userDefined {
+			c.LinearUnits = v
+			c.LinearUnitSize = linearUnits[v]
+		}
+		if v, ok := r.double(gkProjLinearUnitSizeGeoKey); ok {
+			c.LinearUnitSize = v
+		}
+		if c.LinearUnits == 0 && c.LinearUnitSize == 0 {
+			c.LinearUnits, c.LinearUnitSize = 9001, 1
+		}
+		for key := gkProjStdParallel1GeoKey; key <= gkProjStraightVertPoleLongGeoKey; key++ {
+			if v, ok := r.double(key); ok {
+				if c.Parameters == nil {
+					c.Parameters = make(map[int]float64)
+				}
+				c.Parameters[key] = v
+			}
+		}
+	}
+
+	// GDAL keeps the WKT of systems that GeoKeys cannot describe in a citation
+	if c.IsProjected() && c.CoordTrans == 0 {
+		for _, key := range []int{gkGTCitationGeoKey, gkPCSCitationGeoKey} {
+			s := r.citation(key)
+			if i := strings.Index(s, esriPEString); i >= 0 {
+				if w, err := ParseWKT(s[i+len(esriPEString):]); err == nil && w.IsProjected() {
+					return w, nil
+				}
+			}
+		}
+	}
+
+	if c.Name == "" {
+		for _, key := range []int{gkPCSCitationGeoKey, gkGTCitationGeoKey, gkGeogCitationGeoKey} {
+			if s := r.citation(key); s != "" {
+				c.Name = s
+				break
+			}
+		}
+	}
+	return c, nil
+}
+
+// CRS returns the coordinate reference system of the image
+func (d *decoder) CRS() (*CRS, error) {
+	return NewCRS(d.geokeys, d.geoDoubles, d.geoAsciis)
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLookupEPSG(t *testing.T) {
+	utm, err := LookupEPSG(26915)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utm.IsProjected() || utm.GeographicEPSG != 4269 || utm.Datum != 6269 || utm.Ellipsoid.Code != 7019 || utm.Ellipsoid.SemiMajor != 6378137 {
+		t.Errorf("EPSG:26915 is %+v", utm)
+	}
+	if utm.CoordTrans != 1 || utm.Parameters[gkProjNatOriginLongGeoKey] != -93 || utm.Parameters[gkProjFalseNorthingGeoKey] != 0 {
+		t.Errorf("EPSG:26915 parameters %v", utm.Parameters)
+	}
+	south, err := LookupEPSG(32756)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if south.Parameters[gkProjFalseNorthingGeoKey] != 10000000 || south.Parameters[gkProjNatOriginLongGeoKey] != 153 || south.Projection != 16156 {
+		t.Errorf("EPSG:32756 is %+v", south)
+	}
+	wgs84, err := LookupEPSG(4326)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wgs84.IsGeographic() || wgs84.EPSG != 4326 || wgs84.Name != "GCS_WGS_84" || wgs84.Ellipsoid.InvFlattening != 298.257223563 {
+		t.Errorf("EPSG:4326 is %+v", wgs84)
+	}
+	if _, err := LookupEPSG(1234); err == nil {
+		t.Error("expected an error for an unknown code")
+	}
+}
+
+func TestNewCRS(t *testing.T) {
+	key := func(id, location, count, value uint16) *GeoKey {
+		return &GeoKey{KeyId: id, Location: location, Count: count, Value: value}
+	}
+	// a user defined UTM zone 15N on NAD83, as written by some LAS producers
+	keys := map[uint16]*GeoKey{
+		gkGTModelTypeGeoKey:          key(gkGTModelTypeGeoKey, 0, 1, ModelTypeProjected),
+		gkProjectedCSTypeGeoKey:      key(gkProjectedCSTypeGeoKey, 0, 1, userDefined),
+		gkGeographicTypeGeoKey:       key(gkGeographicTypeGeoKey, 0, 1, 4269),
+		gkProjCoordTransGeoKey:       key(gkProjCoordTransGeoKey, 0, 1, 1),
+		gkProjLinearUnitsGeoKey:      key(gkProjLinearUnitsGeoKey, 0, 1, 9001),
+		gkProjNatOriginLatGeoKey:     key(gkProjNatOriginLatGeoKey, tGeoDoubles, 1, 0),
+		gkProjNatOriginLongGeoKey:    key(gkProjNatOriginLongGeoKey, tGeoDoubles, 1, 1),
+		gkProjScaleAtNatOriginGeoKey: key(gkProjScaleAtNatOriginGeoKey, tGeoDoubles, 1, 2),
+		gkProjFalseEastingGeoKey:     key(gkProjFalseEastingGeoKey, tGeoDoubles, 1, 3),
+		gkProjFalseNorthingGeoKey:    key(gkProjFalseNorthingGeoKey, tGeoDoubles, 1, 4),
+		gkPCSCitationGeoKey:          key(gkPCSCitationGeoKey, tGeoAscii, 12, 0),
+	}
+	doubles := []float64{0, -93, 0.9996, 500000, 0}
+	custom, err := NewCRS(keys, doubles, []byte("UTM Zone 15|"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if custom.EPSG != 0 || custom.Name != "UTM Zone 15" || custom.Ellipsoid.Code != 7019 {
+		t.Errorf("user defined CRS %+v", custom)
+	}
+	utm, _ := LookupEPSG(26915)
+	if !custom.Equal(utm) || !utm.Equal(custom) {
+		t.Errorf("%v differs from %v", custom, utm)
+	}
+	other, _ := LookupEPSG(26916)
+	if custom.Equal(other) || utm.Equal(other) {
+		t.Errorf("%v equals %v", custom, other)
+	}
+	doubles[1] = -87
+	if custom, _ := NewCRS(keys, doubles, nil); !custom.Equal(other) {
+		t.Errorf("%v differs from %v", custom, other)
+	}
+
+	// geographic with feet and user defined ellipsoid
+	keys = map[uint16]*GeoKey{
+		gkGeographicTypeGeoKey:    key(gkGeographicTypeGeoKey, 0, 1, userDefined),
+		gkGeogSemiMajorAxisGeoKey: key(gkGeogSemiMajorAxisGeoKey, tGeoDoubles, 1, 0),
+		gkGeogSemiMinorAxisGeoKey: key(gkGeogSemiMinorAxisGeoKey, tGeoDoubles, 1, 1),
+	}
+	sphere, err := NewCRS(keys, []float64{6371000, 6371000}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sphere.IsGeographic() || sphere.Ellipsoid.SemiMinor() != 6371000 || sphere.Equal(wgs84CRS(t)) {
+		t.Errorf("sphere %+v", sphere)
+	}
+
+	if _, err := NewCRS(map[uint16]*GeoKey{}, nil, nil); err == nil {
+		t.Error("expected an error without a model type")
+	}
+}
+
+func wgs84CRS(t *testing.T) *CRS {
+	c, err := LookupEPSG(4326)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTiffCRS(t *testing.T) {
+	for _, code := range []int{0, 32633} {
+		f := &memFile{}
+		if err := Encode(f, testRaster(4, 4), &Bounds{MinX: 0, MaxX: 4, MinY: 0, MaxY: 4}, &Options{ProjectedCSType: code}); err != nil {
+			t.Fatal(err)
+		}
+		tif, err := NewDecoder(bytes.NewReader(f.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		crs, err := tif.CRS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := code
+		if code == 0 {
+			want = 4326
+		}
+		if crs.EPSG != want || crs.GeographicEPSG != 4326 || crs.Ellipsoid.Code != 7030 {
+			t.Errorf("CRS of EPSG:%d is %+v", want, crs)
+		}
+	}
+}
+
+func TestRasterCRS(t *testing.T) {
+	f := &memFile{}
+	if err := Encode(f, NewRaster(4, 4), &Bounds{MinX: 500000, MaxX: 500040, MinY: 4000000, MaxY: 4000040}, &Options{ProjectedCSType: 26915}); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, _, err := tif.Points()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := tif.Bands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _, err := tif.ReadWindow(&Bounds{MinX: 500010, MaxX: 500020, MinY: 4000010, MaxY: 4000020})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, raster := range []*Raster{r, m.Bands[0], w} {
+		if raster.CRS == nil || raster.CRS.EPSG != 26915 {
+			t.Errorf("raster CRS %v, expected EPSG:26915", raster.CRS)
+		}
+	}
+}
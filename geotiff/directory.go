@@ -0,0 +1,180 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+)
+
+// A TIFF file is a chain of image file directories (IFDs), each one holding
+// the tags of a single image. Cloud optimized GeoTIFFs follow the full
+// resolution image with reduced resolution overviews (NewSubfileType=1) and
+// may add transparency masks (NewSubfileType=4). Every directory is a decoder
+// of its own, overviews and masks without GeoKeys share those of the full
+// resolution image they belong to.
+
+func newDirectory(f TiffReader, byteOrder binary.ByteOrder, bigTiff bool, offset int64) *decoder {
+	return &decoder{
+		reader:    f,
+		byteOrder: byteOrder,
+		ifdOffset: offset,
+		bigTiff:   bigTiff,
+		ifd:       make(map[uint16]*Ifd),
+		geokeys:   make(map[uint16]*GeoKey),
+		id:        atomic.AddUint32(&counter, 1),
+	}
+}
+
+// readIfd loads the tags of the directory at d.ifdOffset, it returns the offset
+// of the next directory which is 0 for the last directory in the file.
+func (d *decoder) readIfd() (int64, error) {
+	// BigTIFF directories have an 8 byte entry count, 20 byte entries and an
+	// 8 byte next IFD offset, classic TIFF uses 2, 12 and 4 bytes.
+	countLen, entryLen, offsetLen := int64(2), int64(ifdLen), 4
+	if d.bigTiff {
+		countLen, entryLen, offsetLen = 8, bigIfdLen, 8
+	}
+	header := make([]byte, 8)
+	if _, err := d.reader.ReadAt(header[0:countLen], d.ifdOffset); err != nil {
+		return 0, fmt.Errorf("Error reading offset %d: %v", d.ifdOffset, err)
+	}
+	var numItems int64
+	if d.bigTiff {
+		numItems = int64(d.byteOrder.Uint64(header))
+		if numItems < 0 || numItems > 0xffff {
+			return 0, UnsupportedError(fmt.Sprintf("BigTIFF IFD with %d entries", uint64(numItems)))
+		}
+	} else {
+		numItems = int64(d.byteOrder.Uint16(header[0:2]))
+	}
+	p := make([]byte, numItems*entryLen)
+	if _, err := d.reader.ReadAt(p, d.ifdOffset+countLen); err != nil {
+		return 0, fmt.Errorf("Error reading tags %v", err)
+	}
+	for i := int64(0); i < int64(len(p)); i += entryLen {
+		if err := d.parseIfd(p[i : i+entryLen]); err != nil {
+			return 0, fmt.Errorf("Error parsing IFD tag %d, %v", d.byteOrder.Uint16(p[i:i+2]), err)
+		}
+	}
+	for _, v := range d.ifd {
+		v.setValue()
+	}
+	d.isImage = d.IsImage()
+	if _, err := d.reader.ReadAt(header[0:offsetLen], d.ifdOffset+countLen+numItems*entryLen); err != nil {
+		// A truncated next IFD pointer is treated as the end of the chain
+		return 0, nil
+	}
+	return d.offsetValue(header), nil
+}
+
+// readDirectories follows the IFD chain starting at next, d is the first
+// directory of the file.
+func (d *decoder) readDirectories(next int64) error {
+	dirs := []*decoder{d}
+	visited := map[int64]bool{d.ifdOffset: true}
+	parent := d
+	for next != 0 {
+		if visited[next] {
+			return GeneralIssue(fmt.Sprintf("IFD chain loops back to offset %d", next))
+		}
+		visited[next] = true
+		dir := newDirectory(d.reader, d.byteOrder, d.bigTiff, next)
+		n, err := dir.readIfd()
+		if err != nil {
+			return fmt.Errorf("Error reading IFD %d: %v", len(dirs), err)
+		}
+		if dir.SubfileType()&(sfReducedImage|sfMask) != 0 {
+			dir.parent = parent
+		} else {
+			parent = dir
+		}
+		if _, err := dir.TagFor(tGeoKeys); err == nil {
+			if err := dir.parseGeoKeys(); err != nil {
+				return fmt.Errorf("Error parsing GeoKeys of IFD %d: %v", len(dirs), err)
+			}
+		} else if dir.parent != nil {
+			dir.geokeys = dir.parent.geokeys
+			dir.geoDoubles = dir.parent.geoDoubles
+			dir.geoAsciis = dir.parent.geoAsciis
+		}
+		dirs = append(dirs, dir)
+		next = n
+	}
+	for _, dir := range dirs {
+		dir.dirs = dirs
+	}
+	return nil
+}
+
+// Directories returns every image directory of the file in file order, the
+// first one is the Tiff returned by NewDecoder.
+func (d *decoder) Directories() []Tiff {
+	retval := make([]Tiff, len(d.dirs))
+	for i, dir := range d.dirs {
+		retval[i] = dir
+	}
+	return retval
+}
+
+// SubfileType is the NewSubfileType tag, 0 for a full resolution image
+func (d *decoder) SubfileType() uint {
+	v, _ := d.IntegerValue(tNewSubfileType, 0)
+	return v
+}
+
+func (d *decoder) IsOverview() bool {
+	return d.SubfileType()&sfReducedImage != 0 && !d.IsMask()
+}
+
+func (d *decoder) IsMask() bool {
+	return d.SubfileType()&sfMask != 0
+}
+
+func (d *decoder) fullResolution() *decoder {
+	if d.parent != nil {
+		return d.parent
+	}
+	return d
+}
+
+func (d *decoder) overviews() []*decoder {
+	base := d.fullResolution()
+	retval := make([]*decoder, 0, len(d.dirs))
+	for _, dir := range d.dirs {
+		if dir.parent == base && dir.IsOverview() {
+			retval = append(retval, dir)
+		}
+	}
+	return retval
+}
+
+// Overview picks the directory of this image best suited to a requested
+// resolution in meters/pixel: the coarsest overview that is not coarser than
+// resolution, or the full resolution image when none is.
+func (d *decoder) Overview(resolution float64) (Tiff, error) {
+	base := d.fullResolution()
+	baseRes, err := base.Resolution()
+	if err != nil {
+		return nil, err
+	}
+	baseWidth, _, err := base.PixelDimensions()
+	if err != nil {
+		return nil, err
+	}
+	best, bestRes := base, baseRes
+	for _, o := range base.overviews() {
+		w, _, err := o.PixelDimensions()
+		if err != nil || w == 0 {
+			continue
+		}
+		res := baseRes * baseWidth / w
+		if res <= resolution && res > bestRes {
+			best, bestRes = o, res
+		}
+	}
+	return best, nil
+}
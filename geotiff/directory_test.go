@@ -0,0 +1,171 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// testImage is an uncompressed float32 image of zeros
+type testImage struct {
+	subfileType   uint32
+	width, height uint32
+}
+
+// chainDirectories encodes a 16 x 16 GeoTIFF of 0.01 degree pixels and
+// appends a directory without GeoKeys for each image. It returns the file,
+// the offsets of the directories and of their next directory pointers.
+func chainDirectories(t *testing.T, images ...testImage) ([]byte, []uint32, []uint32) {
+	f := &memFile{}
+	bounds := &Bounds{MinX: 10, MaxX: 10.16, MinY: 45, MaxY: 45.16}
+	if err := Encode(f, NewRaster(16, 16), bounds, nil); err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	data := f.data
+	first := le.Uint32(data[4:8])
+	offsets := []uint32{first}
+	nexts := []uint32{first + 2 + uint32(le.Uint16(data[first:]))*12}
+	for _, img := range images {
+		for len(data)%2 != 0 {
+			data = append(data, 0)
+		}
+		strip := uint32(len(data))
+		data = append(data, make([]byte, img.width*img.height*4)...)
+		ifd := uint32(len(data))
+		le.PutUint32(data[nexts[len(nexts)-1]:], ifd)
+		tags := [][3]uint32{
+			{tNewSubfileType, dtLong, img.subfileType},
+			{tImageWidth, dtLong, img.width},
+			{tImageLength, dtLong, img.height},
+			{tBitsPerSample, dtShort, 32},
+			{tCompression, dtShort, 1},
+			{tPhotometricInterpretation, dtShort, 1},
+			{tStripOffsets, dtLong, strip},
+			{tSamplesPerPixel, dtShort, 1},
+			{tRowsPerStrip, dtLong, img.height},
+			{tStripByteCounts, dtLong, img.width * img.height * 4},
+			{tSampleFormat, dtShort, 3},
+		}
+		data = le.AppendUint16(data, uint16(len(tags)))
+		for _, tag := range tags {
+			data = le.AppendUint16(data, uint16(tag[0]))
+			data = le.AppendUint16(data, uint16(tag[1]))
+			data = le.AppendUint32(data, 1)
+			if tag[1] == dtShort {
+				data = le.AppendUint16(data, uint16(tag[2]))
+				data = le.AppendUint16(data, 0)
+			} else {
+				data = le.AppendUint32(data, tag[2])
+			}
+		}
+		offsets = append(offsets, ifd)
+		nexts = append(nexts, uint32(len(data)))
+		data = le.AppendUint32(data, 0)
+	}
+	return data, offsets, nexts
+}
+
+func TestDirectoryChain(t *testing.T) {
+	data, _, _ := chainDirectories(t,
+		testImage{sfReducedImage, 8, 8},
+		testImage{sfMask, 16, 16})
+	tif, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := tif.Directories()
+	if len(dirs) != 3 || dirs[0] != tif {
+		t.Fatalf("%d directories", len(dirs))
+	}
+	base, overview, mask := dirs[0], dirs[1], dirs[2]
+	if base.SubfileType() != 0 || base.IsOverview() || base.IsMask() {
+		t.Errorf("full resolution image of subfile type %d", base.SubfileType())
+	}
+	if !overview.IsOverview() || overview.IsMask() {
+		t.Errorf("overview of subfile type %d", overview.SubfileType())
+	}
+	if mask.IsOverview() || !mask.IsMask() {
+		t.Errorf("mask of subfile type %d", mask.SubfileType())
+	}
+	for i, dir := range dirs {
+		if len(dir.Directories()) != 3 {
+			t.Errorf("directory %d holds %d directories", i, len(dir.Directories()))
+		}
+		// the GeoKeys of the full resolution image
+		if k, err := dir.KeyFor(gkGeographicTypeGeoKey); err != nil || k.Value != 4326 {
+			t.Errorf("directory %d geographic type %v: %v", i, k, err)
+		}
+	}
+
+	res, err := base.Resolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		resolution float64
+		expected   Tiff
+	}{
+		{res / 2, base},
+		{res * 1.5, base},
+		{res * 2.5, overview},
+		{res * 100, overview},
+	} {
+		for _, dir := range dirs {
+			if o, err := dir.Overview(c.resolution); err != nil || o != c.expected {
+				t.Errorf("overview of %v m/pixel is %v: %v", c.resolution, o, err)
+			}
+		}
+	}
+}
+
+func TestDirectoryPages(t *testing.T) {
+	data, _, _ := chainDirectories(t,
+		testImage{sfReducedImage, 8, 8},
+		testImage{sfPage, 16, 16},
+		testImage{sfReducedImage, 4, 4})
+	tif, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := tif.Directories()
+	if len(dirs) != 4 {
+		t.Fatalf("%d directories", len(dirs))
+	}
+	page := dirs[2]
+	if page.IsOverview() || page.IsMask() || !dirs[3].IsOverview() {
+		t.Errorf("subfile types %d and %d", page.SubfileType(), dirs[3].SubfileType())
+	}
+	// the overview belongs to the second page, which has no GeoKeys
+	if _, err := page.KeyFor(gkGeographicTypeGeoKey); err == nil {
+		t.Error("GeoKeys of the first page on the second")
+	}
+	if _, err := dirs[3].KeyFor(gkGeographicTypeGeoKey); err == nil {
+		t.Error("GeoKeys of the first page on the overview of the second")
+	}
+	// the 4 x 4 overview of the second page is not one of the first
+	res, err := tif.Resolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o, err := tif.Overview(res * 100); err != nil || o != dirs[1] {
+		t.Errorf("overview of the first page %v: %v", o, err)
+	}
+}
+
+func TestDirectoryLoop(t *testing.T) {
+	for _, back := range []int{0, 1, 2} {
+		data, offsets, nexts := chainDirectories(t,
+			testImage{sfReducedImage, 8, 8},
+			testImage{sfReducedImage, 4, 4})
+		binary.LittleEndian.PutUint32(data[nexts[2]:], offsets[back])
+		if _, err := NewDecoder(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "loops back") {
+			t.Errorf("chain back to directory %d: %v", back, err)
+		}
+	}
+}
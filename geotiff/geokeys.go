@@ -12,6 +12,13 @@ const (
 	GeoKeyGeographicType = 2048
 )
 
+// Values of the GTRasterTypeGeoKey, whether georeferencing refers to the
+// corner or to the center of a pixel
+const (
+	RasterPixelIsArea  = 1
+	RasterPixelIsPoint = 2
+)
+
 func NameForKey(key int) string {
 	name, ok := GeoKeys[key]
 	if ok {
@@ -0,0 +1,231 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"math"
+)
+
+// GeoTransform is the affine transformation from raster space (column, row)
+// to model space (x, y), in the order used by GDAL:
+//
+//	x = gt[0] + col*gt[1] + row*gt[2]
+//	y = gt[3] + col*gt[4] + row*gt[5]
+//
+// gt[2] and gt[4] are zero unless the raster is rotated or sheared, gt[5] is
+// negative for the usual north up image. Pixel (0, 0) is the top left corner
+// of the first pixel.
+type GeoTransform [6]float64
+
+// PixelToModel returns the model coordinates of a position in raster space
+func (gt GeoTransform) PixelToModel(col, row float64) (x, y float64) {
+	return gt[0] + col*gt[1] + row*gt[2], gt[3] + col*gt[4] + row*gt[5]
+}
+
+// Invert returns the transformation from model space to raster space
+func (gt GeoTransform) Invert() (GeoTransform, error) {
+	det := gt[1]*gt[5] - gt[2]*gt[4]
+	if det == 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return GeoTransform{}, GeneralIssue(fmt.Sprintf("geotransform %v cannot be inverted", gt))
+	}
+	return GeoTransform{
+		(gt[2]*gt[3] - gt[0]*gt[5]) / det,
+		gt[5] / det,
+		-gt[2] / det,
+		(gt[0]*gt[4] - gt[1]*gt[3]) / det,
+		-gt[4] / det,
+		gt[1] / det,
+	}, nil
+}
+
+// ModelToPixel returns the raster space position of model coordinates
+func (gt GeoTransform) ModelToPixel(x, y float64) (col, row float64, err error) {
+	inv, err := gt.Invert()
+	if err != nil {
+		return 0, 0, err
+	}
+	col, row = inv.PixelToModel(x, y)
+	return col, row, nil
+}
+
+// IsNorthUp is true when the raster is neither rotated nor sheared
+func (gt GeoTransform) IsNorthUp() bool {
+	return gt[2] == 0 && gt[4] == 0
+}
+
+// Translate moves the origin to the raster position (col, row), giving the
+// transformation of a window starting there
+func (gt GeoTransform) Translate(col, row float64) GeoTransform {
+	x, y := gt.PixelToModel(col, row)
+	return GeoTransform{x, gt[1], gt[2], y, gt[4], gt[5]}
+}
+
+// Scale gives the transformation of the same extent resampled with pixels sx
+// by sy times larger, as for an overview
+func (gt GeoTransform) Scale(sx, sy float64) GeoTransform {
+	return GeoTransform{gt[0], gt[1] * sx, gt[2] * sy, gt[3], gt[4] * sx, gt[5] * sy}
+}
+
+// Bounds is the model space envelope of a width x height raster, the origin
+// is the position of pixel (0, 0)
+func (gt GeoTransform) Bounds(width, height int) *Bounds {
+	b := &Bounds{MinX: math.MaxFloat64, MinY: math.MaxFloat64, MaxX: -math.MaxFloat64, MaxY: -math.MaxFloat64}
+	for _, corner := range [][2]float64{{0, 0}, {float64(width), 0}, {0, float64(height)}, {float64(width), float64(height)}} {
+		x, y := gt.PixelToModel(corner[0], corner[1])
+		b.MinX, b.MaxX = math.Min(b.MinX, x), math.Max(b.MaxX, x)
+		b.MinY, b.MaxY = math.Min(b.MinY, y), math.Max(b.MaxY, y)
+	}
+	b.OriginX, b.OriginY = gt[0], gt[3]
+	return b
+}
+
+// orientationAxes returns the model space step of a column and of a row for
+// each value of the Orientation tag, given the pixel scale
+func orientationAxes(orientation uint, sx, sy float64) (col, row [2]float64) {
+	switch orientation {
+	case 2: // row 0 top, column 0 right
+		return [2]float64{-sx, 0}, [2]float64{0, -sy}
+	case 3: // row 0 bottom, column 0 right
+		return [2]float64{-sx, 0}, [2]float64{0, sy}
+	case 4: // row 0 bottom, column 0 left
+		return [2]float64{sx, 0}, [2]float64{0, sy}
+	case 5: // row 0 left, column 0 top
+		return [2]float64{0, -sy}, [2]float64{sx, 0}
+	case 6: // row 0 right, column 0 top
+		return [2]float64{0, -sy}, [2]float64{-sx, 0}
+	case 7: // row 0 right, column 0 bottom
+		return [2]float64{0, sy}, [2]float64{-sx, 0}
+	case 8: // row 0 left, column 0 bottom
+		return [2]float64{0, sy}, [2]float64{sx, 0}
+	default: // row 0 top, column 0 left
+		return [2]float64{sx, 0}, [2]float64{0, -sy}
+	}
+}
+
+// fitTiepoints finds the affine transformation closest, in the least squares
+// sense, to tiepoints given as (I, J, K, X, Y, Z) sextuplets. At least three
+// tiepoints that are not on a line are needed.
+func fitTiepoints(tiepoints []float64) (GeoTransform, error) {
+	// normal equations of x = a + b*I + c*J and y = d + e*I + f*J
+	var m [3][3]float64
+	var vx, vy [3]float64
+	for i := 0; i+6 <= len(tiepoints); i += 6 {
+		p := [3]float64{1, tiepoints[i], tiepoints[i+1]}
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				m[r][c] += p[r] * p[c]
+			}
+			vx[r] += p[r] * tiepoints[i+3]
+			vy[r] += p[r] * tiepoints[i+4]
+		}
+	}
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-12 {
+		return GeoTransform{}, GeneralIssue(fmt.Sprintf("%d tiepoints do not define an affine transformation", len(tiepoints)/6))
+	}
+	// Cramer's rule
+	solve := func(v [3]float64) [3]float64 {
+		var s [3]float64
+		for k := 0; k < 3; k++ {
+			a := m
+			for r := 0; r < 3; r++ {
+				a[r][k] = v[r]
+			}
+			s[k] = (a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+				a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+				a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])) / det
+		}
+		return s
+	}
+	x, y := solve(vx), solve(vy)
+	return GeoTransform{x[0], x[1], x[2], y[0], y[1], y[2]}, nil
+}
+
+// RasterType is RasterPixelIsPoint when the georeferencing tags locate pixel
+// centers, RasterPixelIsArea, the default, when they locate pixel corners
+func (d *decoder) RasterType() int {
+	if k, err := d.KeyFor(gkGTRasterTypeGeoKey); err == nil && k.Value == RasterPixelIsPoint {
+		return RasterPixelIsPoint
+	}
+	return RasterPixelIsArea
+}
+
+// GeoTransform returns the raster to model transformation. It comes from the
+// ModelTransformationTag when present, otherwise from the ModelPixelScaleTag
+// and the first tiepoint, honoring the Orientation tag, or from a least
+// squares fit of three or more tiepoints when there is no pixel scale.
+// PixelIsPoint files are shifted by half a pixel so that the transformation
+// always maps pixel corners. Overviews and masks without georeferencing tags
+// are scaled from their full resolution image.
+func (d *decoder) GeoTransform() (GeoTransform, error) {
+	_, e1 := d.TagFor(tModelTransformationTag)
+	_, e2 := d.TagFor(tModelTiepointTag)
+	if e1 != nil && e2 != nil && d.parent != nil {
+		gt, err := d.parent.GeoTransform()
+		if err != nil {
+			return gt, err
+		}
+		pw, ph, e3 := d.parent.PixelDimensions()
+		w, h, e4 := d.PixelDimensions()
+		if e := checkFailure(e3, e4); e != nil {
+			return GeoTransform{}, e
+		}
+		if w == 0 || h == 0 {
+			return GeoTransform{}, GeneralIssue(fmt.Sprintf("image is %vx%v", w, h))
+		}
+		return gt.Scale(pw/w, ph/h), nil
+	}
+	gt, err := d.tagTransform()
+	if err != nil {
+		return gt, err
+	}
+	if d.RasterType() == RasterPixelIsPoint {
+		gt = gt.Translate(-0.5, -0.5)
+	}
+	return gt, nil
+}
+
+// tagTransform reads the transformation defined by the georeferencing tags
+func (d *decoder) tagTransform() (GeoTransform, error) {
+	if _, err := d.TagFor(tModelTransformationTag); err == nil {
+		m, err := d.FloatArrayValue(tModelTransformationTag)
+		if err != nil {
+			return GeoTransform{}, err
+		}
+		if len(m) < 16 {
+			return GeoTransform{}, GeneralIssue(fmt.Sprintf("ModelTransformationTag has %d values instead of 16", len(m)))
+		}
+		// the first two rows of a 4x4 row major matrix acting on (I, J, K, 1)
+		return GeoTransform{m[3], m[0], m[1], m[7], m[4], m[5]}, nil
+	}
+
+	tiepoints, err := d.FloatArrayValue(tModelTiepointTag)
+	if err != nil {
+		return GeoTransform{}, err
+	}
+	if len(tiepoints) < 6 {
+		return GeoTransform{}, GeneralIssue(fmt.Sprintf("ModelTiepointTag has %d values", len(tiepoints)))
+	}
+	if _, err := d.TagFor(tModelPixelScaleTag); err != nil {
+		if len(tiepoints) >= 18 {
+			return fitTiepoints(tiepoints)
+		}
+		return GeoTransform{}, err
+	}
+	scale, e3 := d.FloatArrayValue(tModelPixelScaleTag)
+	orientation, e4 := d.IntegerValue(tOrientation, uint(1))
+	if e := checkFailure(e3, e4); e != nil {
+		return GeoTransform{}, e
+	}
+	if len(scale) < 2 {
+		return GeoTransform{}, GeneralIssue(fmt.Sprintf("ModelPixelScaleTag has %d values", len(scale)))
+	}
+	col, row := orientationAxes(orientation, scale[0], scale[1])
+	i, j, x, y := tiepoints[0], tiepoints[1], tiepoints[3], tiepoints[4]
+	return GeoTransform{x - i*col[0] - j*row[0], col[0], row[0], y - i*col[1] - j*row[1], col[1], row[1]}, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// testDirectory builds a directory holding the given tags
+func testDirectory(tags ...*Ifd) *decoder {
+	d := &decoder{ifd: make(map[uint16]*Ifd)}
+	for _, t := range tags {
+		t.setValue()
+		d.ifd[t.tag] = t
+	}
+	return d
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestGeoTransform(t *testing.T) {
+	// 30 degrees rotation with 2 unit pixels
+	c, s := 2*math.Cos(math.Pi/6), 2*math.Sin(math.Pi/6)
+	d := testDirectory(
+		newIfd(tImageWidth, dtLong, []uint32{10}),
+		newIfd(tImageLength, dtLong, []uint32{5}),
+		newIfd(tModelTransformationTag, dtDouble, []float64{c, s, 0, 100, s, -c, 0, 200, 0, 0, 0, 0, 0, 0, 0, 1}))
+	gt, err := d.GeoTransform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gt.IsNorthUp() {
+		t.Error("rotated geotransform is north up")
+	}
+	x, y := gt.PixelToModel(3, 4)
+	if !near(x, 100+3*c+4*s) || !near(y, 200+3*s-4*c) {
+		t.Errorf("pixel 3,4 is at %v,%v", x, y)
+	}
+	col, row, err := gt.ModelToPixel(x, y)
+	if err != nil || !near(col, 3) || !near(row, 4) {
+		t.Errorf("model %v,%v is at pixel %v,%v: %v", x, y, col, row, err)
+	}
+	b, err := d.Bounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the corners are (0,0), (10,0), (0,5) and (10,5)
+	if !near(b.MinX, 100) || !near(b.MaxX, 100+10*c+5*s) || !near(b.MinY, 200-5*c) || !near(b.MaxY, 200+10*s) {
+		t.Errorf("bounds %v", b)
+	}
+
+	if _, err := (GeoTransform{1, 2, 4, 1, 1, 2}).Invert(); err == nil {
+		t.Error("expected an error for a singular geotransform")
+	}
+}
+
+func TestTiepoints(t *testing.T) {
+	want := GeoTransform{500, 0.5, 0.1, 900, 0.2, -0.5}
+	var tiepoints []float64
+	for _, p := range [][2]float64{{0, 0}, {10, 0}, {0, 10}, {10, 10}} {
+		x, y := want.PixelToModel(p[0], p[1])
+		tiepoints = append(tiepoints, p[0], p[1], 0, x, y, 0)
+	}
+	d := testDirectory(newIfd(tModelTiepointTag, dtDouble, tiepoints))
+	gt, err := d.GeoTransform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		if !near(gt[i], want[i]) {
+			t.Fatalf("fitted %v, expected %v", gt, want)
+		}
+	}
+
+	d = testDirectory(newIfd(tModelTiepointTag, dtDouble, []float64{0, 0, 0, 1, 1, 0, 1, 1, 0, 2, 2, 0, 2, 2, 0, 3, 3, 0}))
+	if _, err := d.GeoTransform(); err == nil {
+		t.Error("expected an error for tiepoints on a line")
+	}
+	d = testDirectory(newIfd(tModelTiepointTag, dtDouble, []float64{0, 0, 0, 1, 1, 0}))
+	if _, err := d.GeoTransform(); err == nil {
+		t.Error("expected an error for a tiepoint without pixel scale")
+	}
+
+	// a tiepoint away from the origin, with the first row at the bottom
+	d = testDirectory(
+		newIfd(tModelTiepointTag, dtDouble, []float64{2, 3, 0, 10, 20, 0}),
+		newIfd(tModelPixelScaleTag, dtDouble, []float64{0.5, 0.25, 0}),
+		newIfd(tOrientation, dtShort, []uint16{4}))
+	gt, err = d.GeoTransform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gt != (GeoTransform{9, 0.5, 0, 19.25, 0, 0.25}) {
+		t.Errorf("orientation 4: %v", gt)
+	}
+}
+
+func TestOverviewGeoTransform(t *testing.T) {
+	bounds := &Bounds{MinX: 0, MaxX: 100, MinY: 0, MaxY: 70}
+	f := &memFile{}
+	if err := Encode(f, testRaster(100, 70), bounds, &Options{COG: true, TileWidth: 16, TileLength: 16}); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range tif.Directories() {
+		b, err := dir.Bounds()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !near(b.MinX, 0) || !near(b.MaxX, 100) || !near(b.MinY, 0) || !near(b.MaxY, 70) {
+			t.Errorf("overview bounds %v", b)
+		}
+	}
+	gt, err := tif.Directories()[2].GeoTransform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gt[1] != 4 || !near(gt[5], -70.0/18) {
+		t.Errorf("overview 2 geotransform %v", gt)
+	}
+}
+
+func TestRasterType(t *testing.T) {
+	raster := NewRaster(4, 3)
+	for i := range raster.Data {
+		raster.Data[i] = float32(i)
+	}
+	for _, rasterType := range []uint16{RasterPixelIsArea, RasterPixelIsPoint} {
+		d := testDirectory(
+			newIfd(tImageWidth, dtLong, []uint32{4}),
+			newIfd(tImageLength, dtLong, []uint32{3}),
+			newIfd(tModelTiepointTag, dtDouble, []float64{0, 0, 0, 100, 20, 0}),
+			newIfd(tModelPixelScaleTag, dtDouble, []float64{1, 1, 0}))
+		d.geokeys = map[uint16]*GeoKey{gkGTRasterTypeGeoKey: {KeyId: gkGTRasterTypeGeoKey, Count: 1, Value: rasterType}}
+		if d.RasterType() != int(rasterType) {
+			t.Errorf("raster type %v, expected %v", d.RasterType(), rasterType)
+		}
+		b, err := d.Bounds()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the tiepoint is the center of the first pixel of PixelIsPoint files
+		shift := 0.0
+		if rasterType == RasterPixelIsPoint {
+			shift = 0.5
+		}
+		if b.MinX != 100-shift || b.MaxY != 20+shift || b.MaxX != 104-shift || b.MinY != 17+shift {
+			t.Errorf("raster type %v: bounds %v", rasterType, b)
+		}
+		v, err := d.GetValueByLonLat(100.6, 19.6, raster)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[uint16]float32{RasterPixelIsArea: 0, RasterPixelIsPoint: 1}[rasterType]; v != want {
+			t.Errorf("raster type %v: value %v, expected %v", rasterType, v, want)
+		}
+		if _, err := d.GetValueByLonLat(103.7, 19, raster); (err != nil) != (rasterType == RasterPixelIsPoint) {
+			t.Errorf("raster type %v: lookup past the east edge: %v", rasterType, err)
+		}
+	}
+}
@@ -0,0 +1,783 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Decoder for Lerc2 blobs, versions 2 to 6, following the reference
+// implementation at https://github.com/Esri/lerc. Lossless Huffman coding
+// of floating point data (version 6) is not supported.
+
+// Lerc2 data types
+const (
+	lercChar = iota
+	lercByte
+	lercShort
+	lercUShort
+	lercInt
+	lercUInt
+	lercFloat
+	lercDouble
+)
+
+var lercTypeSizes = [...]int{1, 1, 2, 2, 4, 4, 4, 8}
+
+// Values of the image encode mode byte
+const (
+	lercTiles        = 0
+	lercDeltaHuffman = 1
+	lercHuffman      = 2
+	lercFltHuffman   = 3
+)
+
+var errLercTruncated = GeneralIssue("LERC blob is truncated")
+
+// lercImage is a decoded Lerc2 blob, values are pixel interleaved
+type lercImage struct {
+	version, width, height, depth int
+	numValid, microBlockSize      int
+	dataType                      int
+	maxZError, zMin, zMax         float64
+	passNoData                    bool
+	noData, noDataOrig            float64
+	zMaxVec                       []float64
+	valid                         []bool
+	values                        []float64
+}
+
+// lercReader reads little endian values from a blob, the first read past the
+// end sets err and later reads return zeros
+type lercReader struct {
+	p   []byte
+	pos int
+	err error
+}
+
+func (r *lercReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.p) {
+		r.err = errLercTruncated
+		return make([]byte, n)
+	}
+	b := r.p[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *lercReader) u8() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *lercReader) i32() int {
+	return int(int32(binary.LittleEndian.Uint32(r.bytes(4))))
+}
+
+func (r *lercReader) f64() float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(r.bytes(8)))
+}
+
+// uintN reads an unsigned count stored in n bytes
+func (r *lercReader) uintN(n int) int {
+	switch n {
+	case 1:
+		return int(r.u8())
+	case 2:
+		return int(binary.LittleEndian.Uint16(r.bytes(2)))
+	case 4:
+		return int(binary.LittleEndian.Uint32(r.bytes(4)))
+	}
+	r.err = GeneralIssue(fmt.Sprintf("LERC count of %d bytes", n))
+	return 0
+}
+
+// value reads one value of the Lerc2 data type dt
+func (r *lercReader) value(dt int) float64 {
+	if dt < lercChar || dt > lercDouble {
+		r.err = GeneralIssue(fmt.Sprintf("LERC data type %d", dt))
+		return 0
+	}
+	b := r.bytes(lercTypeSizes[dt])
+	switch dt {
+	case lercChar:
+		return float64(int8(b[0]))
+	case lercByte:
+		return float64(b[0])
+	case lercShort:
+		return float64(int16(binary.LittleEndian.Uint16(b)))
+	case lercUShort:
+		return float64(binary.LittleEndian.Uint16(b))
+	case lercInt:
+		return float64(int32(binary.LittleEndian.Uint32(b)))
+	case lercUInt:
+		return float64(binary.LittleEndian.Uint32(b))
+	case lercFloat:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// unstuff unpacks n values of numBits bits. From version 3 on the values are
+// packed from the least significant bit of little endian words and only the
+// bytes needed are stored. Version 2 packs them from the most significant
+// bit and shifts the tail of the last word.
+func (r *lercReader) unstuff(n, numBits, version int) []uint32 {
+	out := make([]uint32, n)
+	if n == 0 || numBits == 0 {
+		return out
+	}
+	if numBits > 32 {
+		r.err = GeneralIssue(fmt.Sprintf("LERC values of %d bits", numBits))
+		return out
+	}
+	total := n * numBits
+	if version >= 3 {
+		src := r.bytes((total + 7) / 8)
+		mask := uint64(1)<<uint(numBits) - 1
+		for i := range out {
+			bit := i * numBits
+			var v uint64
+			for k := 0; k < 5 && bit/8+k < len(src); k++ {
+				v |= uint64(src[bit/8+k]) << uint(8*k)
+			}
+			out[i] = uint32((v >> uint(bit%8)) & mask)
+		}
+		return out
+	}
+	numWords := (total + 31) / 32
+	tail := 0
+	if lastBytes := ((total & 31) + 7) / 8; lastBytes > 0 {
+		tail = 4 - lastBytes
+	}
+	src := r.bytes(4*numWords - tail)
+	words := make([]uint32, numWords+1)
+	for i, b := range src {
+		words[i/4] |= uint32(b) << uint(8*(i%4))
+	}
+	words[numWords-1] <<= uint(8 * tail)
+	for i := range out {
+		bit := i * numBits
+		x := uint64(words[bit/32])<<32 | uint64(words[bit/32+1])
+		out[i] = uint32((x << uint(bit%32)) >> uint(64-numBits))
+	}
+	return out
+}
+
+// bitStuffed reads an array of at most maxCount unsigned integers, optionally
+// stored as indexes into a lookup table
+func (r *lercReader) bitStuffed(maxCount, version int) []uint32 {
+	header := r.u8()
+	countBytes := 4
+	if bits67 := int(header >> 6); bits67 != 0 {
+		countBytes = 3 - bits67
+	}
+	lookup := header&32 != 0
+	numBits := int(header & 31)
+	n := r.uintN(countBytes)
+	if r.err != nil {
+		return nil
+	}
+	if n > maxCount {
+		r.err = GeneralIssue(fmt.Sprintf("LERC array of %d values, at most %d expected", n, maxCount))
+		return nil
+	}
+	if !lookup {
+		return r.unstuff(n, numBits, version)
+	}
+	if numBits == 0 {
+		r.err = GeneralIssue("LERC lookup table of 0 bit values")
+		return nil
+	}
+	// the table is stored without its leading 0
+	numLut := int(r.u8()) - 1
+	if numLut <= 0 {
+		r.err = GeneralIssue("LERC empty lookup table")
+		return nil
+	}
+	lut := append([]uint32{0}, r.unstuff(numLut, numBits, version)...)
+	lutBits := 0
+	for numLut>>uint(lutBits) != 0 {
+		lutBits++
+	}
+	out := r.unstuff(n, lutBits, version)
+	for i, k := range out {
+		if int(k) >= len(lut) {
+			r.err = GeneralIssue("LERC lookup table index out of range")
+			return nil
+		}
+		out[i] = lut[k]
+	}
+	return out
+}
+
+// lercBits reads codes packed from the most significant bit of little endian
+// words, used for Huffman coding
+type lercBits struct {
+	words    []uint32
+	pos      int
+	overflow bool
+}
+
+func newLercBits(p []byte) *lercBits {
+	words := make([]uint32, (len(p)+3)/4+1)
+	for i, b := range p {
+		words[i/4] |= uint32(b) << uint(8*(i%4))
+	}
+	return &lercBits{words: words}
+}
+
+// peek returns the next n bits, 1 <= n <= 32
+func (b *lercBits) peek(n int) uint32 {
+	w := b.pos / 32
+	if w+1 >= len(b.words) {
+		b.overflow = true
+		return 0
+	}
+	x := uint64(b.words[w])<<32 | uint64(b.words[w+1])
+	return uint32((x << uint(b.pos%32)) >> uint(64-n))
+}
+
+// bytesUsed is the length of the words read so far
+func (b *lercBits) bytesUsed() int {
+	return 4 * ((b.pos + 31) / 32)
+}
+
+// lercTypeUsed is the data type tile offsets are stored with, the encoder
+// picks a smaller type when the value fits
+func lercTypeUsed(dt, reduction int) int {
+	switch dt {
+	case lercShort, lercInt:
+		return dt - reduction
+	case lercUShort, lercUInt:
+		return dt - 2*reduction
+	case lercFloat:
+		switch reduction {
+		case 0:
+			return lercFloat
+		case 1:
+			return lercShort
+		}
+		return lercByte
+	case lercDouble:
+		if reduction == 0 {
+			return dt
+		}
+		return dt - 2*reduction + 1
+	}
+	return dt
+}
+
+// cast converts v to the data type of the blob, as the reference
+// implementation does when storing a value
+func (img *lercImage) cast(v float64) float64 {
+	switch img.dataType {
+	case lercChar:
+		return float64(int8(int64(v)))
+	case lercByte:
+		return float64(uint8(int64(v)))
+	case lercShort:
+		return float64(int16(int64(v)))
+	case lercUShort:
+		return float64(uint16(int64(v)))
+	case lercInt:
+		return float64(int32(int64(v)))
+	case lercUInt:
+		return float64(uint32(int64(v)))
+	case lercFloat:
+		return float64(float32(v))
+	}
+	return v
+}
+
+func decodeLerc2(p []byte) (*lercImage, error) {
+	r := &lercReader{p: p}
+	if string(r.bytes(6)) != "Lerc2 " {
+		return nil, UnsupportedError("LERC blob is not Lerc2")
+	}
+	img := &lercImage{depth: 1}
+	img.version = r.i32()
+	if r.err == nil && (img.version < 2 || img.version > 6) {
+		return nil, UnsupportedError(fmt.Sprintf("Lerc2 version %d", img.version))
+	}
+	if img.version >= 3 {
+		r.bytes(4) // checksum
+	}
+	img.height = r.i32()
+	img.width = r.i32()
+	if img.version >= 4 {
+		img.depth = r.i32()
+	}
+	img.numValid = r.i32()
+	img.microBlockSize = r.i32()
+	blobSize := r.i32()
+	img.dataType = r.i32()
+	if img.version >= 6 {
+		r.i32() // number of blobs that follow
+		img.passNoData = r.bytes(4)[0] != 0
+	}
+	img.maxZError = r.f64()
+	img.zMin = r.f64()
+	img.zMax = r.f64()
+	if img.version >= 6 {
+		img.noData = r.f64()
+		img.noDataOrig = r.f64()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	n := img.width * img.height
+	if img.width <= 0 || img.height <= 0 || img.depth <= 0 || int64(n)*int64(img.depth) > 1<<30 {
+		return nil, GeneralIssue(fmt.Sprintf("LERC blob of %dx%dx%d values", img.width, img.height, img.depth))
+	}
+	if img.dataType < lercChar || img.dataType > lercDouble || img.numValid < 0 || img.numValid > n || blobSize > len(p) {
+		return nil, GeneralIssue("LERC header is corrupt")
+	}
+	if blobSize > r.pos {
+		r.p = p[:blobSize]
+	}
+	img.valid = make([]bool, n)
+	img.values = make([]float64, n*img.depth)
+
+	if err := img.readMask(r); err != nil {
+		return nil, err
+	}
+	if img.numValid == 0 {
+		return img, nil
+	}
+	if img.zMin == img.zMax {
+		img.fill(nil)
+		return img, nil
+	}
+	if img.version >= 4 {
+		zMinVec := make([]float64, img.depth)
+		img.zMaxVec = make([]float64, img.depth)
+		for i := range zMinVec {
+			zMinVec[i] = r.value(img.dataType)
+		}
+		equal := true
+		for i := range img.zMaxVec {
+			img.zMaxVec[i] = r.value(img.dataType)
+			equal = equal && zMinVec[i] == img.zMaxVec[i]
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if equal {
+			img.fill(zMinVec)
+			return img, nil
+		}
+	}
+
+	var err error
+	if oneSweep := r.u8(); oneSweep != 0 {
+		img.readOneSweep(r)
+	} else {
+		mode := lercTiles
+		switch {
+		case img.dataType <= lercByte && img.maxZError == 0.5:
+			mode = int(r.u8())
+			if mode > lercHuffman || (img.version < 4 && mode > lercDeltaHuffman) {
+				return nil, GeneralIssue(fmt.Sprintf("LERC encode mode %d", mode))
+			}
+		case img.version >= 6 && img.dataType >= lercFloat && img.maxZError == 0:
+			mode = int(r.u8())
+			if mode == lercFltHuffman {
+				return nil, UnsupportedError("LERC lossless floating point Huffman coding")
+			}
+		}
+		if mode == lercTiles {
+			err = img.readTiles(r)
+		} else {
+			err = img.readHuffman(r, mode)
+		}
+	}
+	if err == nil {
+		err = r.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if img.passNoData && img.noData != img.noDataOrig {
+		for m, v := range img.values {
+			if img.valid[m/img.depth] && v == img.noData {
+				img.values[m] = img.noDataOrig
+			}
+		}
+	}
+	return img, nil
+}
+
+// readMask reads the run length encoded bit mask of valid pixels
+func (img *lercImage) readMask(r *lercReader) error {
+	numBytes := r.i32()
+	if r.err != nil {
+		return r.err
+	}
+	n := len(img.valid)
+	switch {
+	case img.numValid == 0:
+	case img.numValid == n:
+		for k := range img.valid {
+			img.valid[k] = true
+		}
+	case numBytes > 0:
+		bits, err := lercRLE(r.bytes(numBytes), (n+7)/8)
+		if err != nil {
+			return err
+		}
+		for k := range img.valid {
+			img.valid[k] = bits[k>>3]&(0x80>>uint(k&7)) != 0
+		}
+	default:
+		return UnsupportedError("LERC blob reusing the mask of a previous blob")
+	}
+	return r.err
+}
+
+// lercRLE expands the run length encoding of the mask, runs start with a
+// 16 bit count, positive for literal bytes and negative for a repeated byte
+func lercRLE(p []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; ; {
+		if i+2 > len(p) {
+			return nil, errLercTruncated
+		}
+		count := int(int16(binary.LittleEndian.Uint16(p[i:])))
+		i += 2
+		if count == -32768 {
+			break
+		}
+		if count > 0 {
+			if i+count > len(p) {
+				return nil, errLercTruncated
+			}
+			out = append(out, p[i:i+count]...)
+			i += count
+		} else {
+			if i >= len(p) {
+				return nil, errLercTruncated
+			}
+			for j := 0; j < -count; j++ {
+				out = append(out, p[i])
+			}
+			i++
+		}
+		if len(out) > size {
+			break
+		}
+	}
+	if len(out) != size {
+		return nil, GeneralIssue(fmt.Sprintf("LERC mask of %d bytes, expected %d", len(out), size))
+	}
+	return out, nil
+}
+
+// fill sets every valid pixel to zMin, or to the minimum of its band
+func (img *lercImage) fill(zMinVec []float64) {
+	for k, ok := range img.valid {
+		if !ok {
+			continue
+		}
+		for d := 0; d < img.depth; d++ {
+			if zMinVec != nil {
+				img.values[k*img.depth+d] = zMinVec[d]
+			} else {
+				img.values[k*img.depth+d] = img.zMin
+			}
+		}
+	}
+}
+
+// readOneSweep reads the valid pixels stored uncompressed
+func (img *lercImage) readOneSweep(r *lercReader) {
+	for k, ok := range img.valid {
+		if !ok {
+			continue
+		}
+		for d := 0; d < img.depth; d++ {
+			img.values[k*img.depth+d] = r.value(img.dataType)
+		}
+	}
+}
+
+// readTiles reads the image as micro blocks, band by band within a block
+func (img *lercImage) readTiles(r *lercReader) error {
+	mb := img.microBlockSize
+	if mb <= 0 {
+		return GeneralIssue(fmt.Sprintf("LERC micro block size %d", mb))
+	}
+	for i0 := 0; i0 < img.height; i0 += mb {
+		i1 := i0 + mb
+		if i1 > img.height {
+			i1 = img.height
+		}
+		for j0 := 0; j0 < img.width; j0 += mb {
+			j1 := j0 + mb
+			if j1 > img.width {
+				j1 = img.width
+			}
+			for d := 0; d < img.depth; d++ {
+				if err := img.readTile(r, i0, i1, j0, j1, d); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readTile reads band d of one micro block. The flag byte holds the mode in
+// bits 0-1, an integrity check of j0 and from version 5 on bit 2 for values
+// stored as the difference from the previous band. Bits 6-7 select a smaller
+// type for the offset.
+func (img *lercImage) readTile(r *lercReader, i0, i1, j0, j1, d int) error {
+	flag := int(r.u8())
+	diff := false
+	if img.version >= 5 {
+		if (flag>>3)&7 != (j0>>3)&7 {
+			return GeneralIssue("LERC micro block integrity check failed")
+		}
+		diff = flag&4 != 0
+	} else if (flag>>2)&15 != (j0>>3)&15 {
+		return GeneralIssue("LERC micro block integrity check failed")
+	}
+	if diff && d == 0 {
+		return GeneralIssue("LERC difference to the previous band in the first band")
+	}
+	zMax := img.zMax
+	if img.version >= 4 && img.depth > 1 {
+		zMax = img.zMaxVec[d]
+	}
+	prev := func(m int) float64 {
+		if diff {
+			return img.values[m-1]
+		}
+		return 0
+	}
+
+	mode := flag & 3
+	switch mode {
+	case 2:
+		// constant 0
+		for i := i0; i < i1; i++ {
+			for j := j0; j < j1; j++ {
+				if k := i*img.width + j; img.valid[k] {
+					m := k*img.depth + d
+					img.values[m] = prev(m)
+				}
+			}
+		}
+		return r.err
+	case 0:
+		// uncompressed
+		if diff {
+			return GeneralIssue("LERC uncompressed micro block with difference encoding")
+		}
+		for i := i0; i < i1; i++ {
+			for j := j0; j < j1; j++ {
+				if k := i*img.width + j; img.valid[k] {
+					img.values[k*img.depth+d] = r.value(img.dataType)
+				}
+			}
+		}
+		return r.err
+	}
+
+	dt := img.dataType
+	if diff && dt < lercFloat {
+		dt = lercInt
+	}
+	offset := r.value(lercTypeUsed(dt, flag>>6))
+	if mode == 3 {
+		// constant offset
+		for i := i0; i < i1; i++ {
+			for j := j0; j < j1; j++ {
+				if k := i*img.width + j; img.valid[k] {
+					m := k*img.depth + d
+					if diff {
+						img.values[m] = img.cast(math.Min(offset+img.values[m-1], zMax))
+					} else {
+						img.values[m] = img.cast(offset)
+					}
+				}
+			}
+		}
+		return r.err
+	}
+
+	// quantized values, bit stuffed
+	maxCount := (i1 - i0) * (j1 - j0)
+	q := r.bitStuffed(maxCount, img.version)
+	if r.err != nil {
+		return r.err
+	}
+	allValid := len(q) == maxCount
+	scale := 2 * img.maxZError
+	n := 0
+	for i := i0; i < i1; i++ {
+		for j := j0; j < j1; j++ {
+			k := i*img.width + j
+			if !allValid && !img.valid[k] {
+				continue
+			}
+			if n >= len(q) {
+				return GeneralIssue("LERC micro block has too few values")
+			}
+			m := k*img.depth + d
+			img.values[m] = img.cast(math.Min(offset+float64(q[n])*scale+prev(m), zMax))
+			n++
+		}
+	}
+	return nil
+}
+
+// readHuffman reads 8 bit data that was Huffman coded, either the values
+// themselves or their difference from the left (or upper) neighbour
+func (img *lercImage) readHuffman(r *lercReader, mode int) error {
+	version := r.i32()
+	size := r.i32()
+	i0 := r.i32()
+	i1 := r.i32()
+	if r.err != nil {
+		return r.err
+	}
+	wrap := func(i int) int {
+		if i < size {
+			return i
+		}
+		return i - size
+	}
+	if version < 2 || i0 < 0 || i0 >= i1 || size <= 0 || size > 1<<16 || wrap(i0) >= size || wrap(i1-1) >= size {
+		return GeneralIssue("LERC Huffman code table is corrupt")
+	}
+	lengths := r.bitStuffed(i1-i0, img.version)
+	if r.err != nil {
+		return r.err
+	}
+	if len(lengths) != i1-i0 {
+		return GeneralIssue("LERC Huffman code table is corrupt")
+	}
+
+	bits := newLercBits(r.p[r.pos:])
+	codes := make(map[uint64]int)
+	maxLen := 0
+	for i := i0; i < i1; i++ {
+		n := int(lengths[i-i0])
+		if n == 0 {
+			continue
+		}
+		if n > 32 {
+			return GeneralIssue(fmt.Sprintf("LERC Huffman code of %d bits", n))
+		}
+		codes[uint64(n)<<32|uint64(bits.peek(n))] = wrap(i)
+		bits.pos += n
+		if n > maxLen {
+			maxLen = n
+		}
+	}
+	if bits.overflow {
+		return errLercTruncated
+	}
+	r.bytes(bits.bytesUsed())
+
+	bits = newLercBits(r.p[r.pos:])
+	next := func() (byte, error) {
+		for n := 1; n <= maxLen; n++ {
+			if v, ok := codes[uint64(n)<<32|uint64(bits.peek(n))]; ok && !bits.overflow {
+				bits.pos += n
+				if img.dataType == lercChar {
+					return byte(v - 128), nil
+				}
+				return byte(v), nil
+			}
+		}
+		if bits.overflow {
+			return 0, errLercTruncated
+		}
+		return 0, GeneralIssue("LERC Huffman code not found")
+	}
+
+	w, depth := img.width, img.depth
+	if mode == lercDeltaHuffman {
+		for d := 0; d < depth; d++ {
+			var prev byte
+			for i := 0; i < img.height; i++ {
+				for j := 0; j < w; j++ {
+					k := i*w + j
+					if !img.valid[k] {
+						continue
+					}
+					delta, err := next()
+					if err != nil {
+						return err
+					}
+					// predicted from the left, or from above at the start of a run
+					if (j == 0 || !img.valid[k-1]) && i > 0 && img.valid[k-w] {
+						delta += byte(int64(img.values[(k-w)*depth+d]))
+					} else {
+						delta += prev
+					}
+					img.values[k*depth+d] = img.byteValue(delta)
+					prev = delta
+				}
+			}
+		}
+	} else {
+		for k, ok := range img.valid {
+			if !ok {
+				continue
+			}
+			for d := 0; d < depth; d++ {
+				v, err := next()
+				if err != nil {
+					return err
+				}
+				img.values[k*depth+d] = img.byteValue(v)
+			}
+		}
+	}
+	r.bytes(bits.bytesUsed())
+	return r.err
+}
+
+// byteValue interprets b as a value of the 8 bit data type of the blob
+func (img *lercImage) byteValue(b byte) float64 {
+	if img.dataType == lercChar {
+		return float64(int8(b))
+	}
+	return float64(b)
+}
+
+// samples returns the values as TIFF samples in byteOrder, invalid pixels
+// are NaN for floating point data and 0 otherwise
+func (img *lercImage) samples(byteOrder binary.ByteOrder) []byte {
+	size := lercTypeSizes[img.dataType]
+	out := make([]byte, len(img.values)*size)
+	for m, v := range img.values {
+		if !img.valid[m/img.depth] {
+			v = 0
+			if img.dataType >= lercFloat {
+				v = math.NaN()
+			}
+		}
+		b := out[m*size:]
+		switch img.dataType {
+		case lercChar, lercByte:
+			b[0] = byte(int64(v))
+		case lercShort, lercUShort:
+			byteOrder.PutUint16(b, uint16(int64(v)))
+		case lercInt, lercUInt:
+			byteOrder.PutUint32(b, uint32(int64(v)))
+		case lercFloat:
+			byteOrder.PutUint32(b, math.Float32bits(float32(v)))
+		case lercDouble:
+			byteOrder.PutUint64(b, math.Float64bits(v))
+		}
+	}
+	return out
+}
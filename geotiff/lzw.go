@@ -0,0 +1,85 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+// LZW encoder for TIFF strips and tiles. golang.org/x/image/tiff/lzw only
+// provides a reader, and compress/lzw does not implement the "off by one"
+// code width change that TIFF readers (libtiff, x/image) expect.
+
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwFirst    = 258
+	lzwMaxCode  = 4093 // largest code assigned before the table is reset
+	lzwMinWidth = 9
+)
+
+type lzwWriter struct {
+	out   []byte
+	bits  uint32
+	nBits uint
+	width uint
+}
+
+func (w *lzwWriter) writeCode(code int) {
+	w.bits = w.bits<<w.width | uint32(code)
+	w.nBits += w.width
+	for w.nBits >= 8 {
+		w.nBits -= 8
+		w.out = append(w.out, byte(w.bits>>w.nBits))
+	}
+}
+
+func (w *lzwWriter) flush() {
+	if w.nBits > 0 {
+		w.out = append(w.out, byte(w.bits<<(8-w.nBits)))
+		w.nBits = 0
+	}
+}
+
+// lzwEncode compresses p using the TIFF variant of LZW with MSB bit order.
+func lzwEncode(p []byte) []byte {
+	w := &lzwWriter{out: make([]byte, 0, len(p)/2+16), width: lzwMinWidth}
+	table := make(map[uint32]int, 4096)
+	next := lzwFirst
+	w.writeCode(lzwClear)
+	if len(p) == 0 {
+		w.writeCode(lzwEOI)
+		w.flush()
+		return w.out
+	}
+
+	// advance mirrors the code assignment of the decoder, which switches to a
+	// wider code one entry earlier than classic LZW.
+	advance := func() {
+		next++
+		if next > lzwMaxCode {
+			w.writeCode(lzwClear)
+			table = make(map[uint32]int, 4096)
+			next = lzwFirst
+			w.width = lzwMinWidth
+		} else if next > (1<<w.width)-1 {
+			w.width++
+		}
+	}
+
+	ent := int(p[0])
+	for _, c := range p[1:] {
+		key := uint32(ent)<<8 | uint32(c)
+		if code, ok := table[key]; ok {
+			ent = code
+			continue
+		}
+		w.writeCode(ent)
+		table[key] = next
+		ent = int(c)
+		advance()
+	}
+	w.writeCode(ent)
+	advance()
+	w.writeCode(lzwEOI)
+	w.flush()
+	return w.out
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// checkPredictor validates a tPredictor value against the sample format it
+// will be applied to.
+func checkPredictor(predictor, sampleFormat, bitsPerSample uint) error {
+	switch predictor {
+	case prNone:
+		return nil
+	case prHorizontal:
+		if bitsPerSample%8 == 0 && bitsPerSample <= 64 {
+			return nil
+		}
+	case prFloatingPoint:
+		if sampleFormat == smplFloat {
+			return nil
+		}
+	}
+	return UnsupportedError(fmt.Sprintf("predictor %v with sampleFormat=%v, bitsPerSample=%v", predictor, sampleFormat, bitsPerSample))
+}
+
+// unpredict reverses the predictor applied by the encoder to a decompressed
+// strip or tile, in place. Predictors work row by row, a row holds
+// rowSamples samples of sampleSize bytes and pixels are stride samples apart.
+func unpredict(p []byte, byteOrder binary.ByteOrder, predictor uint, rowSamples, stride, sampleSize int) {
+	if predictor == prNone || rowSamples <= 0 {
+		return
+	}
+	rowLen := rowSamples * sampleSize
+	var tmp []byte
+	if predictor == prFloatingPoint {
+		tmp = make([]byte, rowLen)
+	}
+	for off := 0; off+rowLen <= len(p); off += rowLen {
+		row := p[off : off+rowLen]
+		switch predictor {
+		case prHorizontal:
+			horizontalAcc(row, byteOrder, stride, sampleSize)
+		case prFloatingPoint:
+			floatingPointAcc(row, tmp, byteOrder, stride, sampleSize)
+		}
+	}
+}
+
+// horizontalAcc undoes horizontal differencing (predictor 2), each sample was
+// stored as the difference from the same sample of the previous pixel.
+func horizontalAcc(row []byte, byteOrder binary.ByteOrder, stride, sampleSize int) {
+	n := len(row) / sampleSize
+	switch sampleSize {
+	case 1:
+		for i := stride; i < n; i++ {
+			row[i] += row[i-stride]
+		}
+	case 2:
+		for i := stride; i < n; i++ {
+			byteOrder.PutUint16(row[2*i:], byteOrder.Uint16(row[2*i:])+byteOrder.Uint16(row[2*(i-stride):]))
+		}
+	case 4:
+		for i := stride; i < n; i++ {
+			byteOrder.PutUint32(row[4*i:], byteOrder.Uint32(row[4*i:])+byteOrder.Uint32(row[4*(i-stride):]))
+		}
+	case 8:
+		for i := stride; i < n; i++ {
+			byteOrder.PutUint64(row[8*i:], byteOrder.Uint64(row[8*i:])+byteOrder.Uint64(row[8*(i-stride):]))
+		}
+	}
+}
+
+// floatingPointAcc undoes the floating point predictor (predictor 3). The
+// encoder splits each row into byte planes, most significant bytes first,
+// and then differences the bytes, see "Adobe Photoshop TIFF Technical Note 3".
+// The samples are restored in byteOrder so they decode like any other block.
+func floatingPointAcc(row, tmp []byte, byteOrder binary.ByteOrder, stride, sampleSize int) {
+	for i := stride; i < len(row); i++ {
+		row[i] += row[i-stride]
+	}
+	copy(tmp, row)
+	wc := len(row) / sampleSize
+	bigEndian := byteOrder == binary.BigEndian
+	for i := 0; i < wc; i++ {
+		for b := 0; b < sampleSize; b++ {
+			if bigEndian {
+				row[sampleSize*i+b] = tmp[b*wc+i]
+			} else {
+				row[sampleSize*i+sampleSize-1-b] = tmp[b*wc+i]
+			}
+		}
+	}
+}
+
+// predict applies a predictor to an uncompressed strip or tile, the inverse
+// of unpredict, used by the encoder.
+func predict(p []byte, byteOrder binary.ByteOrder, predictor uint, rowSamples, stride, sampleSize int) {
+	if predictor == prNone || rowSamples <= 0 {
+		return
+	}
+	rowLen := rowSamples * sampleSize
+	var tmp []byte
+	if predictor == prFloatingPoint {
+		tmp = make([]byte, rowLen)
+	}
+	for off := 0; off+rowLen <= len(p); off += rowLen {
+		row := p[off : off+rowLen]
+		switch predictor {
+		case prHorizontal:
+			horizontalDiff(row, byteOrder, stride, sampleSize)
+		case prFloatingPoint:
+			floatingPointDiff(row, tmp, byteOrder, stride, sampleSize)
+		}
+	}
+}
+
+func horizontalDiff(row []byte, byteOrder binary.ByteOrder, stride, sampleSize int) {
+	n := len(row) / sampleSize
+	switch sampleSize {
+	case 1:
+		for i := n - 1; i >= stride; i-- {
+			row[i] -= row[i-stride]
+		}
+	case 2:
+		for i := n - 1; i >= stride; i-- {
+			byteOrder.PutUint16(row[2*i:], byteOrder.Uint16(row[2*i:])-byteOrder.Uint16(row[2*(i-stride):]))
+		}
+	case 4:
+		for i := n - 1; i >= stride; i-- {
+			byteOrder.PutUint32(row[4*i:], byteOrder.Uint32(row[4*i:])-byteOrder.Uint32(row[4*(i-stride):]))
+		}
+	case 8:
+		for i := n - 1; i >= stride; i-- {
+			byteOrder.PutUint64(row[8*i:], byteOrder.Uint64(row[8*i:])-byteOrder.Uint64(row[8*(i-stride):]))
+		}
+	}
+}
+
+func floatingPointDiff(row, tmp []byte, byteOrder binary.ByteOrder, stride, sampleSize int) {
+	copy(tmp, row)
+	wc := len(row) / sampleSize
+	bigEndian := byteOrder == binary.BigEndian
+	for i := 0; i < wc; i++ {
+		for b := 0; b < sampleSize; b++ {
+			if bigEndian {
+				row[b*wc+i] = tmp[sampleSize*i+b]
+			} else {
+				row[b*wc+i] = tmp[sampleSize*i+sampleSize-1-b]
+			}
+		}
+	}
+	for i := len(row) - 1; i >= stride; i-- {
+		row[i] -= row[i-stride]
+	}
+}
@@ -0,0 +1,441 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"math"
+)
+
+// Projection converts between longitude and latitude in degrees, on the
+// ellipsoid of a datum, and projected coordinates in meters
+type Projection interface {
+	Forward(lon, lat float64) (x, y float64, err error)
+	Inverse(x, y float64) (lon, lat float64, err error)
+}
+
+// Values of the ProjCoordTransGeoKey implemented by CRS.Projection
+const (
+	ctTransverseMercator  = 1
+	ctMercator            = 7
+	ctLambertConfConic2SP = 8
+	ctLambertConfConic1SP = 9 // CT_LambertConfConic_Helmert in the GeoTIFF spec
+	ctAlbersEqualArea     = 11
+)
+
+const (
+	epsgWebMercator   = 3857
+	epsgWorldMercator = 3395
+	webMercatorRadius = 6378137.0
+)
+
+const degree = math.Pi / 180
+
+// eccentricity of an ellipsoid, 0 for a sphere
+func (e Ellipsoid) eccentricity() float64 {
+	if e.InvFlattening == 0 {
+		return 0
+	}
+	f := 1 / e.InvFlattening
+	return math.Sqrt(f * (2 - f))
+}
+
+// normalizeAngle brings a longitude difference in radians within [-pi, pi]
+func normalizeAngle(a float64) float64 {
+	for a > math.Pi {
+		a -= 2 * math.Pi
+	}
+	for a < -math.Pi {
+		a += 2 * math.Pi
+	}
+	return a
+}
+
+func checkLatitude(lat float64) error {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return GeneralIssue(fmt.Sprintf("latitude %v out of range", lat))
+	}
+	return nil
+}
+
+// transverseMercator uses the Krüger series to the third order in n, within a
+// millimeter up to 3000 km from the central meridian
+type transverseMercator struct {
+	lon0, k0, fe, fn   float64
+	northing0          float64 // northing of the latitude of origin
+	e, scale           float64 // scale is k0 times the rectifying radius A
+	alpha, beta, delta [3]float64
+}
+
+func newTransverseMercator(ellipsoid Ellipsoid, lat0, lon0, k0, fe, fn float64) *transverseMercator {
+	a := ellipsoid.SemiMajor
+	n := 0.0
+	if ellipsoid.InvFlattening != 0 {
+		f := 1 / ellipsoid.InvFlattening
+		n = f / (2 - f)
+	}
+	n2, n3 := n*n, n*n*n
+	p := &transverseMercator{
+		lon0:  lon0 * degree,
+		k0:    k0,
+		fe:    fe,
+		fn:    fn,
+		e:     ellipsoid.eccentricity(),
+		scale: k0 * a / (1 + n) * (1 + n2/4 + n2*n2/64),
+		alpha: [3]float64{n/2 - 2*n2/3 + 5*n3/16, 13*n2/48 - 3*n3/5, 61 * n3 / 240},
+		beta:  [3]float64{n/2 - 2*n2/3 + 37*n3/96, n2/48 + n3/15, 17 * n3 / 480},
+		delta: [3]float64{2*n - 2*n2/3 - 2*n3, 7*n2/3 - 8*n3/5, 56 * n3 / 15},
+	}
+	xi0 := math.Atan(p.conformal(lat0 * degree))
+	p.northing0 = xi0
+	for j := 0; j < 3; j++ {
+		p.northing0 += p.alpha[j] * math.Sin(2*float64(j+1)*xi0)
+	}
+	p.northing0 *= p.scale
+	return p
+}
+
+// conformal returns the tangent of the conformal latitude
+func (p *transverseMercator) conformal(phi float64) float64 {
+	s := math.Sin(phi)
+	return math.Sinh(math.Atanh(s) - p.e*math.Atanh(p.e*s))
+}
+
+func (p *transverseMercator) Forward(lon, lat float64) (float64, float64, error) {
+	if err := checkLatitude(lat); err != nil {
+		return 0, 0, err
+	}
+	dl := normalizeAngle(lon*degree - p.lon0)
+	if math.Abs(dl) >= math.Pi/2 {
+		return 0, 0, GeneralIssue(fmt.Sprintf("longitude %v is too far from the central meridian", lon))
+	}
+	t := p.conformal(lat * degree)
+	xi := math.Atan2(t, math.Cos(dl))
+	eta := math.Atanh(math.Sin(dl) / math.Sqrt(1+t*t))
+	x, y := eta, xi
+	for j := 0; j < 3; j++ {
+		k := 2 * float64(j+1)
+		x += p.alpha[j] * math.Cos(k*xi) * math.Sinh(k*eta)
+		y += p.alpha[j] * math.Sin(k*xi) * math.Cosh(k*eta)
+	}
+	return p.fe + p.scale*x, p.fn + p.scale*y - p.northing0, nil
+}
+
+func (p *transverseMercator) Inverse(x, y float64) (float64, float64, error) {
+	xi := (y - p.fn + p.northing0) / p.scale
+	eta := (x - p.fe) / p.scale
+	xi1, eta1 := xi, eta
+	for j := 0; j < 3; j++ {
+		k := 2 * float64(j+1)
+		xi1 -= p.beta[j] * math.Sin(k*xi) * math.Cosh(k*eta)
+		eta1 -= p.beta[j] * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+	chi := math.Asin(math.Sin(xi1) / math.Cosh(eta1))
+	phi := chi
+	for j := 0; j < 3; j++ {
+		phi += p.delta[j] * math.Sin(2*float64(j+1)*chi)
+	}
+	lon := normalizeAngle(p.lon0 + math.Atan2(math.Sinh(eta1), math.Cos(xi1)))
+	return lon / degree, phi / degree, nil
+}
+
+// isometric returns the inverse of the t function of Snyder (15-9), the
+// isometric latitude exp(psi)
+func isometric(phi, e float64) float64 {
+	s := e * math.Sin(phi)
+	return math.Tan(math.Pi/4+phi/2) * math.Pow((1-s)/(1+s), e/2)
+}
+
+// latitudeFromT inverts Snyder's t function (7-9) by iteration
+func latitudeFromT(t, e float64) float64 {
+	phi := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		s := e * math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-s)/(1+s), e/2))
+		if math.Abs(next-phi) < 1e-12 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
+
+// mercator is the normal Mercator projection, spherical for Web Mercator
+type mercator struct {
+	lon0, fe, fn, e, scale float64
+}
+
+func (p *mercator) Forward(lon, lat float64) (float64, float64, error) {
+	if err := checkLatitude(lat); err != nil {
+		return 0, 0, err
+	}
+	if math.Abs(lat) == 90 {
+		return 0, 0, GeneralIssue("the poles are at infinity in the Mercator projection")
+	}
+	dl := normalizeAngle(lon*degree - p.lon0)
+	return p.fe + p.scale*dl, p.fn + p.scale*math.Log(isometric(lat*degree, p.e)), nil
+}
+
+func (p *mercator) Inverse(x, y float64) (float64, float64, error) {
+	phi := latitudeFromT(math.Exp(-(y-p.fn)/p.scale), p.e)
+	lon := normalizeAngle(p.lon0 + (x-p.fe)/p.scale)
+	return lon / degree, phi / degree, nil
+}
+
+// snyderM is m of Snyder (14-15)
+func snyderM(phi, e float64) float64 {
+	s := e * math.Sin(phi)
+	return math.Cos(phi) / math.Sqrt(1-s*s)
+}
+
+// lambertConformal is the Lambert Conformal Conic with one or two standard
+// parallels
+type lambertConformal struct {
+	lon0, fe, fn, e float64
+	n, aF, rho0     float64 // aF is a*F*k0
+}
+
+func newLambertConformal2SP(ellipsoid Ellipsoid, lat1, lat2, latF, lonF, fe, fn float64) (*lambertConformal, error) {
+	e := ellipsoid.eccentricity()
+	phi1, phi2 := lat1*degree, lat2*degree
+	m1, m2 := snyderM(phi1, e), snyderM(phi2, e)
+	t1, t2 := 1/isometric(phi1, e), 1/isometric(phi2, e)
+	var n float64
+	if math.Abs(phi1-phi2) < 1e-12 {
+		n = math.Sin(phi1)
+	} else {
+		n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+	if n == 0 || math.IsNaN(n) {
+		return nil, GeneralIssue(fmt.Sprintf("standard parallels %v and %v do not define a cone", lat1, lat2))
+	}
+	p := &lambertConformal{lon0: lonF * degree, fe: fe, fn: fn, e: e, n: n}
+	p.aF = ellipsoid.SemiMajor * m1 / (n * math.Pow(t1, n))
+	p.rho0 = p.rho(latF * degree)
+	return p, nil
+}
+
+func newLambertConformal1SP(ellipsoid Ellipsoid, lat0, lon0, k0, fe, fn float64) (*lambertConformal, error) {
+	e := ellipsoid.eccentricity()
+	phi0 := lat0 * degree
+	n := math.Sin(phi0)
+	if n == 0 {
+		return nil, GeneralIssue("the latitude of origin of a Lambert Conformal Conic cannot be 0")
+	}
+	p := &lambertConformal{lon0: lon0 * degree, fe: fe, fn: fn, e: e, n: n}
+	p.aF = ellipsoid.SemiMajor * k0 * snyderM(phi0, e) / (n * math.Pow(1/isometric(phi0, e), n))
+	p.rho0 = p.rho(phi0)
+	return p, nil
+}
+
+func (p *lambertConformal) rho(phi float64) float64 {
+	if math.Abs(math.Abs(phi)-math.Pi/2) < 1e-12 {
+		if phi*p.n > 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return p.aF * math.Pow(1/isometric(phi, p.e), p.n)
+}
+
+func (p *lambertConformal) Forward(lon, lat float64) (float64, float64, error) {
+	if err := checkLatitude(lat); err != nil {
+		return 0, 0, err
+	}
+	rho := p.rho(lat * degree)
+	if math.IsInf(rho, 0) {
+		return 0, 0, GeneralIssue(fmt.Sprintf("latitude %v is at infinity in this Lambert Conformal Conic", lat))
+	}
+	theta := p.n * normalizeAngle(lon*degree-p.lon0)
+	return p.fe + rho*math.Sin(theta), p.fn + p.rho0 - rho*math.Cos(theta), nil
+}
+
+func (p *lambertConformal) Inverse(x, y float64) (float64, float64, error) {
+	dx, dy := x-p.fe, p.rho0-(y-p.fn)
+	rho := math.Copysign(math.Hypot(dx, dy), p.n)
+	if p.n < 0 {
+		dx, dy = -dx, -dy
+	}
+	theta := math.Atan2(dx, dy)
+	var phi float64
+	if rho == 0 {
+		phi = math.Copysign(math.Pi/2, p.n)
+	} else {
+		phi = latitudeFromT(math.Pow(rho/p.aF, 1/p.n), p.e)
+	}
+	lon := normalizeAngle(theta/p.n + p.lon0)
+	return lon / degree, phi / degree, nil
+}
+
+// albers is the Albers Equal Area Conic
+type albers struct {
+	lon0, fe, fn, e, a float64
+	n, c, rho0         float64
+}
+
+// snyderQ is q of Snyder (3-12)
+func snyderQ(phi, e float64) float64 {
+	s := math.Sin(phi)
+	if e == 0 {
+		return 2 * s
+	}
+	es := e * s
+	return (1 - e*e) * (s/(1-es*es) - math.Log((1-es)/(1+es))/(2*e))
+}
+
+func newAlbers(ellipsoid Ellipsoid, lat1, lat2, lat0, lon0, fe, fn float64) (*albers, error) {
+	e := ellipsoid.eccentricity()
+	phi1, phi2 := lat1*degree, lat2*degree
+	m1, m2 := snyderM(phi1, e), snyderM(phi2, e)
+	q1, q2 := snyderQ(phi1, e), snyderQ(phi2, e)
+	var n float64
+	if math.Abs(phi1-phi2) < 1e-12 {
+		n = math.Sin(phi1)
+	} else {
+		n = (m1*m1 - m2*m2) / (q2 - q1)
+	}
+	if n == 0 || math.IsNaN(n) {
+		return nil, GeneralIssue(fmt.Sprintf("standard parallels %v and %v do not define a cone", lat1, lat2))
+	}
+	p := &albers{lon0: lon0 * degree, fe: fe, fn: fn, e: e, a: ellipsoid.SemiMajor, n: n, c: m1*m1 + n*q1}
+	p.rho0 = p.rho(lat0 * degree)
+	return p, nil
+}
+
+func (p *albers) rho(phi float64) float64 {
+	return p.a * math.Sqrt(math.Max(0, p.c-p.n*snyderQ(phi, p.e))) / p.n
+}
+
+func (p *albers) Forward(lon, lat float64) (float64, float64, error) {
+	if err := checkLatitude(lat); err != nil {
+		return 0, 0, err
+	}
+	rho := p.rho(lat * degree)
+	theta := p.n * normalizeAngle(lon*degree-p.lon0)
+	return p.fe + rho*math.Sin(theta), p.fn + p.rho0 - rho*math.Cos(theta), nil
+}
+
+func (p *albers) Inverse(x, y float64) (float64, float64, error) {
+	dx, dy := x-p.fe, p.rho0-(y-p.fn)
+	rho := math.Hypot(dx, dy)
+	if p.n < 0 {
+		dx, dy = -dx, -dy
+	}
+	theta := math.Atan2(dx, dy)
+	q := (p.c - rho*rho*p.n*p.n/(p.a*p.a)) / p.n
+	qp := snyderQ(math.Pi/2, p.e)
+	var phi float64
+	if math.Abs(q) >= qp {
+		phi = math.Copysign(math.Pi/2, q)
+	} else {
+		// Snyder (3-16)
+		e2 := p.e * p.e
+		phi = math.Asin(q / 2)
+		for i := 0; i < 15 && p.e != 0; i++ {
+			s := math.Sin(phi)
+			es := p.e * s
+			d := (1 - es*es) * (1 - es*es) / (2 * math.Cos(phi)) *
+				(q/(1-e2) - s/(1-es*es) + math.Log((1-es)/(1+es))/(2*p.e))
+			phi += d
+			if math.Abs(d) < 1e-12 {
+				break
+			}
+		}
+	}
+	lon := normalizeAngle(theta/p.n + p.lon0)
+	return lon / degree, phi / degree, nil
+}
+
+// angleDegrees converts an angle in EPSG angular units to degrees
+func angleDegrees(v float64, units int) float64 {
+	switch units {
+	case 9101: // radian
+		return v / degree
+	case 9103: // arc-minute
+		return v / 60
+	case 9104: // arc-second
+		return v / 3600
+	case 9105, 9106: // grad, gon
+		return v * 0.9
+	}
+	return v
+}
+
+// Projector returns the Projection of a projected CRS, taking and returning
+// meters. Transverse Mercator, Mercator, Lambert Conformal Conic with one or
+// two standard parallels and Albers Equal Area are supported.
+func (c *CRS) Projector() (Projection, error) {
+	if !c.IsProjected() {
+		return nil, GeneralIssue(fmt.Sprintf("%v is not projected", c))
+	}
+	if c.Ellipsoid.SemiMajor == 0 {
+		return nil, GeneralIssue(fmt.Sprintf("%v has no ellipsoid", c))
+	}
+	unit := c.LinearUnitSize
+	if unit == 0 {
+		unit = 1
+	}
+	// param returns the first of the keys present, the GeoTIFF spec and
+	// common writers disagree on the keys of conic projections
+	param := func(keys ...int) float64 {
+		for _, k := range keys {
+			if v, ok := c.Parameters[k]; ok {
+				return v
+			}
+		}
+		return 0
+	}
+	angle := func(keys ...int) float64 {
+		return angleDegrees(param(keys...), c.AngularUnits)
+	}
+	distance := func(keys ...int) float64 {
+		return param(keys...) * unit
+	}
+	scale := func(key int) float64 {
+		if v, ok := c.Parameters[key]; ok && v != 0 {
+			return v
+		}
+		return 1
+	}
+
+	switch c.CoordTrans {
+	case ctTransverseMercator:
+		return newTransverseMercator(c.Ellipsoid,
+			angle(gkProjNatOriginLatGeoKey), angle(gkProjNatOriginLongGeoKey), scale(gkProjScaleAtNatOriginGeoKey),
+			distance(gkProjFalseEastingGeoKey), distance(gkProjFalseNorthingGeoKey)), nil
+	case ctMercator:
+		p := &mercator{
+			lon0: angle(gkProjNatOriginLongGeoKey, gkProjCenterLongGeoKey) * degree,
+			fe:   distance(gkProjFalseEastingGeoKey),
+			fn:   distance(gkProjFalseNorthingGeoKey),
+		}
+		if c.EPSG == epsgWebMercator {
+			p.scale = webMercatorRadius
+			return p, nil
+		}
+		p.e = c.Ellipsoid.eccentricity()
+		k0 := scale(gkProjScaleAtNatOriginGeoKey)
+		if _, ok := c.Parameters[gkProjStdParallel1GeoKey]; ok {
+			k0 = snyderM(angle(gkProjStdParallel1GeoKey)*degree, p.e)
+		}
+		p.scale = c.Ellipsoid.SemiMajor * k0
+		return p, nil
+	case ctLambertConfConic2SP:
+		return newLambertConformal2SP(c.Ellipsoid,
+			angle(gkProjStdParallel1GeoKey), angle(gkProjStdParallel2GeoKey),
+			angle(gkProjFalseOriginLatGeoKey, gkProjNatOriginLatGeoKey), angle(gkProjFalseOriginLongGeoKey, gkProjNatOriginLongGeoKey),
+			distance(gkProjFalseOriginEastingGeoKey, gkProjFalseEastingGeoKey), distance(gkProjFalseOriginNorthingGeoKey, gkProjFalseNorthingGeoKey))
+	case ctLambertConfConic1SP:
+		return newLambertConformal1SP(c.Ellipsoid,
+			angle(gkProjNatOriginLatGeoKey), angle(gkProjNatOriginLongGeoKey), scale(gkProjScaleAtNatOriginGeoKey),
+			distance(gkProjFalseEastingGeoKey), distance(gkProjFalseNorthingGeoKey))
+	case ctAlbersEqualArea:
+		return newAlbers(c.Ellipsoid,
+			angle(gkProjStdParallel1GeoKey), angle(gkProjStdParallel2GeoKey),
+			angle(gkProjNatOriginLatGeoKey, gkProjFalseOriginLatGeoKey, gkProjCenterLatGeoKey), angle(gkProjNatOriginLongGeoKey, gkProjFalseOriginLongGeoKey, gkProjCenterLongGeoKey),
+			distance(gkProjFalseEastingGeoKey, gkProjFalseOriginEastingGeoKey), distance(gkProjFalseNorthingGeoKey, gkProjFalseOriginNorthingGeoKey))
+	}
+	return nil, UnsupportedError(fmt.Sprintf("projection method %v", ProjCoordTransGeoKey[c.CoordTrans]))
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+var clarke1866 = Ellipsoid{Code: 7008, SemiMajor: 6378206.4, InvFlattening: 294.9786982}
+
+func TestProjections(t *testing.T) {
+	// worked examples of Snyder, Map Projections: A Working Manual
+	tests := []struct {
+		name       string
+		coordTrans int
+		params     map[int]float64
+		lon, lat   float64
+		x, y       float64
+	}{
+		{"transverse mercator", ctTransverseMercator, map[int]float64{
+			gkProjNatOriginLongGeoKey: -75, gkProjScaleAtNatOriginGeoKey: 0.9996,
+		}, -73.5, 40.5, 127106.5, 4484124.4},
+		{"mercator", ctMercator, map[int]float64{
+			gkProjNatOriginLongGeoKey: -180,
+		}, -75, 35, 11688673.7, 4139145.6},
+		{"lambert conformal conic", ctLambertConfConic2SP, map[int]float64{
+			gkProjStdParallel1GeoKey: 33, gkProjStdParallel2GeoKey: 45, gkProjFalseOriginLatGeoKey: 23, gkProjFalseOriginLongGeoKey: -96,
+		}, -75, 35, 1894410.9, 1564649.5},
+		{"albers", ctAlbersEqualArea, map[int]float64{
+			gkProjStdParallel1GeoKey: 29.5, gkProjStdParallel2GeoKey: 45.5, gkProjNatOriginLatGeoKey: 23, gkProjNatOriginLongGeoKey: -96,
+		}, -75, 35, 1885472.7, 1535925.0},
+	}
+	for _, test := range tests {
+		c := &CRS{ModelType: ModelTypeProjected, Ellipsoid: clarke1866, AngularUnits: 9102, CoordTrans: test.coordTrans, Parameters: test.params}
+		p, err := c.Projector()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		x, y, err := p.Forward(test.lon, test.lat)
+		if err != nil || math.Abs(x-test.x) > 0.1 || math.Abs(y-test.y) > 0.1 {
+			t.Errorf("%s: %v,%v projects to %.1f,%.1f, expected %v,%v: %v", test.name, test.lon, test.lat, x, y, test.x, test.y, err)
+		}
+		lon, lat, err := p.Inverse(test.x, test.y)
+		if err != nil || math.Abs(lon-test.lon) > 1e-6 || math.Abs(lat-test.lat) > 1e-6 {
+			t.Errorf("%s: inverse %v,%v: %v", test.name, lon, lat, err)
+		}
+	}
+
+	web, err := LookupEPSG(epsgWebMercator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := web.Projector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, _, _ := p.Forward(180, 0); math.Abs(x-20037508.342789244) > 1e-6 {
+		t.Errorf("web mercator x %v", x)
+	}
+	if _, _, err := p.Forward(0, 91); err == nil {
+		t.Error("expected an error for latitude 91")
+	}
+}
+
+func TestTransformer(t *testing.T) {
+	wgs84, _ := LookupEPSG(4326)
+	utm, err := LookupEPSG(UTMZone(10, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utm.EPSG != 32632 {
+		t.Errorf("UTM zone of 10E 50N is EPSG:%d", utm.EPSG)
+	}
+	tr, err := NewTransformer(wgs84, utm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y, err := tr.Transform(10, 50)
+	if err != nil || math.Abs(x-571666.45) > 0.01 || math.Abs(y-5539109.82) > 0.01 {
+		t.Errorf("10E 50N is %v,%v in UTM 32N: %v", x, y, err)
+	}
+
+	// NAD27 is shifted by tens of meters from WGS 84 and back again
+	nad27, err := LookupEPSG(4267)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err = NewTransformer(nad27, wgs84)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lon, lat, err := tr.Transform(-100, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shift := math.Hypot((lon+100)*85000, (lat-40)*111000); shift < 10 || shift > 100 {
+		t.Errorf("NAD27 shift of %v meters", shift)
+	}
+	back, _ := NewTransformer(wgs84, nad27)
+	lon, lat, _ = back.Transform(lon, lat)
+	if math.Abs(lon+100) > 1e-8 || math.Abs(lat-40) > 1e-8 {
+		t.Errorf("NAD27 round trip %v,%v", lon, lat)
+	}
+
+	if _, err := NewTransformer(&CRS{ModelType: ModelTypeGeographic, Datum: 6999, Ellipsoid: clarke1866}, wgs84); err == nil {
+		t.Error("expected an error for a datum without shift")
+	}
+}
+
+func TestLonLatBounds(t *testing.T) {
+	bounds := &Bounds{MinX: 500000, MaxX: 510000, MinY: 5500000, MaxY: 5507000}
+	f := &memFile{}
+	if err := Encode(f, testRaster(100, 70), bounds, &Options{ProjectedCSType: 32632}); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := tif.LonLatBounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the central meridian of zone 32 is 9E, 5500 km north is about 49.65N
+	if math.Abs(b.MinX-9) > 1e-6 || b.MaxX < 9.13 || b.MaxX > 9.15 || math.Abs(b.MinY-49.652) > 0.001 || math.Abs(b.MaxY-49.715) > 0.001 {
+		t.Errorf("lon lat bounds %v", b)
+	}
+	res, err := tif.Resolution()
+	if err != nil || math.Abs(res-100) > 1 {
+		t.Errorf("resolution %v: %v", res, err)
+	}
+	w, h, err := tif.DimensionMeters()
+	if err != nil || math.Abs(w-10000) > 100 || math.Abs(h-7000) > 100 {
+		t.Errorf("dimensions %vx%v: %v", w, h, err)
+	}
+}
@@ -0,0 +1,420 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// quantizedMax is the largest quantized coordinate and height of a mesh
+const quantizedMax = 32767
+
+// extensionOctNormals is the id of the oct encoded vertex normals extension
+const extensionOctNormals = 1
+
+// GeographicTileBounds returns the bounds in degrees of a tile of the
+// geographic TMS scheme of Cesium terrain: zoom 0 is two tiles, west and
+// east, and rows count from the south.
+func GeographicTileBounds(z, x, y int) *Bounds {
+	size := 180 / float64(uint(1)<<uint(z))
+	minX, minY := -180+float64(x)*size, -90+float64(y)*size
+	return &Bounds{MinX: minX, MaxX: minX + size, MinY: minY, MaxY: minY + size, OriginX: minX, OriginY: minY + size}
+}
+
+// QuantizedMeshOptions control EncodeQuantizedMesh
+type QuantizedMeshOptions struct {
+	// MaxError is the largest difference in meters between the mesh and the
+	// raster, zero only merges exactly planar triangles
+	MaxError float64
+	// Normals adds the oct encoded vertex normals extension
+	Normals bool
+}
+
+// EncodeQuantizedMesh writes r as a quantized-mesh-1.0 terrain tile. r holds
+// heights in meters above the WGS 84 ellipsoid on a square grid of 2^k+1
+// pixels a side whose edge pixels are centered on the edges of bounds, in
+// degrees. Nodata pixels are taken as height 0.
+func EncodeQuantizedMesh(w io.Writer, r *Raster, bounds *Bounds, opts *QuantizedMeshOptions) error {
+	o := QuantizedMeshOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	tin, err := NewTIN(r, o.MaxError)
+	if err != nil {
+		return err
+	}
+	last := float64(r.w - 1)
+	height := func(v [2]int) float64 {
+		h := r.ValueAt(v[1], v[0])
+		if h == noData || math.IsNaN(float64(h)) {
+			return 0
+		}
+		return float64(h)
+	}
+	minH, maxH := math.Inf(1), math.Inf(-1)
+	for _, v := range tin.Vertices {
+		minH, maxH = math.Min(minH, height(v)), math.Max(maxH, height(v))
+	}
+
+	// earth centered positions of the vertices
+	wgs84, _ := LookupEPSG(4326)
+	positions := make([][3]float64, len(tin.Vertices))
+	lo, hi := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}, [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for i, v := range tin.Vertices {
+		lon := bounds.MinX + float64(v[0])/last*(bounds.MaxX-bounds.MinX)
+		lat := bounds.MaxY - float64(v[1])/last*(bounds.MaxY-bounds.MinY)
+		x, y, z := geocentric(lon, lat, height(v), wgs84.Ellipsoid)
+		positions[i] = [3]float64{x, y, z}
+		for k := 0; k < 3; k++ {
+			lo[k], hi[k] = math.Min(lo[k], positions[i][k]), math.Max(hi[k], positions[i][k])
+		}
+	}
+	center := [3]float64{(lo[0] + hi[0]) / 2, (lo[1] + hi[1]) / 2, (lo[2] + hi[2]) / 2}
+	radius := 0.0
+	for _, p := range positions {
+		radius = math.Max(radius, norm(sub(p, center)))
+	}
+	horizon := horizonOcclusionPoint(positions, center, wgs84.Ellipsoid)
+
+	b := &bytes.Buffer{}
+	le := binary.LittleEndian
+	for _, v := range center {
+		binary.Write(b, le, v)
+	}
+	binary.Write(b, le, float32(minH))
+	binary.Write(b, le, float32(maxH))
+	for _, v := range center {
+		binary.Write(b, le, v)
+	}
+	binary.Write(b, le, radius)
+	for _, v := range horizon {
+		binary.Write(b, le, v)
+	}
+
+	// vertices, quantized and zig zag delta encoded
+	n := len(tin.Vertices)
+	us, vs, hs := make([]int, n), make([]int, n), make([]int, n)
+	for i, v := range tin.Vertices {
+		us[i] = int(math.Floor(float64(v[0])/last*quantizedMax + 0.5))
+		vs[i] = int(math.Floor((last-float64(v[1]))/last*quantizedMax + 0.5))
+		if maxH > minH {
+			hs[i] = int(math.Floor((height(v)-minH)/(maxH-minH)*quantizedMax + 0.5))
+		}
+	}
+	binary.Write(b, le, uint32(n))
+	for _, values := range [][]int{us, vs, hs} {
+		prev := 0
+		for _, v := range values {
+			binary.Write(b, le, zigZag(v-prev))
+			prev = v
+		}
+	}
+
+	// indices, high water mark encoded
+	wide := n > 65536
+	writeIndex := func(i int) {
+		if wide {
+			binary.Write(b, le, uint32(i))
+		} else {
+			binary.Write(b, le, uint16(i))
+		}
+	}
+	align := 2
+	if wide {
+		align = 4
+	}
+	for b.Len()%align != 0 {
+		b.WriteByte(0)
+	}
+	binary.Write(b, le, uint32(len(tin.Triangles)))
+	highest := 0
+	for _, t := range tin.Triangles {
+		for _, i := range t {
+			writeIndex(highest - i)
+			if i == highest {
+				highest++
+			}
+		}
+	}
+
+	// the vertices of the west, south, east and north edges
+	for _, edge := range []func(i int) bool{
+		func(i int) bool { return us[i] == 0 },
+		func(i int) bool { return vs[i] == 0 },
+		func(i int) bool { return us[i] == quantizedMax },
+		func(i int) bool { return vs[i] == quantizedMax },
+	} {
+		var indices []int
+		for i := 0; i < n; i++ {
+			if edge(i) {
+				indices = append(indices, i)
+			}
+		}
+		binary.Write(b, le, uint32(len(indices)))
+		for _, i := range indices {
+			writeIndex(i)
+		}
+	}
+
+	if o.Normals {
+		b.WriteByte(extensionOctNormals)
+		binary.Write(b, le, uint32(2*n))
+		for _, normal := range vertexNormals(tin, positions) {
+			x, y := octEncode(normal)
+			b.WriteByte(x)
+			b.WriteByte(y)
+		}
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+func zigZag(v int) uint16 {
+	return uint16((v << 1) ^ (v >> 63))
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func norm(a [3]float64) float64 {
+	return math.Sqrt(dot(a, a))
+}
+
+func scale(a [3]float64, s float64) [3]float64 {
+	return [3]float64{a[0] * s, a[1] * s, a[2] * s}
+}
+
+// horizonOcclusionPoint returns the point, in the space of the ellipsoid
+// scaled to a unit sphere, beyond which the horizon hides every position, as
+// computed by the EllipsoidalOccluder of Cesium
+func horizonOcclusionPoint(positions [][3]float64, center [3]float64, ellipsoid Ellipsoid) [3]float64 {
+	radii := [3]float64{ellipsoid.SemiMajor, ellipsoid.SemiMajor, ellipsoid.SemiMinor()}
+	scaled := func(p [3]float64) [3]float64 {
+		return [3]float64{p[0] / radii[0], p[1] / radii[1], p[2] / radii[2]}
+	}
+	direction := scaled(center)
+	direction = scale(direction, 1/norm(direction))
+	magnitude := 0.0
+	for _, p := range positions {
+		s := scaled(p)
+		m := norm(s)
+		unit := scale(s, 1/m)
+		m = math.Max(1, m)
+		cosAlpha := dot(unit, direction)
+		sinAlpha := norm(cross(unit, direction))
+		cosBeta := 1 / m
+		sinBeta := math.Sqrt(m*m-1) * cosBeta
+		magnitude = math.Max(magnitude, 1/(cosAlpha*cosBeta-sinAlpha*sinBeta))
+	}
+	return scale(direction, magnitude)
+}
+
+// vertexNormals averages the normals of the triangles around each vertex,
+// weighted by their area
+func vertexNormals(tin *TIN, positions [][3]float64) [][3]float64 {
+	normals := make([][3]float64, len(positions))
+	for _, t := range tin.Triangles {
+		a, b, c := positions[t[0]], positions[t[1]], positions[t[2]]
+		face := cross(sub(b, a), sub(c, a))
+		for _, i := range t {
+			normals[i] = [3]float64{normals[i][0] + face[0], normals[i][1] + face[1], normals[i][2] + face[2]}
+		}
+	}
+	for i, n := range normals {
+		if l := norm(n); l > 0 {
+			normals[i] = scale(n, 1/l)
+		}
+	}
+	return normals
+}
+
+// octEncode maps a unit vector to the two bytes of the octahedron encoding
+func octEncode(n [3]float64) (uint8, uint8) {
+	signNotZero := func(v float64) float64 {
+		if v < 0 {
+			return -1
+		}
+		return 1
+	}
+	l1 := math.Abs(n[0]) + math.Abs(n[1]) + math.Abs(n[2])
+	if l1 == 0 {
+		return 128, 128
+	}
+	x, y := n[0]/l1, n[1]/l1
+	if n[2] < 0 {
+		x, y = (1-math.Abs(y))*signNotZero(x), (1-math.Abs(x))*signNotZero(y)
+	}
+	snorm := func(v float64) uint8 {
+		return uint8(math.Floor((math.Max(-1, math.Min(1, v))*0.5+0.5)*255 + 0.5))
+	}
+	return snorm(x), snorm(y)
+}
+
+// TerrainLayer is the layer.json of a quantized-mesh terrain pyramid
+type TerrainLayer struct {
+	TileJSON   string        `json:"tilejson"`
+	Name       string        `json:"name,omitempty"`
+	Format     string        `json:"format"`
+	Version    string        `json:"version"`
+	Scheme     string        `json:"scheme"`
+	Tiles      []string      `json:"tiles"`
+	Projection string        `json:"projection"`
+	Bounds     []float64     `json:"bounds"`
+	MinZoom    int           `json:"minzoom"`
+	MaxZoom    int           `json:"maxzoom"`
+	Extensions []string      `json:"extensions,omitempty"`
+	Available  [][]TileRange `json:"available"`
+}
+
+// TileRange is a rectangle of available tiles, inclusive
+type TileRange struct {
+	StartX int `json:"startX"`
+	StartY int `json:"startY"`
+	EndX   int `json:"endX"`
+	EndY   int `json:"endY"`
+}
+
+// Write writes the layer as JSON
+func (l *TerrainLayer) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// TerrainOptions control GenerateTerrain
+type TerrainOptions struct {
+	// MaxZoom is the deepest zoom level, zero uses the BaseZoom of the source
+	MaxZoom int
+	// GridSize is the number of heights a side sampled for each tile, 2^k+1,
+	// 65 by default
+	GridSize int
+	// MaxError is the largest difference in meters between a mesh and the
+	// heights sampled for its tile
+	MaxError float64
+	// Normals adds oct encoded vertex normals to the tiles
+	Normals bool
+	// Resampling is the kernel sampling the source
+	Resampling Resampling
+}
+
+// GenerateTerrain cuts an elevation source into quantized-mesh-1.0 tiles of
+// the geographic scheme of Cesium, from zoom 0 to opts.MaxZoom, and writes
+// them to sink with rows counted from the south as in TMS. Both tiles of zoom
+// 0 are always written, heights outside of the source are 0. Sources without
+// GeoKeys are taken to be WGS 84 longitude and latitude. It returns the
+// layer.json of the pyramid.
+func GenerateTerrain(src Tiff, sink TileSink, opts *TerrainOptions) (*TerrainLayer, error) {
+	o := TerrainOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxZoom == 0 {
+		if o.MaxZoom = src.BaseZoom(); o.MaxZoom < 0 {
+			return nil, GeneralIssue("GenerateTerrain: the base zoom of the source is unknown")
+		}
+	}
+	if o.MaxZoom < 0 || o.MaxZoom > 30 {
+		return nil, GeneralIssue(fmt.Sprintf("GenerateTerrain: zoom level %d", o.MaxZoom))
+	}
+	if o.GridSize == 0 {
+		o.GridSize = 65
+	}
+	if n := o.GridSize - 1; n < 1 || n&(n-1) != 0 {
+		return nil, GeneralIssue(fmt.Sprintf("GenerateTerrain: grid size %d is not 2^k+1", o.GridSize))
+	}
+	m, err := src.Bands()
+	if err != nil {
+		return nil, err
+	}
+	gt, err := src.GeoTransform()
+	if err != nil {
+		return nil, err
+	}
+	wgs84, _ := LookupEPSG(4326)
+	crs, err := src.CRS()
+	if err != nil {
+		crs = wgs84
+	}
+	toLonLat, err := NewTransformer(crs, wgs84)
+	if err != nil {
+		return nil, err
+	}
+	extent, err := toLonLat.TransformBounds(gt.Bounds(m.Width(), m.Height()))
+	if err != nil {
+		return nil, err
+	}
+	extent = &Bounds{MinX: math.Max(extent.MinX, -180), MaxX: math.Min(extent.MaxX, 180),
+		MinY: math.Max(extent.MinY, -90), MaxY: math.Min(extent.MaxY, 90)}
+	if extent.MinX >= extent.MaxX || extent.MinY >= extent.MaxY {
+		return nil, GeneralIssue(fmt.Sprintf("GenerateTerrain: empty extent %v", extent))
+	}
+
+	layer := &TerrainLayer{
+		TileJSON:   "2.1.0",
+		Format:     "quantized-mesh-1.0",
+		Version:    "1.0.0",
+		Scheme:     "tms",
+		Tiles:      []string{"{z}/{x}/{y}.terrain?v={version}"},
+		Projection: "EPSG:4326",
+		Bounds:     []float64{extent.MinX, extent.MinY, extent.MaxX, extent.MaxY},
+		MaxZoom:    o.MaxZoom,
+	}
+	if o.Normals {
+		layer.Extensions = []string{"octvertexnormals"}
+	}
+	mesh := &QuantizedMeshOptions{MaxError: o.MaxError, Normals: o.Normals}
+	for z := 0; z <= o.MaxZoom; z++ {
+		tiles := TileRange{EndX: 1}
+		if z > 0 {
+			rows := float64(uint(1) << uint(z))
+			size := 180 / rows
+			tiles = TileRange{
+				StartX: int(math.Max(0, math.Floor((extent.MinX+180)/size))),
+				StartY: int(math.Max(0, math.Floor((extent.MinY+90)/size))),
+				EndX:   int(math.Min(2*rows-1, math.Ceil((extent.MaxX+180)/size)-1)),
+				EndY:   int(math.Min(rows-1, math.Ceil((extent.MaxY+90)/size)-1)),
+			}
+		}
+		layer.Available = append(layer.Available, []TileRange{tiles})
+		for y := tiles.StartY; y <= tiles.EndY; y++ {
+			for x := tiles.StartX; x <= tiles.EndX; x++ {
+				b := GeographicTileBounds(z, x, y)
+				// half a pixel beyond the tile puts the edge pixels on its edges
+				half := (b.MaxX - b.MinX) / float64(o.GridSize-1) / 2
+				target := &WarpTarget{CRS: wgs84, Width: o.GridSize, Height: o.GridSize,
+					Bounds: &Bounds{MinX: b.MinX - half, MaxX: b.MaxX + half, MinY: b.MinY - half, MaxY: b.MaxY + half}}
+				r, _, err := Warp(m.Bands[0], gt, crs, target, o.Resampling)
+				if err != nil {
+					return nil, err
+				}
+				var data bytes.Buffer
+				if err := EncodeQuantizedMesh(&data, r, b, mesh); err != nil {
+					return nil, err
+				}
+				if err := sink.WriteTile(z, x, y, data.Bytes()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return layer, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestTIN(t *testing.T) {
+	// a pyramid peaking at the center of the grid
+	r := NewRaster(33, 33)
+	for row := 0; row < 33; row++ {
+		for col := 0; col < 33; col++ {
+			r.SetValue(row, col, float32(100-3*math.Max(math.Abs(float64(row-16)), math.Abs(float64(col-16)))))
+		}
+	}
+	exact, err := NewTIN(r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coarse, err := NewTIN(r, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coarse.Triangles) >= len(exact.Triangles) || len(exact.Triangles) >= 2*32*32 {
+		t.Errorf("%d triangles at 50m, %d exact", len(coarse.Triangles), len(exact.Triangles))
+	}
+	for _, tin := range []*TIN{exact, coarse} {
+		area := 0.0
+		for _, tri := range tin.Triangles {
+			a, b, c := tin.Vertices[tri[0]], tin.Vertices[tri[1]], tin.Vertices[tri[2]]
+			// rows grow southward, the y axis of the map is -row
+			s := float64((b[0]-a[0])*(a[1]-c[1]) - (a[1]-b[1])*(c[0]-a[0]))
+			if s <= 0 {
+				t.Fatalf("triangle %v is not counter clockwise", tri)
+			}
+			area += s / 2
+		}
+		if area != 32*32 {
+			t.Errorf("triangles cover %v pixels", area)
+		}
+	}
+	if _, err := NewTIN(NewRaster(32, 32), 0); err == nil {
+		t.Error("32 pixels a side accepted")
+	}
+}
+
+func TestEncodeQuantizedMesh(t *testing.T) {
+	r := NewRaster(17, 17)
+	for row := 0; row < 17; row++ {
+		for col := 0; col < 17; col++ {
+			r.SetValue(row, col, float32(10*col+row))
+		}
+	}
+	var b bytes.Buffer
+	if err := EncodeQuantizedMesh(&b, r, GeographicTileBounds(10, 1100, 700), &QuantizedMeshOptions{MaxError: 1, Normals: true}); err != nil {
+		t.Fatal(err)
+	}
+	var header struct {
+		Center      [3]float64
+		MinH, MaxH  float32
+		Sphere      [3]float64
+		Radius      float64
+		Horizon     [3]float64
+		VertexCount uint32
+	}
+	rd := bytes.NewReader(b.Bytes())
+	if err := binary.Read(rd, binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.MinH != 0 || header.MaxH != 176 || header.VertexCount < 4 || header.Radius <= 0 {
+		t.Fatalf("header %+v", header)
+	}
+	if h := norm(header.Horizon); h < 1 || h > 1.01 {
+		t.Errorf("horizon occlusion point at %v radii", h)
+	}
+
+	// decode the vertices, the north west corner is the highest
+	n := int(header.VertexCount)
+	coords := make([]uint16, 3*n)
+	binary.Read(rd, binary.LittleEndian, coords)
+	values := make([]int, 3*n)
+	for k := 0; k < 3; k++ {
+		v := 0
+		for i := 0; i < n; i++ {
+			zz := int(coords[k*n+i])
+			v += (zz >> 1) ^ -(zz & 1)
+			values[k*n+i] = v
+		}
+	}
+	corner := false
+	for i := 0; i < n; i++ {
+		u, v, h := values[i], values[n+i], values[2*n+i]
+		if u < 0 || u > quantizedMax || v < 0 || v > quantizedMax {
+			t.Fatalf("vertex %d at %d, %d", i, u, v)
+		}
+		if u == quantizedMax && v == 0 {
+			corner = true
+			if h != quantizedMax {
+				t.Errorf("south east corner height %d", h)
+			}
+		}
+	}
+	if !corner {
+		t.Error("no south east corner vertex")
+	}
+
+	// triangles follow, high water mark encoded
+	var count uint32
+	binary.Read(rd, binary.LittleEndian, &count)
+	highest := 0
+	for i := 0; i < 3*int(count); i++ {
+		var code uint16
+		binary.Read(rd, binary.LittleEndian, &code)
+		index := highest - int(code)
+		if index < 0 || index > highest || index >= n {
+			t.Fatalf("index %d, highest %d", index, highest)
+		}
+		if code == 0 {
+			highest++
+		}
+	}
+	for edge := 0; edge < 4; edge++ {
+		var m uint32
+		binary.Read(rd, binary.LittleEndian, &m)
+		if m < 2 {
+			t.Errorf("edge %d has %d vertices", edge, m)
+		}
+		rd.Seek(2*int64(m), 1)
+	}
+	var ext struct {
+		ID     uint8
+		Length uint32
+	}
+	binary.Read(rd, binary.LittleEndian, &ext)
+	if ext.ID != extensionOctNormals || int(ext.Length) != 2*n || rd.Len() != 2*n {
+		t.Errorf("extension %+v, %d bytes left", ext, rd.Len())
+	}
+}
+
+func TestGenerateTerrain(t *testing.T) {
+	raster := NewRaster(64, 64)
+	for i := range raster.Data {
+		raster.Data[i] = 500
+	}
+	f := &memFile{}
+	if err := Encode(f, raster, &Bounds{MinX: 10, MaxX: 11, MinY: 45, MaxY: 46}, nil); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := memorySink{}
+	layer, err := GenerateTerrain(tif, sink, &TerrainOptions{MaxZoom: 3, Normals: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the square is in tile 8, 6 of zoom 3, 22.5 degrees wide with rows counted from the south
+	if len(layer.Available) != 4 || layer.Available[3][0] != (TileRange{StartX: 8, StartY: 6, EndX: 8, EndY: 6}) {
+		t.Fatalf("available %v", layer.Available)
+	}
+	if len(sink) != 5 || sink["0/0/0"] == nil || sink["0/1/0"] == nil || sink["3/8/6"] == nil {
+		t.Errorf("%d tiles", len(sink))
+	}
+	var b bytes.Buffer
+	if err := layer.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["format"] != "quantized-mesh-1.0" || doc["scheme"] != "tms" || doc["extensions"].([]interface{})[0] != "octvertexnormals" {
+		t.Errorf("layer.json %s", b.String())
+	}
+
+	// the tile holding the square is raised to 500m
+	var header struct {
+		Center     [3]float64
+		MinH, MaxH float32
+	}
+	binary.Read(bytes.NewReader(sink["3/8/6"]), binary.LittleEndian, &header)
+	if header.MinH != 0 || header.MaxH != 500 {
+		t.Errorf("heights %v to %v", header.MinH, header.MaxH)
+	}
+}
@@ -6,35 +6,45 @@ package geotiff
 
 import (
 	"bytes"
-	"compress/zlib"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"io"
-	"io/ioutil"
 	"math"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/golang/geo/s2"
 	"golang.org/x/image/tiff"
-	"golang.org/x/image/tiff/lzw"
 )
 
 type Tiff interface {
 	TagFor(int) (*Ifd, error)
+	IntegerValue(int, ...uint) (uint, error)
+	IntegerArrayValue(int) ([]uint, error)
 	KeyFor(int) (*GeoKey, error)
 	IsGeotiff() bool
 	DescribeGeokeys() ([]string, error)
 	DescribeGeoKey(*GeoKey) string
 	DescribeTiffTags() []string
 	Bounds() (*Bounds, error)
+	LonLatBounds() (*Bounds, error)
+	GeoTransform() (GeoTransform, error)
+	RasterType() int
+	CRS() (*CRS, error)
 	Points() (*Raster, float32, float32, error)
+	NumBands() int
+	BandPoints(band int) (*Raster, float32, float32, error)
+	Bands() (*MultiRaster, error)
+	ReadWindow(bounds *Bounds) (*Raster, *Bounds, error)
+	ReadPixelWindow(x, y, w, h int) (*Raster, *Bounds, error)
+	ReadBandsWindow(x, y, w, h int) (*MultiRaster, *Bounds, error)
 	IsImage() bool
 	GetImage() (image.Image, error)
 	GetValueByLonLat(float64, float64, *Raster) (float32, error)
@@ -53,6 +63,13 @@ type Tiff interface {
 	BaseZoom() int
 	SourceBounds() *Bounds
 	GetId() uint32
+
+	// Multiple image directories (overviews, masks and pages)
+	Directories() []Tiff
+	SubfileType() uint
+	IsOverview() bool
+	IsMask() bool
+	Overview(resolution float64) (Tiff, error)
 }
 
 type NotaTiffFile []byte
@@ -132,8 +149,7 @@ func (v *Ifd) DataTypeName() string {
 func (v *Ifd) ValueName() (string, bool) {
 	switch v.tag {
 	case tCompression:
-		name, ok := CompressionTypes[int(v.intValue)]
-		return name, ok
+		return compressionName(int(v.intValue))
 	case tPhotometricInterpretation:
 		name, ok := PhotoMetricInterpretation[int(v.intValue)]
 		return name, ok
@@ -171,7 +187,7 @@ func (v *Ifd) setValue() {
 			}
 			v.intArray = a
 		}
-	case dtLong:
+	case dtLong, dtIFD:
 		data := v.value.([]uint32)
 		if v.count == 1 {
 			v.intValue = uint(data[0])
@@ -182,6 +198,17 @@ func (v *Ifd) setValue() {
 			}
 			v.intArray = a
 		}
+	case dtLong8, dtIFD8:
+		data := v.value.([]uint64)
+		if v.count == 1 {
+			v.intValue = uint(data[0])
+		} else {
+			a := make([]uint, len(data))
+			for i, v := range data {
+				a[i] = uint(v)
+			}
+			v.intArray = a
+		}
 	case dtDouble:
 		data := v.value.([]float64)
 		if v.count == 1 {
@@ -231,7 +258,7 @@ func (v *Ifd) Value() string {
 		} else {
 			return fmt.Sprintf("[%v]%v...", v.count, v.intArray[:16])
 		}
-	case dtLong:
+	case dtLong, dtIFD, dtLong8, dtIFD8:
 		if v.count < 10 {
 			if v.count == 1 {
 				return fmt.Sprintf("%v", v.intValue)
@@ -259,6 +286,8 @@ func (v *Ifd) Value() string {
 		} else {
 			return fmt.Sprintf("[%v]%f...", v.count, v.floatArray[:10])
 		}
+	case dtUndefined:
+		return fmt.Sprintf("[%v]bytes", v.count)
 	case dtASCII:
 		if v.count < 256 {
 			return fmt.Sprintf("[%v]%s", v.count, v.stringValue)
@@ -270,9 +299,19 @@ func (v *Ifd) Value() string {
 	return ""
 }
 
+// Bytes returns the raw value of a BYTE, ASCII or UNDEFINED entry, such as
+// JPEGTables, and nil for any other type.
+func (v *Ifd) Bytes() []byte {
+	switch v.fieldType {
+	case dtByte, dtASCII, dtUndefined:
+		return v.value.([]byte)
+	}
+	return nil
+}
+
 func (v *Ifd) PutData(byteOrder binary.ByteOrder, buf []byte) {
 	switch v.fieldType {
-	case dtByte, dtASCII:
+	case dtByte, dtASCII, dtUndefined:
 		data := v.value.([]byte)
 		copy(buf, data)
 		buf = buf[len(data):]
@@ -282,12 +321,24 @@ func (v *Ifd) PutData(byteOrder binary.ByteOrder, buf []byte) {
 			byteOrder.PutUint16(buf, e)
 			buf = buf[2:]
 		}
-	case dtLong:
+	case dtLong, dtIFD:
 		data := v.value.([]uint32)
 		for _, e := range data {
 			byteOrder.PutUint32(buf, e)
 			buf = buf[4:]
 		}
+	case dtLong8, dtIFD8:
+		data := v.value.([]uint64)
+		for _, e := range data {
+			byteOrder.PutUint64(buf, e)
+			buf = buf[8:]
+		}
+	case dtSlong8:
+		data := v.value.([]int64)
+		for _, e := range data {
+			byteOrder.PutUint64(buf, uint64(e))
+			buf = buf[8:]
+		}
 	case dtRational:
 		data := v.value.([]Rational)
 		for _, e := range data {
@@ -360,18 +411,20 @@ type decoder struct {
 	reader         TiffReader
 	byteOrder      binary.ByteOrder
 	ifdOffset      int64
+	bigTiff        bool
 	ifd            map[uint16]*Ifd
 	geokeys        map[uint16]*GeoKey
 	geoAsciis      []byte
 	geoDoubles     []float64
 	bounds         *Bounds
-	minZ, maxZ     float64
 	myImage        image.Image
 	isImage        bool
 	imageStored    bool
 	latchPixLength sync.Once
 	pixLength      int
 	id             uint32
+	dirs           []*decoder // every image directory in the file, in file order
+	parent         *decoder   // full resolution image that a reduced resolution or mask directory belongs to
 }
 
 func GroundResolutionatZoom(lat float64, zoom int64) float64 {
@@ -401,7 +454,7 @@ func (d *decoder) PixelDimensions() (widthPixels, heightPixels float64, err erro
 }
 
 func (d *decoder) DimensionMeters() (widthMeters float64, heightMeters float64, err error) {
-	if bounds, err := d.Bounds(); err != nil {
+	if bounds, err := d.geographicBounds(); err != nil {
 		return 0.0, 0.0, err
 	} else {
 		centerX, centerY := bounds.Center()
@@ -409,8 +462,8 @@ func (d *decoder) DimensionMeters() (widthMeters float64, heightMeters float64,
 		p2 := s2.PointFromLatLng(s2.LatLngFromDegrees(centerY, bounds.MaxX))
 		angl := p1.Distance(p2)
 		distanceX := angl.Radians() * Wgs84SemiMajorAxis // ground distance in meters
-		p3 := s2.PointFromLatLng(s2.LatLngFromDegrees(centerX, bounds.MinY))
-		p4 := s2.PointFromLatLng(s2.LatLngFromDegrees(centerX, bounds.MaxY))
+		p3 := s2.PointFromLatLng(s2.LatLngFromDegrees(bounds.MinY, centerX))
+		p4 := s2.PointFromLatLng(s2.LatLngFromDegrees(bounds.MaxY, centerX))
 		angl2 := p3.Distance(p4)
 		distanceY := angl2.Radians() * Wgs84SemiMajorAxis // ground distance in meters
 		return distanceX, distanceY, nil
@@ -431,7 +484,10 @@ func (d *decoder) DateTime() (time.Time, error) {
 
 // meters/pixel
 func (d *decoder) Resolution() (float64, error) {
-	bounds, _ := d.Bounds()
+	bounds, err := d.geographicBounds()
+	if err != nil {
+		return 0, err
+	}
 	var w float64
 	if t, err := d.TagFor(tImageWidth); err != nil {
 		return 0, err
@@ -440,12 +496,14 @@ func (d *decoder) Resolution() (float64, error) {
 	}
 	_, centerY := bounds.Center()
 	distance := ApproxDistance(s2.LatLngFromDegrees(centerY, bounds.MinX), s2.LatLngFromDegrees(centerY, bounds.MaxX)) * 1000.0
-	fmt.Println("Geotiff Distance", distance, "m Width", float64(w), "pixels")
 	return distance / float64(w), nil
 }
 
 func (d *decoder) ZoomLevel() (int64, error) {
-	bounds, _ := d.Bounds()
+	bounds, err := d.geographicBounds()
+	if err != nil {
+		return 0, err
+	}
 	var w float64
 	if t, err := d.TagFor(tImageWidth); err != nil {
 		return 0, err
@@ -496,21 +554,37 @@ func tileXYToLatLng(z, x, y, px, py uint) (float64, float64) {
 	return latitude, longitude
 }
 
+// GetValueByLonLat returns the value of raster, the image or one of its
+// overviews, at a WGS 84 longitude and latitude in degrees. They are
+// transformed to the CRS of projected images and used as is by geographic ones.
 func (d *decoder) GetValueByLonLat(lon float64, lat float64, raster *Raster) (float32, error) {
-	var xIndex int
-	var yIndex int
-	bounds, _ := d.Bounds()
-	xDiff := bounds.MaxX - bounds.MinX
-	yDiff := bounds.MaxY - bounds.MinY
-
-	xInc := xDiff / float64(raster.Width())
-	yInc := yDiff / float64(raster.Height())
-
-	xD := lon - bounds.MinX
-	yD := bounds.MaxY - lat
-	xIndex = int(xD / xInc)
-	yIndex = int(yD / yInc)
-	if xIndex >= 0 && xIndex < raster.Width() && yIndex >= 0 && yIndex < raster.Height() {
+	gt, err := d.GeoTransform()
+	if err != nil {
+		return 0.0, err
+	}
+	w, h, err := d.PixelDimensions()
+	if err != nil {
+		return 0.0, err
+	}
+	gt = gt.Scale(w/float64(raster.Width()), h/float64(raster.Height()))
+	x, y := lon, lat
+	if crs := d.projectedCRS(); crs != nil {
+		wgs84, _ := LookupEPSG(4326)
+		t, err := NewTransformer(wgs84, crs)
+		if err != nil {
+			return 0.0, err
+		}
+		if x, y, err = t.Transform(lon, lat); err != nil {
+			return 0.0, err
+		}
+	}
+	col, row, err := gt.ModelToPixel(x, y)
+	if err != nil {
+		return 0.0, err
+	}
+	xIndex := int(math.Floor(col))
+	yIndex := int(math.Floor(row))
+	if col >= 0 && xIndex < raster.Width() && row >= 0 && yIndex < raster.Height() {
 		return raster.ValueAt(yIndex, xIndex), nil
 	} else {
 		return 0.0, errors.New("Point not inside bounds")
@@ -522,22 +596,34 @@ func (d *decoder) parseIfd(p []byte) error {
 	tag := d.byteOrder.Uint16(p[0:2])
 
 	datatype := d.byteOrder.Uint16(p[2:4])
-	count := d.byteOrder.Uint32(p[4:8])
-	if dt := int(datatype); dt <= 0 || dt >= len(lengths) {
+	if dt := int(datatype); dt <= 0 || dt >= len(lengths) || lengths[dt] == 0 {
 		return UnsupportedError(fmt.Sprintf("IFD entry datatype: %v", datatype))
 	}
-	if datalen := lengths[datatype] * count; datalen > 4 {
+	var count64 uint64
+	var valueField []byte
+	if d.bigTiff {
+		count64 = d.byteOrder.Uint64(p[4:12])
+		valueField = p[12:20]
+	} else {
+		count64 = uint64(d.byteOrder.Uint32(p[4:8]))
+		valueField = p[8:12]
+	}
+	if count64 > math.MaxUint32 {
+		return UnsupportedError(fmt.Sprintf("IFD entry count: %v", count64))
+	}
+	count := uint32(count64)
+	if datalen := uint64(lengths[datatype]) * count64; datalen > uint64(len(valueField)) {
 		// The IFD contains a pointer to the real value.
 		raw = make([]byte, datalen)
-		if _, e := d.reader.ReadAt(raw, int64(d.byteOrder.Uint32(p[8:12]))); e != nil {
+		if _, e := d.reader.ReadAt(raw, d.offsetValue(valueField)); e != nil {
 			return e
 		}
 	} else {
-		raw = p[8 : 8+count]
+		raw = valueField[:datalen]
 	}
 
 	switch datatype {
-	case dtByte:
+	case dtByte, dtUndefined:
 		u := make([]byte, count)
 		for i := uint32(0); i < count; i++ {
 			u[i] = raw[i]
@@ -549,12 +635,24 @@ func (d *decoder) parseIfd(p []byte) error {
 			u[i] = d.byteOrder.Uint16(raw[2*i : 2*(i+1)])
 		}
 		d.ifd[tag] = &Ifd{tag: tag, fieldType: datatype, count: count, value: u}
-	case dtLong:
+	case dtLong, dtIFD:
 		u := make([]uint32, count)
 		for i := uint32(0); i < count; i++ {
 			u[i] = d.byteOrder.Uint32(raw[4*i : 4*(i+1)])
 		}
 		d.ifd[tag] = &Ifd{tag: tag, fieldType: datatype, count: count, value: u}
+	case dtLong8, dtIFD8:
+		u := make([]uint64, count)
+		for i := uint32(0); i < count; i++ {
+			u[i] = d.byteOrder.Uint64(raw[8*i : 8*(i+1)])
+		}
+		d.ifd[tag] = &Ifd{tag: tag, fieldType: datatype, count: count, value: u}
+	case dtSlong8:
+		u := make([]int64, count)
+		for i := uint32(0); i < count; i++ {
+			u[i] = int64(d.byteOrder.Uint64(raw[8*i : 8*(i+1)]))
+		}
+		d.ifd[tag] = &Ifd{tag: tag, fieldType: datatype, count: count, value: u}
 	case dtASCII:
 		u := make([]byte, count)
 		copy(u, raw)
@@ -616,6 +714,14 @@ func (d *decoder) parseIfd(p []byte) error {
 	return nil
 }
 
+// offsetValue reads a file offset, 8 bytes in BigTIFF and 4 bytes otherwise
+func (d *decoder) offsetValue(p []byte) int64 {
+	if d.bigTiff {
+		return int64(d.byteOrder.Uint64(p[0:8]))
+	}
+	return int64(d.byteOrder.Uint32(p[0:4]))
+}
+
 func (d *decoder) TagFor(tag int) (*Ifd, error) {
 	ret, ok := d.ifd[uint16(tag)]
 	if ok {
@@ -646,9 +752,25 @@ func (d *decoder) FloatValue(tag int, defaultValue ...float64) (float64, error)
 	return 0.0, err
 }
 
+// noData returns the GDAL_NODATA value, GDAL stores it as an ASCII string
+func (d *decoder) noData() (float64, error) {
+	ifd, err := d.TagFor(tGDALNodata)
+	if err != nil {
+		return -9999.0, nil
+	}
+	if ifd.fieldType == dtASCII {
+		return strconv.ParseFloat(strings.TrimSpace(strings.TrimRight(ifd.stringValue, "\x00")), 64)
+	}
+	return ifd.floatValue, nil
+}
+
 func (d *decoder) IntegerArrayValue(tag int) ([]uint, error) {
 	ifd, err := d.TagFor(tag)
 	if err == nil {
+		if ifd.count == 1 && ifd.intArray == nil {
+			// single values are only kept in intValue
+			return []uint{ifd.intValue}, nil
+		}
 		return ifd.intArray, nil
 	}
 	return []uint{}, err
@@ -657,6 +779,9 @@ func (d *decoder) IntegerArrayValue(tag int) ([]uint, error) {
 func (d *decoder) FloatArrayValue(tag int) ([]float64, error) {
 	ifd, err := d.TagFor(tag)
 	if err == nil {
+		if ifd.count == 1 && ifd.floatArray == nil {
+			return []float64{ifd.floatValue}, nil
+		}
 		return ifd.floatArray, nil
 	}
 	return []float64{}, err
@@ -683,7 +808,7 @@ func (d *decoder) IsGeotiff() bool {
 	if _, err := d.TagFor(tGeoKeys); err == nil {
 		return true
 	}
-	return false
+	return d.parent != nil && d.parent.IsGeotiff()
 }
 
 func (d *decoder) parseGeoKeys() error {
@@ -750,6 +875,8 @@ func (d *decoder) DescribeTiffTags() []string {
 
 type Raster struct {
 	Data []float32
+	// CRS is the coordinate reference system of the cells, nil when unknown
+	CRS  *CRS
 	w, h int
 }
 
@@ -781,47 +908,6 @@ func NewRaster(width, height int) *Raster {
 	}
 }
 
-type ImageInfo struct {
-	width, height, rowsPerStrip, bitsPerSample, sampleFormat, samplesPerPixel, compression uint
-	nodata                                                                                 float32
-	writeRow                                                                               int
-}
-
-type TileImageInfo struct {
-	width, height, tilesAcross, tilesDown, tileWidth, tileLength, bitsPerSample, sampleFormat, samplesPerPixel, compression uint
-	nodata                                                                                                                  float32
-	writeRow                                                                                                                int
-}
-
-func (d *decoder) readBlock(blockData []byte, row uint, imageInfo *ImageInfo, raster *Raster) *Raster {
-	w := imageInfo.width
-	h := imageInfo.height
-	writeRow := int(row)
-	rowsPerStrip := imageInfo.rowsPerStrip
-	block := rowsPerStrip
-	if (h - row) < rowsPerStrip {
-		block = h - row
-	}
-	writeCol := 0
-	for rowi := uint(0); rowi < block; rowi++ {
-		for i := rowi * w * 4; i < (rowi+1)*w*4; i += 4 {
-			zval := math.Float32frombits(d.byteOrder.Uint32(blockData[i : i+4]))
-			if zval <= imageInfo.nodata {
-				raster.SetValue(writeRow, writeCol, -9999.0)
-				writeCol++
-			} else {
-				d.minZ = math.Min(d.minZ, float64(zval))
-				d.maxZ = math.Max(d.maxZ, float64(zval))
-				raster.SetValue(writeRow, writeCol, zval)
-				writeCol++
-			}
-		}
-		writeRow++
-		writeCol = 0
-	}
-	return raster
-}
-
 func uintMin(m1, m2 uint) uint {
 	if m1 <= m2 {
 		return m1
@@ -836,162 +922,20 @@ func uintMax(m1, m2 uint) uint {
 	return m2
 }
 
-func (d *decoder) readTileBlock(blockData []byte, tileRow, tileCol uint, imageInfo *TileImageInfo, raster *Raster) *Raster {
-	w := imageInfo.width
-	h := imageInfo.height
-	var rmax = int(uintMin((imageInfo.tilesDown * imageInfo.tileLength), h))
-	var cmax = int(uintMin((imageInfo.tilesAcross * imageInfo.tileWidth), w))
-
-	x, y := (tileCol * imageInfo.tileWidth), (tileRow * imageInfo.tileLength)
-
-	for tRow := 0; tRow < int(imageInfo.tileLength); tRow++ {
-		py := int(y) + tRow
-		px := int(x)
-		for tCol := 0; tCol < int(imageInfo.tileWidth*4); tCol += 4 {
-			i := tRow*int(imageInfo.tileWidth*4) + tCol
-			zval := math.Float32frombits(d.byteOrder.Uint32(blockData[i : i+4]))
-
-			// Tiles can exceed the image width and height, they can be padded
-			// in either direction, we test to make sure we are writing within the raster
-			if px < cmax && py < rmax {
-				if zval <= imageInfo.nodata {
-					raster.SetValue(py, px, float32(-9999.0))
-				} else {
-					d.minZ = math.Min(d.minZ, float64(zval))
-					d.maxZ = math.Max(d.maxZ, float64(zval))
-					raster.SetValue(py, px, zval)
-				}
-			}
-			px++
-		}
-	}
-	return raster
-}
-
+// TilePoints decodes a single band tiled image, it is the same as Points.
 func (d *decoder) TilePoints() (*Raster, float32, float32, error) {
-	imageWidth, e1 := d.IntegerValue(tImageWidth)
-	imageLength, e2 := d.IntegerValue(tImageLength)
-	samplesPerPixel, e3 := d.IntegerValue(tSamplesPerPixel)
-	tileWidth, e4 := d.IntegerValue(tTileWidth)
-	tileLength, e5 := d.IntegerValue(tTileLength)
-	tileByteCounts, e6 := d.IntegerArrayValue(tTileByteCounts)
-	tileOffsets, e7 := d.IntegerArrayValue(tTileOffsets)
-	bitsPerSample, e8 := d.IntegerValue(tBitsPerSample)
-	sampleFormat, e9 := d.IntegerValue(tSampleFormat, uint(1))
-	compression, e10 := d.IntegerValue(tCompression, uint(1))
-	nodata, e11 := d.FloatValue(tGDALNodata, float64(-9999.0))
-	if e := checkFailure(e1, e2, e3, e4, e5, e6, e7, e8, e9, e10, e11); e != nil {
-		return nil, 0, 0, e
-	}
-	tilesAcross := (imageWidth + tileWidth - 1) / tileWidth
-	tilesDown := (imageLength + tileLength - 1) / tileLength
-	fmt.Printf("Tiles are arranged %d x %d (acrossxdown)\n", tilesAcross, tilesDown)
-	imageInfo := &TileImageInfo{imageWidth, imageLength, tilesAcross, tilesDown, tileWidth, tileLength, bitsPerSample, sampleFormat, samplesPerPixel, compression, float32(nodata), 0}
-	d.minZ = 9999.0
-	d.maxZ = -9999.0
-	// TODO: Add other sample formats, such as RGB, return type of Raster would need to change
-	// or we bit pack RGBA into float32
-	if samplesPerPixel == uint(1) && bitsPerSample == uint(32) && sampleFormat == uint(3) {
-		raster := NewRaster(int(imageWidth), int(imageLength))
-		for td := uint(0); td < tilesDown; td++ {
-			for ta := uint(0); ta < tilesAcross; ta++ {
-				tile := (td * tilesAcross) + ta
-				p := make([]byte, int(tileByteCounts[tile]))
-
-				if _, err := d.reader.ReadAt(p, int64(tileOffsets[tile])); err != nil {
-					return nil, 0, 0, err
-				}
-
-				switch int(compression) {
-				case cNone:
-					d.readTileBlock(p, td, ta, imageInfo, raster)
-				case cLZW:
-					dataReader := lzw.NewReader(bytes.NewReader(p), lzw.MSB, 8)
-					if p1, err := ioutil.ReadAll(dataReader); err == nil {
-						d.readTileBlock(p1, td, ta, imageInfo, raster)
-					} else {
-						return nil, 0, 0, err
-					}
-				case cDeflate:
-					if dataReader, e := zlib.NewReader(bytes.NewReader(p)); e == nil {
-						if p1, err := ioutil.ReadAll(dataReader); err == nil {
-							d.readTileBlock(p1, td, ta, imageInfo, raster)
-						} else {
-							return nil, 0, 0, err
-						}
-					} else {
-						return nil, 0, 0, e
-					}
-				}
-			}
-		}
-		return raster, float32(d.minZ), float32(d.maxZ), nil
-	}
-	return nil, float32(0), float32(0), fmt.Errorf("Error reading tile")
+	return d.Points()
 }
 
+// Points decodes a single band image, use BandPoints or Bands for images
+// with several samples per pixel. Nodata values are set to -9999.
 func (d *decoder) Points() (*Raster, float32, float32, error) {
-	_, e0 := d.IntegerValue(tTileWidth)
-	if e := checkFailure(e0); e == nil {
-		return d.TilePoints()
+	if n := d.NumBands(); n != 1 {
+		return nil, 0, 0, GeneralIssue(fmt.Sprintf("data is not single band: samplesPerPixel=%v, use BandPoints or Bands", n))
 	}
-	imageWidth, e1 := d.IntegerValue(tImageWidth)
-	imageLength, e2 := d.IntegerValue(tImageLength)
-	samplesPerPixel, e3 := d.IntegerValue(tSamplesPerPixel)
-	stripOffsets, e4 := d.IntegerArrayValue(tStripOffsets)
-	stripByteCounts, e5 := d.IntegerArrayValue(tStripByteCounts)
-	bitsPerSample, e6 := d.IntegerValue(tBitsPerSample)
-	rowsPerStrip, e7 := d.IntegerValue(tRowsPerStrip)
-	sampleFormat, e8 := d.IntegerValue(tSampleFormat, uint(1))
-	compression, e9 := d.IntegerValue(tCompression, uint(1))
-	nodata, e10 := d.FloatValue(tGDALNodata, float64(-9999.0))
-	if e := checkFailure(e1, e2, e3, e4, e5, e6, e7, e8, e9, e10); e != nil {
-		return nil, 0, 0, e
-	}
-	imageInfo := &ImageInfo{imageWidth, imageLength, rowsPerStrip, bitsPerSample, sampleFormat, samplesPerPixel, compression, float32(nodata), 0}
-	d.minZ = 9999.0
-	d.maxZ = -9999.0
-	if samplesPerPixel == uint(1) && bitsPerSample == uint(32) && sampleFormat == uint(3) {
-		raster := NewRaster(int(imageWidth), int(imageLength))
-		switch int(compression) {
-		case cNone:
-			for row := uint(0); row < imageLength; row += rowsPerStrip {
-				p := make([]byte, int(stripByteCounts[row/rowsPerStrip]))
-				if _, err := d.reader.ReadAt(p, int64(stripOffsets[row/rowsPerStrip])); err != nil {
-					return nil, 0, 0, err
-				}
-				d.readBlock(p, row, imageInfo, raster)
-			}
-		case cLZW:
-			for row := uint(0); row < imageLength; row += rowsPerStrip {
-				p := make([]byte, int(stripByteCounts[row/rowsPerStrip]))
-				d.reader.ReadAt(p, int64(stripOffsets[row/rowsPerStrip]))
-				dataReader := lzw.NewReader(bytes.NewReader(p), lzw.MSB, 8)
-				if p1, err := ioutil.ReadAll(dataReader); err == nil {
-					d.readBlock(p1, row, imageInfo, raster)
-				} else {
-					return nil, 0, 0, err
-				}
-			}
-		case cDeflate:
-			for row := uint(0); row < imageLength; row += rowsPerStrip {
-				p := make([]byte, int(stripByteCounts[row/rowsPerStrip]))
-				d.reader.ReadAt(p, int64(stripOffsets[row/rowsPerStrip]))
-				if dataReader, e := zlib.NewReader(bytes.NewReader(p)); e == nil {
-					if p1, err := ioutil.ReadAll(dataReader); err == nil {
-						d.readBlock(p1, row, imageInfo, raster)
-					} else {
-						return nil, 0, 0, err
-					}
-				} else {
-					return nil, 0, 0, e
-				}
-			}
-		}
-		return raster, float32(d.minZ), float32(d.maxZ), nil
-	}
-	return nil, 0, 0, GeneralIssue(fmt.Sprintf("data is not in float32 format: samplesPerPixel=%v, bitsPerSample=%v, sampleFormat=%v\n", samplesPerPixel, bitsPerSample, sampleFormat))
+	return d.BandPoints(0)
 }
+
 func (d *decoder) IsImage() bool {
 	sampleFormat, e8 := d.IntegerValue(tSampleFormat, uint(1))
 	photoMetricInterpretation, e9 := d.IntegerValue(tPhotometricInterpretation)
@@ -1019,6 +963,9 @@ func (d *decoder) GetImage() (image.Image, error) {
 
 func (d *decoder) StoreImage() error {
 	if d.isImage {
+		if len(d.dirs) > 0 && d.dirs[0] != d {
+			return UnsupportedError("image decoding of directories other than the first")
+		}
 		if !d.imageStored {
 			if img, err := tiff.Decode(d.reader); err != nil {
 				return err
@@ -1096,43 +1043,22 @@ func (d *decoder) IsMercator() bool {
 	return false
 }
 
+// Bounds is the model space envelope of the image, derived from its
+// GeoTransform
 func (d *decoder) Bounds() (*Bounds, error) {
 	if d.bounds != nil {
 		return d.bounds, nil
 	}
-	modelTiepoint, e1 := d.FloatArrayValue(tModelTiepointTag)
-	modelScale, e2 := d.FloatArrayValue(tModelPixelScaleTag)
-	imageWidth, e3 := d.IntegerValue(tImageWidth)
-	imageLength, e4 := d.IntegerValue(tImageLength)
-	orientation, e5 := d.IntegerValue(tOrientation, uint(1)) // provide a default value
-	if e := checkFailure(e1, e2, e3, e4, e5); e != nil {
-		return nil, e
+	gt, err := d.GeoTransform()
+	if err != nil {
+		return nil, err
 	}
-
-	x := modelTiepoint[3]
-	y := modelTiepoint[4]
-	xPixels := modelScale[0] * float64(imageWidth)
-	yPixels := modelScale[1] * float64(imageLength)
-	switch orientation {
-	case 1: // y=top, x=left
-		d.bounds = &Bounds{MinX: x, MaxY: y, MaxX: x + xPixels, MinY: y - yPixels, OriginX: x, OriginY: y}
-	case 2: // y=top, x=right
-		d.bounds = &Bounds{MaxX: x, MaxY: y, MinX: x - xPixels, MinY: y - yPixels, OriginX: x, OriginY: y}
-	case 3: // y=bottom x=right
-		d.bounds = &Bounds{MaxX: x, MinY: y, MinX: x - xPixels, MaxY: y + yPixels, OriginX: x, OriginY: y}
-	case 4: // y=bottom x=left
-		d.bounds = &Bounds{MinX: x, MinY: y, MaxX: x + xPixels, MaxY: y + yPixels, OriginX: x, OriginY: y}
-	case 5: // y=left x=top
-		d.bounds = &Bounds{MinX: y, MaxY: x, MaxX: y + yPixels, MinY: x - xPixels, OriginX: x, OriginY: y}
-	case 6: // y=right x=top
-		d.bounds = &Bounds{MaxX: y, MaxY: x, MinX: y - yPixels, MinY: x - xPixels, OriginX: x, OriginY: y}
-	case 7: // y=right x=bottom
-		d.bounds = &Bounds{MaxX: y, MinY: x, MinX: y - yPixels, MaxY: x + xPixels, OriginX: x, OriginY: y}
-	case 8: // y=left x=bottom
-		d.bounds = &Bounds{MinX: y, MinY: x, MaxX: y + yPixels, MaxY: x + xPixels, OriginX: x, OriginY: y}
-	default: // y=top, x=left
-		d.bounds = &Bounds{MinX: x, MaxY: y, MaxX: x + xPixels, MinY: y - yPixels, OriginX: x, OriginY: y}
+	imageWidth, e1 := d.IntegerValue(tImageWidth)
+	imageLength, e2 := d.IntegerValue(tImageLength)
+	if e := checkFailure(e1, e2); e != nil {
+		return nil, e
 	}
+	d.bounds = gt.Bounds(int(imageWidth), int(imageLength))
 	return d.bounds, nil
 }
 
@@ -1142,61 +1068,72 @@ func (d *decoder) GetId() uint32 {
 
 var LittleSignature []byte = []byte{0x49, 0x49, 0x2a, 0x00}
 var BigSignature []byte = []byte{0x4d, 0x4d, 0x0, 0x2a}
+var LittleBigTiffSignature []byte = []byte{0x49, 0x49, 0x2b, 0x00}
+var BigBigTiffSignature []byte = []byte{0x4d, 0x4d, 0x0, 0x2b}
 
-func isTiff(b []byte) (binary.ByteOrder, error) {
+// isTiff returns the byte order of the file and whether it is a BigTIFF
+func isTiff(b []byte) (binary.ByteOrder, bool, error) {
 	if bytes.Equal(b, LittleSignature) {
-		return binary.LittleEndian, nil
+		return binary.LittleEndian, false, nil
 	} else if bytes.Equal(b, BigSignature) {
-		return binary.BigEndian, nil
+		return binary.BigEndian, false, nil
+	} else if bytes.Equal(b, LittleBigTiffSignature) {
+		return binary.LittleEndian, true, nil
+	} else if bytes.Equal(b, BigBigTiffSignature) {
+		return binary.BigEndian, true, nil
 	}
-	return nil, NotaTiffFile(b)
+	return nil, false, NotaTiffFile(b)
 }
 
 var counter uint32
 
-// NewDecoder sets up a file Tiff to supply all tif tags, geo keys and Points as *Raster.
-// The points Raster is not created until Tiff.Points() is executed.
-// All tags and geo keys are loaded when this method returns Tiff (with error == nil)
-func NewDecoder(f TiffReader) (Tiff, error) {
+// readFirstIfd checks the header of f and loads the first directory, it
+// returns the offset of the next one
+func readFirstIfd(f TiffReader) (*decoder, int64, error) {
 	header := make([]byte, 8)
-	if _, err := f.Read(header); err != nil {
-		return nil, fmt.Errorf("Error reading header %v", err)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, 0, fmt.Errorf("Error reading header %v", err)
 	}
-	endian, err := isTiff(header[:4])
+	endian, bigTiff, err := isTiff(header[:4])
 	if err != nil {
-		return nil, err
-	}
-
-	d := &decoder{
-		reader:    f,
-		byteOrder: endian,
-		ifdOffset: int64(endian.Uint32(header[4:])),
-		ifd:       make(map[uint16]*Ifd),
-		geokeys:   make(map[uint16]*GeoKey),
-		minZ:      9999.0,
-		maxZ:      -9999.0,
-		id:        atomic.AddUint32(&counter, 1),
+		return nil, 0, err
 	}
-	if _, err := d.reader.ReadAt(header[0:2], d.ifdOffset); err != nil {
-		return nil, fmt.Errorf("Error reading offset %d: %v", d.ifdOffset, err)
-	}
-	numItems := d.byteOrder.Uint16(header[0:2])
-	p := make([]byte, numItems*ifdLen)
-	if _, err := d.reader.ReadAt(p, d.ifdOffset+2); err != nil {
-		return nil, fmt.Errorf("Error reading tags %v", err)
-	}
-	for i := 0; i < len(p); i += ifdLen {
-		if err := d.parseIfd(p[i : i+ifdLen]); err != nil {
-			return nil, fmt.Errorf("Error parsing IFD tag %d, %v", d.byteOrder.Uint16(p[i:i+2]), err)
+	ifdOffset := int64(endian.Uint32(header[4:]))
+	if bigTiff {
+		// Bytesize of offsets (always 8), a reserved zero, then the 8 byte first IFD offset
+		if endian.Uint16(header[4:6]) != 8 {
+			return nil, 0, UnsupportedError(fmt.Sprintf("BigTIFF offset size %d", endian.Uint16(header[4:6])))
+		}
+		if _, err := f.ReadAt(header, 8); err != nil {
+			return nil, 0, fmt.Errorf("Error reading header %v", err)
 		}
+		ifdOffset = int64(endian.Uint64(header))
 	}
-	for _, v := range d.ifd {
-		v.setValue()
+
+	d := newDirectory(f, endian, bigTiff, ifdOffset)
+	next, err := d.readIfd()
+	if err != nil {
+		return nil, 0, err
+	}
+	return d, next, nil
+}
+
+// NewDecoder sets up a file Tiff to supply all tif tags, geo keys and Points as *Raster.
+// The points Raster is not created until Tiff.Points() is executed.
+// All tags and geo keys are loaded when this method returns Tiff (with error == nil)
+// The returned Tiff is the first image directory, every directory in the file
+// is available through Tiff.Directories()
+func NewDecoder(f TiffReader) (Tiff, error) {
+	d, next, err := readFirstIfd(f)
+	if err != nil {
+		return nil, err
 	}
 	if err := d.parseGeoKeys(); err != nil {
 		return nil, fmt.Errorf("Error parsing GeoKeys %v", err)
 	}
-	d.isImage = d.IsImage()
+	if err := d.readDirectories(next); err != nil {
+		return nil, err
+	}
 	return d, nil
 }
 
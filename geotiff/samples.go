@@ -0,0 +1,124 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// sampleDecoder converts one raw sample of a strip or tile into a float64,
+// for every combination of SampleFormat and BitsPerSample that Points supports.
+type sampleDecoder struct {
+	size    int // bytes per sample
+	isFloat bool
+	read    func([]byte) float64
+}
+
+func newSampleDecoder(byteOrder binary.ByteOrder, sampleFormat, bitsPerSample uint) (*sampleDecoder, error) {
+	switch sampleFormat {
+	case smplUint, smplUndefined:
+		switch bitsPerSample {
+		case 8:
+			return &sampleDecoder{1, false, func(p []byte) float64 { return float64(p[0]) }}, nil
+		case 16:
+			return &sampleDecoder{2, false, func(p []byte) float64 { return float64(byteOrder.Uint16(p)) }}, nil
+		case 32:
+			return &sampleDecoder{4, false, func(p []byte) float64 { return float64(byteOrder.Uint32(p)) }}, nil
+		case 64:
+			return &sampleDecoder{8, false, func(p []byte) float64 { return float64(byteOrder.Uint64(p)) }}, nil
+		}
+	case smplSint:
+		switch bitsPerSample {
+		case 8:
+			return &sampleDecoder{1, false, func(p []byte) float64 { return float64(int8(p[0])) }}, nil
+		case 16:
+			return &sampleDecoder{2, false, func(p []byte) float64 { return float64(int16(byteOrder.Uint16(p))) }}, nil
+		case 32:
+			return &sampleDecoder{4, false, func(p []byte) float64 { return float64(int32(byteOrder.Uint32(p))) }}, nil
+		case 64:
+			return &sampleDecoder{8, false, func(p []byte) float64 { return float64(int64(byteOrder.Uint64(p))) }}, nil
+		}
+	case smplFloat:
+		switch bitsPerSample {
+		case 16:
+			return &sampleDecoder{2, true, func(p []byte) float64 { return float64(float16ToFloat32(byteOrder.Uint16(p))) }}, nil
+		case 32:
+			return &sampleDecoder{4, true, func(p []byte) float64 { return float64(math.Float32frombits(byteOrder.Uint32(p))) }}, nil
+		case 64:
+			return &sampleDecoder{8, true, func(p []byte) float64 { return math.Float64frombits(byteOrder.Uint64(p)) }}, nil
+		}
+	}
+	return nil, UnsupportedError(fmt.Sprintf("sampleFormat=%v with bitsPerSample=%v", sampleFormat, bitsPerSample))
+}
+
+// isNodata reports whether v is the nodata value. Floating point samples are
+// compared to nodata rounded to their precision, NaN samples are nodata.
+func (s *sampleDecoder) isNodata(v, nodata float64) bool {
+	if !s.isFloat {
+		return v == nodata
+	}
+	switch s.size {
+	case 2:
+		nodata = float64(float16ToFloat32(float32ToFloat16(float32(nodata))))
+	case 4:
+		nodata = float64(float32(nodata))
+	}
+	return math.IsNaN(v) || v == nodata
+}
+
+// float16ToFloat32 expands an IEEE 754 half precision value
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// subnormal, normalize it
+		exp = 127 - 15 + 1
+		for mant&0x400 == 0 {
+			mant <<= 1
+			exp--
+		}
+		mant &= 0x3ff
+		return math.Float32frombits(sign | exp<<23 | mant<<13)
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+// float32ToFloat16 rounds f to the nearest half precision value, ties to even
+func float32ToFloat16(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+	round := func(h, rem, half uint32) uint16 {
+		if rem > half || rem == half && h&1 != 0 {
+			h++ // may carry into the exponent
+		}
+		return sign | uint16(h)
+	}
+	switch {
+	case b&0x7fffffff > 0x7f800000:
+		return sign | 0x7e00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		// subnormal
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		return round(mant>>shift, mant&(1<<shift-1), 1<<(shift-1))
+	}
+	return round(uint32(exp)<<10|mant>>13, mant&0x1fff, 0x1000)
+}
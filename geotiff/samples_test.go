@@ -0,0 +1,109 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestSampleDecoder(t *testing.T) {
+	tests := []struct {
+		format, bits uint
+		raw          []byte
+		answer       float64
+	}{
+		{smplUint, 8, []byte{200}, 200},
+		{smplSint, 8, []byte{0xff}, -1},
+		{smplUint, 16, []byte{0xff, 0xff}, 65535},
+		{smplSint, 16, []byte{0x00, 0x80}, -32768},
+		{smplUint, 32, []byte{0x01, 0x00, 0x00, 0x80}, 2147483649},
+		{smplSint, 32, []byte{0xfe, 0xff, 0xff, 0xff}, -2},
+		{smplSint, 64, []byte{0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, -3},
+		{smplFloat, 16, []byte{0x00, 0x3c}, 1},
+		{smplFloat, 16, []byte{0x00, 0xc5}, -5},
+		{smplFloat, 16, []byte{0x01, 0x00}, math.Pow(2, -24)},
+		{smplFloat, 32, []byte{0x00, 0x00, 0x20, 0x41}, 10},
+		{smplFloat, 64, []byte{0, 0, 0, 0, 0, 0, 0x24, 0xc0}, -10},
+	}
+	for _, tc := range tests {
+		s, err := newSampleDecoder(binary.LittleEndian, tc.format, tc.bits)
+		if err != nil {
+			t.Fatalf("format %v bits %v: %v", tc.format, tc.bits, err)
+		}
+		if s.size != len(tc.raw) {
+			t.Errorf("format %v bits %v: size %v", tc.format, tc.bits, s.size)
+		}
+		if v := s.read(tc.raw); v != tc.answer {
+			t.Errorf("format %v bits %v: read %v, expected %v", tc.format, tc.bits, v, tc.answer)
+		}
+	}
+	if _, err := newSampleDecoder(binary.LittleEndian, smplFloat, 8); err == nil {
+		t.Error("8 bit floats should not be supported")
+	}
+}
+
+func TestSampleNodata(t *testing.T) {
+	u16, _ := newSampleDecoder(binary.LittleEndian, smplUint, 16)
+	f32, _ := newSampleDecoder(binary.LittleEndian, smplFloat, 32)
+	if !u16.isNodata(65535, 65535) || u16.isNodata(100, 65535) {
+		t.Error("integer nodata must match exactly")
+	}
+	if f32.isNodata(-3.4e38, -9999) || !f32.isNodata(math.NaN(), -9999) || f32.isNodata(0, -9999) || !f32.isNodata(-9999, -9999) {
+		t.Error("float nodata must match exactly or be NaN")
+	}
+	// nodata 0 with negative elevations
+	for _, v := range []float64{-0.5, -1, -120.25, -3.4e38} {
+		if f32.isNodata(v, 0) {
+			t.Errorf("%v is nodata 0", v)
+		}
+	}
+	if !f32.isNodata(0, 0) {
+		t.Error("0 is not nodata 0")
+	}
+
+	// nodata rounded to the precision of the samples
+	f16, _ := newSampleDecoder(binary.LittleEndian, smplFloat, 16)
+	f64, _ := newSampleDecoder(binary.LittleEndian, smplFloat, 64)
+	if !f32.isNodata(float64(float32(0.1)), 0.1) || f64.isNodata(float64(float32(0.1)), 0.1) || !f64.isNodata(0.1, 0.1) {
+		t.Error("nodata 0.1 must be rounded to 32 bit floats only")
+	}
+	if !f16.isNodata(-10000, -9999) || f16.isNodata(-9992, -9999) {
+		t.Error("nodata -9999 must be rounded to -10000 for 16 bit floats")
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	for h := 0; h < 0x10000; h++ {
+		f := float16ToFloat32(uint16(h))
+		if f != f { // NaN
+			continue
+		}
+		if r := float32ToFloat16(f); r != uint16(h) {
+			t.Fatalf("%#04x is %v, rounded to %#04x", h, f, r)
+		}
+	}
+	tests := []struct {
+		f float32
+		h uint16
+	}{
+		{1 + 1.0/2048, 0x3c00},    // tie to even
+		{1 + 3.0/2048, 0x3c02},    // tie to even
+		{1 + 1.5/2048, 0x3c01},    // above the tie
+		{65520, 0x7c00},           // overflow
+		{-1e-8, 0x8000},           // underflow
+		{3.0 / (1 << 25), 0x0002}, // subnormal tie to even
+		{float32(math.Inf(1)), 0x7c00},
+	}
+	for _, test := range tests {
+		if h := float32ToFloat16(test.f); h != test.h {
+			t.Errorf("%v rounded to %#04x, expected %#04x", test.f, h, test.h)
+		}
+	}
+	if h := float32ToFloat16(float32(math.NaN())); h&0x7c00 != 0x7c00 || h&0x3ff == 0 {
+		t.Errorf("NaN rounded to %#04x", h)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// maxColorCode is the largest elevation code held by the 24 bits of a pixel
+const maxColorCode = 1<<24 - 1
+
+// TerrainRGB encodes elevations as Mapbox Terrain-RGB PNG:
+//
+//	height = Base + (R*256*256 + G*256 + B) * Interval
+//
+// The zero value uses the Mapbox parameters, a base of -10000 and an interval
+// of 0.1 meters. A Base without an Interval is an error.
+type TerrainRGB struct {
+	Base     float64
+	Interval float64
+}
+
+func (e TerrainRGB) params() (float64, float64) {
+	if e.Base == 0 && e.Interval == 0 {
+		return -10000, 0.1
+	}
+	return e.Base, e.Interval
+}
+
+func (e TerrainRGB) check() error {
+	if e.Interval < 0 || e.Interval == 0 && e.Base != 0 {
+		return GeneralIssue(fmt.Sprintf("Terrain-RGB: base %v with an interval of %v", e.Base, e.Interval))
+	}
+	return nil
+}
+
+func (e TerrainRGB) code(h float64) uint32 {
+	base, interval := e.params()
+	return clampCode(math.Floor((h-base)/interval + 0.5))
+}
+
+func (e TerrainRGB) height(code uint32) float64 {
+	base, interval := e.params()
+	return base + float64(code)*interval
+}
+
+// Terrarium encodes elevations as the Terrarium PNG of Mapzen and AWS
+// terrain tiles, in 1/256 meter steps:
+//
+//	height = R*256 + G + B/256 - 32768
+type Terrarium struct{}
+
+func (e Terrarium) check() error {
+	return nil
+}
+
+func (e Terrarium) code(h float64) uint32 {
+	return clampCode(math.Floor((h+32768)*256 + 0.5))
+}
+
+func (e Terrarium) height(code uint32) float64 {
+	return float64(code)/256 - 32768
+}
+
+func clampCode(v float64) uint32 {
+	return uint32(math.Max(0, math.Min(maxColorCode, v)))
+}
+
+// elevationCoding converts between elevations and the 24 bit codes of RGB
+// pixels
+type elevationCoding interface {
+	check() error
+	code(h float64) uint32
+	height(code uint32) float64
+}
+
+// encodeElevation writes r as PNG, nodata pixels are transparent
+func encodeElevation(w io.Writer, r *Raster, c elevationCoding) error {
+	if r == nil || r.w <= 0 || r.h <= 0 {
+		return GeneralIssue("elevation PNG: raster is empty")
+	}
+	if err := c.check(); err != nil {
+		return err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, r.w, r.h))
+	for row := 0; row < r.h; row++ {
+		for col := 0; col < r.w; col++ {
+			v := r.ValueAt(row, col)
+			if v == noData || math.IsNaN(float64(v)) {
+				continue
+			}
+			code := c.code(float64(v))
+			img.SetNRGBA(col, row, color.NRGBA{R: uint8(code >> 16), G: uint8(code >> 8), B: uint8(code), A: 255})
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// decodeElevation reads an elevation PNG, transparent pixels are nodata
+func decodeElevation(rd io.Reader, c elevationCoding) (*Raster, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(rd)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	r := NewRaster(b.Dx(), b.Dy())
+	r.CRS, _ = LookupEPSG(epsgWebMercator)
+	for row := 0; row < r.h; row++ {
+		for col := 0; col < r.w; col++ {
+			p := color.NRGBAModel.Convert(img.At(b.Min.X+col, b.Min.Y+row)).(color.NRGBA)
+			if p.A == 0 {
+				r.SetValue(row, col, noData)
+				continue
+			}
+			r.SetValue(row, col, float32(c.height(uint32(p.R)<<16|uint32(p.G)<<8|uint32(p.B))))
+		}
+	}
+	return r, nil
+}
+
+// EncodePNG writes the elevations of r, in meters, as a Terrain-RGB PNG.
+// Nodata pixels are transparent.
+func (e TerrainRGB) EncodePNG(w io.Writer, r *Raster) error {
+	return encodeElevation(w, r, e)
+}
+
+// DecodePNG reads a Web Mercator Terrain-RGB PNG, transparent pixels are set
+// to -9999
+func (e TerrainRGB) DecodePNG(r io.Reader) (*Raster, error) {
+	return decodeElevation(r, e)
+}
+
+// EncodePNG writes the elevations of r, in meters, as a Terrarium PNG.
+// Nodata pixels are transparent.
+func (e Terrarium) EncodePNG(w io.Writer, r *Raster) error {
+	return encodeElevation(w, r, e)
+}
+
+// DecodePNG reads a Web Mercator Terrarium PNG, transparent pixels are set to
+// -9999
+func (e Terrarium) DecodePNG(r io.Reader) (*Raster, error) {
+	return decodeElevation(r, e)
+}
+
+// encodeElevationTile implements TileEncoder for the elevation encodings
+func encodeElevationTile(tile *MultiRaster, c elevationCoding) ([]byte, error) {
+	if n := tile.NumBands(); n != 1 {
+		return nil, GeneralIssue(fmt.Sprintf("elevation tile of %d bands", n))
+	}
+	var b bytes.Buffer
+	if err := encodeElevation(&b, tile.Bands[0], c); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (e TerrainRGB) Format() string {
+	return "png"
+}
+
+func (e TerrainRGB) EncodeTile(tile *MultiRaster, bounds *Bounds) ([]byte, error) {
+	return encodeElevationTile(tile, e)
+}
+
+func (e Terrarium) Format() string {
+	return "png"
+}
+
+func (e Terrarium) EncodeTile(tile *MultiRaster, bounds *Bounds) ([]byte, error) {
+	return encodeElevationTile(tile, e)
+}
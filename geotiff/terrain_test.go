@@ -0,0 +1,117 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestTerrainEncodings(t *testing.T) {
+	r := NewRaster(4, 2)
+	copy(r.Data, []float32{0, 8848.86, -430.5, noData, 1234.56, -10, 0.01, 100})
+	for _, c := range []struct {
+		name      string
+		coding    elevationCoding
+		tolerance float64
+		zero      color.NRGBA // color of sea level
+	}{
+		{"terrain-rgb", TerrainRGB{}, 0.05, color.NRGBA{1, 134, 160, 255}},
+		{"terrain-rgb 1cm", TerrainRGB{Base: -1000, Interval: 0.01}, 0.005, color.NRGBA{1, 134, 160, 255}},
+		{"terrarium", Terrarium{}, 1.0 / 512, color.NRGBA{128, 0, 0, 255}},
+	} {
+		var b bytes.Buffer
+		if err := encodeElevation(&b, r, c.coding); err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := color.NRGBAModel.Convert(img.At(0, 0)); p != c.zero {
+			t.Errorf("%s: sea level is %v, expected %v", c.name, p, c.zero)
+		}
+		back, err := decodeElevation(bytes.NewReader(b.Bytes()), c.coding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back.CRS == nil || back.CRS.EPSG != epsgWebMercator {
+			t.Errorf("%s: CRS %v", c.name, back.CRS)
+		}
+		for i, v := range r.Data {
+			if v == noData {
+				if back.Data[i] != noData {
+					t.Errorf("%s: nodata decoded as %v", c.name, back.Data[i])
+				}
+				continue
+			}
+			if math.Abs(float64(back.Data[i]-v)) > c.tolerance {
+				t.Errorf("%s: %v decoded as %v", c.name, v, back.Data[i])
+			}
+		}
+	}
+
+	// elevations beyond the range of the encoding are clamped
+	low := NewRaster(1, 1)
+	low.Data[0] = -20000
+	var b bytes.Buffer
+	if err := (TerrainRGB{}).EncodePNG(&b, low); err != nil {
+		t.Fatal(err)
+	}
+	back, err := (TerrainRGB{}).DecodePNG(&b)
+	if err != nil || back.Data[0] != -10000 {
+		t.Errorf("clamped to %v: %v", back.Data, err)
+	}
+
+	// a base keeps its place without the default interval
+	b.Reset()
+	if err := (TerrainRGB{Base: -500}).EncodePNG(&b, r); err == nil {
+		t.Error("Terrain-RGB base without an interval")
+	}
+	if _, err := (TerrainRGB{Interval: -0.1}).EncodeTile(&MultiRaster{Bands: []*Raster{r}}, nil); err == nil {
+		t.Error("negative Terrain-RGB interval")
+	}
+	if _, err := (TerrainRGB{Base: -500}).DecodePNG(bytes.NewReader(nil)); err == nil {
+		t.Error("Terrain-RGB base without an interval")
+	}
+}
+
+func TestTerrainTiles(t *testing.T) {
+	raster := NewRaster(16, 16)
+	for i := range raster.Data {
+		raster.Data[i] = 250
+	}
+	f := &memFile{}
+	if err := Encode(f, raster, &Bounds{MinX: 10, MaxX: 11, MinY: 45, MaxY: 46}, nil); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := memorySink{}
+	if _, err := GenerateTiles(tif, sink, &TileOptions{MinZoom: 6, MaxZoom: 6, Encoder: Terrarium{}}); err != nil {
+		t.Fatal(err)
+	}
+	tile, err := (Terrarium{}).DecodePNG(bytes.NewReader(sink["6/33/22"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, v := range tile.Data {
+		if v != noData {
+			found = true
+			if v != 250 {
+				t.Fatalf("tile value %v", v)
+			}
+		}
+	}
+	if !found || tile.Data[0] != noData {
+		t.Error("the tile is not partly covered")
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// TileSize is the width and height in pixels of XYZ tiles
+const TileSize = 256
+
+// maxMercatorLatitude is the latitude of the edges of the Web Mercator world
+const maxMercatorLatitude = 85.0511287798066
+
+// TileBounds returns the bounds of an XYZ tile in Web Mercator meters
+// (EPSG:3857), tile 0, 0 is the north west corner of the world
+func TileBounds(z, x, y int) *Bounds {
+	half := math.Pi * webMercatorRadius
+	size := 2 * half / float64(uint(1)<<uint(z))
+	minX, maxY := -half+float64(x)*size, half-float64(y)*size
+	return &Bounds{MinX: minX, MaxX: minX + size, MinY: maxY - size, MaxY: maxY, OriginX: minX, OriginY: maxY}
+}
+
+// TileEncoder turns the bands of a tile into the bytes stored by a TileSink
+type TileEncoder interface {
+	// Format is the file extension and MBTiles format of the tiles
+	Format() string
+	// EncodeTile encodes a TileSize x TileSize tile covering bounds, in Web
+	// Mercator meters. Nodata pixels are -9999.
+	EncodeTile(tile *MultiRaster, bounds *Bounds) ([]byte, error)
+}
+
+// TileSink stores the tiles of a pyramid
+type TileSink interface {
+	WriteTile(z, x, y int, data []byte) error
+}
+
+// TileMetadataWriter is implemented by sinks that record the extent and
+// format of the pyramid, it is called before the first tile is written
+type TileMetadataWriter interface {
+	WriteMetadata(metadata map[string]string) error
+}
+
+// GeoTIFFTiles encodes tiles as float32 GeoTIFFs in EPSG:3857, keeping
+// elevations and other measurements intact
+type GeoTIFFTiles struct {
+	Compression CompressionType
+}
+
+func (e GeoTIFFTiles) Format() string {
+	return "tif"
+}
+
+func (e GeoTIFFTiles) EncodeTile(tile *MultiRaster, bounds *Bounds) ([]byte, error) {
+	w := &writeBuffer{}
+	if err := EncodeBands(w, tile, bounds, &Options{Compression: e.Compression, ProjectedCSType: epsgWebMercator}); err != nil {
+		return nil, err
+	}
+	return w.data, nil
+}
+
+// PNGTiles encodes tiles of 8 bit samples as PNG: one band as gray, three
+// bands as RGB and four as RGBA. Nodata pixels are transparent.
+type PNGTiles struct{}
+
+func (e PNGTiles) Format() string {
+	return "png"
+}
+
+func (e PNGTiles) EncodeTile(tile *MultiRaster, bounds *Bounds) ([]byte, error) {
+	n := tile.NumBands()
+	if n != 1 && n != 3 && n != 4 {
+		return nil, UnsupportedError(fmt.Sprintf("PNG tile of %d bands", n))
+	}
+	sample := func(band, row, col int) uint8 {
+		return uint8(math.Max(0, math.Min(255, math.Floor(float64(tile.Bands[band].ValueAt(row, col))+0.5))))
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, tile.Width(), tile.Height()))
+	for row := 0; row < tile.Height(); row++ {
+		for col := 0; col < tile.Width(); col++ {
+			if tile.Bands[0].ValueAt(row, col) == noData {
+				continue
+			}
+			c := color.NRGBA{A: 255}
+			switch n {
+			case 1:
+				c.R = sample(0, row, col)
+				c.G, c.B = c.R, c.R
+			default:
+				c.R, c.G, c.B = sample(0, row, col), sample(1, row, col), sample(2, row, col)
+				if n == 4 {
+					c.A = sample(3, row, col)
+				}
+			}
+			img.SetNRGBA(col, row, c)
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeBuffer is an in memory io.WriteSeeker for the GeoTIFF encoder
+type writeBuffer struct {
+	data []byte
+	pos  int
+}
+
+func (b *writeBuffer) Write(p []byte) (int, error) {
+	if end := b.pos + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	copy(b.data[b.pos:], p)
+	b.pos += len(p)
+	return len(p), nil
+}
+
+func (b *writeBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(b.pos)
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	}
+	if offset < 0 {
+		return 0, GeneralIssue("writeBuffer: negative position")
+	}
+	b.pos = int(offset)
+	return offset, nil
+}
+
+// TileOptions control GenerateTiles
+type TileOptions struct {
+	// MinZoom is the smallest zoom level generated
+	MinZoom int
+	// MaxZoom is the zoom level of the base tiles, resampled from the source.
+	// Zero uses the BaseZoom of the source.
+	MaxZoom int
+	// Resampling is the kernel of the base tiles, tiles of the lower zoom
+	// levels average their four children
+	Resampling Resampling
+	// Encoder defaults to GeoTIFFTiles for single band sources and to
+	// PNGTiles otherwise
+	Encoder TileEncoder
+}
+
+type tiler struct {
+	src      Tiff
+	srcGT    GeoTransform
+	srcCRS   *CRS
+	toSrc    *Transformer // from Web Mercator
+	width    int          // of the source
+	height   int
+	numBands int
+	web      *CRS
+	maxZoom  int
+	extent   *Bounds // of the source in Web Mercator meters
+	opts     *TileOptions
+	sink     TileSink
+	numTiles int
+}
+
+// covers reports whether a tile intersects the source
+func (t *tiler) covers(z, x, y int) bool {
+	b := TileBounds(z, x, y)
+	return b.MinX < t.extent.MaxX && b.MaxX > t.extent.MinX && b.MinY < t.extent.MaxY && b.MaxY > t.extent.MinY
+}
+
+// window reads the source pixels under a tile, with a margin for the
+// kernels, and returns them with their geotransform. It returns nil when the
+// tile misses the source.
+func (t *tiler) window(bounds *Bounds) (*MultiRaster, GeoTransform, error) {
+	b, err := t.toSrc.TransformBounds(bounds)
+	if err != nil {
+		return nil, GeoTransform{}, err
+	}
+	inv, err := t.srcGT.Invert()
+	if err != nil {
+		return nil, GeoTransform{}, err
+	}
+	minCol, minRow := math.MaxFloat64, math.MaxFloat64
+	maxCol, maxRow := -math.MaxFloat64, -math.MaxFloat64
+	for _, corner := range [][2]float64{{b.MinX, b.MinY}, {b.MinX, b.MaxY}, {b.MaxX, b.MinY}, {b.MaxX, b.MaxY}} {
+		col, row := inv.PixelToModel(corner[0], corner[1])
+		minCol, maxCol = math.Min(minCol, col), math.Max(maxCol, col)
+		minRow, maxRow = math.Min(minRow, row), math.Max(maxRow, row)
+	}
+	// two pixels cover the cubic neighbourhood of the edge pixels
+	const margin = 2
+	x0 := int(math.Max(0, math.Floor(minCol)-margin))
+	y0 := int(math.Max(0, math.Floor(minRow)-margin))
+	x1 := int(math.Min(float64(t.width), math.Ceil(maxCol)+margin))
+	y1 := int(math.Min(float64(t.height), math.Ceil(maxRow)+margin))
+	if x1 <= x0 || y1 <= y0 {
+		return nil, GeoTransform{}, nil
+	}
+	m, _, err := t.src.ReadBandsWindow(x0, y0, x1-x0, y1-y0)
+	if err != nil {
+		return nil, GeoTransform{}, err
+	}
+	return m, t.srcGT.Translate(float64(x0), float64(y0)), nil
+}
+
+// tile builds, writes and returns a tile, nil when it holds no data. Tiles
+// of the base zoom are resampled from the source, the others are built from
+// their children, depth first so that only a branch of the pyramid is held in
+// memory, and base tiles only read the source pixels they cover.
+func (t *tiler) tile(z, x, y int) (*MultiRaster, error) {
+	if !t.covers(z, x, y) {
+		return nil, nil
+	}
+	bounds := TileBounds(z, x, y)
+	var m *MultiRaster
+	if z == t.maxZoom {
+		src, gt, err := t.window(bounds)
+		if err != nil || src == nil {
+			return nil, err
+		}
+		m = &MultiRaster{w: TileSize, h: TileSize}
+		for _, band := range src.Bands {
+			r, _, err := Warp(band, gt, t.srcCRS, &WarpTarget{CRS: t.web, Bounds: bounds, Width: TileSize, Height: TileSize}, t.opts.Resampling)
+			if err != nil {
+				return nil, err
+			}
+			m.Bands = append(m.Bands, r)
+		}
+	} else {
+		quad := NewMultiRaster(2*TileSize, 2*TileSize, t.numBands)
+		for _, b := range quad.Bands {
+			for i := range b.Data {
+				b.Data[i] = noData
+			}
+		}
+		found := false
+		for i := 0; i < 4; i++ {
+			dx, dy := i%2, i/2
+			child, err := t.tile(z+1, 2*x+dx, 2*y+dy)
+			if err != nil {
+				return nil, err
+			}
+			if child == nil {
+				continue
+			}
+			found = true
+			for b, band := range child.Bands {
+				for row := 0; row < TileSize; row++ {
+					copy(quad.Bands[b].Data[(dy*TileSize+row)*2*TileSize+dx*TileSize:], band.Data[row*TileSize:(row+1)*TileSize])
+				}
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+		m = halveBands(quad)
+	}
+
+	empty := true
+	for _, v := range m.Bands[0].Data {
+		if v != noData {
+			empty = false
+			break
+		}
+	}
+	if empty {
+		return nil, nil
+	}
+	data, err := t.opts.Encoder.EncodeTile(m, bounds)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.sink.WriteTile(z, x, y, data); err != nil {
+		return nil, err
+	}
+	t.numTiles++
+	return m, nil
+}
+
+// GenerateTiles cuts src into TileSize x TileSize Web Mercator tiles, from
+// the base zoom level down to opts.MinZoom, and writes them to sink. Sources
+// without GeoKeys are taken to be WGS 84 longitude and latitude. It returns the
+// number of tiles written, tiles without data are skipped.
+func GenerateTiles(src Tiff, sink TileSink, opts *TileOptions) (int, error) {
+	o := TileOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxZoom == 0 {
+		if o.MaxZoom = src.BaseZoom(); o.MaxZoom < 0 {
+			return 0, GeneralIssue("GenerateTiles: the base zoom of the source is unknown")
+		}
+	}
+	if o.MinZoom < 0 || o.MinZoom > o.MaxZoom || o.MaxZoom > 30 {
+		return 0, GeneralIssue(fmt.Sprintf("GenerateTiles: zoom levels %d to %d", o.MinZoom, o.MaxZoom))
+	}
+	numBands := src.NumBands()
+	if o.Encoder == nil {
+		o.Encoder = PNGTiles{}
+		if numBands == 1 {
+			o.Encoder = GeoTIFFTiles{Compression: Deflate}
+		}
+	}
+	width, height, err := src.PixelDimensions()
+	if err != nil {
+		return 0, err
+	}
+	gt, err := src.GeoTransform()
+	if err != nil {
+		return 0, err
+	}
+	wgs84, _ := LookupEPSG(4326)
+	web, _ := LookupEPSG(epsgWebMercator)
+	crs := wgs84
+	if src.IsGeotiff() {
+		if crs, err = src.CRS(); err != nil {
+			return 0, err
+		}
+	}
+	toSrc, err := NewTransformer(web, crs)
+	if err != nil {
+		return 0, err
+	}
+
+	// the extent is clipped to the latitudes of the Web Mercator world
+	toLonLat, err := NewTransformer(crs, wgs84)
+	if err != nil {
+		return 0, err
+	}
+	lonLat, err := toLonLat.TransformBounds(gt.Bounds(int(width), int(height)))
+	if err != nil {
+		return 0, err
+	}
+	minLat := math.Max(lonLat.MinY, -maxMercatorLatitude)
+	maxLat := math.Min(lonLat.MaxY, maxMercatorLatitude)
+	if minLat >= maxLat {
+		return 0, GeneralIssue(fmt.Sprintf("GenerateTiles: %v is outside of the Web Mercator world", lonLat))
+	}
+	project, _ := web.Projector()
+	minX, minY, e1 := project.Forward(math.Max(lonLat.MinX, -180), minLat)
+	maxX, maxY, e2 := project.Forward(math.Min(lonLat.MaxX, 180), maxLat)
+	if e := checkFailure(e1, e2); e != nil {
+		return 0, e
+	}
+
+	t := &tiler{src: src, srcGT: gt, srcCRS: crs, toSrc: toSrc, width: int(width), height: int(height), numBands: numBands, web: web, maxZoom: o.MaxZoom, opts: &o, sink: sink,
+		extent: &Bounds{MinX: minX, MinY: minY, MaxX: maxX, MaxY: maxY}}
+	if mw, ok := sink.(TileMetadataWriter); ok {
+		err := mw.WriteMetadata(map[string]string{
+			"format":  o.Encoder.Format(),
+			"bounds":  fmt.Sprintf("%g,%g,%g,%g", math.Max(lonLat.MinX, -180), minLat, math.Min(lonLat.MaxX, 180), maxLat),
+			"center":  fmt.Sprintf("%g,%g,%d", (lonLat.MinX+lonLat.MaxX)/2, (minLat+maxLat)/2, o.MinZoom),
+			"minzoom": fmt.Sprint(o.MinZoom),
+			"maxzoom": fmt.Sprint(o.MaxZoom),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// the tiles of the smallest zoom level covering the extent
+	n := 1 << uint(o.MinZoom)
+	size := TileBounds(o.MinZoom, 0, 0).MaxX - TileBounds(o.MinZoom, 0, 0).MinX
+	half := math.Pi * webMercatorRadius
+	x0 := int(math.Max(0, math.Floor((minX+half)/size)))
+	x1 := int(math.Min(float64(n-1), math.Floor((maxX+half)/size)))
+	y0 := int(math.Max(0, math.Floor((half-maxY)/size)))
+	y1 := int(math.Min(float64(n-1), math.Floor((half-minY)/size)))
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			if _, err := t.tile(o.MinZoom, x, y); err != nil {
+				return t.numTiles, err
+			}
+		}
+	}
+	return t.numTiles, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type memorySink map[string][]byte
+
+func (s memorySink) WriteTile(z, x, y int, data []byte) error {
+	s[fmt.Sprintf("%d/%d/%d", z, x, y)] = data
+	return nil
+}
+
+func (s memorySink) WriteMetadata(metadata map[string]string) error {
+	for k, v := range metadata {
+		s[k] = []byte(v)
+	}
+	return nil
+}
+
+func TestTileBounds(t *testing.T) {
+	world := TileBounds(0, 0, 0)
+	if !near(world.MinX, -20037508.342789244) || !near(world.MaxY, 20037508.342789244) {
+		t.Errorf("world %v", world)
+	}
+	b := TileBounds(1, 1, 0)
+	if b.MinX != 0 || b.MinY != 0 || !near(b.MaxX, world.MaxX) {
+		t.Errorf("tile 1/1/0 %v", b)
+	}
+}
+
+func TestGenerateTiles(t *testing.T) {
+	// a degree square north of the equator, each pixel holds its column
+	raster := NewRaster(64, 64)
+	for row := 0; row < 64; row++ {
+		for col := 0; col < 64; col++ {
+			raster.SetValue(row, col, float32(col))
+		}
+	}
+	f := &memFile{}
+	if err := Encode(f, raster, &Bounds{MinX: 10, MaxX: 11, MinY: 45, MaxY: 46}, nil); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := memorySink{}
+	n, err := GenerateTiles(tif, sink, &TileOptions{MinZoom: 4, MaxZoom: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a degree is 0.71 tiles wide and 1.02 tiles high at zoom 8 and 45N
+	if n < 7 || sink["4/8/5"] == nil || sink["5/16/11"] == nil || string(sink["format"]) != "tif" || string(sink["maxzoom"]) != "8" {
+		t.Fatalf("%d tiles, metadata %s %s", n, sink["format"], sink["maxzoom"])
+	}
+	for key := range sink {
+		var z, x, y int
+		if _, err := fmt.Sscanf(key, "%d/%d/%d", &z, &x, &y); err == nil && (z < 4 || z > 8) {
+			t.Errorf("tile %s outside of the zoom levels", key)
+		}
+	}
+
+	// the value at 10.5E 45.5N is column 32 within a tile pixel at every zoom level
+	web, _ := LookupEPSG(epsgWebMercator)
+	wgs84, _ := LookupEPSG(4326)
+	toWeb, _ := NewTransformer(wgs84, web)
+	mx, my, _ := toWeb.Transform(10.5, 45.5)
+	for z := 4; z <= 8; z++ {
+		size := TileBounds(z, 0, 0).MaxX - TileBounds(z, 0, 0).MinX
+		x := int((mx + math.Pi*webMercatorRadius) / size)
+		y := int((math.Pi*webMercatorRadius - my) / size)
+		data := sink[fmt.Sprintf("%d/%d/%d", z, x, y)]
+		tile, err := NewDecoder(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("tile %d/%d/%d: %v", z, x, y, err)
+		}
+		r, _, _, err := tile.Points()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := tile.GetValueByLonLat(10.5, 45.5, r)
+		pixel := 64 * 360 / float64(int(TileSize)<<uint(z)) // tile pixel width in columns
+		if err != nil || math.Abs(float64(v)-32) > pixel+0.5 {
+			t.Errorf("zoom %d: value %v: %v", z, v, err)
+		}
+	}
+}
+
+// windowedTiff fails whole image reads and records the windows read
+type windowedTiff struct {
+	Tiff
+	crsErr  error
+	windows []image.Rectangle
+}
+
+func (w *windowedTiff) Bands() (*MultiRaster, error) {
+	return nil, GeneralIssue("whole image read")
+}
+
+func (w *windowedTiff) Points() (*Raster, float32, float32, error) {
+	return nil, 0, 0, GeneralIssue("whole image read")
+}
+
+func (w *windowedTiff) ReadBandsWindow(x, y, width, height int) (*MultiRaster, *Bounds, error) {
+	w.windows = append(w.windows, image.Rect(x, y, x+width, y+height))
+	return w.Tiff.ReadBandsWindow(x, y, width, height)
+}
+
+func (w *windowedTiff) CRS() (*CRS, error) {
+	if w.crsErr != nil {
+		return nil, w.crsErr
+	}
+	return w.Tiff.CRS()
+}
+
+func TestGenerateTilesWindows(t *testing.T) {
+	raster := NewRaster(64, 64)
+	for i := range raster.Data {
+		raster.Data[i] = float32(i % 64)
+	}
+	f := &memFile{}
+	if err := Encode(f, raster, &Bounds{MinX: 10, MaxX: 11, MinY: 45, MaxY: 46}, &Options{TileWidth: 16, TileLength: 16}); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &windowedTiff{Tiff: tif}
+	n, err := GenerateTiles(src, memorySink{}, &TileOptions{MinZoom: 9, MaxZoom: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a zoom 10 tile is 0.35 degrees wide, 22.5 columns of the source
+	if n < 9 || len(src.windows) < 9 {
+		t.Fatalf("%d tiles from %d windows", n, len(src.windows))
+	}
+	for _, w := range src.windows {
+		if w.Dx() > 32 || w.Dy() > 48 {
+			t.Errorf("window %v for a base tile", w)
+		}
+	}
+
+	src = &windowedTiff{Tiff: tif, crsErr: UnsupportedError("model type 7")}
+	if _, err := GenerateTiles(src, memorySink{}, &TileOptions{MaxZoom: 10}); err != src.crsErr {
+		t.Errorf("error %v, expected %v", err, src.crsErr)
+	}
+}
+
+func TestGenerateRGBTiles(t *testing.T) {
+	m := NewMultiRaster(32, 32, 3)
+	for i := range m.Bands[0].Data {
+		m.Bands[0].Data[i], m.Bands[1].Data[i], m.Bands[2].Data[i] = 200, 100, 50
+	}
+	f := &memFile{}
+	if err := EncodeBands(f, m, &Bounds{MinX: -1, MaxX: 1, MinY: -1, MaxY: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if _, err := GenerateTiles(tif, &DirectorySink{Root: root, Extension: "png"}, &TileOptions{MaxZoom: 3}); err != nil {
+		t.Fatal(err)
+	}
+	// the square spans the four tiles around the origin
+	for _, tile := range []string{"0/0/0.png", "3/3/3.png", "3/4/4.png"} {
+		data, err := os.ReadFile(filepath.Join(root, tile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, a := img.At(0, 0).RGBA(); tile == "0/0/0.png" && a != 0 {
+			t.Errorf("%s: corner of the world is not transparent", tile)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "3/4/4.png"))
+	img, _ := png.Decode(bytes.NewReader(data))
+	if r, g, b, a := img.At(0, 0).RGBA(); r>>8 != 200 || g>>8 != 100 || b>>8 != 50 || a>>8 != 255 {
+		t.Errorf("pixel %v %v %v %v", r>>8, g>>8, b>>8, a>>8)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// DirectorySink writes tiles to Root/z/x/y.Extension, the layout of XYZ tile
+// servers. Extension defaults to the format of the tiles of GenerateTiles.
+type DirectorySink struct {
+	Root      string
+	Extension string
+	format    string
+}
+
+func (s *DirectorySink) WriteMetadata(metadata map[string]string) error {
+	s.format = metadata["format"]
+	return nil
+}
+
+func (s *DirectorySink) WriteTile(z, x, y int, data []byte) error {
+	ext := s.Extension
+	if ext == "" {
+		ext = s.format
+	}
+	if ext == "" {
+		return GeneralIssue("DirectorySink: missing tile extension")
+	}
+	dir := filepath.Join(s.Root, strconv.Itoa(z), strconv.Itoa(x))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, strconv.Itoa(y)+"."+ext), data, 0644)
+}
+
+// MBTilesSink writes tiles to an MBTiles 1.3 SQLite database. The caller
+// opens the database with the driver of its choice and calls Close once the
+// pyramid is written to commit the tiles.
+type MBTilesSink struct {
+	name string
+	tx   *sql.Tx
+	stmt *sql.Stmt
+	mu   sync.Mutex
+}
+
+// NewMBTilesSink creates the MBTiles tables in db, name is the name of the
+// tileset in the metadata table
+func NewMBTilesSink(db *sql.DB, name string) (*MBTilesSink, error) {
+	for _, q := range []string{
+		"CREATE TABLE IF NOT EXISTS metadata (name TEXT, value TEXT)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS metadata_name ON metadata (name)",
+		"CREATE TABLE IF NOT EXISTS tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS tile_index ON tiles (zoom_level, tile_column, tile_row)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &MBTilesSink{name: name, tx: tx, stmt: stmt}, nil
+}
+
+// WriteMetadata records the tileset name and the metadata of the pyramid
+func (s *MBTilesSink) WriteMetadata(metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := map[string]string{"name": s.name, "type": "baselayer"}
+	for k, v := range metadata {
+		values[k] = v
+	}
+	for k, v := range values {
+		if _, err := s.tx.Exec("INSERT OR REPLACE INTO metadata (name, value) VALUES (?, ?)", k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTile stores a tile, MBTiles rows count from the south as in TMS
+func (s *MBTilesSink) WriteTile(z, x, y int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.stmt.Exec(z, x, (1<<uint(z))-1-y, data)
+	return err
+}
+
+// Close commits the tiles and metadata
+func (s *MBTilesSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stmt.Close()
+	return s.tx.Commit()
+}
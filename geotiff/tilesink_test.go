@@ -0,0 +1,191 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver keeping the rows of the statements of
+// MBTilesSink, the statements of a transaction apply when it commits
+type fakeDB struct {
+	mu       sync.Mutex
+	created  []string
+	tiles    map[[3]int64][]byte
+	metadata map[string]string
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{tiles: map[[3]int64][]byte{}, metadata: map[string]string{}}
+}
+
+func (db *fakeDB) Open(string) (driver.Conn, error)             { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return db }
+
+type fakeConn struct {
+	db      *fakeDB
+	pending []func() // the statements of the transaction
+	inTx    bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c: c, query: query}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	for _, apply := range c.pending {
+		apply()
+	}
+	c.pending, c.inTx = nil, false
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.pending, c.inTx = nil, false
+	return nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return strings.Count(s.query, "?") }
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.c.db
+	var apply func()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE "):
+		apply = func() { db.created = append(db.created, s.query) }
+	case strings.HasPrefix(s.query, "INSERT OR REPLACE INTO tiles "):
+		key := [3]int64{args[0].(int64), args[1].(int64), args[2].(int64)}
+		data := append([]byte(nil), args[3].([]byte)...)
+		apply = func() { db.tiles[key] = data }
+	case strings.HasPrefix(s.query, "INSERT OR REPLACE INTO metadata "):
+		name, value := args[0].(string), args[1].(string)
+		apply = func() { db.metadata[name] = value }
+	default:
+		return nil, errors.New("unexpected statement " + s.query)
+	}
+	if s.c.inTx {
+		s.c.pending = append(s.c.pending, apply)
+	} else {
+		db.mu.Lock()
+		apply()
+		db.mu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func TestMBTilesSink(t *testing.T) {
+	fake := newFakeDB()
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	sink, err := NewMBTilesSink(db, "elevation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.created) != 4 {
+		t.Errorf("%d tables and indexes created, expected 4", len(fake.created))
+	}
+
+	var wg sync.WaitGroup
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			wg.Add(1)
+			go func(x, y int) {
+				defer wg.Done()
+				if err := sink.WriteTile(2, x, y, []byte{byte(x), byte(y)}); err != nil {
+					t.Error(err)
+				}
+			}(x, y)
+		}
+	}
+	wg.Wait()
+	if err := sink.WriteTile(0, 0, 0, []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteMetadata(map[string]string{"format": "png", "type": "overlay"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.tiles) != 0 {
+		t.Errorf("%d tiles before Close", len(fake.tiles))
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.tiles) != 17 {
+		t.Errorf("%d tiles, expected 17", len(fake.tiles))
+	}
+	for x := int64(0); x < 4; x++ {
+		for y := int64(0); y < 4; y++ {
+			// TMS rows count from the south
+			data := fake.tiles[[3]int64{2, x, 3 - y}]
+			if len(data) != 2 || data[0] != byte(x) || data[1] != byte(y) {
+				t.Errorf("tile 2/%d/%d in row %d is %v", x, y, 3-y, data)
+			}
+		}
+	}
+	if string(fake.tiles[[3]int64{0, 0, 0}]) != "root" {
+		t.Errorf("tile 0/0/0 is %q", fake.tiles[[3]int64{0, 0, 0}])
+	}
+	expected := map[string]string{"name": "elevation", "type": "overlay", "format": "png"}
+	if len(fake.metadata) != len(expected) {
+		t.Errorf("metadata %v, expected %v", fake.metadata, expected)
+	}
+	for k, v := range expected {
+		if fake.metadata[k] != v {
+			t.Errorf("metadata %s is %q, expected %q", k, fake.metadata[k], v)
+		}
+	}
+}
+
+func TestDirectorySink(t *testing.T) {
+	root := t.TempDir()
+	sink := &DirectorySink{Root: root}
+	if err := sink.WriteTile(1, 0, 1, []byte("tile")); err == nil {
+		t.Error("tile without an extension")
+	}
+	if err := sink.WriteMetadata(map[string]string{"format": "png"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteTile(1, 0, 1, []byte("tile")); err != nil {
+		t.Fatal(err)
+	}
+	sink = &DirectorySink{Root: root, Extension: "webp"}
+	if err := sink.WriteMetadata(map[string]string{"format": "png"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteTile(1, 0, 1, []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"1/0/1.png": "tile", "1/0/1.webp": "other"} {
+		if data, err := os.ReadFile(filepath.Join(root, name)); err != nil || string(data) != expected {
+			t.Errorf("%s is %q: %v", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"math"
+)
+
+// TIN is a triangulated irregular network over the grid of a raster.
+// Vertices holds the column and row of each vertex, Triangles the indices of
+// the vertices of each triangle, counter clockwise with north up.
+type TIN struct {
+	Vertices  [][2]int
+	Triangles [][3]int
+}
+
+// NewTIN simplifies a square raster of 2^k+1 pixels a side into a right
+// triangulated irregular network (RTIN, as in Mapbox Martini) whose heights
+// are within maxError of the raster. Nodata pixels are taken as height 0.
+func NewTIN(r *Raster, maxError float64) (*TIN, error) {
+	size := r.w
+	tileSize := size - 1
+	if r.h != size || tileSize < 1 || tileSize&(tileSize-1) != 0 {
+		return nil, GeneralIssue(fmt.Sprintf("TIN: raster is %dx%d, not square with 2^k+1 pixels a side", r.w, r.h))
+	}
+	height := func(x, y int) float64 {
+		v := r.Data[y*size+x]
+		if v == noData || math.IsNaN(float64(v)) {
+			return 0
+		}
+		return float64(v)
+	}
+
+	// the largest error of each triangle is stored at the middle of its
+	// hypotenuse, children first so that parents include their descendants
+	numTriangles := tileSize*tileSize*2 - 2
+	numParents := numTriangles - tileSize*tileSize
+	errors := make([]float64, size*size)
+	if tileSize > 1 {
+		for i := numTriangles - 1; i >= 0; i-- {
+			ax, ay, bx, by := rtinCoords(i, tileSize)
+			mx, my := (ax+bx)>>1, (ay+by)>>1
+			cx, cy := mx+my-ay, my+ax-mx
+			middle := my*size + mx
+			errors[middle] = math.Max(errors[middle], math.Abs((height(ax, ay)+height(bx, by))/2-height(mx, my)))
+			if i < numParents {
+				left := ((ay+cy)>>1)*size + ((ax + cx) >> 1)
+				right := ((by+cy)>>1)*size + ((bx + cx) >> 1)
+				errors[middle] = math.Max(errors[middle], math.Max(errors[left], errors[right]))
+			}
+		}
+	}
+
+	t := &TIN{}
+	indices := make([]int, size*size) // vertex index + 1 of each pixel
+	vertex := func(x, y int) int {
+		i := y*size + x
+		if indices[i] == 0 {
+			t.Vertices = append(t.Vertices, [2]int{x, y})
+			indices[i] = len(t.Vertices)
+		}
+		return indices[i] - 1
+	}
+	var split func(ax, ay, bx, by, cx, cy int)
+	split = func(ax, ay, bx, by, cx, cy int) {
+		mx, my := (ax+bx)>>1, (ay+by)>>1
+		if abs(ax-cx)+abs(ay-cy) > 1 && errors[my*size+mx] > maxError {
+			split(cx, cy, ax, ay, mx, my)
+			split(bx, by, cx, cy, mx, my)
+			return
+		}
+		// rows grow southward, reversing the raster order turns the
+		// triangle counter clockwise on the map
+		t.Triangles = append(t.Triangles, [3]int{vertex(ax, ay), vertex(bx, by), vertex(cx, cy)})
+	}
+	split(0, 0, tileSize, tileSize, tileSize, 0)
+	split(tileSize, tileSize, 0, 0, 0, tileSize)
+	return t, nil
+}
+
+// rtinCoords returns the ends of the hypotenuse of triangle i of the RTIN
+// hierarchy of a tileSize grid
+func rtinCoords(i, tileSize int) (ax, ay, bx, by int) {
+	id := i + 2
+	var cx, cy int
+	if id&1 != 0 {
+		bx, by, cx = tileSize, tileSize, tileSize // bottom left triangle
+	} else {
+		ax, ay, cy = tileSize, tileSize, tileSize // top right triangle
+	}
+	for id >>= 1; id > 1; id >>= 1 {
+		mx, my := (ax+bx)>>1, (ay+by)>>1
+		if id&1 != 0 { // left half
+			bx, by = ax, ay
+			ax, ay = cx, cy
+		} else { // right half
+			ax, ay = bx, by
+			bx, by = cx, cy
+		}
+		cx, cy = mx, my
+	}
+	return ax, ay, bx, by
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
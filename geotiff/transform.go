@@ -0,0 +1,266 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"math"
+)
+
+// toWGS84 holds the position vector Helmert parameters from a datum to WGS 84:
+// translations in meters, rotations in arc seconds and scale in parts per
+// million, as in the TOWGS84 clause of WKT. Values are the EPSG
+// transformations GDAL uses by default.
+var toWGS84 = map[int][7]float64{
+	6326: {},                                                                  // WGS 84
+	6269: {},                                                                  // NAD83, EPSG:1188
+	6283: {},                                                                  // GDA94, EPSG:1150
+	6322: {0, 0, 4.5, 0, 0, 0.554, 0.2263},                                    // WGS 72, EPSG:1237
+	6324: {0, 0, 1.9, 0, 0, 0.814, -0.38},                                     // WGS 72BE, EPSG:1238
+	6267: {-8, 160, 176, 0, 0, 0, 0},                                          // NAD27 CONUS, EPSG:1173
+	6230: {-87, -98, -121, 0, 0, 0, 0},                                        // ED50, EPSG:1133
+	6202: {-133, -48, 148, 0, 0, 0, 0},                                        // AGD66, EPSG:1108
+	6203: {-134, -48, 149, 0, 0, 0, 0},                                        // AGD84, EPSG:1236
+	6289: {565.2369, 50.0087, 465.658, -0.406857, 0.350733, -1.87035, 4.0812}, // Amersfoort, EPSG:15739
+}
+
+const arcSecond = math.Pi / (180 * 3600)
+
+// geocentric converts geodetic coordinates in degrees, with a height above
+// the ellipsoid, to earth centered cartesian coordinates in meters
+func geocentric(lon, lat, h float64, ellipsoid Ellipsoid) (x, y, z float64) {
+	a, e := ellipsoid.SemiMajor, ellipsoid.eccentricity()
+	phi, lambda := lat*degree, lon*degree
+	sinPhi := math.Sin(phi)
+	n := a / math.Sqrt(1-e*e*sinPhi*sinPhi)
+	return (n + h) * math.Cos(phi) * math.Cos(lambda), (n + h) * math.Cos(phi) * math.Sin(lambda), (n*(1-e*e) + h) * sinPhi
+}
+
+// geodetic converts earth centered coordinates back to longitude and latitude
+// in degrees, the height is dropped
+func geodetic(x, y, z float64, ellipsoid Ellipsoid) (lon, lat float64) {
+	a, e := ellipsoid.SemiMajor, ellipsoid.eccentricity()
+	e2 := e * e
+	p := math.Hypot(x, y)
+	phi := math.Atan2(z, p*(1-e2))
+	for i := 0; i < 10; i++ {
+		sinPhi := math.Sin(phi)
+		n := a / math.Sqrt(1-e2*sinPhi*sinPhi)
+		next := math.Atan2(z+e2*n*sinPhi, p)
+		if math.Abs(next-phi) < 1e-14 {
+			phi = next
+			break
+		}
+		phi = next
+	}
+	return math.Atan2(y, x) / degree, phi / degree
+}
+
+// helmert applies position vector parameters, or their inverse, to
+// geocentric coordinates
+func helmert(p [7]float64, x, y, z float64, inverse bool) (float64, float64, float64) {
+	tx, ty, tz := p[0], p[1], p[2]
+	rx, ry, rz := p[3]*arcSecond, p[4]*arcSecond, p[5]*arcSecond
+	s := 1 + p[6]*1e-6
+	if inverse {
+		tx, ty, tz, rx, ry, rz, s = -tx, -ty, -tz, -rx, -ry, -rz, 1/s
+		x, y, z = x+tx, y+ty, z+tz
+		return s * (x - rz*y + ry*z), s * (rz*x + y - rx*z), s * (-ry*x + rx*y + z)
+	}
+	return tx + s*(x-rz*y+ry*z), ty + s*(rz*x+y-rx*z), tz + s*(-ry*x+rx*y+z)
+}
+
+// Transformer converts coordinates from one CRS to another, geographic
+// coordinates are longitude (x) and latitude (y) in the angular units of
+// their system
+type Transformer struct {
+	src, dst         *CRS
+	srcProj, dstProj Projection
+	shift            bool // the datums differ, coordinates go through WGS 84
+	srcShift         [7]float64
+	dstShift         [7]float64
+}
+
+func sameDatum(a, b *CRS) bool {
+	return a.Datum == b.Datum && a.Datum != 0 ||
+		sameValue(a.Ellipsoid.SemiMajor, b.Ellipsoid.SemiMajor) && sameValue(a.Ellipsoid.InvFlattening, b.Ellipsoid.InvFlattening) &&
+			(a.Datum == 0 || b.Datum == 0)
+}
+
+// NewTransformer converts from src to dst, shifting datums through WGS 84
+// with the Helmert parameters of toWGS84
+func NewTransformer(src, dst *CRS) (*Transformer, error) {
+	if src == nil || dst == nil {
+		return nil, GeneralIssue("NewTransformer: missing CRS")
+	}
+	t := &Transformer{src: src, dst: dst}
+	for _, c := range []*CRS{src, dst} {
+		if c.ModelType == ModelTypeGeocentric {
+			return nil, UnsupportedError("geocentric coordinates")
+		}
+	}
+	var err error
+	if src.IsProjected() {
+		if t.srcProj, err = src.Projector(); err != nil {
+			return nil, err
+		}
+	}
+	if dst.IsProjected() {
+		if t.dstProj, err = dst.Projector(); err != nil {
+			return nil, err
+		}
+	}
+	if !sameDatum(src, dst) {
+		var ok1, ok2 bool
+		t.srcShift, ok1 = src.helmert()
+		t.dstShift, ok2 = dst.helmert()
+		if !ok1 || !ok2 || src.Ellipsoid.SemiMajor == 0 || dst.Ellipsoid.SemiMajor == 0 {
+			return nil, UnsupportedError(fmt.Sprintf("datum shift from %v to %v", src, dst))
+		}
+		t.shift = true
+	}
+	return t, nil
+}
+
+// helmert returns the shift of the datum of c to WGS 84
+func (c *CRS) helmert() ([7]float64, bool) {
+	var p [7]float64
+	if n := len(c.ToWGS84); n == 3 || n == 7 {
+		copy(p[:], c.ToWGS84)
+		return p, true
+	}
+	p, ok := toWGS84[c.Datum]
+	return p, ok
+}
+
+// unitSize is the meters per linear unit of a projected system
+func (c *CRS) unitSize() float64 {
+	if c.LinearUnitSize == 0 {
+		return 1
+	}
+	return c.LinearUnitSize
+}
+
+// Transform converts a single coordinate pair
+func (t *Transformer) Transform(x, y float64) (float64, float64, error) {
+	var lon, lat float64
+	if t.srcProj != nil {
+		var err error
+		unit := t.src.unitSize()
+		if lon, lat, err = t.srcProj.Inverse(x*unit, y*unit); err != nil {
+			return 0, 0, err
+		}
+	} else {
+		lon = angleDegrees(x, t.src.AngularUnits) + t.src.PrimeMeridian
+		lat = angleDegrees(y, t.src.AngularUnits)
+	}
+
+	if t.shift {
+		gx, gy, gz := geocentric(lon, lat, 0, t.src.Ellipsoid)
+		gx, gy, gz = helmert(t.srcShift, gx, gy, gz, false)
+		gx, gy, gz = helmert(t.dstShift, gx, gy, gz, true)
+		lon, lat = geodetic(gx, gy, gz, t.dst.Ellipsoid)
+	}
+
+	if t.dstProj != nil {
+		px, py, err := t.dstProj.Forward(lon, lat)
+		if err != nil {
+			return 0, 0, err
+		}
+		unit := t.dst.unitSize()
+		return px / unit, py / unit, nil
+	}
+	lon -= t.dst.PrimeMeridian
+	degrees := angleDegrees(1, t.dst.AngularUnits)
+	return lon / degrees, lat / degrees, nil
+}
+
+// boundsSamples is the number of points transformed along each edge of a
+// box, projected edges are curves
+const boundsSamples = 21
+
+// TransformBounds returns the envelope of the transformed edges of b
+func (t *Transformer) TransformBounds(b *Bounds) (*Bounds, error) {
+	r := &Bounds{MinX: math.MaxFloat64, MinY: math.MaxFloat64, MaxX: -math.MaxFloat64, MaxY: -math.MaxFloat64}
+	add := func(x, y float64) error {
+		tx, ty, err := t.Transform(x, y)
+		if err != nil {
+			return err
+		}
+		r.MinX, r.MaxX = math.Min(r.MinX, tx), math.Max(r.MaxX, tx)
+		r.MinY, r.MaxY = math.Min(r.MinY, ty), math.Max(r.MaxY, ty)
+		return nil
+	}
+	for i := 0; i < boundsSamples; i++ {
+		f := float64(i) / (boundsSamples - 1)
+		x := b.MinX + f*(b.MaxX-b.MinX)
+		y := b.MinY + f*(b.MaxY-b.MinY)
+		for _, p := range [][2]float64{{x, b.MinY}, {x, b.MaxY}, {b.MinX, y}, {b.MaxX, y}} {
+			if err := add(p[0], p[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ox, oy, err := t.Transform(b.OriginX, b.OriginY)
+	if err != nil {
+		return nil, err
+	}
+	r.OriginX, r.OriginY = ox, oy
+	return r, nil
+}
+
+// UTMZone returns the EPSG code of the WGS 84 UTM zone holding a longitude
+// and latitude in degrees, without the Norway and Svalbard exceptions
+func UTMZone(lon, lat float64) int {
+	zone := int(math.Floor((normalizeAngle(lon*degree)/degree+180)/6)) + 1
+	if zone > 60 {
+		zone = 60
+	}
+	hemisphere := "N"
+	if lat < 0 {
+		hemisphere = "S"
+	}
+	return int(PcsZones[fmt.Sprintf("%d%s", zone, hemisphere)])
+}
+
+// projectedCRS returns the CRS of the image when it is projected, nil for
+// geographic images and images without GeoKeys whose bounds are degrees
+func (d *decoder) projectedCRS() *CRS {
+	if crs, err := d.CRS(); err == nil && crs.IsProjected() {
+		return crs
+	}
+	return nil
+}
+
+// LonLatBounds returns the bounds of the image as WGS 84 longitude and
+// latitude, transforming the edges of projected images
+func (d *decoder) LonLatBounds() (*Bounds, error) {
+	b, err := d.Bounds()
+	if err != nil {
+		return nil, err
+	}
+	crs, err := d.CRS()
+	if err != nil {
+		return nil, err
+	}
+	wgs84, err := LookupEPSG(4326)
+	if err != nil {
+		return nil, err
+	}
+	t, err := NewTransformer(crs, wgs84)
+	if err != nil {
+		return nil, err
+	}
+	return t.TransformBounds(b)
+}
+
+// geographicBounds returns the bounds in degrees used for ground distances,
+// the plain bounds of images that are not projected
+func (d *decoder) geographicBounds() (*Bounds, error) {
+	if d.projectedCRS() != nil {
+		return d.LonLatBounds()
+	}
+	return d.Bounds()
+}
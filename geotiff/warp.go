@@ -0,0 +1,272 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"math"
+)
+
+// Resampling selects how Warp computes a target pixel from the source pixels
+type Resampling int
+
+const (
+	Nearest  Resampling = iota // value of the source pixel holding the target pixel center
+	Bilinear                   // weighted 2x2 neighbourhood
+	Cubic                      // cubic convolution over a 4x4 neighbourhood
+	Average                    // mean of the source pixels covered by the target pixel
+	Mode                       // most frequent source value covered by the target pixel
+)
+
+func (r Resampling) String() string {
+	switch r {
+	case Nearest:
+		return "nearest"
+	case Bilinear:
+		return "bilinear"
+	case Cubic:
+		return "cubic"
+	case Average:
+		return "average"
+	case Mode:
+		return "mode"
+	}
+	return fmt.Sprintf("Resampling(%d)", int(r))
+}
+
+const noData = -9999.0
+
+// WarpTarget describes the grid Warp resamples onto. Bounds default to the
+// envelope of the transformed source, Width and Height take precedence over
+// Resolution, which defaults to square pixels keeping the source pixel count.
+type WarpTarget struct {
+	CRS        *CRS
+	Bounds     *Bounds // in the units of CRS
+	Resolution float64 // pixel size in the units of CRS
+	Width      int
+	Height     int
+}
+
+// grid returns the size and north up geotransform of the target
+func (t *WarpTarget) grid(src *Raster, srcBounds *Bounds, tr *Transformer) (int, int, GeoTransform, error) {
+	b := t.Bounds
+	if b == nil {
+		var err error
+		if b, err = tr.TransformBounds(srcBounds); err != nil {
+			return 0, 0, GeoTransform{}, err
+		}
+	}
+	dx, dy := b.MaxX-b.MinX, b.MaxY-b.MinY
+	if !(dx > 0 && dy > 0) {
+		return 0, 0, GeoTransform{}, GeneralIssue(fmt.Sprintf("empty warp extent %v", b))
+	}
+	w, h := t.Width, t.Height
+	var rx, ry float64
+	switch {
+	case w > 0 && h > 0:
+		rx, ry = dx/float64(w), dy/float64(h)
+	default:
+		res := t.Resolution
+		if res <= 0 {
+			res = math.Sqrt(dx * dy / float64(src.w*src.h))
+		}
+		w, h = int(math.Ceil(dx/res-snap)), int(math.Ceil(dy/res-snap))
+		rx, ry = res, res
+	}
+	return w, h, GeoTransform{b.MinX, rx, 0, b.MaxY, 0, -ry}, nil
+}
+
+// Warp resamples src, placed by srcGT in srcCRS, onto a grid of the target
+// CRS. srcCRS defaults to the CRS of src. Pixels of src equal to -9999 are
+// nodata and left out of every kernel, target pixels without source data are
+// set to -9999. The geotransform of the returned raster is returned with it.
+func Warp(src *Raster, srcGT GeoTransform, srcCRS *CRS, target *WarpTarget, resampling Resampling) (*Raster, GeoTransform, error) {
+	if target == nil || target.CRS == nil {
+		return nil, GeoTransform{}, GeneralIssue("Warp: missing target CRS")
+	}
+	if srcCRS == nil {
+		srcCRS = src.CRS
+	}
+	if resampling < Nearest || resampling > Mode {
+		return nil, GeoTransform{}, UnsupportedError(resampling.String())
+	}
+	forward, err := NewTransformer(srcCRS, target.CRS)
+	if err != nil {
+		return nil, GeoTransform{}, err
+	}
+	inverse, err := NewTransformer(target.CRS, srcCRS)
+	if err != nil {
+		return nil, GeoTransform{}, err
+	}
+	toPixel, err := srcGT.Invert()
+	if err != nil {
+		return nil, GeoTransform{}, err
+	}
+	w, h, gt, err := target.grid(src, srcGT.Bounds(src.w, src.h), forward)
+	if err != nil {
+		return nil, GeoTransform{}, err
+	}
+
+	// sourcePixel maps a target raster position to a source raster position
+	sourcePixel := func(col, row float64) (float64, float64, bool) {
+		x, y := gt.PixelToModel(col, row)
+		sx, sy, err := inverse.Transform(x, y)
+		if err != nil || math.IsNaN(sx) || math.IsNaN(sy) {
+			return 0, 0, false
+		}
+		c, r := toPixel.PixelToModel(sx, sy)
+		return c, r, true
+	}
+
+	dst := NewRaster(w, h)
+	dst.CRS = target.CRS
+	for row := 0; row < h; row++ {
+		for col := 0; col < w; col++ {
+			v := float32(noData)
+			if c, r, ok := sourcePixel(float64(col)+0.5, float64(row)+0.5); ok {
+				switch resampling {
+				case Nearest:
+					v = nearest(src, c, r)
+				case Bilinear:
+					v = bilinear(src, c, r)
+				case Cubic:
+					v = cubic(src, c, r)
+				default:
+					// the footprint of the target pixel in the source
+					minC, minR, maxC, maxR := c, r, c, r
+					for _, corner := range [][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+						if cc, cr, ok := sourcePixel(float64(col)+corner[0], float64(row)+corner[1]); ok {
+							minC, maxC = math.Min(minC, cc), math.Max(maxC, cc)
+							minR, maxR = math.Min(minR, cr), math.Max(maxR, cr)
+						}
+					}
+					v = footprint(src, minC, minR, maxC, maxR, resampling == Mode)
+				}
+			}
+			dst.SetValue(row, col, v)
+		}
+	}
+	return dst, gt, nil
+}
+
+// sample returns the source value at a pixel, ok is false outside of the
+// raster and for nodata
+func sample(src *Raster, col, row int) (float32, bool) {
+	if col < 0 || row < 0 || col >= src.w || row >= src.h {
+		return noData, false
+	}
+	v := src.ValueAt(row, col)
+	return v, v != noData
+}
+
+func nearest(src *Raster, c, r float64) float32 {
+	v, _ := sample(src, int(math.Floor(c)), int(math.Floor(r)))
+	return v
+}
+
+// bilinear interpolates between pixel centers, weights of missing pixels are
+// redistributed over the others
+func bilinear(src *Raster, c, r float64) float32 {
+	if _, ok := sample(src, int(math.Floor(c)), int(math.Floor(r))); !ok {
+		return noData
+	}
+	c, r = c-0.5, r-0.5
+	c0, r0 := math.Floor(c), math.Floor(r)
+	fc, fr := c-c0, r-r0
+	var sum, weights float64
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 2; i++ {
+			v, ok := sample(src, int(c0)+i, int(r0)+j)
+			if !ok {
+				continue
+			}
+			w := (1 - math.Abs(float64(i)-fc)) * (1 - math.Abs(float64(j)-fr))
+			sum += w * float64(v)
+			weights += w
+		}
+	}
+	if weights == 0 {
+		return noData
+	}
+	return float32(sum / weights)
+}
+
+// cubicWeight is the Keys kernel with a = -0.5
+func cubicWeight(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x <= 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// cubic convolution, falling back to bilinear when part of the 4x4
+// neighbourhood is missing
+func cubic(src *Raster, c, r float64) float32 {
+	if _, ok := sample(src, int(math.Floor(c)), int(math.Floor(r))); !ok {
+		return noData
+	}
+	cc, rc := c-0.5, r-0.5
+	c0, r0 := math.Floor(cc), math.Floor(rc)
+	var sum float64
+	for j := -1; j <= 2; j++ {
+		wr := cubicWeight(rc - r0 - float64(j))
+		for i := -1; i <= 2; i++ {
+			v, ok := sample(src, int(c0)+i, int(r0)+j)
+			if !ok {
+				return bilinear(src, c, r)
+			}
+			sum += wr * cubicWeight(cc-c0-float64(i)) * float64(v)
+		}
+	}
+	return float32(sum)
+}
+
+// footprint averages, or finds the most frequent of, the source pixels whose
+// centers fall in a box of the source raster. A box smaller than a pixel
+// takes the pixel holding its center.
+func footprint(src *Raster, minC, minR, maxC, maxR float64, mode bool) float32 {
+	c0, c1 := int(math.Ceil(minC-0.5)), int(math.Floor(maxC-0.5))
+	r0, r1 := int(math.Ceil(minR-0.5)), int(math.Floor(maxR-0.5))
+	if c1 < c0 || r1 < r0 {
+		return nearest(src, (minC+maxC)/2, (minR+maxR)/2)
+	}
+	var sum float64
+	var n int
+	var counts map[float32]int
+	if mode {
+		counts = make(map[float32]int)
+	}
+	for row := r0; row <= r1; row++ {
+		for col := c0; col <= c1; col++ {
+			v, ok := sample(src, col, row)
+			if !ok {
+				continue
+			}
+			sum += float64(v)
+			n++
+			if mode {
+				counts[v]++
+			}
+		}
+	}
+	if n == 0 {
+		return noData
+	}
+	if !mode {
+		return float32(sum / float64(n))
+	}
+	best, bestCount := float32(0), 0
+	for v, count := range counts {
+		// ties go to the smallest value so that the result is repeatable
+		if count > bestCount || count == bestCount && v < best {
+			best, bestCount = v, count
+		}
+	}
+	return best
+}
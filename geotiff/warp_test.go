@@ -0,0 +1,116 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWarpSameGrid(t *testing.T) {
+	wgs84, _ := LookupEPSG(4326)
+	src := NewRaster(8, 6)
+	for i := range src.Data {
+		src.Data[i] = float32(i % 7)
+	}
+	src.Data[9] = noData
+	gt := GeoTransform{10, 0.5, 0, 50, 0, -0.5}
+	for r := Nearest; r <= Mode; r++ {
+		dst, dstGT, err := Warp(src, gt, wgs84, &WarpTarget{CRS: wgs84}, r)
+		if err != nil {
+			t.Fatalf("%v: %v", r, err)
+		}
+		if dst.Width() != 8 || dst.Height() != 6 {
+			t.Fatalf("%v: %vx%v raster", r, dst.Width(), dst.Height())
+		}
+		for i := range gt {
+			if !near(gt[i], dstGT[i]) {
+				t.Fatalf("%v: geotransform %v", r, dstGT)
+			}
+		}
+		for i, v := range dst.Data {
+			if math.Abs(float64(v-src.Data[i])) > 1e-4 {
+				t.Errorf("%v: pixel %d is %v, expected %v", r, i, v, src.Data[i])
+				break
+			}
+		}
+	}
+	if _, _, err := Warp(src, gt, wgs84, &WarpTarget{CRS: wgs84}, Resampling(9)); err == nil {
+		t.Error("expected an error for an unknown resampling")
+	}
+}
+
+func TestWarpDownsample(t *testing.T) {
+	wgs84, _ := LookupEPSG(4326)
+	src := NewRaster(4, 2)
+	copy(src.Data, []float32{1, 2, 5, 5, 3, 2, 7, noData})
+	gt := GeoTransform{0, 1, 0, 2, 0, -1}
+	target := &WarpTarget{CRS: wgs84, Resolution: 2}
+	avg, _, err := Warp(src, gt, wgs84, target, Average)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if avg.Width() != 2 || avg.Height() != 1 || avg.Data[0] != 2 || avg.Data[1] != 17.0/3 {
+		t.Errorf("average %v", avg.Data)
+	}
+	mode, _, err := Warp(src, gt, wgs84, target, Mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode.Data[0] != 2 || mode.Data[1] != 5 {
+		t.Errorf("mode %v", mode.Data)
+	}
+}
+
+func TestWarpUTM(t *testing.T) {
+	utm, _ := LookupEPSG(32632)
+	wgs84, _ := LookupEPSG(4326)
+	// each pixel holds its easting in kilometers
+	src := NewRaster(100, 100)
+	for row := 0; row < 100; row++ {
+		for col := 0; col < 100; col++ {
+			src.SetValue(row, col, float32(500+col)+0.5)
+		}
+	}
+	gt := GeoTransform{500000, 1000, 0, 5600000, 0, -1000}
+	target := &WarpTarget{CRS: wgs84, Bounds: &Bounds{MinX: 8, MaxX: 11, MinY: 49.5, MaxY: 50.5}, Resolution: 0.01}
+	dst, dstGT, err := Warp(src, gt, utm, target, Bilinear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Width() != 300 || dst.Height() != 100 {
+		t.Fatalf("%vx%v raster", dst.Width(), dst.Height())
+	}
+	// west of the central meridian is outside of the source
+	if v := dst.ValueAt(50, 10); v != noData {
+		t.Errorf("pixel west of 9E is %v", v)
+	}
+	toUTM, _ := NewTransformer(wgs84, utm)
+	for _, p := range [][2]int{{50, 150}, {10, 190}, {60, 120}} {
+		lon, lat := dstGT.PixelToModel(float64(p[1])+0.5, float64(p[0])+0.5)
+		x, _, _ := toUTM.Transform(lon, lat)
+		if v := dst.ValueAt(p[0], p[1]); math.Abs(float64(v)-x/1000) > 0.01 {
+			t.Errorf("pixel %v is %v, expected %v", p, v, x/1000)
+		}
+	}
+}
+
+func TestWarpCRS(t *testing.T) {
+	wgs84, _ := LookupEPSG(4326)
+	web, _ := LookupEPSG(epsgWebMercator)
+	src := NewRaster(4, 4)
+	gt := GeoTransform{10, 0.25, 0, 46, 0, -0.25}
+	if _, _, err := Warp(src, gt, nil, &WarpTarget{CRS: web}, Nearest); err == nil {
+		t.Error("warp without a source CRS")
+	}
+	src.CRS = wgs84
+	dst, _, err := Warp(src, gt, nil, &WarpTarget{CRS: web}, Nearest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.CRS != web {
+		t.Errorf("warped raster CRS %v, expected %v", dst.CRS, web)
+	}
+}
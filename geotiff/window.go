@@ -0,0 +1,96 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// snap tolerates rounding errors when a window edge falls on a pixel edge
+const snap = 1e-9
+
+// ReadPixelWindow decodes the w x h pixels at column x and row y of a single
+// band image, reading only the strips or tiles that intersect the window.
+// The window is clipped to the image, the returned Bounds cover the pixels
+// read and are nil when the image is not georeferenced.
+func (d *decoder) ReadPixelWindow(x, y, w, h int) (*Raster, *Bounds, error) {
+	if n := d.NumBands(); n != 1 {
+		return nil, nil, GeneralIssue(fmt.Sprintf("data is not single band: samplesPerPixel=%v", n))
+	}
+	m, bounds, err := d.pixelWindow([]int{0}, x, y, w, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.Bands[0], bounds, nil
+}
+
+// ReadBandsWindow decodes every band of a pixel window like ReadPixelWindow
+func (d *decoder) ReadBandsWindow(x, y, w, h int) (*MultiRaster, *Bounds, error) {
+	bands := make([]int, d.NumBands())
+	for i := range bands {
+		bands[i] = i
+	}
+	return d.pixelWindow(bands, x, y, w, h)
+}
+
+func (d *decoder) pixelWindow(bands []int, x, y, w, h int) (*MultiRaster, *Bounds, error) {
+	width, e1 := d.IntegerValue(tImageWidth)
+	height, e2 := d.IntegerValue(tImageLength)
+	if e := checkFailure(e1, e2); e != nil {
+		return nil, nil, e
+	}
+	full := image.Rect(0, 0, int(width), int(height))
+	win := image.Rect(x, y, x+w, y+h).Intersect(full)
+	if w <= 0 || h <= 0 || win.Empty() {
+		return nil, nil, GeneralIssue(fmt.Sprintf("window %dx%d at %d,%d does not intersect the image %v", w, h, x, y, full))
+	}
+	m, err := d.readBands(bands, &win)
+	if err != nil {
+		return nil, nil, err
+	}
+	var bounds *Bounds
+	if gt, err := d.GeoTransform(); err == nil {
+		bounds = gt.Translate(float64(win.Min.X), float64(win.Min.Y)).Bounds(win.Dx(), win.Dy())
+	}
+	return m, bounds, nil
+}
+
+// ReadWindow decodes the pixels of a single band image that intersect bounds,
+// given in the units of the image. The window grows to whole pixels, covering
+// every corner of bounds when the image is rotated, and is clipped to the
+// image. The returned Bounds cover the pixels read.
+func (d *decoder) ReadWindow(bounds *Bounds) (*Raster, *Bounds, error) {
+	gt, err := d.GeoTransform()
+	if err != nil {
+		return nil, nil, err
+	}
+	inv, err := gt.Invert()
+	if err != nil {
+		return nil, nil, err
+	}
+	width, e1 := d.IntegerValue(tImageWidth)
+	height, e2 := d.IntegerValue(tImageLength)
+	if e := checkFailure(e1, e2); e != nil {
+		return nil, nil, e
+	}
+	minCol, minRow := math.MaxFloat64, math.MaxFloat64
+	maxCol, maxRow := -math.MaxFloat64, -math.MaxFloat64
+	for _, corner := range [][2]float64{{bounds.MinX, bounds.MinY}, {bounds.MinX, bounds.MaxY}, {bounds.MaxX, bounds.MinY}, {bounds.MaxX, bounds.MaxY}} {
+		col, row := inv.PixelToModel(corner[0], corner[1])
+		minCol, maxCol = math.Min(minCol, col), math.Max(maxCol, col)
+		minRow, maxRow = math.Min(minRow, row), math.Max(maxRow, row)
+	}
+	// clamp before converting, far away bounds must not overflow an int
+	pixel := func(v float64, limit uint) int {
+		return int(math.Max(-1, math.Min(v, float64(limit)+1)))
+	}
+	x0 := pixel(math.Floor(minCol+snap), width)
+	x1 := pixel(math.Ceil(maxCol-snap), width)
+	y0 := pixel(math.Floor(minRow+snap), height)
+	y1 := pixel(math.Ceil(maxRow-snap), height)
+	return d.ReadPixelWindow(x0, y0, x1-x0, y1-y0)
+}
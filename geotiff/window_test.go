@@ -0,0 +1,111 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// countingReader counts the reads made after the directories are loaded
+type countingReader struct {
+	*bytes.Reader
+	reads int
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.Reader.ReadAt(p, off)
+}
+
+func TestReadPixelWindow(t *testing.T) {
+	raster := testRaster(45, 37)
+	bounds := &Bounds{MinX: 100, MaxX: 145, MinY: 10, MaxY: 47}
+	tests := []struct {
+		opts       *Options
+		x, y, w, h int
+		reads      int
+	}{
+		{&Options{TileWidth: 16, TileLength: 16}, 20, 20, 5, 5, 1},
+		{&Options{TileWidth: 16, TileLength: 16, Compression: Deflate}, 10, 10, 10, 10, 4},
+		{&Options{RowsPerStrip: 8, Compression: LZW, Predictor: 2}, 0, 9, 45, 6, 1},
+		{&Options{RowsPerStrip: 8}, 40, 30, 20, 20, 2}, // clipped to 5x7
+	}
+	for i, tc := range tests {
+		f := &memFile{}
+		if err := Encode(f, raster, bounds, tc.opts); err != nil {
+			t.Fatalf("test %d: Encode: %v", i, err)
+		}
+		r := &countingReader{Reader: bytes.NewReader(f.data)}
+		tif, err := NewDecoder(r)
+		if err != nil {
+			t.Fatalf("test %d: NewDecoder: %v", i, err)
+		}
+		r.reads = 0
+		got, b, err := tif.ReadPixelWindow(tc.x, tc.y, tc.w, tc.h)
+		if err != nil {
+			t.Fatalf("test %d: ReadPixelWindow: %v", i, err)
+		}
+		if r.reads != tc.reads {
+			t.Errorf("test %d: %d blocks read, expected %d", i, r.reads, tc.reads)
+		}
+		w, h := tc.w, tc.h
+		if tc.x+w > 45 {
+			w = 45 - tc.x
+		}
+		if tc.y+h > 37 {
+			h = 37 - tc.y
+		}
+		if got.Width() != w || got.Height() != h {
+			t.Fatalf("test %d: window is %dx%d, expected %dx%d", i, got.Width(), got.Height(), w, h)
+		}
+		for row := 0; row < h; row++ {
+			for col := 0; col < w; col++ {
+				if v, want := got.ValueAt(row, col), raster.ValueAt(tc.y+row, tc.x+col); v != want {
+					t.Fatalf("test %d: value at %d,%d is %v, expected %v", i, row, col, v, want)
+				}
+			}
+		}
+		// one unit per pixel
+		if b.MinX != float64(100+tc.x) || b.MaxY != float64(47-tc.y) || b.MaxX != b.MinX+float64(w) || b.MinY != b.MaxY-float64(h) {
+			t.Errorf("test %d: bounds %v", i, b)
+		}
+	}
+}
+
+func TestReadWindow(t *testing.T) {
+	raster := testRaster(45, 37)
+	bounds := &Bounds{MinX: 100, MaxX: 145, MinY: 10, MaxY: 47}
+	f := &memFile{}
+	if err := Encode(f, raster, bounds, &Options{TileWidth: 16, TileLength: 16}); err != nil {
+		t.Fatal(err)
+	}
+	tif, err := NewDecoder(bytes.NewReader(f.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pixel edges are kept, partial pixels are included
+	for _, aoi := range []*Bounds{{MinX: 110, MaxX: 115, MinY: 30, MaxY: 40}, {MinX: 110.3, MaxX: 114.2, MinY: 30.1, MaxY: 39.9}} {
+		got, b, err := tif.ReadWindow(aoi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Width() != 5 || got.Height() != 10 {
+			t.Errorf("window %v is %dx%d", aoi, got.Width(), got.Height())
+		}
+		if math.Floor(aoi.MinX) != b.MinX || math.Ceil(aoi.MaxY) != b.MaxY {
+			t.Errorf("window %v has bounds %v", aoi, b)
+		}
+		if got.ValueAt(0, 0) != raster.ValueAt(47-int(b.MaxY), int(b.MinX)-100) {
+			t.Errorf("window %v starts with %v", aoi, got.ValueAt(0, 0))
+		}
+	}
+
+	if _, _, err := tif.ReadWindow(&Bounds{MinX: 0, MaxX: 10, MinY: 0, MaxY: 10}); err == nil {
+		t.Error("expected an error for a window outside of the image")
+	}
+}
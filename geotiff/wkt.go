@@ -0,0 +1,741 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wktNode is a KEYWORD[...] element of well known text, or a leaf holding a
+// quoted string, number or enumeration when keyword is empty
+type wktNode struct {
+	keyword  string
+	value    string
+	children []*wktNode
+}
+
+// child returns the first child node with one of the keywords
+func (n *wktNode) child(keywords ...string) *wktNode {
+	for _, c := range n.children {
+		for _, k := range keywords {
+			if c.keyword == k {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// text returns the i-th leaf of the node
+func (n *wktNode) text(i int) string {
+	for _, c := range n.children {
+		if c.keyword == "" {
+			if i == 0 {
+				return c.value
+			}
+			i--
+		}
+	}
+	return ""
+}
+
+func (n *wktNode) number(i int) (float64, error) {
+	v, err := strconv.ParseFloat(n.text(i), 64)
+	if err != nil {
+		return 0, GeneralIssue(fmt.Sprintf("WKT %s: %v", n.keyword, err))
+	}
+	return v, nil
+}
+
+// epsg returns the code of an AUTHORITY (WKT1) or ID (WKT2) child naming the
+// EPSG authority, 0 otherwise
+func (n *wktNode) epsg() int {
+	a := n.child("AUTHORITY", "ID")
+	if a == nil || !strings.EqualFold(a.text(0), "EPSG") {
+		return 0
+	}
+	code, _ := strconv.Atoi(a.text(1))
+	return code
+}
+
+type wktParser struct {
+	s   string
+	pos int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *wktParser) errorf(format string, args ...interface{}) error {
+	return GeneralIssue(fmt.Sprintf("WKT at offset %d: %s", p.pos, fmt.Sprintf(format, args...)))
+}
+
+// item parses a node or a leaf
+func (p *wktParser) item() (*wktNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("unexpected end")
+	}
+	if p.s[p.pos] == '"' {
+		var b strings.Builder
+		for p.pos++; p.pos < len(p.s); p.pos++ {
+			if p.s[p.pos] == '"' {
+				// a doubled quote is a quote inside the string
+				if p.pos+1 < len(p.s) && p.s[p.pos+1] == '"' {
+					b.WriteByte('"')
+					p.pos++
+					continue
+				}
+				p.pos++
+				return &wktNode{value: b.String()}, nil
+			}
+			b.WriteByte(p.s[p.pos])
+		}
+		return nil, p.errorf("unterminated string")
+	}
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(",[]()\"", rune(p.s[p.pos])) && !unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	word := p.s[start:p.pos]
+	if word == "" {
+		return nil, p.errorf("unexpected %q", p.s[p.pos])
+	}
+	p.skipSpace()
+	if p.pos >= len(p.s) || (p.s[p.pos] != '[' && p.s[p.pos] != '(') {
+		return &wktNode{value: word}, nil
+	}
+	end := byte(']')
+	if p.s[p.pos] == '(' {
+		end = ')'
+	}
+	p.pos++
+	n := &wktNode{keyword: strings.ToUpper(word)}
+	for {
+		c, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		n.children = append(n.children, c)
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("unexpected end in %s", n.keyword)
+		}
+		switch p.s[p.pos] {
+		case ',':
+			p.pos++
+		case end:
+			p.pos++
+			return n, nil
+		default:
+			return nil, p.errorf("unexpected %q in %s", p.s[p.pos], n.keyword)
+		}
+	}
+}
+
+// normalizeName keeps the lower case letters and digits of a WKT name
+func normalizeName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// wktDatums are the names of datums in EPSG, WKT1 and ESRI usage
+var wktDatums = map[string]int{
+	"wgs84":                           6326,
+	"wgs1984":                         6326,
+	"worldgeodeticsystem1984":         6326,
+	"worldgeodeticsystem1984ensemble": 6326,
+	"wgs72":                           6322,
+	"wgs1972":                         6322,
+	"worldgeodeticsystem1972":         6322,
+	"nad83":                           6269,
+	"northamericandatum1983":          6269,
+	"northamerican1983":               6269,
+	"nad27":                           6267,
+	"northamericandatum1927":          6267,
+	"northamerican1927":               6267,
+	"ed50":                            6230,
+	"europeandatum1950":               6230,
+	"european1950":                    6230,
+	"gda94":                           6283,
+	"geocentricdatumofaustralia1994":  6283,
+	"agd66":                           6202,
+	"australiangeodeticdatum1966":     6202,
+	"agd84":                           6203,
+	"australiangeodeticdatum1984":     6203,
+	"amersfoort":                      6289,
+}
+
+// datumCode finds the EPSG code of a datum by name, ESRI names start with D_
+func datumCode(name string) int {
+	if strings.HasPrefix(name, "D_") {
+		name = name[2:]
+	}
+	key := normalizeName(name)
+	if code, ok := wktDatums[key]; ok {
+		return code
+	}
+	for code, n := range GeogGeodeticDatumGeoKey {
+		n = strings.TrimPrefix(strings.TrimPrefix(n, "DatumE_"), "Datum_")
+		if normalizeName(n) == key {
+			return code
+		}
+	}
+	return 0
+}
+
+// wktMethods maps projection method names and EPSG method codes to
+// ProjCoordTransGeoKey values
+var wktMethods = map[string]int{
+	"transversemercator":                 ctTransverseMercator,
+	"gausskruger":                        ctTransverseMercator,
+	"9807":                               ctTransverseMercator,
+	"mercator":                           ctMercator,
+	"mercator1sp":                        ctMercator,
+	"mercator2sp":                        ctMercator,
+	"mercatorvarianta":                   ctMercator,
+	"mercatorvariantb":                   ctMercator,
+	"9804":                               ctMercator,
+	"9805":                               ctMercator,
+	"lambertconformalconic":              ctLambertConfConic2SP,
+	"lambertconformalconic2sp":           ctLambertConfConic2SP,
+	"lambertconicconformal2sp":           ctLambertConfConic2SP,
+	"9802":                               ctLambertConfConic2SP,
+	"lambertconformalconic1sp":           ctLambertConfConic1SP,
+	"lambertconicconformal1sp":           ctLambertConfConic1SP,
+	"9801":                               ctLambertConfConic1SP,
+	"albersconicequalarea":               ctAlbersEqualArea,
+	"albersequalarea":                    ctAlbersEqualArea,
+	"albers":                             ctAlbersEqualArea,
+	"9822":                               ctAlbersEqualArea,
+	"popularvisualisationpseudomercator": ctMercator,
+	"mercatorauxiliarysphere":            ctMercator,
+	"1024":                               ctMercator,
+}
+
+// webMercatorMethods only define EPSG:3857
+var webMercatorMethods = map[string]bool{"popularvisualisationpseudomercator": true, "mercatorauxiliarysphere": true, "1024": true}
+
+// wktParameters maps parameter names of WKT1, WKT2 and EPSG parameter codes
+// to GeoKeys
+var wktParameters = map[string]int{
+	"latitudeoforigin":              gkProjNatOriginLatGeoKey,
+	"latitudeofnaturalorigin":       gkProjNatOriginLatGeoKey,
+	"8801":                          gkProjNatOriginLatGeoKey,
+	"centralmeridian":               gkProjNatOriginLongGeoKey,
+	"longitudeoforigin":             gkProjNatOriginLongGeoKey,
+	"longitudeofnaturalorigin":      gkProjNatOriginLongGeoKey,
+	"8802":                          gkProjNatOriginLongGeoKey,
+	"scalefactor":                   gkProjScaleAtNatOriginGeoKey,
+	"scalefactoratnaturalorigin":    gkProjScaleAtNatOriginGeoKey,
+	"8805":                          gkProjScaleAtNatOriginGeoKey,
+	"falseeasting":                  gkProjFalseEastingGeoKey,
+	"8806":                          gkProjFalseEastingGeoKey,
+	"falsenorthing":                 gkProjFalseNorthingGeoKey,
+	"8807":                          gkProjFalseNorthingGeoKey,
+	"standardparallel1":             gkProjStdParallel1GeoKey,
+	"latitudeof1ststandardparallel": gkProjStdParallel1GeoKey,
+	"8823":                          gkProjStdParallel1GeoKey,
+	"standardparallel2":             gkProjStdParallel2GeoKey,
+	"latitudeof2ndstandardparallel": gkProjStdParallel2GeoKey,
+	"8824":                          gkProjStdParallel2GeoKey,
+	"latitudeoffalseorigin":         gkProjFalseOriginLatGeoKey,
+	"8821":                          gkProjFalseOriginLatGeoKey,
+	"longitudeoffalseorigin":        gkProjFalseOriginLongGeoKey,
+	"8822":                          gkProjFalseOriginLongGeoKey,
+	"eastingatfalseorigin":          gkProjFalseOriginEastingGeoKey,
+	"8826":                          gkProjFalseOriginEastingGeoKey,
+	"northingatfalseorigin":         gkProjFalseOriginNorthingGeoKey,
+	"8827":                          gkProjFalseOriginNorthingGeoKey,
+	"latitudeofcenter":              gkProjCenterLatGeoKey,
+	"latitudeofprojectioncentre":    gkProjCenterLatGeoKey,
+	"8811":                          gkProjCenterLatGeoKey,
+	"longitudeofcenter":             gkProjCenterLongGeoKey,
+	"longitudeofprojectioncentre":   gkProjCenterLongGeoKey,
+	"8812":                          gkProjCenterLongGeoKey,
+	"azimuth":                       gkProjAzimuthAngleGeoKey,
+	"azimuthofinitialline":          gkProjAzimuthAngleGeoKey,
+	"8813":                          gkProjAzimuthAngleGeoKey,
+	"scalefactoroninitialline":      gkProjScaleAtCenterGeoKey,
+	"8815":                          gkProjScaleAtCenterGeoKey,
+	"eastingatprojectioncentre":     gkProjCenterEastingGeoKey,
+	"8816":                          gkProjCenterEastingGeoKey,
+	"northingatprojectioncentre":    gkProjCenterNorthingGeoKey,
+	"8817":                          gkProjCenterNorthingGeoKey,
+}
+
+// unitFactor returns the conversion factor of a UNIT, ANGLEUNIT or
+// LENGTHUNIT node, to radians or meters
+func unitFactor(n *wktNode) (float64, error) {
+	if n == nil {
+		return 0, nil
+	}
+	return n.number(1)
+}
+
+// findUnit looks for a unit among the children of a CRS node, WKT2 can give
+// it on each axis instead
+func findUnit(n *wktNode, keywords ...string) *wktNode {
+	if u := n.child(keywords...); u != nil {
+		return u
+	}
+	for _, c := range n.children {
+		if c.keyword == "AXIS" {
+			if u := c.child(keywords...); u != nil {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+// angularUnitCode returns the EPSG code of an angular unit given in radians
+func angularUnitCode(n *wktNode, radians float64) (int, error) {
+	code := n.epsg()
+	if code == 9122 { // degree (supplier to define representation)
+		code = 9102
+	}
+	if _, ok := GeogAngularUnitsGeoKey[code]; ok {
+		return code, nil
+	}
+	for _, code := range []int{9102, 9101, 9105, 9103, 9104} {
+		if sameValue(radians, angleDegrees(1, code)*degree) || math.Abs(radians-angleDegrees(1, code)*degree) < 1e-15 {
+			return code, nil
+		}
+	}
+	return 0, UnsupportedError(fmt.Sprintf("angular unit %q of %v radians", n.text(0), radians))
+}
+
+// parseGeographic fills the datum, ellipsoid, prime meridian and angular
+// units of c from a geographic CRS node
+func parseGeographic(n *wktNode, c *CRS) error {
+	c.AngularUnits = 9102
+	// the unit of geocentric systems is linear
+	if u := findUnit(n, "UNIT", "ANGLEUNIT"); u != nil && c.ModelType != ModelTypeGeocentric {
+		radians, err := unitFactor(u)
+		if err != nil {
+			return err
+		}
+		if c.AngularUnits, err = angularUnitCode(u, radians); err != nil {
+			return err
+		}
+	}
+	datum := n.child("DATUM", "GEODETICDATUM", "TRF", "ENSEMBLE")
+	if datum == nil {
+		return GeneralIssue(fmt.Sprintf("WKT %s %q has no datum", n.keyword, n.text(0)))
+	}
+	if c.Datum = datum.epsg(); c.Datum == 0 {
+		c.Datum = datumCode(datum.text(0))
+	}
+	ellipsoid := datum.child("SPHEROID", "ELLIPSOID")
+	if ellipsoid == nil {
+		ellipsoid = n.child("SPHEROID", "ELLIPSOID")
+	}
+	if ellipsoid == nil {
+		return GeneralIssue(fmt.Sprintf("WKT datum %q has no ellipsoid", datum.text(0)))
+	}
+	a, e1 := ellipsoid.number(1)
+	invf, e2 := ellipsoid.number(2)
+	if e := checkFailure(e1, e2); e != nil {
+		return e
+	}
+	if u := ellipsoid.child("LENGTHUNIT"); u != nil {
+		if size, err := unitFactor(u); err == nil && size > 0 {
+			a *= size
+		}
+	}
+	c.Ellipsoid = Ellipsoid{Code: ellipsoid.epsg(), SemiMajor: a, InvFlattening: invf}
+	if c.Ellipsoid.Code == 0 {
+		for code, e := range ellipsoids {
+			if sameValue(e[0], a) && sameValue(e[1], invf) {
+				c.Ellipsoid.Code = code
+				break
+			}
+		}
+	}
+	if t := datum.child("TOWGS84"); t != nil {
+		for i := 0; i < 7 && t.text(i) != ""; i++ {
+			v, err := t.number(i)
+			if err != nil {
+				return err
+			}
+			c.ToWGS84 = append(c.ToWGS84, v)
+		}
+	}
+	c.PrimeMeridian = 0
+	if pm := n.child("PRIMEM", "PRIMEMERIDIAN"); pm != nil {
+		v, err := pm.number(1)
+		if err != nil {
+			return err
+		}
+		if u := pm.child("ANGLEUNIT", "UNIT"); u != nil {
+			radians, err := unitFactor(u)
+			if err != nil {
+				return err
+			}
+			c.PrimeMeridian = v * radians / degree
+		} else {
+			c.PrimeMeridian = angleDegrees(v, c.AngularUnits)
+		}
+	}
+	c.GeographicEPSG = n.epsg()
+	if c.Datum == 0 {
+		if def, ok := gcsDefinitions[c.GeographicEPSG]; ok {
+			c.Datum = def.datum
+		}
+	}
+	return nil
+}
+
+// parseProjected fills the base geographic system, projection method,
+// parameters and linear units of c from a projected CRS node
+func parseProjected(n *wktNode, c *CRS) error {
+	base := n.child("GEOGCS", "BASEGEOGCRS", "BASEGEODCRS", "GEOGCRS", "GEODCRS")
+	if base == nil {
+		return GeneralIssue(fmt.Sprintf("WKT %s %q has no geographic system", n.keyword, n.text(0)))
+	}
+	if err := parseGeographic(base, c); err != nil {
+		return err
+	}
+
+	c.LinearUnits, c.LinearUnitSize = 9001, 1
+	if u := findUnit(n, "UNIT", "LENGTHUNIT"); u != nil {
+		size, err := unitFactor(u)
+		if err != nil {
+			return err
+		}
+		c.LinearUnits, c.LinearUnitSize = u.epsg(), size
+		if _, ok := linearUnits[c.LinearUnits]; !ok {
+			c.LinearUnits = userDefined
+			for code, s := range linearUnits {
+				if sameValue(s, size) {
+					c.LinearUnits = code
+					break
+				}
+			}
+		}
+	}
+
+	// WKT1 keeps parameters next to the method, WKT2 inside a conversion
+	conversion := n.child("CONVERSION")
+	if conversion == nil {
+		conversion = n
+	}
+	method := conversion.child("PROJECTION", "METHOD", "PROJECTIONMETHOD")
+	if method == nil {
+		return GeneralIssue(fmt.Sprintf("WKT %s %q has no projection method", n.keyword, n.text(0)))
+	}
+	names := []string{normalizeName(method.text(0)), strconv.Itoa(method.epsg())}
+	for _, name := range names {
+		if ct, ok := wktMethods[name]; ok {
+			c.CoordTrans = ct
+			if webMercatorMethods[name] && c.EPSG == 0 {
+				c.EPSG = epsgWebMercator
+			}
+			break
+		}
+	}
+	if c.CoordTrans == 0 {
+		for ct, name := range ProjCoordTransGeoKey {
+			if normalizeName(strings.TrimPrefix(name, "CT_")) == names[0] {
+				c.CoordTrans = ct
+			}
+		}
+	}
+	if c.CoordTrans == 0 {
+		return UnsupportedError(fmt.Sprintf("WKT projection method %q", method.text(0)))
+	}
+
+	c.Parameters = make(map[int]float64)
+	for _, p := range conversion.children {
+		if p.keyword != "PARAMETER" {
+			continue
+		}
+		key, ok := wktParameters[strconv.Itoa(p.epsg())]
+		if !ok {
+			if key, ok = wktParameters[normalizeName(p.text(0))]; !ok {
+				continue
+			}
+		}
+		v, err := p.number(1)
+		if err != nil {
+			return err
+		}
+		// WKT2 parameters carry their own units, WKT1 use those of the CRS
+		if u := p.child("ANGLEUNIT"); u != nil {
+			radians, err := unitFactor(u)
+			if err != nil {
+				return err
+			}
+			v = v * radians / (angleDegrees(1, c.AngularUnits) * degree)
+		} else if u := p.child("LENGTHUNIT"); u != nil {
+			size, err := unitFactor(u)
+			if err != nil {
+				return err
+			}
+			v = v * size / c.LinearUnitSize
+		}
+		c.Parameters[key] = v
+	}
+	// the keys of conic origins follow the GeoKeys written by GDAL
+	for from, to := range canonicalParameters[c.CoordTrans] {
+		if v, ok := c.Parameters[from]; ok {
+			delete(c.Parameters, from)
+			if _, ok := c.Parameters[to]; !ok {
+				c.Parameters[to] = v
+			}
+		}
+	}
+	return nil
+}
+
+// canonicalParameters renames the parameters of methods whose origin has
+// several names in WKT
+var canonicalParameters = map[int]map[int]int{
+	ctLambertConfConic2SP: {
+		gkProjNatOriginLatGeoKey:  gkProjFalseOriginLatGeoKey,
+		gkProjNatOriginLongGeoKey: gkProjFalseOriginLongGeoKey,
+		gkProjFalseEastingGeoKey:  gkProjFalseOriginEastingGeoKey,
+		gkProjFalseNorthingGeoKey: gkProjFalseOriginNorthingGeoKey,
+	},
+	ctAlbersEqualArea: {
+		gkProjCenterLatGeoKey:           gkProjNatOriginLatGeoKey,
+		gkProjCenterLongGeoKey:          gkProjNatOriginLongGeoKey,
+		gkProjFalseOriginLatGeoKey:      gkProjNatOriginLatGeoKey,
+		gkProjFalseOriginLongGeoKey:     gkProjNatOriginLongGeoKey,
+		gkProjFalseOriginEastingGeoKey:  gkProjFalseEastingGeoKey,
+		gkProjFalseOriginNorthingGeoKey: gkProjFalseNorthingGeoKey,
+	},
+}
+
+// ParseWKT builds a CRS from the well known text of a coordinate reference
+// system, in the WKT1 form of OGC 01-009 and GDAL, the ESRI dialect, or WKT2
+// (ISO 19162). The horizontal part of compound systems is used, bound
+// systems give their source system.
+func ParseWKT(wkt string) (*CRS, error) {
+	p := &wktParser{s: strings.TrimRight(wkt, "\x00 \t\r\n")}
+	n, err := p.item()
+	if err != nil {
+		return nil, err
+	}
+	if n.keyword == "" {
+		return nil, GeneralIssue(fmt.Sprintf("WKT does not start with a keyword: %q", n.value))
+	}
+	for {
+		switch n.keyword {
+		case "COMPD_CS", "COMPOUNDCRS":
+			var horizontal *wktNode
+			for _, c := range n.children {
+				switch c.keyword {
+				case "PROJCS", "GEOGCS", "PROJCRS", "PROJECTEDCRS", "GEOGCRS", "GEOGRAPHICCRS", "GEODCRS", "GEODETICCRS", "BOUNDCRS":
+					if horizontal == nil {
+						horizontal = c
+					}
+				}
+			}
+			if horizontal == nil {
+				return nil, UnsupportedError("WKT compound system without horizontal system")
+			}
+			n = horizontal
+			continue
+		case "BOUNDCRS":
+			source := n.child("SOURCECRS")
+			if source == nil || len(source.children) == 0 || source.children[0].keyword == "" {
+				return nil, GeneralIssue("WKT BOUNDCRS without SOURCECRS")
+			}
+			n = source.children[0]
+			continue
+		}
+		break
+	}
+
+	c := &CRS{Name: n.text(0), EPSG: n.epsg()}
+	switch n.keyword {
+	case "PROJCS", "PROJCRS", "PROJECTEDCRS":
+		c.ModelType = ModelTypeProjected
+		err = parseProjected(n, c)
+	case "GEOGCS", "GEOGCRS", "GEOGRAPHICCRS":
+		c.ModelType = ModelTypeGeographic
+		err = parseGeographic(n, c)
+	case "GEOCCS":
+		c.ModelType = ModelTypeGeocentric
+		err = parseGeographic(n, c)
+	case "GEODCRS", "GEODETICCRS":
+		c.ModelType = ModelTypeGeographic
+		if cs := n.child("CS"); cs != nil && strings.EqualFold(cs.text(0), "Cartesian") {
+			c.ModelType = ModelTypeGeocentric
+		}
+		err = parseGeographic(n, c)
+	default:
+		return nil, UnsupportedError(fmt.Sprintf("WKT %s", n.keyword))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.IsProjected() {
+		if c.GeographicEPSG == 0 {
+			c.GeographicEPSG = c.EPSG
+		}
+		c.EPSG = c.GeographicEPSG
+	}
+	return c, nil
+}
+
+// wktNumber formats a number without exponent
+func wktNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func wktQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+func wktAuthority(code int) string {
+	if code == 0 || code == userDefined {
+		return ""
+	}
+	return fmt.Sprintf(`,AUTHORITY["EPSG","%d"]`, code)
+}
+
+// tableName returns the name of a code in a GeoKey value table without its
+// prefix, or a default
+func tableName(table map[int]string, code int, def string) string {
+	name, ok := table[code]
+	if !ok || code == userDefined {
+		return def
+	}
+	if i := strings.Index(name, "_"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// wktMethodNames are the WKT1 names of the projection methods of GDAL
+var wktMethodNames = map[int]string{
+	ctTransverseMercator:  "Transverse_Mercator",
+	ctMercator:            "Mercator_1SP",
+	ctLambertConfConic2SP: "Lambert_Conformal_Conic_2SP",
+	ctLambertConfConic1SP: "Lambert_Conformal_Conic_1SP",
+	ctAlbersEqualArea:     "Albers_Conic_Equal_Area",
+}
+
+// wktParameterOrder lists the GeoKeys of projection parameters with their
+// WKT1 names, in the order they are written
+var wktParameterOrder = []struct {
+	key  int
+	name string
+}{
+	{gkProjStdParallel1GeoKey, "standard_parallel_1"},
+	{gkProjStdParallel2GeoKey, "standard_parallel_2"},
+	{gkProjNatOriginLatGeoKey, "latitude_of_origin"},
+	{gkProjFalseOriginLatGeoKey, "latitude_of_origin"},
+	{gkProjCenterLatGeoKey, "latitude_of_center"},
+	{gkProjNatOriginLongGeoKey, "central_meridian"},
+	{gkProjFalseOriginLongGeoKey, "central_meridian"},
+	{gkProjCenterLongGeoKey, "longitude_of_center"},
+	{gkProjAzimuthAngleGeoKey, "azimuth"},
+	{gkProjStraightVertPoleLongGeoKey, "central_meridian"},
+	{gkProjScaleAtNatOriginGeoKey, "scale_factor"},
+	{gkProjScaleAtCenterGeoKey, "scale_factor"},
+	{gkProjFalseEastingGeoKey, "false_easting"},
+	{gkProjFalseOriginEastingGeoKey, "false_easting"},
+	{gkProjCenterEastingGeoKey, "false_easting"},
+	{gkProjFalseNorthingGeoKey, "false_northing"},
+	{gkProjFalseOriginNorthingGeoKey, "false_northing"},
+	{gkProjCenterNorthingGeoKey, "false_northing"},
+}
+
+// geographicWKT writes the GEOGCS of c
+func (c *CRS) geographicWKT(b *strings.Builder) {
+	name := c.Name
+	if c.IsProjected() || name == "" {
+		name = tableName(GeographicTypeGeoKey, c.GeographicEPSG, "unknown")
+	}
+	fmt.Fprintf(b, `GEOGCS[%s,DATUM[%s,SPHEROID[%s,%s,%s%s]`, wktQuote(name),
+		wktQuote(tableName(GeogGeodeticDatumGeoKey, c.Datum, "unknown")),
+		wktQuote(tableName(GeogEllipsoidGeoKey, c.Ellipsoid.Code, "unknown")),
+		wktNumber(c.Ellipsoid.SemiMajor), wktNumber(c.Ellipsoid.InvFlattening), wktAuthority(c.Ellipsoid.Code))
+	if len(c.ToWGS84) > 0 {
+		values := make([]string, len(c.ToWGS84))
+		for i, v := range c.ToWGS84 {
+			values[i] = wktNumber(v)
+		}
+		fmt.Fprintf(b, ",TOWGS84[%s]", strings.Join(values, ","))
+	}
+	fmt.Fprintf(b, "%s]", wktAuthority(c.Datum))
+	pm, pmName := angleDegrees(1, c.AngularUnits), "Greenwich"
+	if c.PrimeMeridian != 0 {
+		pmName = "unnamed"
+	}
+	fmt.Fprintf(b, `,PRIMEM[%s,%s]`, wktQuote(pmName), wktNumber(c.PrimeMeridian/pm))
+	unitName := strings.ToLower(tableName(GeogAngularUnitsGeoKey, c.AngularUnits, "degree"))
+	fmt.Fprintf(b, `,UNIT[%s,%s%s]`, wktQuote(unitName), wktNumber(pm*degree), wktAuthority(c.AngularUnits))
+	fmt.Fprintf(b, "%s]", wktAuthority(c.GeographicEPSG))
+}
+
+// WKT returns the WKT1 definition of the CRS, as written by GDAL and
+// expected by LAS 1.4. Projected systems need a known projection method.
+func (c *CRS) WKT() (string, error) {
+	if c.Ellipsoid.SemiMajor == 0 {
+		return "", GeneralIssue(fmt.Sprintf("%v has no ellipsoid", c))
+	}
+	var b strings.Builder
+	switch c.ModelType {
+	case ModelTypeGeographic:
+		c.geographicWKT(&b)
+		return b.String(), nil
+	case ModelTypeProjected:
+	default:
+		return "", UnsupportedError(fmt.Sprintf("WKT of %v", c))
+	}
+
+	method, ok := wktMethodNames[c.CoordTrans]
+	if !ok {
+		name, known := ProjCoordTransGeoKey[c.CoordTrans]
+		if !known {
+			return "", UnsupportedError(fmt.Sprintf("WKT of %v without projection method", c))
+		}
+		method = strings.TrimPrefix(name, "CT_")
+	}
+	if _, ok := c.Parameters[gkProjStdParallel1GeoKey]; ok && c.CoordTrans == ctMercator {
+		method = "Mercator_2SP"
+	}
+	fmt.Fprintf(&b, "PROJCS[%s,", wktQuote(c.Name))
+	c.geographicWKT(&b)
+	fmt.Fprintf(&b, ",PROJECTION[%s]", wktQuote(method))
+	written := make(map[string]bool)
+	for _, p := range wktParameterOrder {
+		v, ok := c.Parameters[p.key]
+		name := p.name
+		if c.CoordTrans == ctAlbersEqualArea {
+			// GDAL names the origin of Albers its center
+			name = strings.Replace(strings.Replace(name, "latitude_of_origin", "latitude_of_center", 1), "central_meridian", "longitude_of_center", 1)
+		}
+		if !ok || written[name] {
+			continue
+		}
+		written[name] = true
+		fmt.Fprintf(&b, ",PARAMETER[%s,%s]", wktQuote(name), wktNumber(v))
+	}
+	unitName := "metre"
+	if c.LinearUnits != 9001 {
+		unitName = tableName(GeogLinearUnitsGeoKey, c.LinearUnits, "unknown")
+	}
+	fmt.Fprintf(&b, ",UNIT[%s,%s%s]", wktQuote(unitName), wktNumber(c.unitSize()), wktAuthority(c.LinearUnits))
+	fmt.Fprintf(&b, `,AXIS["Easting",EAST],AXIS["Northing",NORTH]%s]`, wktAuthority(c.EPSG))
+	return b.String(), nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"math"
+	"testing"
+)
+
+const utm32WKT1 = `PROJCS["WGS 84 / UTM zone 32N",
+    GEOGCS["WGS 84",
+        DATUM["WGS_1984",
+            SPHEROID["WGS 84",6378137,298.257223563,
+                AUTHORITY["EPSG","7030"]],
+            AUTHORITY["EPSG","6326"]],
+        PRIMEM["Greenwich",0,
+            AUTHORITY["EPSG","8901"]],
+        UNIT["degree",0.0174532925199433,
+            AUTHORITY["EPSG","9122"]],
+        AUTHORITY["EPSG","4326"]],
+    PROJECTION["Transverse_Mercator"],
+    PARAMETER["latitude_of_origin",0],
+    PARAMETER["central_meridian",9],
+    PARAMETER["scale_factor",0.9996],
+    PARAMETER["false_easting",500000],
+    PARAMETER["false_northing",0],
+    UNIT["metre",1,
+        AUTHORITY["EPSG","9001"]],
+    AXIS["Easting",EAST],
+    AXIS["Northing",NORTH],
+    AUTHORITY["EPSG","32632"]]`
+
+const utm32WKT2 = `PROJCRS["WGS 84 / UTM zone 32N",
+    BASEGEOGCRS["WGS 84",
+        ENSEMBLE["World Geodetic System 1984 ensemble",
+            MEMBER["World Geodetic System 1984 (Transit)"],
+            MEMBER["World Geodetic System 1984 (G730)"],
+            ELLIPSOID["WGS 84",6378137,298.257223563,
+                LENGTHUNIT["metre",1]],
+            ENSEMBLEACCURACY[2.0]],
+        PRIMEM["Greenwich",0,
+            ANGLEUNIT["degree",0.0174532925199433]],
+        ID["EPSG",4326]],
+    CONVERSION["UTM zone 32N",
+        METHOD["Transverse Mercator",
+            ID["EPSG",9807]],
+        PARAMETER["Latitude of natural origin",0,
+            ANGLEUNIT["degree",0.0174532925199433],
+            ID["EPSG",8801]],
+        PARAMETER["Longitude of natural origin",0.15707963267949,
+            ANGLEUNIT["radian",1],
+            ID["EPSG",8802]],
+        PARAMETER["Scale factor at natural origin",0.9996,
+            SCALEUNIT["unity",1],
+            ID["EPSG",8805]],
+        PARAMETER["False easting",500,
+            LENGTHUNIT["kilometre",1000],
+            ID["EPSG",8806]],
+        PARAMETER["False northing",0,
+            LENGTHUNIT["metre",1],
+            ID["EPSG",8807]]],
+    CS[Cartesian,2],
+        AXIS["(E)",east,
+            ORDER[1],
+            LENGTHUNIT["metre",1]],
+        AXIS["(N)",north,
+            ORDER[2],
+            LENGTHUNIT["metre",1]],
+    ID["EPSG",32632]]`
+
+const albersESRI = `PROJCS["USA_Contiguous_Albers_Equal_Area_Conic",GEOGCS["GCS_North_American_1983",` +
+	`DATUM["D_North_American_1983",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],` +
+	`UNIT["Degree",0.0174532925199433]],PROJECTION["Albers"],PARAMETER["False_Easting",0.0],` +
+	`PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",-96.0],PARAMETER["Standard_Parallel_1",29.5],` +
+	`PARAMETER["Standard_Parallel_2",45.5],PARAMETER["Latitude_Of_Origin",37.5],UNIT["Meter",1.0]]`
+
+func TestParseWKT(t *testing.T) {
+	utm, _ := LookupEPSG(32632)
+	for _, wkt := range []string{utm32WKT1, utm32WKT2} {
+		c, err := ParseWKT(wkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.EPSG != 32632 || c.GeographicEPSG != 4326 || c.Datum != 6326 || c.Ellipsoid.Code != 7030 || c.AngularUnits != 9102 || c.LinearUnits != 9001 {
+			t.Errorf("parsed %+v", c)
+		}
+		// compare the definitions rather than the codes
+		c.EPSG = 0
+		if !c.Equal(utm) || !utm.Equal(c) {
+			t.Errorf("parsed %+v, expected %+v", c, utm)
+		}
+	}
+
+	c, err := ParseWKT(albersESRI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.EPSG != 0 || c.Datum != 6269 || c.CoordTrans != ctAlbersEqualArea || c.Parameters[gkProjStdParallel2GeoKey] != 45.5 {
+		t.Errorf("parsed %+v", c)
+	}
+	p, err := c.Projector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the origin of the projection
+	if x, y, err := p.Forward(-96, 37.5); err != nil || math.Abs(x) > 1e-6 || math.Abs(y) > 1e-6 {
+		t.Errorf("origin projects to %v,%v: %v", x, y, err)
+	}
+
+	c, err = ParseWKT(`GEOGCS["NAD27",DATUM["North_American_Datum_1927",SPHEROID["Clarke 1866",6378206.4,294.978698213898],` +
+		`TOWGS84[-8,160,176,0,0,0,0]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433],AUTHORITY["EPSG","4267"]]` + "\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsGeographic() || c.EPSG != 4267 || c.Datum != 6267 || c.Ellipsoid.Code != 7008 || len(c.ToWGS84) != 7 {
+		t.Errorf("parsed %+v", c)
+	}
+
+	c, err = ParseWKT(`PROJCRS["WGS 84 / Pseudo-Mercator",BASEGEOGCRS["WGS 84",DATUM["World Geodetic System 1984",` +
+		`ELLIPSOID["WGS 84",6378137,298.257223563]],UNIT["degree",0.0174532925199433]],` +
+		`CONVERSION["Popular Visualisation Pseudo-Mercator",METHOD["Popular Visualisation Pseudo Mercator"]],CS[Cartesian,2],UNIT["metre",1]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.EPSG != epsgWebMercator || c.CoordTrans != ctMercator {
+		t.Errorf("parsed %+v", c)
+	}
+
+	for _, wkt := range []string{"", "GEOGCS", `GEOGCS["x",DATUM["y"]`, `VERT_CS["NAVD88",VERT_DATUM["North American Vertical Datum 1988",2005]]`,
+		`PROJCS["x",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],UNIT["degree",0.0174532925199433]],PROJECTION["Unknown"]]`} {
+		if _, err := ParseWKT(wkt); err == nil {
+			t.Errorf("expected an error for %q", wkt)
+		}
+	}
+}
+
+func TestCompoundWKT(t *testing.T) {
+	c, err := ParseWKT(`COMPD_CS["UTM 32N + EGM96",` + utm32WKT1 + `,VERT_CS["EGM96 height",VERT_DATUM["EGM96",2005],UNIT["metre",1]]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.EPSG != 32632 {
+		t.Errorf("parsed %+v", c)
+	}
+}
+
+func TestWKT(t *testing.T) {
+	for _, code := range []int{4326, 4267, 32632, 32755, epsgWebMercator} {
+		c, err := LookupEPSG(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wkt, err := c.WKT()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseWKT(wkt)
+		if err != nil {
+			t.Fatalf("%s: %v", wkt, err)
+		}
+		if parsed.EPSG != code {
+			t.Errorf("%s parsed as %v", wkt, parsed)
+		}
+		parsed.EPSG = 0
+		if !parsed.Equal(c) {
+			t.Errorf("%s parsed as %+v", wkt, parsed)
+		}
+	}
+
+	c, _ := ParseWKT(albersESRI)
+	wkt, err := c.WKT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseWKT(wkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(c) {
+		t.Errorf("%s parsed as %+v", wkt, parsed)
+	}
+}
+
+func TestCitationWKT(t *testing.T) {
+	citation := "ESRI PE String = " + albersESRI + "|"
+	keys := map[uint16]*GeoKey{
+		gkGTModelTypeGeoKey:     {KeyId: gkGTModelTypeGeoKey, Count: 1, Value: ModelTypeProjected},
+		gkProjectedCSTypeGeoKey: {KeyId: gkProjectedCSTypeGeoKey, Count: 1, Value: userDefined},
+		gkGTCitationGeoKey:      {KeyId: gkGTCitationGeoKey, Location: tGeoAscii, Count: uint16(len(citation))},
+		gkGeographicTypeGeoKey:  {KeyId: gkGeographicTypeGeoKey, Count: 1, Value: 4269},
+		gkProjLinearUnitsGeoKey: {KeyId: gkProjLinearUnitsGeoKey, Count: 1, Value: 9001},
+	}
+	c, err := NewCRS(keys, nil, []byte(citation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CoordTrans != ctAlbersEqualArea || c.Name != "USA_Contiguous_Albers_Equal_Area_Conic" {
+		t.Errorf("CRS %+v", c)
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	defaultRowsPerStrip = 16
+	nodataString        = "-9999"
+)
+
+// Options are the encoding parameters used by Encode.
+type Options struct {
+	// Compression is the type of compression applied to each strip or tile.
+	Compression CompressionType
+	// Predictor is applied before compression, 2 for horizontal differencing
+	// or 3 for the floating point predictor. Zero or 1 applies none.
+	Predictor int
+	// TileWidth and TileLength select a tiled layout when both are non zero,
+	// both must be multiples of 16. Otherwise the image is written in strips.
+	TileWidth  int
+	TileLength int
+	// RowsPerStrip is the number of rows in each strip, defaults to 16.
+	RowsPerStrip int
+	// PlanarSeparate stores each band in strips or tiles of its own
+	// (PlanarConfiguration 2) instead of interleaving the bands of a pixel.
+	PlanarSeparate bool
+	// COG writes a cloud optimized GeoTIFF: tiled, 512x512 unless a tile size
+	// is given, with averaged overviews down to a single tile and every IFD
+	// ahead of the image data.
+	COG bool
+	// ProjectedCSType is the EPSG code of a projected coordinate system, the
+	// Bounds are then in that system's units. Zero writes geographic WGS84
+	// (EPSG:4326) with Bounds in degrees.
+	ProjectedCSType int
+}
+
+func (opts *Options) isTiled() bool {
+	return opts.TileWidth > 0 && opts.TileLength > 0
+}
+
+func (opts *Options) predictor() uint {
+	if opts.Predictor == 0 {
+		return prNone
+	}
+	return uint(opts.Predictor)
+}
+
+func (opts *Options) validate() error {
+	if opts.TileWidth < 0 || opts.TileLength < 0 || opts.RowsPerStrip < 0 {
+		return GeneralIssue("Options: tile and strip sizes must be positive")
+	}
+	if opts.isTiled() && (opts.TileWidth%16 != 0 || opts.TileLength%16 != 0) {
+		return GeneralIssue(fmt.Sprintf("Options: tile size %dx%d is not a multiple of 16", opts.TileWidth, opts.TileLength))
+	}
+	if (opts.TileWidth > 0) != (opts.TileLength > 0) {
+		return GeneralIssue("Options: both TileWidth and TileLength are required for a tiled layout")
+	}
+	switch opts.Compression {
+	case Uncompressed, Deflate, LZW:
+	default:
+		return UnsupportedError(fmt.Sprintf("compression type %v", opts.Compression))
+	}
+	if opts.Predictor != 0 {
+		return checkPredictor(uint(opts.Predictor), smplFloat, 32)
+	}
+	return nil
+}
+
+func newIfd(tag uint16, fieldType uint16, value interface{}) *Ifd {
+	var count int
+	switch v := value.(type) {
+	case []byte:
+		count = len(v)
+	case []uint16:
+		count = len(v)
+	case []uint32:
+		count = len(v)
+	case []float32:
+		count = len(v)
+	case []float64:
+		count = len(v)
+	default:
+		panic(fmt.Sprintf("newIfd: unsupported value type %T", value))
+	}
+	return &Ifd{tag: tag, fieldType: fieldType, count: uint32(count), value: value}
+}
+
+func asciiIfd(tag uint16, s string) *Ifd {
+	return newIfd(tag, dtASCII, append([]byte(s), 0))
+}
+
+func (v *Ifd) dataLen() uint32 {
+	return lengths[v.fieldType] * v.count
+}
+
+// encodeBlock copies the w x h window at (x, y) of the bands into a little
+// endian float32 buffer, pixel interleaved and padded with nodata outside of
+// the rasters.
+func encodeBlock(bands []*Raster, x, y, w, h int) []byte {
+	buf := make([]byte, w*h*4*len(bands))
+	nodata := math.Float32bits(-9999.0)
+	i := 0
+	for row := y; row < y+h; row++ {
+		for col := x; col < x+w; col++ {
+			for _, raster := range bands {
+				bits := nodata
+				if row < raster.h && col < raster.w {
+					bits = math.Float32bits(raster.ValueAt(row, col))
+				}
+				binary.LittleEndian.PutUint32(buf[i:i+4], bits)
+				i += 4
+			}
+		}
+	}
+	return buf
+}
+
+// repeat returns n copies of v, for tags holding a value per sample
+func repeat(v uint16, n int) []uint16 {
+	r := make([]uint16, n)
+	for i := range r {
+		r[i] = v
+	}
+	return r
+}
+
+func compressBlock(compression CompressionType, p []byte) ([]byte, error) {
+	switch compression {
+	case Deflate:
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(p); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case LZW:
+		return lzwEncode(p), nil
+	}
+	return p, nil
+}
+
+func geoKeyDirectory(opts *Options) []uint16 {
+	keys := []*GeoKey{{KeyId: gkGTRasterTypeGeoKey, Count: 1, Value: 1}} // RasterPixelIsArea
+	if opts.ProjectedCSType != 0 {
+		keys = append(keys,
+			&GeoKey{KeyId: gkGTModelTypeGeoKey, Count: 1, Value: 1}, // ModelTypeProjected
+			&GeoKey{KeyId: gkProjectedCSTypeGeoKey, Count: 1, Value: uint16(opts.ProjectedCSType)})
+	} else {
+		keys = append(keys,
+			&GeoKey{KeyId: gkGTModelTypeGeoKey, Count: 1, Value: 2},         // ModelTypeGeographic
+			&GeoKey{KeyId: gkGeographicTypeGeoKey, Count: 1, Value: 4326},   // GCS_WGS_84
+			&GeoKey{KeyId: gkGeogAngularUnitsGeoKey, Count: 1, Value: 9102}) // Angular_Degree
+	}
+	sort.Sort(ByKey(keys))
+	dir := []uint16{KeyDirectoryVersion, KeyRevision, MinorRevision, uint16(len(keys))}
+	for _, k := range keys {
+		dir = append(dir, k.KeyId, k.Location, k.Count, k.Value)
+	}
+	return dir
+}
+
+// Encode writes raster as a single band float32 GeoTIFF. The raster is
+// georeferenced by bounds, which may be nil to write a plain TIFF, and the
+// package nodata value -9999 is recorded in the GDAL_NODATA tag. A nil opts
+// writes uncompressed strips.
+func Encode(w io.WriteSeeker, raster *Raster, bounds *Bounds, opts *Options) error {
+	if raster == nil {
+		return GeneralIssue("Encode: raster is empty")
+	}
+	return EncodeBands(w, &MultiRaster{w: raster.w, h: raster.h, Bands: []*Raster{raster}}, bounds, opts)
+}
+
+// EncodeBands writes every band of m as a float32 GeoTIFF with one sample
+// per band, see Encode.
+func EncodeBands(w io.WriteSeeker, m *MultiRaster, bounds *Bounds, opts *Options) error {
+	if m == nil || m.w <= 0 || m.h <= 0 || len(m.Bands) == 0 {
+		return GeneralIssue("Encode: raster is empty")
+	}
+	for i, b := range m.Bands {
+		if b == nil || b.w != m.w || b.h != m.h {
+			return GeneralIssue(fmt.Sprintf("Encode: band %d is not %dx%d", i, m.w, m.h))
+		}
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.COG && !opts.isTiled() {
+		cog := *opts
+		cog.TileWidth, cog.TileLength = cogTileSize, cogTileSize
+		opts = &cog
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	img, err := encodeImage(m, bounds, opts, 0)
+	if err != nil {
+		return err
+	}
+	images := []*encodedImage{img}
+	if opts.COG {
+		// halve the image until it fits in a single tile
+		for o := m; o.w > opts.TileWidth || o.h > opts.TileLength; {
+			o = halveBands(o)
+			img, err := encodeImage(o, nil, opts, sfReducedImage)
+			if err != nil {
+				return err
+			}
+			images = append(images, img)
+		}
+	}
+	return writeImages(w, images, opts.COG)
+}
+
+// encodedImage holds the tags and compressed blocks of a directory, the block
+// offsets are filled in by writeImages
+type encodedImage struct {
+	tags    []*Ifd
+	offsets []uint32
+	blocks  [][]byte
+}
+
+// encodeImage compresses the strips or tiles of m and builds its tags, bounds
+// is nil for overviews which are located by the full resolution image.
+func encodeImage(m *MultiRaster, bounds *Bounds, opts *Options, subfileType uint32) (*encodedImage, error) {
+	var blocks [][]byte
+	var blockWidth, blockLength int
+	if opts.isTiled() {
+		blockWidth, blockLength = opts.TileWidth, opts.TileLength
+	} else {
+		blockWidth, blockLength = m.w, opts.RowsPerStrip
+		if blockLength == 0 {
+			blockLength = defaultRowsPerStrip
+		}
+		if blockLength > m.h {
+			blockLength = m.h
+		}
+	}
+	planes := [][]*Raster{m.Bands}
+	planar := pcChunky
+	if opts.PlanarSeparate && len(m.Bands) > 1 {
+		planes = nil
+		for _, b := range m.Bands {
+			planes = append(planes, []*Raster{b})
+		}
+		planar = pcPlanar
+	}
+	for _, plane := range planes {
+		for y := 0; y < m.h; y += blockLength {
+			for x := 0; x < m.w; x += blockWidth {
+				h := blockLength
+				if !opts.isTiled() && y+h > m.h {
+					h = m.h - y
+				}
+				raw := encodeBlock(plane, x, y, blockWidth, h)
+				predict(raw, binary.LittleEndian, opts.predictor(), blockWidth*len(plane), len(plane), 4)
+				block, err := compressBlock(opts.Compression, raw)
+				if err != nil {
+					return nil, err
+				}
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	offsets := make([]uint32, len(blocks))
+	byteCounts := make([]uint32, len(blocks))
+	for i, b := range blocks {
+		byteCounts[i] = uint32(len(b))
+	}
+
+	tags := []*Ifd{
+		newIfd(tImageWidth, dtLong, []uint32{uint32(m.w)}),
+		newIfd(tImageLength, dtLong, []uint32{uint32(m.h)}),
+		newIfd(tBitsPerSample, dtShort, repeat(32, len(m.Bands))),
+		newIfd(tCompression, dtShort, []uint16{uint16(opts.Compression.optToCompression())}),
+		newIfd(tPhotometricInterpretation, dtShort, []uint16{pBlackIsZero}),
+		newIfd(tSamplesPerPixel, dtShort, []uint16{uint16(len(m.Bands))}),
+		newIfd(tPlanarConfiguration, dtShort, []uint16{uint16(planar)}),
+		newIfd(tSampleFormat, dtShort, repeat(smplFloat, len(m.Bands))),
+		asciiIfd(tGDALNodata, nodataString),
+	}
+	if subfileType != 0 {
+		tags = append(tags, newIfd(tNewSubfileType, dtLong, []uint32{subfileType}))
+	}
+	if len(m.Bands) > 1 {
+		// samples beyond the first are unspecified data, not alpha
+		tags = append(tags, newIfd(tExtraSamples, dtShort, repeat(0, len(m.Bands)-1)))
+	}
+	if opts.predictor() != prNone {
+		tags = append(tags, newIfd(tPredictor, dtShort, []uint16{uint16(opts.predictor())}))
+	}
+	if opts.isTiled() {
+		tags = append(tags,
+			newIfd(tTileWidth, dtLong, []uint32{uint32(blockWidth)}),
+			newIfd(tTileLength, dtLong, []uint32{uint32(blockLength)}),
+			newIfd(tTileOffsets, dtLong, offsets),
+			newIfd(tTileByteCounts, dtLong, byteCounts))
+	} else {
+		tags = append(tags,
+			newIfd(tRowsPerStrip, dtLong, []uint32{uint32(blockLength)}),
+			newIfd(tStripOffsets, dtLong, offsets),
+			newIfd(tStripByteCounts, dtLong, byteCounts))
+	}
+	if bounds != nil {
+		tags = append(tags,
+			newIfd(tModelTiepointTag, dtDouble, []float64{0, 0, 0, bounds.MinX, bounds.MaxY, 0}),
+			newIfd(tModelPixelScaleTag, dtDouble, []float64{bounds.Xspan() / float64(m.w), bounds.Yspan() / float64(m.h), 0}),
+			newIfd(tGeoKeys, dtShort, geoKeyDirectory(opts)))
+	}
+	sort.Sort(ByTag(tags))
+	return &encodedImage{tags: tags, offsets: offsets, blocks: blocks}, nil
+}
+
+// writeImages writes a classic little endian TIFF of the images in order. The
+// layout is the header, every IFD followed by its out of line tag values,
+// then the image blocks. Cloud optimized files start with the GDAL ghost area
+// and store the blocks of the smallest overview first, each block between a
+// leader and a trailer.
+func writeImages(w io.WriteSeeker, images []*encodedImage, cog bool) error {
+	enc := binary.LittleEndian
+	var buf bytes.Buffer
+	buf.WriteString(leHeader)
+	binary.Write(&buf, enc, uint32(0)) // first IFD, set below
+	if cog {
+		buf.WriteString(ghostArea(cogMetadata))
+	}
+	if buf.Len()&1 != 0 {
+		buf.WriteByte(0) // IFDs start on a word boundary
+	}
+
+	offset := uint64(buf.Len())
+	ifdOffsets := make([]uint32, len(images))
+	valueOffsets := make([][]uint32, len(images))
+	for i, img := range images {
+		ifdOffsets[i] = uint32(offset)
+		offset += uint64(2 + len(img.tags)*ifdLen + 4)
+		valueOffsets[i] = make([]uint32, len(img.tags))
+		for j, t := range img.tags {
+			if n := t.dataLen(); n > 4 {
+				valueOffsets[i][j] = uint32(offset)
+				offset += uint64(n + n&1) // values start on a word boundary
+			}
+		}
+	}
+	order := make([]*encodedImage, len(images))
+	for i := range images {
+		if cog {
+			order[i] = images[len(images)-1-i]
+		} else {
+			order[i] = images[i]
+		}
+	}
+	for _, img := range order {
+		for i, b := range img.blocks {
+			if cog {
+				offset += 4 // leader
+			}
+			img.offsets[i] = uint32(offset)
+			offset += uint64(len(b))
+			if cog {
+				offset += 4 // trailer
+			}
+		}
+	}
+	if offset > math.MaxUint32 {
+		return GeneralIssue(fmt.Sprintf("Encode: %d bytes do not fit in a classic TIFF", offset))
+	}
+
+	enc.PutUint32(buf.Bytes()[4:8], ifdOffsets[0])
+	entry := make([]byte, ifdLen)
+	for i, img := range images {
+		binary.Write(&buf, enc, uint16(len(img.tags)))
+		for j, t := range img.tags {
+			for k := range entry {
+				entry[k] = 0
+			}
+			enc.PutUint16(entry[0:2], t.tag)
+			enc.PutUint16(entry[2:4], t.fieldType)
+			enc.PutUint32(entry[4:8], t.count)
+			if t.dataLen() > 4 {
+				enc.PutUint32(entry[8:12], valueOffsets[i][j])
+			} else {
+				t.PutData(enc, entry[8:12])
+			}
+			buf.Write(entry)
+		}
+		next := uint32(0)
+		if i+1 < len(images) {
+			next = ifdOffsets[i+1]
+		}
+		binary.Write(&buf, enc, next)
+		for _, t := range img.tags {
+			if n := t.dataLen(); n > 4 {
+				p := make([]byte, n+n&1)
+				t.PutData(enc, p)
+				buf.Write(p)
+			}
+		}
+	}
+
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, img := range order {
+		for _, b := range img.blocks {
+			if cog {
+				if err := binary.Write(w, enc, uint32(len(b))); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			if cog {
+				if _, err := w.Write(blockTrailer(b)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
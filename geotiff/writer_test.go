@@ -0,0 +1,146 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/tiff/lzw"
+)
+
+// memFile is an in memory io.WriteSeeker
+type memFile struct {
+	data []byte
+	pos  int
+}
+
+func (m *memFile) Write(p []byte) (int, error) {
+	if end := m.pos + len(p); end > len(m.data) {
+		m.data = append(m.data, make([]byte, end-len(m.data))...)
+	}
+	copy(m.data[m.pos:], p)
+	m.pos += len(p)
+	return len(p), nil
+}
+
+func (m *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		m.pos = int(offset)
+	case io.SeekCurrent:
+		m.pos += int(offset)
+	case io.SeekEnd:
+		m.pos = len(m.data) + int(offset)
+	}
+	if m.pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	return int64(m.pos), nil
+}
+
+func testRaster(w, h int) *Raster {
+	r := NewRaster(w, h)
+	for row := 0; row < h; row++ {
+		for col := 0; col < w; col++ {
+			r.SetValue(row, col, float32(row*w+col)/4.0)
+		}
+	}
+	r.SetValue(h/2, w/2, -9999.0)
+	return r
+}
+
+func TestLzwEncode(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	inputs := [][]byte{{}, {7}, bytes.Repeat([]byte("abcabcabd"), 1000), make([]byte, 100000)}
+	random := make([]byte, 50000)
+	for i := range random {
+		random[i] = byte(rnd.Intn(256))
+	}
+	inputs = append(inputs, random)
+
+	for i, in := range inputs {
+		r := lzw.NewReader(bytes.NewReader(lzwEncode(in)), lzw.MSB, 8)
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("input %d: %v", i, err)
+		}
+		if !bytes.Equal(in, out) {
+			t.Errorf("input %d: round trip of %d bytes yielded %d bytes", i, len(in), len(out))
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	bounds := &Bounds{MinX: -105.5, MaxX: -105.0, MinY: 39.5, MaxY: 39.75}
+	options := []*Options{
+		nil,
+		{Compression: Deflate, RowsPerStrip: 7},
+		{Compression: LZW},
+		{Compression: Deflate, TileWidth: 16, TileLength: 32},
+		{Compression: LZW, TileWidth: 32, TileLength: 16},
+		{Compression: Deflate, Predictor: 2},
+		{Compression: LZW, Predictor: 3, RowsPerStrip: 5},
+		{Compression: Deflate, Predictor: 3, TileWidth: 16, TileLength: 16},
+		{Compression: LZW, Predictor: 2, TileWidth: 32, TileLength: 32},
+	}
+	raster := testRaster(45, 37)
+
+	for i, opts := range options {
+		f := &memFile{}
+		if err := Encode(f, raster, bounds, opts); err != nil {
+			t.Fatalf("options %d: Encode: %v", i, err)
+		}
+		tif, err := NewDecoder(bytes.NewReader(f.data))
+		if err != nil {
+			t.Fatalf("options %d: NewDecoder: %v", i, err)
+		}
+		if !tif.IsGeotiff() {
+			t.Errorf("options %d: not a geotiff", i)
+		}
+		b, err := tif.Bounds()
+		if err != nil {
+			t.Fatalf("options %d: Bounds: %v", i, err)
+		}
+		if b.MinX != bounds.MinX || b.MaxY != bounds.MaxY || b.MaxX != bounds.MaxX || b.MinY != bounds.MinY {
+			t.Errorf("options %d: bounds %v, expected %v", i, b, bounds)
+		}
+		got, minZ, maxZ, err := tif.Points()
+		if err != nil {
+			t.Fatalf("options %d: Points: %v", i, err)
+		}
+		if got.Width() != raster.Width() || got.Height() != raster.Height() {
+			t.Fatalf("options %d: size %dx%d, expected %dx%d", i, got.Width(), got.Height(), raster.Width(), raster.Height())
+		}
+		for j, v := range raster.Data {
+			if got.Data[j] != v {
+				t.Fatalf("options %d: value %d is %v, expected %v", i, j, got.Data[j], v)
+			}
+		}
+		if minZ != 0 || maxZ != float32(45*37-1)/4.0 {
+			t.Errorf("options %d: min/max %v/%v", i, minZ, maxZ)
+		}
+	}
+}
+
+func TestEncodeOptions(t *testing.T) {
+	raster := testRaster(8, 8)
+	bad := []*Options{
+		{TileWidth: 16},
+		{TileWidth: 20, TileLength: 16},
+		{RowsPerStrip: -1},
+		{Compression: CompressionType(99)},
+		{Predictor: 4},
+	}
+	for i, opts := range bad {
+		if err := Encode(&memFile{}, raster, nil, opts); err == nil {
+			t.Errorf("options %d: expected an error", i)
+		}
+	}
+}
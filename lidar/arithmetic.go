@@ -0,0 +1,345 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+// The adaptive arithmetic decoder, entropy models and integer decompressor of
+// LASzip, after the coder of Amir Said used by Martin Isenburg.
+
+const (
+	acMinLength = 0x01000000
+	acMaxLength = 0xFFFFFFFF
+
+	bmLengthShift = 13 // length bits discarded before multiplication
+	bmMaxCount    = 1 << bmLengthShift
+
+	dmLengthShift = 15
+	dmMaxCount    = 1 << dmLengthShift
+)
+
+// byteSource feeds the arithmetic decoder, it returns zeros past its end
+type byteSource interface {
+	nextByte() byte
+}
+
+// layerSource is a byte source over a layer of a layered chunk
+type layerSource struct {
+	data []byte
+	pos  int
+}
+
+func (s *layerSource) nextByte() byte {
+	if s.pos >= len(s.data) {
+		return 0
+	}
+	s.pos++
+	return s.data[s.pos-1]
+}
+
+type arithmeticDecoder struct {
+	in     byteSource
+	value  uint32
+	length uint32
+}
+
+func newArithmeticDecoder(in byteSource) *arithmeticDecoder {
+	d := &arithmeticDecoder{in: in, length: acMaxLength}
+	for i := 0; i < 4; i++ {
+		d.value = d.value<<8 | uint32(in.nextByte())
+	}
+	return d
+}
+
+func (d *arithmeticDecoder) renorm() {
+	for {
+		d.value = d.value<<8 | uint32(d.in.nextByte())
+		d.length <<= 8
+		if d.length >= acMinLength {
+			return
+		}
+	}
+}
+
+// bitModel is an adaptive model of a binary symbol
+type bitModel struct {
+	bit0Count   uint32
+	bitCount    uint32
+	bit0Prob    uint32
+	untilUpdate uint32
+	updateCycle uint32
+}
+
+func newBitModel() *bitModel {
+	return &bitModel{bit0Count: 1, bitCount: 2, bit0Prob: 1 << (bmLengthShift - 1), updateCycle: 4, untilUpdate: 4}
+}
+
+func (m *bitModel) update() {
+	if m.bitCount += m.updateCycle; m.bitCount > bmMaxCount {
+		m.bitCount = (m.bitCount + 1) >> 1
+		m.bit0Count = (m.bit0Count + 1) >> 1
+		if m.bit0Count == m.bitCount {
+			m.bitCount++
+		}
+	}
+	scale := uint32(0x80000000) / m.bitCount
+	m.bit0Prob = (m.bit0Count * scale) >> (31 - bmLengthShift)
+	if m.updateCycle = (5 * m.updateCycle) >> 2; m.updateCycle > 64 {
+		m.updateCycle = 64
+	}
+	m.untilUpdate = m.updateCycle
+}
+
+// symbolModel is an adaptive model of symbols 0 to symbols-1
+type symbolModel struct {
+	symbols      uint32
+	lastSymbol   uint32
+	distribution []uint32
+	symbolCount  []uint32
+	decoderTable []uint32 // speeds up the search of models of more than 16 symbols
+	tableSize    uint32
+	tableShift   uint32
+	totalCount   uint32
+	updateCycle  uint32
+	untilUpdate  uint32
+}
+
+func newSymbolModel(symbols uint32) *symbolModel {
+	m := &symbolModel{symbols: symbols, lastSymbol: symbols - 1}
+	if symbols > 16 {
+		tableBits := uint32(3)
+		for symbols > 1<<(tableBits+2) {
+			tableBits++
+		}
+		m.tableSize = 1 << tableBits
+		m.tableShift = dmLengthShift - tableBits
+		m.decoderTable = make([]uint32, m.tableSize+2)
+	}
+	m.distribution = make([]uint32, symbols)
+	m.symbolCount = make([]uint32, symbols)
+	for i := range m.symbolCount {
+		m.symbolCount[i] = 1
+	}
+	m.updateCycle = symbols
+	m.update()
+	m.updateCycle = (symbols + 6) >> 1
+	m.untilUpdate = m.updateCycle
+	return m
+}
+
+func (m *symbolModel) update() {
+	if m.totalCount += m.updateCycle; m.totalCount > dmMaxCount {
+		m.totalCount = 0
+		for i := range m.symbolCount {
+			m.symbolCount[i] = (m.symbolCount[i] + 1) >> 1
+			m.totalCount += m.symbolCount[i]
+		}
+	}
+	var sum, s uint32
+	scale := uint32(0x80000000) / m.totalCount
+	for k := uint32(0); k < m.symbols; k++ {
+		m.distribution[k] = (scale * sum) >> (31 - dmLengthShift)
+		sum += m.symbolCount[k]
+		if m.decoderTable != nil {
+			for w := m.distribution[k] >> m.tableShift; s < w; {
+				s++
+				m.decoderTable[s] = k - 1
+			}
+		}
+	}
+	if m.decoderTable != nil {
+		m.decoderTable[0] = 0
+		for s <= m.tableSize {
+			s++
+			m.decoderTable[s] = m.symbols - 1
+		}
+	}
+	m.updateCycle = (5 * m.updateCycle) >> 2
+	if max := (m.symbols + 6) << 3; m.updateCycle > max {
+		m.updateCycle = max
+	}
+	m.untilUpdate = m.updateCycle
+}
+
+func (d *arithmeticDecoder) decodeBit(m *bitModel) uint32 {
+	x := m.bit0Prob * (d.length >> bmLengthShift)
+	var sym uint32
+	if d.value >= x {
+		sym = 1
+		d.value -= x
+		d.length -= x
+	} else {
+		d.length = x
+		m.bit0Count++
+	}
+	if d.length < acMinLength {
+		d.renorm()
+	}
+	if m.untilUpdate--; m.untilUpdate == 0 {
+		m.update()
+	}
+	return sym
+}
+
+func (d *arithmeticDecoder) decodeSymbol(m *symbolModel) uint32 {
+	var sym, x uint32
+	y := d.length
+	d.length >>= dmLengthShift
+	if m.decoderTable != nil {
+		dv := d.value / d.length
+		t := dv >> m.tableShift
+		sym = m.decoderTable[t]
+		n := m.decoderTable[t+1] + 1
+		for n > sym+1 { // bisection within the range of the table
+			k := (sym + n) >> 1
+			if m.distribution[k] > dv {
+				n = k
+			} else {
+				sym = k
+			}
+		}
+		x = m.distribution[sym] * d.length
+		if sym != m.lastSymbol {
+			y = m.distribution[sym+1] * d.length
+		}
+	} else {
+		n := m.symbols
+		k := n >> 1
+		for {
+			z := d.length * m.distribution[k]
+			if z > d.value {
+				n = k
+				y = z
+			} else {
+				sym = k
+				x = z
+			}
+			if k = (sym + n) >> 1; k == sym {
+				break
+			}
+		}
+	}
+	d.value -= x
+	d.length = y - x
+	if d.length < acMinLength {
+		d.renorm()
+	}
+	m.symbolCount[sym]++
+	if m.untilUpdate--; m.untilUpdate == 0 {
+		m.update()
+	}
+	return sym
+}
+
+// readBits reads bits of equal probability
+func (d *arithmeticDecoder) readBits(bits uint32) uint32 {
+	if bits > 19 {
+		low := d.readShort()
+		return d.readBits(bits-16)<<16 | uint32(low)
+	}
+	d.length >>= bits
+	sym := d.value / d.length
+	d.value -= d.length * sym
+	if d.length < acMinLength {
+		d.renorm()
+	}
+	return sym
+}
+
+func (d *arithmeticDecoder) readShort() uint16 {
+	d.length >>= 16
+	sym := d.value / d.length
+	d.value -= d.length * sym
+	if d.length < acMinLength {
+		d.renorm()
+	}
+	return uint16(sym)
+}
+
+func (d *arithmeticDecoder) readInt() uint32 {
+	low := uint32(d.readShort())
+	return uint32(d.readShort())<<16 | low
+}
+
+func (d *arithmeticDecoder) readInt64() uint64 {
+	low := uint64(d.readInt())
+	return uint64(d.readInt())<<32 | low
+}
+
+// integerDecoder decompresses integers predicted by the item decoders, the
+// corrections are coded by their bit length k and then the bits themselves
+type integerDecoder struct {
+	d           *arithmeticDecoder
+	bitsHigh    uint32
+	corrBits    uint32
+	corrRange   uint32
+	corrMin     int32
+	mBits       []*symbolModel
+	mCorrector  []*symbolModel // by k, k=0 uses mCorrector0
+	mCorrector0 *bitModel
+	k           uint32
+}
+
+// newIntegerDecoder decompresses integers of bits bits, 32 for any int32, in
+// contexts independent contexts
+func newIntegerDecoder(d *arithmeticDecoder, bits, contexts uint32) *integerDecoder {
+	i := &integerDecoder{d: d, bitsHigh: 8}
+	if bits > 0 && bits < 32 {
+		i.corrBits = bits
+		i.corrRange = 1 << bits
+		i.corrMin = -int32(i.corrRange / 2)
+	} else {
+		i.corrBits = 32
+		i.corrMin = -1 << 31
+	}
+	i.mBits = make([]*symbolModel, contexts)
+	for c := range i.mBits {
+		i.mBits[c] = newSymbolModel(i.corrBits + 1)
+	}
+	i.mCorrector0 = newBitModel()
+	i.mCorrector = make([]*symbolModel, i.corrBits+1)
+	for k := uint32(1); k <= i.corrBits; k++ {
+		if k <= i.bitsHigh {
+			i.mCorrector[k] = newSymbolModel(1 << k)
+		} else {
+			i.mCorrector[k] = newSymbolModel(1 << i.bitsHigh)
+		}
+	}
+	return i
+}
+
+func (i *integerDecoder) decompress(pred int32, context uint32) int32 {
+	real := pred + i.readCorrector(i.mBits[context])
+	if real < 0 {
+		real += int32(i.corrRange)
+	} else if uint32(real) >= i.corrRange {
+		real -= int32(i.corrRange)
+	}
+	return real
+}
+
+func (i *integerDecoder) readCorrector(m *symbolModel) int32 {
+	i.k = i.d.decodeSymbol(m)
+	k := i.k
+	if k == 0 {
+		return int32(i.d.decodeBit(i.mCorrector0))
+	}
+	if k >= 32 {
+		return i.corrMin
+	}
+	var c int64
+	if k <= i.bitsHigh {
+		c = int64(i.d.decodeSymbol(i.mCorrector[k]))
+	} else {
+		k1 := k - i.bitsHigh
+		c = int64(i.d.decodeSymbol(i.mCorrector[k]))
+		c = c<<k1 | int64(i.d.readBits(k1))
+	}
+	// the corrections of k bits are -(2^k-1) to -2^(k-1) and 2^(k-1) to 2^k
+	if c >= 1<<(k-1) {
+		c++
+	} else {
+		c -= 1<<k - 1
+	}
+	return int32(c)
+}
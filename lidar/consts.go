@@ -8,6 +8,7 @@ const (
 	headerSizePosition = 94
 	geotiffSignature   = "LASF_Projection\x00"
 	laszipSignature    = "laszip encoded\x00\x00"
+	lasfSpecSignature  = "LASF_Spec\x00\x00\x00\x00\x00\x00\x00"
 
 	RGeoKeys    = 34735
 	RGeoDoubles = 34736
@@ -17,6 +18,20 @@ const (
 	CoordinateSystemWKT = 2112
 )
 
+// pointRecordLengths are the lengths of the records of point formats 0 to 10
+// without extra bytes
+var pointRecordLengths = []uint16{20, 28, 26, 34, 57, 63, 30, 36, 38, 59, 67}
+
+// pointFormatMask clears the bits set in the point format of LAZ files
+const pointFormatMask = 0x3F
+
+// header sizes of LAS 1.2, 1.3 and 1.4
+const (
+	headerSize12 = 227
+	headerSize13 = 235
+	headerSize14 = 375
+)
+
 type GpsTimeType int
 
 // GlobalEncoding Bits
@@ -0,0 +1,320 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/geodatalake/lambdas/geotiff"
+)
+
+// ExtraBytesType is the data type of an extra bytes attribute
+type ExtraBytesType byte
+
+const (
+	ExtraBytesUndocumented ExtraBytesType = iota // Size opaque bytes
+	ExtraBytesUint8
+	ExtraBytesInt8
+	ExtraBytesUint16
+	ExtraBytesInt16
+	ExtraBytesUint32
+	ExtraBytesInt32
+	ExtraBytesUint64
+	ExtraBytesInt64
+	ExtraBytesFloat32
+	ExtraBytesFloat64
+)
+
+var extraBytesSizes = []int{0, 1, 1, 2, 2, 4, 4, 8, 8, 4, 8}
+
+// the deprecated types 11 to 30 are tuples of 2 and 3 values of types 1 to
+// 10, they are kept as opaque bytes like undocumented extra bytes
+const extraBytesLastTuple = 30
+
+// Options bits of an extra bytes descriptor, telling which fields are set
+const (
+	ExtraBytesNoData = 1 << iota
+	ExtraBytesMin
+	ExtraBytesMax
+	ExtraBytesScale
+	ExtraBytesOffset
+)
+
+const (
+	extraBytesRecordID       = 4
+	extraBytesDescriptorSize = 192
+)
+
+// ExtraBytesDescriptor describes an attribute stored in the extra bytes of
+// the point records by the Extra Bytes VLR. NoData, Min and Max are raw
+// values, before the scale and offset.
+type ExtraBytesDescriptor struct {
+	Name        string
+	Description string
+	DataType    ExtraBytesType
+	Options     byte
+	Size        int // in bytes
+	NoData      float64
+	Min         float64
+	Max         float64
+	Scale       float64
+	Offset      float64
+	position    int // in the extra bytes of a record
+}
+
+// opaque tells whether the values of the attribute are not read
+func (d *ExtraBytesDescriptor) opaque() bool {
+	return d.DataType == ExtraBytesUndocumented || d.DataType > ExtraBytesFloat64
+}
+
+// parseExtraBytes reads the descriptors of an Extra Bytes VLR, the
+// attributes follow each other in the extra bytes
+func parseExtraBytes(data []byte) ([]*ExtraBytesDescriptor, error) {
+	if len(data)%extraBytesDescriptorSize != 0 {
+		return nil, geotiff.GeneralIssue(fmt.Sprintf("extra bytes record of %d bytes", len(data)))
+	}
+	le := binary.LittleEndian
+	var descriptors []*ExtraBytesDescriptor
+	position := 0
+	for b := data; len(b) > 0; b = b[extraBytesDescriptorSize:] {
+		d := &ExtraBytesDescriptor{
+			DataType:    ExtraBytesType(b[2]),
+			Options:     b[3],
+			Name:        cString(b[4:36]),
+			Description: cString(b[160:192]),
+			position:    position,
+		}
+		switch {
+		case d.DataType > extraBytesLastTuple:
+			return nil, geotiff.UnsupportedError(fmt.Sprintf("extra bytes data type %d of %s", d.DataType, d.Name))
+		case d.DataType == ExtraBytesUndocumented:
+			d.Size = int(d.Options)
+		case d.DataType > ExtraBytesFloat64:
+			t := int(d.DataType) - 1
+			d.Size = (t/10 + 1) * extraBytesSizes[t%10+1]
+		default:
+			d.Size = extraBytesSizes[d.DataType]
+			d.NoData = d.anytype(b[40:48])
+			d.Min = d.anytype(b[64:72])
+			d.Max = d.anytype(b[88:96])
+			d.Scale = math.Float64frombits(le.Uint64(b[112:120]))
+			d.Offset = math.Float64frombits(le.Uint64(b[136:144]))
+		}
+		descriptors = append(descriptors, d)
+		position += d.Size
+	}
+	return descriptors, nil
+}
+
+// cString returns b up to its first NUL
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// anytype reads the 8 byte no_data, min and max fields of the descriptor,
+// integers are widened to 64 bits and floats to doubles
+func (d *ExtraBytesDescriptor) anytype(b []byte) float64 {
+	v := binary.LittleEndian.Uint64(b)
+	switch d.DataType {
+	case ExtraBytesInt8, ExtraBytesInt16, ExtraBytesInt32, ExtraBytesInt64:
+		return float64(int64(v))
+	case ExtraBytesFloat32, ExtraBytesFloat64:
+		return math.Float64frombits(v)
+	}
+	return float64(v)
+}
+
+func (d *ExtraBytesDescriptor) putAnytype(b []byte, value float64) {
+	switch d.DataType {
+	case ExtraBytesInt8, ExtraBytesInt16, ExtraBytesInt32, ExtraBytesInt64:
+		binary.LittleEndian.PutUint64(b, uint64(int64(value)))
+	case ExtraBytesFloat32, ExtraBytesFloat64:
+		binary.LittleEndian.PutUint64(b, math.Float64bits(value))
+	default:
+		binary.LittleEndian.PutUint64(b, uint64(value))
+	}
+}
+
+// Raw returns the stored value of the attribute in the extra bytes of a
+// point, false when they are too short or the type is undocumented or a tuple
+func (d *ExtraBytesDescriptor) Raw(extra []byte) (float64, bool) {
+	if d.opaque() || d.position+d.Size > len(extra) {
+		return 0, false
+	}
+	b := extra[d.position:]
+	le := binary.LittleEndian
+	switch d.DataType {
+	case ExtraBytesUint8:
+		return float64(b[0]), true
+	case ExtraBytesInt8:
+		return float64(int8(b[0])), true
+	case ExtraBytesUint16:
+		return float64(le.Uint16(b)), true
+	case ExtraBytesInt16:
+		return float64(int16(le.Uint16(b))), true
+	case ExtraBytesUint32:
+		return float64(le.Uint32(b)), true
+	case ExtraBytesInt32:
+		return float64(int32(le.Uint32(b))), true
+	case ExtraBytesUint64:
+		return float64(le.Uint64(b)), true
+	case ExtraBytesInt64:
+		return float64(int64(le.Uint64(b))), true
+	case ExtraBytesFloat32:
+		return float64(math.Float32frombits(le.Uint32(b))), true
+	}
+	return math.Float64frombits(le.Uint64(b)), true
+}
+
+// Value returns the attribute of a point with the scale and offset applied,
+// false when the point has no data for it
+//
+//	for it.Next() {
+//		if v, ok := descriptor.Value(it.Point().ExtraBytes); ok {
+//			...
+//		}
+//	}
+func (d *ExtraBytesDescriptor) Value(extra []byte) (float64, bool) {
+	raw, ok := d.Raw(extra)
+	if !ok {
+		return 0, false
+	}
+	if d.Options&ExtraBytesNoData != 0 && (raw == d.NoData || math.IsNaN(raw) && math.IsNaN(d.NoData)) {
+		return 0, false
+	}
+	if d.Options&ExtraBytesScale != 0 {
+		raw *= d.Scale
+	}
+	if d.Options&ExtraBytesOffset != 0 {
+		raw += d.Offset
+	}
+	return raw, true
+}
+
+// NewExtraBytesVlr returns the Extra Bytes VLR of descriptors to write with
+// a Writer, whose ExtraBytes option must hold their sizes
+func NewExtraBytesVlr(descriptors []*ExtraBytesDescriptor) *Vlr {
+	data := make([]byte, extraBytesDescriptorSize*len(descriptors))
+	le := binary.LittleEndian
+	for i, d := range descriptors {
+		b := data[i*extraBytesDescriptorSize:]
+		b[2] = byte(d.DataType)
+		b[3] = d.Options
+		copy(b[4:36], d.Name)
+		copy(b[160:192], d.Description)
+		if d.DataType == ExtraBytesUndocumented {
+			b[3] = byte(d.Size)
+		}
+		if d.opaque() {
+			continue
+		}
+		d.putAnytype(b[40:48], d.NoData)
+		d.putAnytype(b[64:72], d.Min)
+		d.putAnytype(b[88:96], d.Max)
+		le.PutUint64(b[112:120], math.Float64bits(d.Scale))
+		le.PutUint64(b[136:144], math.Float64bits(d.Offset))
+	}
+	return NewVlr(lasfSpecSignature, extraBytesRecordID, "Extra Bytes Record", data)
+}
+
+// parseExtraBytes keeps the descriptors of the Extra Bytes VLR, which must
+// fit in the records. A broken record is skipped, its error is returned when
+// an attribute is requested.
+func (d *decoder) parseExtraBytes() {
+	for _, vlr := range d.vlrs {
+		if vlr.userID != lasfSpecSignature || vlr.recordID != extraBytesRecordID {
+			continue
+		}
+		descriptors, err := parseExtraBytes(vlr.data)
+		if err != nil {
+			d.extraBytesErr = err
+			continue
+		}
+		size := 0
+		for _, e := range descriptors {
+			size += e.Size
+		}
+		format := d.header.GetPointFormat()
+		if int(format) < len(pointRecordLengths) && int(pointRecordLengths[format])+size > int(d.header.GetPointLength()) {
+			d.extraBytesErr = geotiff.GeneralIssue(fmt.Sprintf("%d extra bytes in records of %d bytes for point format %d", size, d.header.GetPointLength(), format))
+			continue
+		}
+		d.extraBytes = descriptors
+	}
+}
+
+func (d *decoder) ExtraBytes() []*ExtraBytesDescriptor {
+	return d.extraBytes
+}
+
+func (d *decoder) ExtraBytesAttribute(name string) (*ExtraBytesDescriptor, error) {
+	for _, e := range d.extraBytes {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	if d.extraBytesErr != nil {
+		return nil, d.extraBytesErr
+	}
+	return nil, geotiff.GeneralIssue(fmt.Sprintf("extra bytes attribute %s is not present", name))
+}
+
+// AttributeRange keeps the points whose extra bytes attribute Name is within
+// Min and Max, after its scale and offset
+type AttributeRange struct {
+	Name string
+	Min  float64
+	Max  float64
+}
+
+type attributeRange struct {
+	descriptor *ExtraBytesDescriptor
+	min, max   float64
+}
+
+// resolveAttributes finds the descriptors of the attributes of the options
+func (d *decoder) resolveAttributes() error {
+	if d.opt == nil {
+		return nil
+	}
+	if d.opt.Attribute != "" {
+		e, err := d.ExtraBytesAttribute(d.opt.Attribute)
+		if err != nil {
+			return err
+		}
+		if e.opaque() {
+			return geotiff.UnsupportedError(fmt.Sprintf("rasterizing extra bytes %s of type %d", e.Name, e.DataType))
+		}
+		d.attribute = e
+	}
+	for _, r := range d.opt.AttributeRanges {
+		e, err := d.ExtraBytesAttribute(r.Name)
+		if err != nil {
+			return err
+		}
+		if e.opaque() {
+			return geotiff.UnsupportedError(fmt.Sprintf("filtering extra bytes %s of type %d", e.Name, e.DataType))
+		}
+		d.ranges = append(d.ranges, attributeRange{descriptor: e, min: r.Min, max: r.Max})
+	}
+	return nil
+}
+
+// inRanges tells whether the attributes of a point are within the ranges
+func inRanges(ranges []attributeRange, extra []byte) bool {
+	for _, r := range ranges {
+		v, ok := r.descriptor.Value(extra)
+		if !ok || v < r.min || v > r.max {
+			return false
+		}
+	}
+	return true
+}
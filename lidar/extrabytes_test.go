@@ -0,0 +1,221 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// sampleDescriptors returns attributes of each kind of extra bytes, with
+// their values in 28 bytes
+func sampleDescriptors() []*ExtraBytesDescriptor {
+	return []*ExtraBytesDescriptor{
+		{Name: "height", DataType: ExtraBytesUint16, Options: ExtraBytesScale | ExtraBytesOffset, Scale: 0.5, Offset: 100},
+		{Name: "quality", DataType: ExtraBytesUint8, Options: ExtraBytesNoData, NoData: 255},
+		{Name: "offset", DataType: ExtraBytesInt16, Options: ExtraBytesNoData | ExtraBytesOffset, NoData: -1, Offset: -10, Scale: 3},
+		{Name: "pair", DataType: 13},
+		{Name: "reflectance", DataType: ExtraBytesFloat32, Options: ExtraBytesNoData | ExtraBytesMin | ExtraBytesMax, NoData: math.NaN(), Min: -1, Max: 1},
+		{Name: "opaque", DataType: ExtraBytesUndocumented, Size: 3},
+		{Name: "range", DataType: ExtraBytesFloat64, Options: ExtraBytesScale, Scale: 2},
+	}
+}
+
+// putExtraBytes returns the extra bytes of the attributes of
+// sampleDescriptors
+func putExtraBytes(height uint16, quality byte, offset int16, reflectance float32, r float64) []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 28)
+	le.PutUint16(b[0:], height)
+	b[2] = quality
+	le.PutUint16(b[3:], uint16(offset))
+	le.PutUint32(b[9:], math.Float32bits(reflectance))
+	le.PutUint64(b[16:], math.Float64bits(r))
+	return b
+}
+
+func TestParseExtraBytes(t *testing.T) {
+	vlr := NewExtraBytesVlr(sampleDescriptors())
+	descriptors, err := parseExtraBytes(vlr.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	positions := []int{0, 2, 3, 5, 9, 13, 16}
+	sizes := []int{2, 1, 2, 4, 4, 3, 8}
+	for i, d := range descriptors {
+		e := sampleDescriptors()[i]
+		if d.Name != e.Name || d.DataType != e.DataType || d.position != positions[i] || d.Size != sizes[i] {
+			t.Errorf("attribute %s of type %d at %d of %d bytes, expected %s of type %d at %d of %d bytes", d.Name, d.DataType, d.position, d.Size, e.Name, e.DataType, positions[i], sizes[i])
+		}
+		if d.opaque() {
+			continue
+		}
+		if d.Options != e.Options || d.Scale != e.Scale || d.Offset != e.Offset || d.Min != e.Min || d.Max != e.Max ||
+			d.NoData != e.NoData && !(math.IsNaN(d.NoData) && math.IsNaN(e.NoData)) {
+			t.Errorf("attribute %+v, expected %+v", *d, *e)
+		}
+	}
+	if len(descriptors) != len(positions) {
+		t.Fatalf("%d attributes, expected %d", len(descriptors), len(positions))
+	}
+
+	for dataType, size := range map[ExtraBytesType]int{11: 2, 14: 4, 20: 16, 21: 3, 26: 12, 30: 24} {
+		descriptors, err := parseExtraBytes(NewExtraBytesVlr([]*ExtraBytesDescriptor{{Name: "tuple", DataType: dataType}}).data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if descriptors[0].Size != size {
+			t.Errorf("type %d of %d bytes, expected %d", dataType, descriptors[0].Size, size)
+		}
+	}
+	if _, err := parseExtraBytes(NewExtraBytesVlr([]*ExtraBytesDescriptor{{Name: "unknown", DataType: 31}}).data); err == nil {
+		t.Error("extra bytes of type 31")
+	}
+	if _, err := parseExtraBytes(vlr.data[:100]); err == nil {
+		t.Error("truncated extra bytes record")
+	}
+}
+
+func TestExtraBytesValues(t *testing.T) {
+	descriptors, err := parseExtraBytes(NewExtraBytesVlr(sampleDescriptors()).data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none := math.Inf(-1)
+	tests := []struct {
+		extra []byte
+		raw   []float64 // none when the attribute has no raw value
+		value []float64 // none when the attribute has no value
+	}{
+		{
+			putExtraBytes(10, 3, -4, 0.25, 1.5),
+			[]float64{10, 3, -4, none, 0.25, none, 1.5},
+			[]float64{105, 3, -14, none, 0.25, none, 3},
+		},
+		{
+			putExtraBytes(65535, 255, -1, float32(math.NaN()), -2),
+			[]float64{65535, 255, -1, none, math.NaN(), none, -2},
+			[]float64{32867.5, none, none, none, none, none, -4},
+		},
+		{
+			putExtraBytes(0, 0, 0, 0, 0)[:9],
+			[]float64{0, 0, 0, none, none, none, none},
+			[]float64{100, 0, -10, none, none, none, none},
+		},
+	}
+	same := func(v float64, ok bool, expected float64) bool {
+		if expected == none {
+			return !ok
+		}
+		return ok && (v == expected || math.IsNaN(v) && math.IsNaN(expected))
+	}
+	for i, test := range tests {
+		for j, d := range descriptors {
+			if v, ok := d.Raw(test.extra); !same(v, ok, test.raw[j]) {
+				t.Errorf("point %d: raw %s %v, %v, expected %v", i, d.Name, v, ok, test.raw[j])
+			}
+			if v, ok := d.Value(test.extra); !same(v, ok, test.value[j]) {
+				t.Errorf("point %d: %s %v, %v, expected %v", i, d.Name, v, ok, test.value[j])
+			}
+		}
+	}
+}
+
+func TestBuildAttribute(t *testing.T) {
+	descriptors := sampleDescriptors()
+	var points []*Point
+	for i := 0; i < 24; i++ {
+		quality := byte(i % 5)
+		if i == 7 {
+			quality = 255
+		}
+		points = append(points, &Point{X: float64(i % 5), Y: float64(i / 5), Z: 1000,
+			ExtraBytes: putExtraBytes(uint16(i*3), quality, 0, 0, 0)})
+	}
+	name := writeLas(t, &WriterOptions{PointFormat: 0, ExtraBytes: 28, Vlrs: []*Vlr{NewExtraBytesVlr(descriptors)}}, points)
+
+	opt := &ReadOptions{Filtering: true, Attribute: "height", AttributeRanges: []AttributeRange{{Name: "quality", Min: 1, Max: 3}}}
+	las := openLas(t, name, opt)
+	if e, err := las.ExtraBytesAttribute("height"); err != nil || e.Scale != 0.5 {
+		t.Fatalf("attribute height %v: %v", e, err)
+	}
+	r, err := las.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Width() != 5 || r.Height() != 5 {
+		t.Fatalf("%d x %d raster, expected 5 x 5", r.Width(), r.Height())
+	}
+	if r.CRS != nil {
+		t.Errorf("raster CRS %v without GeoKeys", r.CRS)
+	}
+	for i, p := range points {
+		if q := p.ExtraBytes[2]; q < 1 || q > 3 {
+			continue
+		}
+		row, col := 4-int(p.Y), int(p.X)
+		if v, expected := r.ValueAt(row, col), float32(i*3)*0.5+100; v != expected {
+			t.Errorf("cell %d, %d of point %d is %v, expected %v", row, col, i, v, expected)
+		}
+	}
+
+	// Z within the ranges
+	opt = &ReadOptions{Filtering: true, AttributeRanges: []AttributeRange{{Name: "quality", Min: 1, Max: 3}}}
+	if r, err = openLas(t, name, opt).Build(); err != nil {
+		t.Fatal(err)
+	}
+	if v := r.ValueAt(4, 1); v != 1000 {
+		t.Errorf("cell 4, 1 is %v, expected 1000", v)
+	}
+
+	for _, opt := range []*ReadOptions{
+		{Filtering: true, Attribute: "pair"},
+		{Filtering: true, Attribute: "opaque"},
+		{Filtering: true, AttributeRanges: []AttributeRange{{Name: "pair", Max: 1}}},
+		{Filtering: true, Attribute: "missing"},
+		{Filtering: true, AttributeRanges: []AttributeRange{{Name: "height", Min: 2, Max: 1}}},
+	} {
+		f := openFile(t, name)
+		if _, err := NewFileReader(f, opt); err == nil {
+			t.Errorf("options %v", opt)
+		}
+	}
+}
+
+func TestBrokenExtraBytes(t *testing.T) {
+	valid := NewExtraBytesVlr([]*ExtraBytesDescriptor{{Name: "height", DataType: ExtraBytesUint16}})
+	for _, vlr := range []*Vlr{
+		NewExtraBytesVlr([]*ExtraBytesDescriptor{{Name: "unknown", DataType: 31}}),
+		NewVlr(lasfSpecSignature, extraBytesRecordID, "Extra Bytes Record", valid.data[:100]),
+		NewExtraBytesVlr([]*ExtraBytesDescriptor{{Name: "wide", DataType: ExtraBytesFloat64}}),
+	} {
+		points := []*Point{{X: 0, Y: 0, Z: 10, ExtraBytes: []byte{1, 0}}, {X: 1, Y: 1, Z: 20, ExtraBytes: []byte{2, 0}}}
+		name := writeLas(t, &WriterOptions{PointFormat: 1, ExtraBytes: 2, Vlrs: []*Vlr{vlr}}, points)
+
+		// the points and rasters do not need the attributes
+		las := openLas(t, name, nil)
+		if len(las.ExtraBytes()) != 0 {
+			t.Errorf("attributes %v of a broken record", las.ExtraBytes())
+		}
+		if n := countPoints(t, las); n != 2 {
+			t.Errorf("%d points, expected 2", n)
+		}
+		if r, err := las.Build(); err != nil || r.ValueAt(0, 1) != 20 {
+			t.Errorf("raster of a broken record: %v", err)
+		}
+		if _, err := las.ExtraBytesAttribute("height"); err == nil {
+			t.Error("attribute of a broken record")
+		}
+		for _, opt := range []*ReadOptions{
+			{Filtering: true, Attribute: "height"},
+			{Filtering: true, AttributeRanges: []AttributeRange{{Name: "height", Max: 1}}},
+		} {
+			if _, err := NewFileReader(openFile(t, name), opt); err == nil {
+				t.Errorf("options %v of a broken record", opt)
+			}
+		}
+	}
+}
@@ -284,7 +284,8 @@ func (h *LasHeader14) Imageinfo() *ImageInfo {
 		maxx:    h.maxX,
 		miny:    h.minY,
 		maxy:    h.maxY,
-		minz:    h.maxZ}
+		minz:    h.minZ,
+		maxz:    h.maxZ}
 }
 
 func (h *LasHeader14) DumpHeader() []string {
@@ -0,0 +1,156 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/geodatalake/lambdas/geotiff"
+)
+
+// iteratorBatch is the number of points read at once by a PointIterator
+const iteratorBatch = 10000
+
+// PointIterator yields the points of a LAS file in order without reading the
+// whole file:
+//
+//	it := las.Points(ctx)
+//	for it.Next() {
+//		p := it.Point()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type PointIterator interface {
+	// Next advances to the next point, it returns false after the last
+	// point, on an error or once the context is done
+	Next() bool
+	// Point returns the current point, it is reused by the next call to Next
+	Point() *Point
+	// Index returns the index of the current point in the file, 0 before
+	// the first call to Next
+	Index() uint64
+	// Err returns the error that ended the iteration, if any
+	Err() error
+}
+
+type pointIterator struct {
+	ctx          context.Context
+	d            *decoder
+	format       byte
+	pf           PointFormat
+	recordLength int
+	numPoints    uint64
+	batch        []byte
+	first        uint64 // the index of the first point of the batch
+	index        uint64 // the index of the next point
+	point        Point
+	err          error
+}
+
+// Points iterates over the points of the file until ctx is done
+func (d *decoder) Points(ctx context.Context) PointIterator {
+	it := &pointIterator{
+		ctx:          ctx,
+		d:            d,
+		format:       d.header.GetPointFormat(),
+		recordLength: int(d.header.GetPointLength()),
+		numPoints:    d.header.GetNumberOfPoints(),
+	}
+	var err error
+	if it.pf, err = NewPointFormat(it.format); err != nil {
+		it.err = err
+	} else if it.recordLength < int(pointRecordLengths[it.format]) {
+		it.err = geotiff.GeneralIssue(fmt.Sprintf("records of %d bytes for point format %d", it.recordLength, it.format))
+	}
+	return it
+}
+
+func (it *pointIterator) Next() bool {
+	if it.err != nil || it.index >= it.numPoints {
+		return false
+	}
+	at := int(it.index-it.first) * it.recordLength
+	if it.batch == nil || at >= len(it.batch) {
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+		num := it.numPoints - it.index
+		if num > iteratorBatch {
+			num = iteratorBatch
+		}
+		if it.batch == nil {
+			it.batch = make([]byte, int(num)*it.recordLength)
+		}
+		it.batch = it.batch[:int(num)*it.recordLength]
+		offset := int64(it.d.header.GetPointsOffset()) + int64(it.index)*int64(it.recordLength)
+		if _, err := it.d.points.ReadAt(it.batch, offset); err != nil {
+			it.err = err
+			return false
+		}
+		it.first, at = it.index, 0
+	}
+	decodePoint(&it.point, it.pf, it.batch[at:at+it.recordLength], it.format, it.d.header)
+	it.index++
+	return true
+}
+
+func (it *pointIterator) Point() *Point {
+	return &it.point
+}
+
+func (it *pointIterator) Index() uint64 {
+	if it.index == 0 {
+		return 0
+	}
+	return it.index - 1
+}
+
+func (it *pointIterator) Err() error {
+	return it.err
+}
+
+// decodePoint fills p from a record read by pf, a PointFormat of format,
+// with its coordinates scaled by the header
+func decodePoint(p *Point, pf PointFormat, b []byte, format byte, header HeaderFormat) {
+	pf.ReadPoint(b)
+	extra := p.ExtraBytes[:0]
+	*p = Point{
+		Intensity:           pf.GetIntensity(),
+		ReturnNumber:        pf.GetReturnNumber(),
+		NumberOfReturns:     pf.GetTotalReturns(),
+		ClassificationFlags: pf.GetClassificationFlags(),
+		ScanDirectionFlag:   pf.GetScanDirectionFlag(),
+		EdgeOfFlightLine:    pf.GetEdgeOfFlightLine(),
+		Classification:      uint8(pf.GetClassification()),
+		ScanAngle:           pf.GetScanAngle(),
+		UserData:            pf.GetUserData(),
+		PointSourceID:       pf.GetPointSourceID(),
+	}
+	p.X, p.Y, p.Z = header.ScalePoints(pf.GetX(), pf.GetY(), pf.GetZ())
+	if g, ok := pf.(HasGpsTime); ok {
+		p.GpsTime = g.GetGpsTime()
+	}
+	if c, ok := pf.(HasRGB); ok {
+		p.Red, p.Green, p.Blue = c.GetRGB()
+	}
+	if n, ok := pf.(HasNIR); ok {
+		p.NIR = n.GetNIR()
+	}
+	if c, ok := pf.(HasScannerChannel); ok {
+		p.ScannerChannel = c.GetScannerChannel()
+	}
+	if w, ok := pf.(HasWaveform); ok {
+		wp := w.GetWavePacket()
+		p.WavePacketDescriptorIndex, p.ByteOffsetToWaveformData, p.WaveformPacketSizeInBytes = wp.DescriptorIndex, wp.ByteOffset, wp.Size
+		p.ReturnPointWaveformLocation, p.Xt, p.Yt, p.Zt = wp.ReturnPointLocation, wp.Xt, wp.Yt, wp.Zt
+	}
+	if n := int(pointRecordLengths[format]); len(b) > n {
+		p.ExtraBytes = append(extra, b[n:]...)
+	}
+}
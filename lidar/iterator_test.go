@@ -0,0 +1,133 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"context"
+	"io"
+	"math"
+	"testing"
+)
+
+// countingReader counts the reads of the point records
+type countingReader struct {
+	r       io.ReaderAt
+	offsets []int64
+}
+
+func (c *countingReader) ReadAt(b []byte, off int64) (int, error) {
+	c.offsets = append(c.offsets, off)
+	return c.r.ReadAt(b, off)
+}
+
+func TestIteratorBatches(t *testing.T) {
+	n := 2*iteratorBatch + 3
+	var points []*Point
+	for i := 0; i < n; i++ {
+		points = append(points, &Point{X: float64(i), Intensity: uint16(i), ExtraBytes: []byte{byte(i)}})
+	}
+	d := openLas(t, writeLas(t, &WriterOptions{PointFormat: 0, ExtraBytes: 1}, points), nil).(*decoder)
+	reads := &countingReader{r: d.points}
+	d.points = reads
+
+	it := d.Points(context.Background())
+	if it.Index() != 0 {
+		t.Errorf("index %d before the first point", it.Index())
+	}
+	i := 0
+	for ; it.Next(); i++ {
+		p := it.Point()
+		if it.Index() != uint64(i) || p.X != float64(i) || p.Intensity != uint16(i) || len(p.ExtraBytes) != 1 || p.ExtraBytes[0] != byte(i) {
+			t.Fatalf("point %d at index %d is %+v", i, it.Index(), *p)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != n {
+		t.Errorf("read %d points, expected %d", i, n)
+	}
+	offset := int64(d.header.GetPointsOffset())
+	length := int64(d.header.GetPointLength())
+	expected := []int64{offset, offset + iteratorBatch*length, offset + 2*iteratorBatch*length}
+	if len(reads.offsets) != len(expected) {
+		t.Fatalf("reads at %v, expected %v", reads.offsets, expected)
+	}
+	for i, o := range expected {
+		if reads.offsets[i] != o {
+			t.Errorf("read %d at %d, expected %d", i, reads.offsets[i], o)
+		}
+	}
+	if it.Next() {
+		t.Error("point after the last")
+	}
+}
+
+func TestIteratorCancel(t *testing.T) {
+	var points []*Point
+	for i := 0; i < iteratorBatch+10; i++ {
+		points = append(points, &Point{X: float64(i)})
+	}
+	las := openLas(t, writeLas(t, &WriterOptions{PointFormat: 1}, points), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it := las.Points(ctx)
+	n := 0
+	for it.Next() {
+		if n++; n == 5 {
+			cancel()
+		}
+	}
+	// the context is checked before reading each batch
+	if n != iteratorBatch {
+		t.Errorf("read %d points after cancelling, expected %d", n, iteratorBatch)
+	}
+	if it.Err() != context.Canceled {
+		t.Errorf("error %v, expected %v", it.Err(), context.Canceled)
+	}
+	if it.Next() {
+		t.Error("point after cancelling")
+	}
+
+	it = las.Points(ctx)
+	if it.Next() || it.Err() != context.Canceled {
+		t.Errorf("iteration of a done context: %v", it.Err())
+	}
+}
+
+func TestDecodePoint(t *testing.T) {
+	header := &LasHeaderLegacy{xScaleFactor: 0.01, yScaleFactor: 0.01, zScaleFactor: 0.01}
+	for format := byte(0); format < byte(len(pointRecordLengths)); format++ {
+		pf, err := NewPointFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p Point
+		p.ExtraBytes = make([]byte, 0, 4)
+		reused := &p.ExtraBytes[:1][0]
+		for i, expected := range samplePoints(20) {
+			record := make([]byte, int(pointRecordLengths[format])+len(expected.ExtraBytes))
+			var xyz [3]int32
+			for i, v := range []float64{expected.X, expected.Y, expected.Z} {
+				xyz[i] = int32(math.Floor(v/0.01 + 0.5))
+			}
+			if err := encodePoint(record, expected, format, xyz); err != nil {
+				t.Fatal(err)
+			}
+			copy(record[pointRecordLengths[format]:], expected.ExtraBytes)
+			decodePoint(&p, pf, record, format, header)
+			if q := formatPoint(expected, format); !equalPoints(p, q) {
+				t.Fatalf("format %d: point %d is %+v, expected %+v", format, i, p, q)
+			}
+			if &p.ExtraBytes[0] != reused {
+				t.Errorf("format %d: extra bytes of point %d are not reused", format, i)
+			}
+		}
+		decodePoint(&p, pf, make([]byte, pointRecordLengths[format]), format, header)
+		if len(p.ExtraBytes) != 0 {
+			t.Errorf("format %d: extra bytes %v without extra bytes", format, p.ExtraBytes)
+		}
+	}
+}
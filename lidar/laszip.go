@@ -0,0 +1,498 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/geodatalake/lambdas/geotiff"
+)
+
+// LASzip compressors
+const (
+	lazNone = iota
+	lazPointwise
+	lazPointwiseChunked
+	lazLayeredChunked
+)
+
+// LASzip items
+const (
+	lazByte         = 0
+	lazPoint10      = 6
+	lazGpsTime11    = 7
+	lazRgb12        = 8
+	lazWavePacket13 = 9
+	lazPoint14      = 10
+	lazRgb14        = 11
+	lazRgbNir14     = 12
+	lazWavePacket14 = 13
+	lazByte14       = 14
+)
+
+// lazVariableChunks is the chunk size of chunks of varying numbers of points
+const lazVariableChunks = math.MaxUint32
+
+type laszipItem struct {
+	itemType uint16
+	size     uint16
+	version  uint16
+}
+
+// laszipInfo is the content of the laszip VLR
+type laszipInfo struct {
+	compressor uint16
+	coder      uint16
+	chunkSize  uint32
+	items      []laszipItem
+}
+
+func parseLaszip(data []byte) (*laszipInfo, error) {
+	if len(data) < 34 {
+		return nil, geotiff.GeneralIssue(fmt.Sprintf("laszip VLR of %d bytes", len(data)))
+	}
+	le := binary.LittleEndian
+	info := &laszipInfo{compressor: le.Uint16(data[0:2]), coder: le.Uint16(data[2:4]), chunkSize: le.Uint32(data[12:16])}
+	num := int(le.Uint16(data[32:34]))
+	if len(data) < 34+6*num {
+		return nil, geotiff.GeneralIssue(fmt.Sprintf("laszip VLR of %d bytes with %d items", len(data), num))
+	}
+	for i := 0; i < num; i++ {
+		b := data[34+6*i:]
+		info.items = append(info.items, laszipItem{itemType: le.Uint16(b[0:2]), size: le.Uint16(b[2:4]), version: le.Uint16(b[4:6])})
+	}
+	return info, nil
+}
+
+// validate checks that the items of info are supported and fill records of
+// recordLength bytes
+func (info *laszipInfo) validate(recordLength uint16) error {
+	if info.coder != 0 {
+		return geotiff.UnsupportedError(fmt.Sprintf("LASzip coder %d", info.coder))
+	}
+	if info.compressor < lazPointwise || info.compressor > lazLayeredChunked {
+		return geotiff.UnsupportedError(fmt.Sprintf("LASzip compressor %d", info.compressor))
+	}
+	length := 0
+	for _, item := range info.items {
+		layered := item.itemType >= lazPoint14
+		if layered != (info.compressor == lazLayeredChunked) {
+			return geotiff.UnsupportedError(fmt.Sprintf("LASzip item %d with compressor %d", item.itemType, info.compressor))
+		}
+		ok := false
+		switch item.itemType {
+		case lazByte, lazPoint10, lazGpsTime11, lazRgb12:
+			ok = item.version == 2
+		case lazWavePacket13:
+			ok = item.version == 1 || item.version == 2
+		case lazPoint14, lazRgb14, lazRgbNir14, lazWavePacket14, lazByte14:
+			ok = item.version == 3 || item.version == 4
+		}
+		if !ok {
+			return geotiff.UnsupportedError(fmt.Sprintf("LASzip item %d version %d", item.itemType, item.version))
+		}
+		length += int(item.size)
+	}
+	if length != int(recordLength) {
+		return geotiff.GeneralIssue(fmt.Sprintf("LASzip items of %d bytes in records of %d", length, recordLength))
+	}
+	return nil
+}
+
+// fileSource is a buffered byte source over a file
+type fileSource struct {
+	r     io.ReaderAt
+	buf   []byte
+	start int64 // the offset of buf
+	pos   int
+	err   error
+}
+
+func newFileSource(r io.ReaderAt, offset int64) *fileSource {
+	return &fileSource{r: r, buf: make([]byte, 0, 1<<16), start: offset}
+}
+
+func (s *fileSource) offset() int64 {
+	return s.start + int64(s.pos)
+}
+
+func (s *fileSource) seek(offset int64) {
+	if offset >= s.start && offset <= s.start+int64(len(s.buf)) {
+		s.pos = int(offset - s.start)
+		return
+	}
+	s.buf, s.start, s.pos = s.buf[:0], offset, 0
+}
+
+func (s *fileSource) fill() bool {
+	if s.err != nil {
+		return false
+	}
+	s.start += int64(len(s.buf))
+	s.buf, s.pos = s.buf[:cap(s.buf)], 0
+	n, err := s.r.ReadAt(s.buf, s.start)
+	s.buf = s.buf[:n]
+	if n == 0 {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		s.err = err
+		return false
+	}
+	return true
+}
+
+func (s *fileSource) nextByte() byte {
+	if s.pos >= len(s.buf) && !s.fill() {
+		return 0
+	}
+	s.pos++
+	return s.buf[s.pos-1]
+}
+
+func (s *fileSource) read(p []byte) error {
+	for i := range p {
+		p[i] = s.nextByte()
+	}
+	return s.err
+}
+
+func (s *fileSource) uint32() uint32 {
+	b := make([]byte, 4)
+	s.read(b)
+	return binary.LittleEndian.Uint32(b)
+}
+
+// chunkDecoder decompresses the points of a chunk after its first
+type chunkDecoder interface {
+	read(record []byte)
+}
+
+type pointwiseChunk struct {
+	items   []itemDecoder
+	offsets []int
+}
+
+func (c *pointwiseChunk) read(record []byte) {
+	for i, item := range c.items {
+		item.read(record[c.offsets[i]:], 0)
+	}
+}
+
+type layeredChunk struct {
+	point   *point14Decoder
+	items   []itemDecoder
+	offsets []int
+}
+
+func (c *layeredChunk) read(record []byte) {
+	context := c.point.read(record)
+	for i, item := range c.items {
+		item.read(record[c.offsets[i]:], context)
+	}
+}
+
+// lazReader reads the points of a LAZ file as if they were not compressed, as
+// an io.ReaderAt of the point records at their uncompressed offsets. Reads in
+// order decompress each chunk once, other reads start over from the chunk
+// holding their first point.
+type lazReader struct {
+	sync.Mutex
+	r            io.ReaderAt
+	info         *laszipInfo
+	pointsOffset int64
+	recordLength int64
+	numPoints    uint64
+	chunkStarts  []int64  // the offsets of the chunks from the chunk table
+	chunkTotals  []uint64 // the first point of each chunk of variable chunks
+	src          *fileSource
+	next         uint64 // the point decompressed next
+	chunk        int
+	left         uint64 // the points left in the chunk
+	chunkEnd     int64  // the end of a layered chunk
+	decoder      chunkDecoder
+}
+
+func newLazReader(r io.ReaderAt, info *laszipInfo, header HeaderFormat) (*lazReader, error) {
+	if err := info.validate(header.GetPointLength()); err != nil {
+		return nil, err
+	}
+	l := &lazReader{
+		r:            r,
+		info:         info,
+		pointsOffset: int64(header.GetPointsOffset()),
+		recordLength: int64(header.GetPointLength()),
+		numPoints:    header.GetNumberOfPoints(),
+	}
+	if info.compressor != lazPointwise {
+		if err := l.readChunkTable(); err != nil {
+			return nil, err
+		}
+	}
+	l.restart()
+	return l, nil
+}
+
+// readChunkTable reads the table of chunks, it is missing from files that
+// were not completely written
+func (l *lazReader) readChunkTable() error {
+	b := make([]byte, 8)
+	if _, err := l.r.ReadAt(b, l.pointsOffset); err != nil {
+		return err
+	}
+	tableOffset := int64(binary.LittleEndian.Uint64(b))
+	chunksStart := l.pointsOffset + 8
+	if tableOffset == -1 {
+		// written to a stream, the offset follows the table at the end
+		size, ok := readerSize(l.r)
+		if !ok {
+			return geotiff.UnsupportedError("LASzip chunk table at the end of a stream")
+		}
+		if _, err := l.r.ReadAt(b, size-8); err != nil {
+			return err
+		}
+		tableOffset = int64(binary.LittleEndian.Uint64(b))
+	}
+	variable := l.info.chunkSize == lazVariableChunks
+	if tableOffset <= chunksStart {
+		if variable && l.info.compressor == lazPointwiseChunked {
+			return geotiff.GeneralIssue("LASzip chunks of variable size without a chunk table")
+		}
+		return nil
+	}
+	src := newFileSource(l.r, tableOffset)
+	if version := src.uint32(); version != 0 {
+		return geotiff.UnsupportedError(fmt.Sprintf("LASzip chunk table version %d", version))
+	}
+	count := int(src.uint32())
+	if src.err != nil {
+		return src.err
+	}
+	starts := make([]int64, count+1)
+	var totals []uint64
+	if variable {
+		totals = make([]uint64, count+1)
+	}
+	if count > 0 {
+		d := newArithmeticDecoder(src)
+		ic := newIntegerDecoder(d, 32, 2)
+		var lastCount, lastSize int32
+		for i := 1; i <= count; i++ {
+			if variable {
+				lastCount = ic.decompress(lastCount, 0)
+				totals[i] = totals[i-1] + uint64(uint32(lastCount))
+			}
+			lastSize = ic.decompress(lastSize, 1)
+			starts[i] = int64(uint32(lastSize))
+		}
+	}
+	starts[0] = chunksStart
+	for i := 1; i <= count; i++ {
+		if starts[i] += starts[i-1]; starts[i] <= starts[i-1] {
+			return geotiff.GeneralIssue(fmt.Sprintf("LASzip chunk %d of %d bytes", i, starts[i]-starts[i-1]))
+		}
+	}
+	l.chunkStarts, l.chunkTotals = starts, totals
+	return nil
+}
+
+// readerSize returns the size of files
+func readerSize(r io.ReaderAt) (int64, bool) {
+	if f, ok := r.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil {
+			return fi.Size(), true
+		}
+	}
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		return s.Size(), true
+	}
+	return 0, false
+}
+
+// restart goes back to the first point
+func (l *lazReader) restart() {
+	l.next, l.chunk, l.left, l.decoder = 0, -1, 0, nil
+	start := l.pointsOffset
+	if l.info.compressor != lazPointwise {
+		start += 8
+	}
+	l.src = newFileSource(l.r, start)
+	l.chunkEnd = start
+}
+
+// seek positions the reader before point index
+func (l *lazReader) seek(index uint64) {
+	if index == l.next {
+		return
+	}
+	chunk := -1
+	switch {
+	case l.chunkStarts == nil || l.info.compressor == lazPointwise:
+	case l.chunkTotals != nil:
+		for chunk = 0; chunk+2 < len(l.chunkTotals) && l.chunkTotals[chunk+1] <= index; chunk++ {
+		}
+	default:
+		if chunk = int(index / uint64(l.info.chunkSize)); chunk >= len(l.chunkStarts)-1 {
+			chunk = -1
+		}
+	}
+	if chunk >= 0 {
+		l.chunk, l.left, l.decoder = chunk-1, 0, nil
+		l.next = uint64(chunk) * uint64(l.info.chunkSize)
+		if l.chunkTotals != nil {
+			l.next = l.chunkTotals[chunk]
+		}
+		l.chunkEnd = l.chunkStarts[chunk]
+		l.src.seek(l.chunkEnd)
+	} else if index < l.next {
+		l.restart()
+	}
+	record := make([]byte, l.recordLength)
+	for l.next < index && l.src.err == nil {
+		l.readRecord(record)
+	}
+}
+
+// startChunk reads the first point of the next chunk into record, it is
+// stored as is and the rest of the chunk follows
+func (l *lazReader) startChunk(record []byte) {
+	l.chunk++
+	switch {
+	case l.info.compressor == lazPointwise:
+		l.left = l.numPoints
+	case l.chunkTotals != nil && l.chunk+1 < len(l.chunkTotals):
+		l.left = l.chunkTotals[l.chunk+1] - l.chunkTotals[l.chunk]
+	default:
+		l.left = uint64(l.info.chunkSize)
+	}
+	if l.chunk < len(l.chunkStarts) {
+		l.src.seek(l.chunkStarts[l.chunk])
+	} else if l.info.compressor == lazLayeredChunked {
+		l.src.seek(l.chunkEnd)
+	}
+	l.src.read(record)
+
+	offsets := make([]int, len(l.info.items))
+	for i, at := 0, 0; i < len(l.info.items); i++ {
+		offsets[i] = at
+		at += int(l.info.items[i].size)
+	}
+	if l.info.compressor != lazLayeredChunked {
+		d := newArithmeticDecoder(l.src)
+		c := &pointwiseChunk{offsets: offsets}
+		for i, item := range l.info.items {
+			first := record[offsets[i] : offsets[i]+int(item.size)]
+			switch item.itemType {
+			case lazPoint10:
+				c.items = append(c.items, newPoint10Decoder(d, first))
+			case lazGpsTime11:
+				c.items = append(c.items, gpsTime11Decoder{newGpsTimeDecoder(d, int64(binary.LittleEndian.Uint64(first)), false)})
+			case lazRgb12:
+				c.items = append(c.items, &rgb12Decoder{d: d, last: getRgb(first), m: newRgbModels()})
+			case lazWavePacket13:
+				c.items = append(c.items, &wavePacket13Decoder{d: d, w: newWavePacketModels(d, first)})
+			case lazByte:
+				c.items = append(c.items, newBytesDecoder(d, first))
+			}
+		}
+		l.decoder = c
+		return
+	}
+
+	// the number of points, the sizes of the layers of each item and then
+	// the layers
+	l.left = uint64(l.src.uint32())
+	var sizes [][]uint32
+	for _, item := range l.info.items {
+		n := 1
+		switch item.itemType {
+		case lazPoint14:
+			n = point14Layers
+		case lazRgbNir14:
+			n = 2
+		case lazByte14:
+			n = int(item.size)
+		}
+		s := make([]uint32, n)
+		for i := range s {
+			s[i] = l.src.uint32()
+		}
+		sizes = append(sizes, s)
+	}
+	var layers [][][]byte
+	for _, s := range sizes {
+		var item [][]byte
+		for _, size := range s {
+			data := make([]byte, size)
+			l.src.read(data)
+			item = append(item, data)
+		}
+		layers = append(layers, item)
+	}
+	l.chunkEnd = l.src.offset()
+
+	c := &layeredChunk{}
+	context := 0
+	for i, item := range l.info.items {
+		first := record[offsets[i] : offsets[i]+int(item.size)]
+		switch item.itemType {
+		case lazPoint14:
+			c.point = newPoint14Decoder(layers[i], first)
+			context = c.point.current
+			continue
+		case lazRgb14, lazRgbNir14:
+			c.items = append(c.items, newRgb14Decoder(layers[i], first, context))
+		case lazWavePacket14:
+			c.items = append(c.items, newWavePacket14Decoder(layers[i][0], first, context))
+		case lazByte14:
+			c.items = append(c.items, newBytes14Decoder(layers[i], first, context))
+		}
+		c.offsets = append(c.offsets, offsets[i])
+	}
+	l.decoder = c
+}
+
+// readRecord decompresses the next point into record
+func (l *lazReader) readRecord(record []byte) {
+	if l.left == 0 {
+		l.startChunk(record)
+	} else {
+		l.decoder.read(record)
+	}
+	l.left--
+	l.next++
+}
+
+// ReadAt reads the uncompressed records of the points at off
+func (l *lazReader) ReadAt(p []byte, off int64) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	if off < l.pointsOffset || (off-l.pointsOffset)%l.recordLength != 0 || int64(len(p))%l.recordLength != 0 {
+		return 0, geotiff.GeneralIssue(fmt.Sprintf("LAZ read of %d bytes at %d is not of whole points", len(p), off))
+	}
+	index := uint64((off - l.pointsOffset) / l.recordLength)
+	if index >= l.numPoints {
+		return 0, io.EOF
+	}
+	l.seek(index)
+	n := 0
+	for ; n < len(p) && l.next < l.numPoints; n += int(l.recordLength) {
+		l.readRecord(p[n : n+int(l.recordLength)])
+		if l.src.err != nil {
+			err := l.src.err
+			l.restart()
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,1197 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The tests compress LAS files with a port of the LASzip encoder, the
+// counterpart of the decoder of arithmetic.go and lazitems.go.
+
+type arithmeticEncoder struct {
+	out    []byte
+	base   uint32
+	length uint32
+}
+
+func newArithmeticEncoder() *arithmeticEncoder {
+	return &arithmeticEncoder{length: acMaxLength}
+}
+
+func (e *arithmeticEncoder) carry() {
+	p := len(e.out) - 1
+	for e.out[p] == 0xFF {
+		e.out[p] = 0
+		p--
+	}
+	e.out[p]++
+}
+
+func (e *arithmeticEncoder) renorm() {
+	for {
+		e.out = append(e.out, byte(e.base>>24))
+		e.base <<= 8
+		if e.length <<= 8; e.length >= acMinLength {
+			return
+		}
+	}
+}
+
+func (e *arithmeticEncoder) encodeBit(m *bitModel, bit uint32) {
+	x := m.bit0Prob * (e.length >> bmLengthShift)
+	if bit == 0 {
+		e.length = x
+		m.bit0Count++
+	} else {
+		base := e.base
+		e.base += x
+		e.length -= x
+		if base > e.base {
+			e.carry()
+		}
+	}
+	if e.length < acMinLength {
+		e.renorm()
+	}
+	if m.untilUpdate--; m.untilUpdate == 0 {
+		m.update()
+	}
+}
+
+func (e *arithmeticEncoder) encodeSymbol(m *symbolModel, sym uint32) {
+	base := e.base
+	if sym == m.lastSymbol {
+		x := m.distribution[sym] * (e.length >> dmLengthShift)
+		e.base += x
+		e.length -= x
+	} else {
+		e.length >>= dmLengthShift
+		x := m.distribution[sym] * e.length
+		e.base += x
+		e.length = m.distribution[sym+1]*e.length - x
+	}
+	if base > e.base {
+		e.carry()
+	}
+	if e.length < acMinLength {
+		e.renorm()
+	}
+	m.symbolCount[sym]++
+	if m.untilUpdate--; m.untilUpdate == 0 {
+		m.update()
+	}
+}
+
+func (e *arithmeticEncoder) writeBits(bits, sym uint32) {
+	if bits > 19 {
+		e.writeBits(16, sym&0xFFFF)
+		sym >>= 16
+		bits -= 16
+	}
+	base := e.base
+	e.length >>= bits
+	e.base += sym * e.length
+	if base > e.base {
+		e.carry()
+	}
+	if e.length < acMinLength {
+		e.renorm()
+	}
+}
+
+func (e *arithmeticEncoder) writeInt(v uint32) {
+	e.writeBits(16, v&0xFFFF)
+	e.writeBits(16, v>>16)
+}
+
+// done flushes the encoder, with the bytes read ahead by the decoder
+func (e *arithmeticEncoder) done() []byte {
+	base := e.base
+	another := true
+	if e.length > 2*acMinLength {
+		e.base += acMinLength
+		e.length = acMinLength >> 1
+	} else {
+		e.base += acMinLength >> 1
+		e.length = acMinLength >> 9
+		another = false
+	}
+	if base > e.base {
+		e.carry()
+	}
+	e.renorm()
+	e.out = append(e.out, 0, 0)
+	if another {
+		e.out = append(e.out, 0)
+	}
+	return e.out
+}
+
+// integerEncoder compresses integers with the models of an integerDecoder
+type integerEncoder struct {
+	e *arithmeticEncoder
+	m *integerDecoder
+}
+
+func newIntegerEncoder(e *arithmeticEncoder, bits, contexts uint32) *integerEncoder {
+	return &integerEncoder{e: e, m: newIntegerDecoder(nil, bits, contexts)}
+}
+
+func (c *integerEncoder) compress(pred, real int32, context uint32) {
+	m := c.m
+	corr := real - pred
+	if m.corrRange != 0 {
+		if corr < m.corrMin {
+			corr += int32(m.corrRange)
+		} else if corr > m.corrMin+int32(m.corrRange)-1 {
+			corr -= int32(m.corrRange)
+		}
+	}
+	c1 := uint32(corr - 1)
+	if corr <= 0 {
+		c1 = uint32(-corr)
+	}
+	k := uint32(0)
+	for ; c1 != 0; c1 >>= 1 {
+		k++
+	}
+	m.k = k
+	c.e.encodeSymbol(m.mBits[context], k)
+	if k == 0 {
+		c.e.encodeBit(m.mCorrector0, uint32(corr))
+		return
+	}
+	if k >= 32 {
+		return
+	}
+	v := int64(corr) - 1
+	if corr < 0 {
+		v = int64(corr) + 1<<k - 1
+	}
+	if k <= m.bitsHigh {
+		c.e.encodeSymbol(m.mCorrector[k], uint32(v))
+		return
+	}
+	low := k - m.bitsHigh
+	c.e.encodeSymbol(m.mCorrector[k], uint32(v>>low))
+	c.e.writeBits(low, uint32(v)&(1<<low-1))
+}
+
+// itemEncoder compresses the items of the points after the first of a chunk
+type itemEncoder interface {
+	write(item []byte, context int)
+}
+
+type point10Encoder struct {
+	e                                             *arithmeticEncoder
+	p                                             *point10Decoder // the models and last values
+	icIntensity, icPointSourceID, icDx, icDy, icZ *integerEncoder
+}
+
+func newPoint10Encoder(e *arithmeticEncoder, first []byte) *point10Encoder {
+	p := newPoint10Decoder(nil, first)
+	i := func(m *integerDecoder) *integerEncoder { return &integerEncoder{e: e, m: m} }
+	return &point10Encoder{e: e, p: p, icIntensity: i(p.icIntensity), icPointSourceID: i(p.icPointSourceID), icDx: i(p.icDx), icDy: i(p.icDy), icZ: i(p.icZ)}
+}
+
+func (w *point10Encoder) write(item []byte, context int) {
+	p, le := w.p, binary.LittleEndian
+	last := p.last[:]
+	r, n := uint32(item[14]&7), uint32(item[14]>>3&7)
+	m, l := numberReturnMap[n][r], numberReturnLevel[n][r]
+	intensity := le.Uint16(item[12:14])
+	changed := b2u(last[14] != item[14])<<5 | b2u(p.lastIntensity[m] != intensity)<<4 | b2u(last[15] != item[15])<<3 |
+		b2u(last[16] != item[16])<<2 | b2u(last[17] != item[17])<<1 | b2u(le.Uint16(last[18:20]) != le.Uint16(item[18:20]))
+	w.e.encodeSymbol(p.mChangedValues, changed)
+	if changed&32 != 0 {
+		w.e.encodeSymbol(byteModel(p.mBitByte[:], last[14]), uint32(item[14]))
+	}
+	if changed&16 != 0 {
+		c := m
+		if c > 3 {
+			c = 3
+		}
+		w.icIntensity.compress(int32(p.lastIntensity[m]), int32(intensity), c)
+		p.lastIntensity[m] = intensity
+	}
+	if changed&8 != 0 {
+		w.e.encodeSymbol(byteModel(p.mClassification[:], last[15]), uint32(item[15]))
+	}
+	if changed&4 != 0 {
+		w.e.encodeSymbol(p.mScanAngleRank[item[14]>>6&1], uint32(item[16]-last[16]))
+	}
+	if changed&2 != 0 {
+		w.e.encodeSymbol(byteModel(p.mUserData[:], last[17]), uint32(item[17]))
+	}
+	if changed&1 != 0 {
+		w.icPointSourceID.compress(int32(le.Uint16(last[18:20])), int32(le.Uint16(item[18:20])), 0)
+	}
+	single := b2u(n == 1)
+	diff := int32(le.Uint32(item[0:4]) - le.Uint32(last[0:4]))
+	w.icDx.compress(p.lastXDiff[m].get(), diff, single)
+	p.lastXDiff[m].add(diff)
+	diff = int32(le.Uint32(item[4:8]) - le.Uint32(last[4:8]))
+	w.icDy.compress(p.lastYDiff[m].get(), diff, single+evenBits(w.icDx.m.k, 20))
+	p.lastYDiff[m].add(diff)
+	k := (w.icDx.m.k + w.icDy.m.k) / 2
+	z := int32(le.Uint32(item[8:12]))
+	w.icZ.compress(p.lastHeight[l], z, single+evenBits(k, 18))
+	p.lastHeight[l] = z
+	copy(last, item[:20])
+}
+
+// gpsTimeEncoder compresses times with the sequences of a gpsTimeDecoder
+type gpsTimeEncoder struct {
+	e  *arithmeticEncoder
+	g  *gpsTimeDecoder
+	ic *integerEncoder
+}
+
+func newGpsTimeEncoder(e *arithmeticEncoder, g *gpsTimeDecoder) *gpsTimeEncoder {
+	return &gpsTimeEncoder{e: e, g: g, ic: &integerEncoder{e: e, m: g.ic}}
+}
+
+func (w *gpsTimeEncoder) encode0Diff(sym uint32) {
+	if w.g.layered {
+		sym--
+	}
+	w.e.encodeSymbol(w.g.m0Diff, sym)
+}
+
+func (w *gpsTimeEncoder) encodeMulti(sym uint32) {
+	if w.g.layered && sym >= gpsMultiUnchanged {
+		sym--
+	}
+	w.e.encodeSymbol(w.g.mMulti, sym)
+}
+
+func diff32(t, last int64) (int32, bool) {
+	return int32(t - last), t-last == int64(int32(t-last))
+}
+
+// sequence returns the offset of another sequence within 32 bits of t
+func (w *gpsTimeEncoder) sequence(t int64) int {
+	for i := 1; i < 4; i++ {
+		if _, ok := diff32(t, w.g.lastTime[(w.g.last+i)&3]); ok {
+			return i
+		}
+	}
+	return 0
+}
+
+func (w *gpsTimeEncoder) full(t int64) {
+	g := w.g
+	w.ic.compress(int32(uint64(g.lastTime[g.last])>>32), int32(uint64(t)>>32), 8)
+	w.e.writeInt(uint32(t))
+	g.next = (g.next + 1) & 3
+	g.last = g.next
+	g.lastDiff[g.last], g.multiExtreme[g.last], g.lastTime[g.last] = 0, 0, t
+}
+
+func (w *gpsTimeEncoder) write(t int64) {
+	g := w.g
+	// layered chunks have no codes of unchanged times, they only follow a
+	// switch of sequence and are coded as differences of 0
+	unchanged := t == g.lastTime[g.last] && !g.layered
+	if g.lastDiff[g.last] == 0 {
+		if unchanged {
+			w.encode0Diff(0)
+			return
+		}
+		if d, ok := diff32(t, g.lastTime[g.last]); ok {
+			w.encode0Diff(1)
+			w.ic.compress(0, d, 0)
+			g.lastDiff[g.last], g.multiExtreme[g.last], g.lastTime[g.last] = d, 0, t
+		} else if i := w.sequence(t); i > 0 {
+			w.encode0Diff(2 + uint32(i))
+			g.last = (g.last + i) & 3
+			w.write(t)
+		} else {
+			w.encode0Diff(2)
+			w.full(t)
+		}
+		return
+	}
+	if unchanged {
+		w.encodeMulti(gpsMultiUnchanged)
+		return
+	}
+	d, ok := diff32(t, g.lastTime[g.last])
+	if !ok {
+		if i := w.sequence(t); i > 0 {
+			w.encodeMulti(gpsMultiCodeFull + uint32(i))
+			g.last = (g.last + i) & 3
+			w.write(t)
+		} else {
+			w.encodeMulti(gpsMultiCodeFull)
+			w.full(t)
+		}
+		return
+	}
+	last := g.lastDiff[g.last]
+	f := float32(d) / float32(last)
+	multi := int32(gpsMulti)
+	if f < gpsMulti && f > -gpsMulti {
+		multi = int32(f - 0.5)
+		if f >= 0 {
+			multi = int32(f + 0.5)
+		}
+	} else if f < 0 {
+		multi = gpsMultiMinus
+	}
+	switch {
+	case multi == 1:
+		w.encodeMulti(1)
+		w.ic.compress(last, d, 1)
+		g.multiExtreme[g.last] = 0
+	case multi > 1 && multi < gpsMulti:
+		context := uint32(2)
+		if multi >= 10 {
+			context = 3
+		}
+		w.encodeMulti(uint32(multi))
+		w.ic.compress(multi*last, d, context)
+	case multi >= gpsMulti:
+		w.encodeMulti(gpsMulti)
+		w.ic.compress(gpsMulti*last, d, 4)
+		g.extreme(d)
+	case multi < 0 && multi > gpsMultiMinus:
+		w.encodeMulti(uint32(gpsMulti - multi))
+		w.ic.compress(multi*last, d, 5)
+	case multi < 0:
+		w.encodeMulti(gpsMulti - gpsMultiMinus)
+		w.ic.compress(gpsMultiMinus*last, d, 6)
+		g.extreme(d)
+	default:
+		w.encodeMulti(0)
+		w.ic.compress(0, d, 7)
+		g.extreme(d)
+	}
+	g.lastTime[g.last] = t
+}
+
+type gpsTime11Encoder struct {
+	*gpsTimeEncoder
+}
+
+func (w gpsTime11Encoder) write(item []byte, context int) {
+	w.gpsTimeEncoder.write(int64(binary.LittleEndian.Uint64(item)))
+}
+
+// writeRgb compresses c following last
+func writeRgb(e *arithmeticEncoder, m *rgbModels, last, c [3]uint16) {
+	lo := func(v uint16) int32 { return int32(v & 0xFF) }
+	hi := func(v uint16) int32 { return int32(v >> 8) }
+	sym := b2u(lo(last[0]) != lo(c[0])) | b2u(hi(last[0]) != hi(c[0]))<<1 |
+		b2u(lo(last[1]) != lo(c[1]))<<2 | b2u(hi(last[1]) != hi(c[1]))<<3 |
+		b2u(lo(last[2]) != lo(c[2]))<<4 | b2u(hi(last[2]) != hi(c[2]))<<5 |
+		b2u(lo(c[0]) != lo(c[1]) || lo(c[0]) != lo(c[2]) || hi(c[0]) != hi(c[1]) || hi(c[0]) != hi(c[2]))<<6
+	e.encodeSymbol(m.byteUsed, sym)
+	fold := func(model *symbolModel, v int32) {
+		e.encodeSymbol(model, uint32(byte(v)))
+	}
+	diffLow := lo(c[0]) - lo(last[0])
+	if sym&1 != 0 {
+		fold(m.diff[0], diffLow)
+	}
+	diffHigh := hi(c[0]) - hi(last[0])
+	if sym&2 != 0 {
+		fold(m.diff[1], diffHigh)
+	}
+	if sym&64 == 0 {
+		return
+	}
+	if sym&4 != 0 {
+		fold(m.diff[2], lo(c[1])-u8Clamp(diffLow+lo(last[1])))
+	}
+	if sym&16 != 0 {
+		diffLow = (diffLow + lo(c[1]) - lo(last[1])) / 2
+		fold(m.diff[4], lo(c[2])-u8Clamp(diffLow+lo(last[2])))
+	}
+	if sym&8 != 0 {
+		fold(m.diff[3], hi(c[1])-u8Clamp(diffHigh+hi(last[1])))
+	}
+	if sym&32 != 0 {
+		diffHigh = (diffHigh + hi(c[1]) - hi(last[1])) / 2
+		fold(m.diff[5], hi(c[2])-u8Clamp(diffHigh+hi(last[2])))
+	}
+}
+
+type rgb12Encoder struct {
+	e    *arithmeticEncoder
+	last [3]uint16
+	m    *rgbModels
+}
+
+func (w *rgb12Encoder) write(item []byte, context int) {
+	c := getRgb(item)
+	writeRgb(w.e, w.m, w.last, c)
+	w.last = c
+}
+
+type bytesEncoder struct {
+	e *arithmeticEncoder
+	b *bytesDecoder
+}
+
+func (w *bytesEncoder) write(item []byte, context int) {
+	for i := range w.b.last {
+		w.e.encodeSymbol(w.b.m[i], uint32(item[i]-w.b.last[i]))
+		w.b.last[i] = item[i]
+	}
+}
+
+// layeredEncoder compresses the items of formats 6 to 10 in layers, a layer
+// is left out when it does not change in the chunk
+type layeredEncoder interface {
+	itemEncoder
+	layers() [][]byte
+}
+
+func layerBytes(e *arithmeticEncoder, changed bool) []byte {
+	if !changed {
+		return nil
+	}
+	return e.done()
+}
+
+type point14Encoder struct {
+	d        *point14Decoder // makes the contexts
+	e        [point14Layers]*arithmeticEncoder
+	changed  [point14Layers]bool
+	contexts [4]*point14Context
+	current  int
+}
+
+func newPoint14Encoder(first []byte) *point14Encoder {
+	p := &point14Encoder{d: newPoint14Decoder(make([][]byte, point14Layers), first)}
+	for i := range p.e {
+		p.e[i] = newArithmeticEncoder()
+	}
+	p.current = p.d.current
+	p.contexts[p.current] = p.d.contexts[p.current]
+	p.changed[lChannelReturnsXY] = true
+	return p
+}
+
+// context returns the scanner channel of the last point
+func (p *point14Encoder) context() int {
+	return p.current
+}
+
+func (p *point14Encoder) write(b []byte, context int) {
+	item := unpackPoint14(b)
+	c := p.contexts[p.current]
+	last := &c.last
+	lpr := b2u(last.returnNumber == 1) + 2*b2u(last.returnNumber >= last.numberOfReturns)
+	if last.gpsTimeChange {
+		lpr += 4
+	}
+	channel := item.scannerChannel
+	if channel != p.current && p.contexts[channel] != nil {
+		last = &p.contexts[channel].last
+	}
+	pointSourceChange := item.pointSourceID != last.pointSourceID
+	gpsTimeChange := item.gpsTime != last.gpsTime
+	scanAngleChange := item.scanAngle != last.scanAngle
+	lastN, lastR := last.numberOfReturns, last.returnNumber
+	n, r := item.numberOfReturns, item.returnNumber
+	changed := b2u(channel != p.current)<<6 | b2u(pointSourceChange)<<5 | b2u(gpsTimeChange)<<4 | b2u(scanAngleChange)<<3 | b2u(n != lastN)<<2
+	switch r {
+	case lastR:
+	case (lastR + 1) % 16:
+		changed |= 1
+	case (lastR + 15) % 16:
+		changed |= 2
+	default:
+		changed |= 3
+	}
+	xy := p.e[lChannelReturnsXY]
+	xy.encodeSymbol(c.mChangedValues[lpr], changed)
+	if changed&(1<<6) != 0 {
+		xy.encodeSymbol(c.mScannerChannel, uint32((channel-p.current+4)%4-1))
+		if p.contexts[channel] == nil {
+			p.contexts[channel] = p.d.newContext(c.last)
+		}
+		p.current = channel
+		c = p.contexts[channel]
+		last = &c.last
+	}
+	if changed&(1<<2) != 0 {
+		if c.mNumberOfReturns[lastN] == nil {
+			c.mNumberOfReturns[lastN] = newSymbolModel(16)
+		}
+		xy.encodeSymbol(c.mNumberOfReturns[lastN], n)
+	}
+	if changed&3 == 3 {
+		if gpsTimeChange {
+			if c.mReturnNumber[lastR] == nil {
+				c.mReturnNumber[lastR] = newSymbolModel(16)
+			}
+			xy.encodeSymbol(c.mReturnNumber[lastR], r)
+		} else {
+			xy.encodeSymbol(c.mReturnNumberGpsSame, (r+30-lastR)%16)
+		}
+	}
+
+	m, l := numberReturnMap6[n][r], numberReturnLevel8(n, r)
+	cpr := 2*b2u(r == 1) + b2u(r >= n)
+	single := b2u(n == 1)
+	median := m<<1 | b2u(gpsTimeChange)
+	dx, dy := &integerEncoder{e: xy, m: c.icDx}, &integerEncoder{e: xy, m: c.icDy}
+	diff := item.x - last.x
+	dx.compress(c.lastXDiff[median].get(), diff, single)
+	c.lastXDiff[median].add(diff)
+	diff = item.y - last.y
+	dy.compress(c.lastYDiff[median].get(), diff, single+evenBits(c.icDx.k, 20))
+	c.lastYDiff[median].add(diff)
+
+	k := (c.icDx.k + c.icDy.k) / 2
+	(&integerEncoder{e: p.e[lZ], m: c.icZ}).compress(c.lastZ[l], item.z, single+evenBits(k, 18))
+	c.lastZ[l] = item.z
+	p.changed[lZ] = p.changed[lZ] || item.z != last.z
+
+	ccc := (last.classification&0x1F)<<1 + b2u(cpr == 3)
+	p.e[lClassification].encodeSymbol(byteModel(c.mClassification[:], byte(ccc)), item.classification)
+	p.changed[lClassification] = p.changed[lClassification] || item.classification != last.classification
+
+	lastFlags := last.edge<<5 | last.scanDirection<<4 | last.flags
+	flags := item.edge<<5 | item.scanDirection<<4 | item.flags
+	if c.mFlags[lastFlags] == nil {
+		c.mFlags[lastFlags] = newSymbolModel(64)
+	}
+	p.e[lFlags].encodeSymbol(c.mFlags[lastFlags], flags)
+	p.changed[lFlags] = p.changed[lFlags] || flags != lastFlags
+
+	i := cpr<<1 | b2u(gpsTimeChange)
+	(&integerEncoder{e: p.e[lIntensity], m: c.icIntensity}).compress(int32(c.lastIntensity[i]), int32(item.intensity), cpr)
+	p.changed[lIntensity] = p.changed[lIntensity] || item.intensity != c.lastIntensity[i]
+	c.lastIntensity[i] = item.intensity
+
+	if scanAngleChange {
+		(&integerEncoder{e: p.e[lScanAngle], m: c.icScanAngle}).compress(int32(last.scanAngle), int32(item.scanAngle), b2u(gpsTimeChange))
+		p.changed[lScanAngle] = true
+	}
+	p.e[lUserData].encodeSymbol(byteModel(c.mUserData[:], byte(last.userData/4)), item.userData)
+	p.changed[lUserData] = p.changed[lUserData] || item.userData != last.userData
+	if pointSourceChange {
+		(&integerEncoder{e: p.e[lPointSource], m: c.icPointSourceID}).compress(int32(last.pointSourceID), int32(item.pointSourceID), 0)
+		p.changed[lPointSource] = true
+	}
+	if gpsTimeChange {
+		newGpsTimeEncoder(p.e[lGpsTime], c.gps).write(item.gpsTime)
+		p.changed[lGpsTime] = true
+	}
+	item.gpsTimeChange = gpsTimeChange
+	c.last = item
+}
+
+func (p *point14Encoder) layers() [][]byte {
+	var layers [][]byte
+	for i, e := range p.e {
+		layers = append(layers, layerBytes(e, p.changed[i]))
+	}
+	return layers
+}
+
+type rgb14Encoder struct {
+	r                   *rgb14Decoder // the contexts
+	rgb, nir            *arithmeticEncoder
+	changedRgb, changed bool
+}
+
+func newRgb14Encoder(first []byte, context int) *rgb14Encoder {
+	layers := [][]byte{nil}
+	if len(first) == 8 {
+		layers = append(layers, nil)
+	}
+	return &rgb14Encoder{r: newRgb14Decoder(layers, first, context), rgb: newArithmeticEncoder(), nir: newArithmeticEncoder()}
+}
+
+func (w *rgb14Encoder) write(item []byte, context int) {
+	r := w.r
+	c := r.contexts[r.current]
+	if context != r.current {
+		if r.contexts[context] == nil {
+			first := make([]byte, 8)
+			putRgb(first, c.last)
+			binary.LittleEndian.PutUint16(first[6:8], c.lastNir)
+			r.contexts[context] = newRgb14Context(first)
+		}
+		r.current = context
+		c = r.contexts[context]
+	}
+	rgb := getRgb(item)
+	writeRgb(w.rgb, c.m, c.last, rgb)
+	w.changedRgb = w.changedRgb || rgb != c.last
+	c.last = rgb
+	if r.nir == nil {
+		return
+	}
+	nir := binary.LittleEndian.Uint16(item[6:8])
+	sym := b2u(nir&0xFF != c.lastNir&0xFF) | b2u(nir>>8 != c.lastNir>>8)<<1
+	w.nir.encodeSymbol(c.mNirUsed, sym)
+	if sym&1 != 0 {
+		w.nir.encodeSymbol(c.mNirDiff[0], uint32(byte(nir)-byte(c.lastNir)))
+	}
+	if sym&2 != 0 {
+		w.nir.encodeSymbol(c.mNirDiff[1], uint32(byte(nir>>8)-byte(c.lastNir>>8)))
+	}
+	w.changed = w.changed || sym != 0
+	c.lastNir = nir
+}
+
+func (w *rgb14Encoder) layers() [][]byte {
+	layers := [][]byte{layerBytes(w.rgb, w.changedRgb)}
+	if w.r.nir != nil {
+		layers = append(layers, layerBytes(w.nir, w.changed))
+	}
+	return layers
+}
+
+type bytes14Encoder struct {
+	b       *bytes14Decoder // the contexts
+	e       []*arithmeticEncoder
+	changed []bool
+}
+
+func newBytes14Encoder(first []byte, context int) *bytes14Encoder {
+	w := &bytes14Encoder{b: newBytes14Decoder(make([][]byte, len(first)), first, context), changed: make([]bool, len(first))}
+	for range first {
+		w.e = append(w.e, newArithmeticEncoder())
+	}
+	return w
+}
+
+func (w *bytes14Encoder) write(item []byte, context int) {
+	b := w.b
+	c := b.contexts[b.current]
+	if context != b.current {
+		if b.contexts[context] == nil {
+			b.contexts[context] = newBytes14Context(c.last)
+		}
+		b.current = context
+		c = b.contexts[context]
+	}
+	for i, e := range w.e {
+		e.encodeSymbol(c.m[i], uint32(item[i]-c.last[i]))
+		w.changed[i] = w.changed[i] || item[i] != c.last[i]
+		c.last[i] = item[i]
+	}
+}
+
+func (w *bytes14Encoder) layers() [][]byte {
+	var layers [][]byte
+	for i, e := range w.e {
+		layers = append(layers, layerBytes(e, w.changed[i]))
+	}
+	return layers
+}
+
+// compressChunk compresses records of items into a chunk: the first record
+// as is and the others pointwise or in layers
+func compressChunk(records []byte, items []laszipItem) []byte {
+	length := 0
+	for _, item := range items {
+		length += int(item.size)
+	}
+	chunk := append([]byte(nil), records[:length]...)
+	// item returns item i of the record at
+	item := func(i, at int) []byte {
+		for _, item := range items[:i] {
+			at += int(item.size)
+		}
+		return records[at : at+int(items[i].size)]
+	}
+	first := func(i int) []byte { return item(i, 0) }
+
+	if items[0].itemType != lazPoint14 {
+		e := newArithmeticEncoder()
+		var encoders []itemEncoder
+		for i, item := range items {
+			switch item.itemType {
+			case lazPoint10:
+				encoders = append(encoders, newPoint10Encoder(e, first(i)))
+			case lazGpsTime11:
+				g := newGpsTimeDecoder(nil, int64(binary.LittleEndian.Uint64(first(i))), false)
+				encoders = append(encoders, gpsTime11Encoder{newGpsTimeEncoder(e, g)})
+			case lazRgb12:
+				encoders = append(encoders, &rgb12Encoder{e: e, last: getRgb(first(i)), m: newRgbModels()})
+			case lazByte:
+				encoders = append(encoders, &bytesEncoder{e: e, b: newBytesDecoder(nil, first(i))})
+			}
+		}
+		for at := length; at < len(records); at += length {
+			for i, w := range encoders {
+				w.write(item(i, at), 0)
+			}
+		}
+		return append(chunk, e.done()...)
+	}
+
+	point := newPoint14Encoder(first(0))
+	encoders := []layeredEncoder{point}
+	for i, item := range items[1:] {
+		switch item.itemType {
+		case lazRgb14, lazRgbNir14:
+			encoders = append(encoders, newRgb14Encoder(first(i+1), point.context()))
+		case lazByte14:
+			encoders = append(encoders, newBytes14Encoder(first(i+1), point.context()))
+		}
+	}
+	for at := length; at < len(records); at += length {
+		point.write(item(0, at), 0)
+		for i, w := range encoders[1:] {
+			w.write(item(i+1, at), point.context())
+		}
+	}
+	le := binary.LittleEndian
+	chunk = le.AppendUint32(chunk, uint32(len(records)/length))
+	var layers [][]byte
+	for _, w := range encoders {
+		for _, layer := range w.layers() {
+			chunk = le.AppendUint32(chunk, uint32(len(layer)))
+			layers = append(layers, layer)
+		}
+	}
+	for _, layer := range layers {
+		chunk = append(chunk, layer...)
+	}
+	return chunk
+}
+
+// chunkTable is how compressLas writes the offset of the chunk table
+type chunkTable int
+
+const (
+	tableAtStart chunkTable = iota // the offset is before the chunks
+	tableAtEnd                     // the offset is -1 and follows the table
+)
+
+// compressLas compresses a LAS file without EVLRs into chunks of the given
+// numbers of points, which are all chunkSize but the last or of variable
+// sizes when chunkSize is lazVariableChunks
+func compressLas(las []byte, items []laszipItem, chunkSize uint32, chunks []int, table chunkTable) []byte {
+	le := binary.LittleEndian
+	offset := le.Uint32(las[96:100])
+	recordLength := int(le.Uint16(las[105:107]))
+	records := las[offset:]
+
+	compressor := uint16(lazPointwiseChunked)
+	if items[0].itemType == lazPoint14 {
+		compressor = lazLayeredChunked
+	}
+	vlr := make([]byte, 34+6*len(items))
+	le.PutUint16(vlr[0:2], compressor)
+	vlr[4], vlr[5] = 3, 4
+	le.PutUint32(vlr[12:16], chunkSize)
+	le.PutUint64(vlr[16:24], math.MaxUint64)
+	le.PutUint64(vlr[24:32], math.MaxUint64)
+	le.PutUint16(vlr[32:34], uint16(len(items)))
+	for i, item := range items {
+		le.PutUint16(vlr[34+6*i:], item.itemType)
+		le.PutUint16(vlr[36+6*i:], item.size)
+		le.PutUint16(vlr[38+6*i:], item.version)
+	}
+	var laz bytes.Buffer
+	header := append([]byte(nil), las[:offset]...)
+	le.PutUint32(header[96:100], offset+54+uint32(len(vlr)))
+	le.PutUint32(header[100:104], le.Uint32(header[100:104])+1)
+	header[104] |= 0x80
+	laz.Write(header)
+	writeRecordHeader(&laz, laszipSignature, 22204, uint64(len(vlr)), "by laszip of LAStools", false)
+	laz.Write(vlr)
+
+	tableOffset := laz.Len()
+	laz.Write(make([]byte, 8))
+	var sizes []int
+	for _, n := range chunks {
+		start := laz.Len()
+		laz.Write(compressChunk(records[:n*recordLength], items))
+		records = records[n*recordLength:]
+		sizes = append(sizes, laz.Len()-start)
+	}
+	out := laz.Bytes()
+	if table == tableAtStart {
+		le.PutUint64(out[tableOffset:], uint64(len(out)))
+	} else {
+		le.PutUint64(out[tableOffset:], math.MaxUint64)
+	}
+	start := len(out)
+	out = le.AppendUint32(out, 0)
+	out = le.AppendUint32(out, uint32(len(chunks)))
+	e := newArithmeticEncoder()
+	ic := newIntegerEncoder(e, 32, 2)
+	var lastCount, lastSize int32
+	for i, n := range chunks {
+		if chunkSize == lazVariableChunks {
+			ic.compress(lastCount, int32(n), 0)
+			lastCount = int32(n)
+		}
+		ic.compress(lastSize, int32(sizes[i]), 1)
+		lastSize = int32(sizes[i])
+	}
+	out = append(out, e.done()...)
+	if table == tableAtEnd {
+		out = le.AppendUint64(out, uint64(start))
+	}
+	return out
+}
+
+func TestArithmeticCoder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	e := newArithmeticEncoder()
+	symbols := []*symbolModel{newSymbolModel(5), newSymbolModel(256), newSymbolModel(516)}
+	bits := newBitModel()
+	ic16, ic32 := newIntegerEncoder(e, 16, 4), newIntegerEncoder(e, 32, 2)
+	type op struct {
+		kind, arg int
+		value     int64
+	}
+	var ops []op
+	for n := 0; n < 100000; n++ {
+		o := op{kind: rng.Intn(5)}
+		switch o.kind {
+		case 0:
+			o.arg = n % 3
+			o.value = int64(rng.Intn(int(symbols[o.arg].symbols)))
+			if rng.Intn(3) > 0 {
+				o.value = int64(o.arg) // skewed
+			}
+			e.encodeSymbol(symbols[o.arg], uint32(o.value))
+		case 1:
+			o.value = int64(b2u(rng.Intn(5) == 0))
+			e.encodeBit(bits, uint32(o.value))
+		case 2:
+			o.arg = rng.Intn(32) + 1
+			o.value = int64(rng.Uint32() & uint32(1<<uint(o.arg)-1))
+			e.writeBits(uint32(o.arg), uint32(o.value))
+		case 3:
+			o.arg = n % 4
+			o.value = int64(int16(rng.NormFloat64() * 300))
+			ic16.compress(100, int32(o.value), uint32(o.arg))
+		case 4:
+			o.arg = n % 2
+			o.value = int64(int32(rng.Uint32()))
+			if rng.Intn(2) == 0 {
+				o.value = int64(rng.NormFloat64() * 1000)
+			}
+			ic32.compress(-5, int32(o.value), uint32(o.arg))
+		}
+		ops = append(ops, o)
+	}
+
+	d := newArithmeticDecoder(&layerSource{data: e.done()})
+	symbols = []*symbolModel{newSymbolModel(5), newSymbolModel(256), newSymbolModel(516)}
+	bits = newBitModel()
+	d16, d32 := newIntegerDecoder(d, 16, 4), newIntegerDecoder(d, 32, 2)
+	for i, o := range ops {
+		var v int64
+		switch o.kind {
+		case 0:
+			v = int64(d.decodeSymbol(symbols[o.arg]))
+		case 1:
+			v = int64(d.decodeBit(bits))
+		case 2:
+			v = int64(d.readBits(uint32(o.arg)))
+		case 3:
+			v = int64(int16(d16.decompress(100, uint32(o.arg))))
+		case 4:
+			v = int64(d32.decompress(-5, uint32(o.arg)))
+		}
+		if v != o.value {
+			t.Fatalf("operation %d of kind %d: %d, expected %d", i, o.kind, v, o.value)
+		}
+	}
+}
+
+// lazPoints returns points exercising the compressed fields: pulses of up to
+// 4 returns, jumps of the GPS time to other sequences, gray and colored
+// points and changes of scanner channel
+func lazPoints(n int, channels bool) []*Point {
+	rng := rand.New(rand.NewSource(int64(n)))
+	var points []*Point
+	x, y, z, gps := 1000.0, 2000.0, 100.0, 3e5
+	times := []float64{gps, 4e8, 7}
+	for len(points) < n {
+		returns := byte(rng.Intn(4) + 1)
+		switch rng.Intn(50) {
+		case 0:
+			times[0] = gps
+			gps = times[rng.Intn(len(times))]
+		case 1:
+			gps += 1000
+		}
+		gps += 0.00001 * float64(rng.Intn(3)+1)
+		channel := byte(0)
+		if channels {
+			channel = byte(len(points)/37%4+rng.Intn(2)) % 4
+		}
+		for r := byte(1); r <= returns && len(points) < n; r++ {
+			x += rng.Float64()
+			y += rng.NormFloat64() * 0.2
+			z += rng.NormFloat64() * 3
+			p := &Point{X: x, Y: y, Z: z, Intensity: uint16(rng.Intn(400)), ReturnNumber: r, NumberOfReturns: returns,
+				Classification: byte(rng.Intn(3) + 1), ScanAngle: float32(rng.Intn(7) - 3), UserData: 4,
+				PointSourceID: uint16(len(points) / 900), GpsTime: gps, ScannerChannel: channel,
+				ScanDirectionFlag: byte(len(points) / 100 % 2), EdgeOfFlightLine: byte(b2u(rng.Intn(20) == 0)),
+				ClassificationFlags: byte(rng.Intn(2)), NIR: uint16(rng.Intn(3000)), ExtraBytes: []byte{byte(len(points)), 7, byte(r)}}
+			if rng.Intn(3) > 0 {
+				p.Red, p.Green, p.Blue = uint16(rng.Intn(65536)), uint16(rng.Intn(65536)), uint16(rng.Intn(65536))
+			} else {
+				p.Red = uint16(rng.Intn(8)) * 257
+				p.Green, p.Blue = p.Red, p.Red
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// lazFiles writes points to a LAS file and to LAZ files of the chunks
+func lazFiles(t *testing.T, format byte, points []*Point, items []laszipItem, chunkSize uint32, chunks []int, table chunkTable) (string, string) {
+	las := writeLas(t, &WriterOptions{PointFormat: format, ExtraBytes: 3}, points)
+	raw, err := ioutil.ReadFile(las)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laz := filepath.Join(t.TempDir(), "points.laz")
+	if err := ioutil.WriteFile(laz, compressLas(raw, items, chunkSize, chunks, table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return las, laz
+}
+
+func openLaz(t *testing.T, name string) (*decoder, *lazReader) {
+	d := openLas(t, name, nil).(*decoder)
+	l, ok := d.points.(*lazReader)
+	if !ok {
+		t.Fatalf("%s is not read as LAZ", name)
+	}
+	return d, l
+}
+
+// checkLaz compares the records of the LAZ file to the LAS file read in order,
+// from the start of chunks and at random
+func checkLaz(t *testing.T, name, las, laz string, chunks []int) {
+	raw, err := ioutil.ReadFile(las)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	offset := int64(le.Uint32(raw[96:100]))
+	recordLength := int64(le.Uint16(raw[105:107]))
+	records := raw[offset:]
+	numPoints := int64(len(records)) / recordLength
+
+	d, l := openLaz(t, laz)
+	if d.header.GetNumberOfPoints() != uint64(numPoints) {
+		t.Fatalf("%s: %d points, expected %d", name, d.header.GetNumberOfPoints(), numPoints)
+	}
+	if d.header.GetPointFormat() != raw[104] {
+		t.Errorf("%s: point format %d, expected %d", name, d.header.GetPointFormat(), raw[104])
+	}
+	pointsOffset := int64(d.header.GetPointsOffset())
+	check := func(what string, first, n int64) {
+		b := make([]byte, n*recordLength)
+		if _, err := l.ReadAt(b, pointsOffset+first*recordLength); err != nil {
+			t.Fatalf("%s: %s read of %d points at %d: %v", name, what, n, first, err)
+		}
+		for i := int64(0); i < n; i++ {
+			got, expected := b[i*recordLength:(i+1)*recordLength], records[(first+i)*recordLength:(first+i+1)*recordLength]
+			if !bytes.Equal(got, expected) {
+				t.Fatalf("%s: %s read, point %d is %v, expected %v", name, what, first+i, got, expected)
+			}
+		}
+	}
+
+	// in order, across chunks
+	for first := int64(0); first < numPoints; first += 777 {
+		n := numPoints - first
+		if n > 777 {
+			n = 777
+		}
+		check("sequential", first, n)
+	}
+
+	// from the start of each chunk, backwards so that each read seeks
+	var starts []int64
+	var start int64
+	for _, n := range chunks {
+		starts = append(starts, start)
+		start += int64(n)
+	}
+	for i := len(starts) - 1; i >= 0; i-- {
+		check("chunk", starts[i], 1)
+		if l.chunk != i {
+			t.Errorf("%s: read of chunk %d from chunk %d", name, i, l.chunk)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 20; i++ {
+		check("random", rng.Int63n(numPoints), 1)
+	}
+	if _, err := l.ReadAt(make([]byte, recordLength), pointsOffset+numPoints*recordLength); err == nil {
+		t.Errorf("%s: read after the last point", name)
+	}
+}
+
+// checkBuild compares the rasters built from the LAS and LAZ files
+func checkBuild(t *testing.T, name, las, laz string) {
+	expected, err := openLas(t, las, nil).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := openLas(t, laz, nil).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Width() != expected.Width() || r.Height() != expected.Height() {
+		t.Fatalf("%s: %d x %d raster, expected %d x %d", name, r.Width(), r.Height(), expected.Width(), expected.Height())
+	}
+	cells := 0
+	for row := 0; row < r.Height(); row++ {
+		for col := 0; col < r.Width(); col++ {
+			if r.ValueAt(row, col) != expected.ValueAt(row, col) {
+				t.Fatalf("%s: cell %d, %d is %v, expected %v", name, row, col, r.ValueAt(row, col), expected.ValueAt(row, col))
+			}
+			if r.ValueAt(row, col) != -9999 {
+				cells++
+			}
+		}
+	}
+	if cells == 0 {
+		t.Errorf("%s: no points in the raster", name)
+	}
+}
+
+func TestLazPointwise(t *testing.T) {
+	items := []laszipItem{{lazPoint10, 20, 2}, {lazGpsTime11, 8, 2}, {lazRgb12, 6, 2}, {lazByte, 3, 2}}
+	points := lazPoints(4500, false)
+	tests := []struct {
+		name      string
+		chunkSize uint32
+		chunks    []int
+		table     chunkTable
+	}{
+		{"fixed chunks", 1000, []int{1000, 1000, 1000, 1000, 500}, tableAtStart},
+		{"variable chunks", lazVariableChunks, []int{700, 1300, 1, 1999, 500}, tableAtStart},
+		{"table at the end", 2000, []int{2000, 2000, 500}, tableAtEnd},
+	}
+	for _, test := range tests {
+		las, laz := lazFiles(t, 3, points, items, test.chunkSize, test.chunks, test.table)
+		checkLaz(t, test.name, las, laz, test.chunks)
+		if test.table == tableAtStart {
+			checkBuild(t, test.name, las, laz)
+		}
+	}
+}
+
+func TestLazLayered(t *testing.T) {
+	items := []laszipItem{{lazPoint14, 30, 3}, {lazRgbNir14, 8, 3}, {lazByte14, 3, 3}}
+	points := lazPoints(4500, true)
+	tests := []struct {
+		name      string
+		chunkSize uint32
+		chunks    []int
+	}{
+		{"fixed chunks", 1000, []int{1000, 1000, 1000, 1000, 500}},
+		{"variable chunks", lazVariableChunks, []int{700, 1300, 1, 1999, 500}},
+	}
+	for _, test := range tests {
+		las, laz := lazFiles(t, 8, points, items, test.chunkSize, test.chunks, tableAtStart)
+		checkLaz(t, test.name, las, laz, test.chunks)
+		checkBuild(t, test.name, las, laz)
+	}
+}
+
+var laszipFixtures = flag.Bool("laszip-fixtures", false, "rewrite the LAS files of testdata/laszip")
+
+// TestLazReference compares the LAZ files compressed by LASzip in
+// testdata/laszip to the LAS files they were compressed from, see
+// testdata/laszip/README
+func TestLazReference(t *testing.T) {
+	for _, format := range []byte{1, 3, 6, 7, 8} {
+		las := filepath.Join("testdata", "laszip", fmt.Sprintf("format%d.las", format))
+		laz := las[:len(las)-len(".las")] + ".laz"
+		if *laszipFixtures {
+			vlr := NewExtraBytesVlr([]*ExtraBytesDescriptor{
+				{Name: "index", DataType: ExtraBytesUint8},
+				{Name: "constant", DataType: ExtraBytesUint8},
+				{Name: "return", DataType: ExtraBytesUint8},
+			})
+			name := writeLas(t, &WriterOptions{PointFormat: format, ExtraBytes: 3, Vlrs: []*Vlr{vlr}}, lazPoints(1500, format >= 6))
+			raw, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(las, raw, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		t.Run(fmt.Sprintf("format %d", format), func(t *testing.T) {
+			if _, err := os.Stat(laz); os.IsNotExist(err) {
+				t.Skipf("%s is missing, see testdata/laszip/README", laz)
+			}
+			if _, ok := openLas(t, laz, nil).(*decoder).points.(*lazReader); !ok {
+				t.Fatalf("%s is not read as LAZ", laz)
+			}
+			expected := openLas(t, las, nil).Points(context.Background())
+			it := openLas(t, laz, nil).Points(context.Background())
+			n := 0
+			for ; expected.Next(); n++ {
+				if !it.Next() {
+					t.Fatalf("%d points, expected more: %v", n, it.Err())
+				}
+				if !equalPoints(*it.Point(), *expected.Point()) {
+					t.Fatalf("point %d is %+v, expected %+v", n, *it.Point(), *expected.Point())
+				}
+			}
+			if it.Next() || it.Err() != nil || expected.Err() != nil || n == 0 {
+				t.Errorf("%d points: %v, %v", n, it.Err(), expected.Err())
+			}
+			checkBuild(t, laz, las, laz)
+		})
+	}
+}
+
+func TestLaszipValidate(t *testing.T) {
+	f, err := os.Open(writeLas(t, &WriterOptions{PointFormat: 1}, []*Point{{}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d, err := NewFileReader(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := d.(*decoder).header
+	tests := []struct {
+		name string
+		info laszipInfo
+	}{
+		{"short items", laszipInfo{compressor: lazPointwiseChunked, items: []laszipItem{{lazPoint10, 20, 2}}}},
+		{"item version", laszipInfo{compressor: lazPointwiseChunked, items: []laszipItem{{lazPoint10, 20, 1}, {lazGpsTime11, 8, 2}}}},
+		{"layered items", laszipInfo{compressor: lazPointwiseChunked, items: []laszipItem{{lazPoint14, 30, 3}}}},
+		{"coder", laszipInfo{compressor: lazPointwiseChunked, coder: 1, items: []laszipItem{{lazPoint10, 20, 2}, {lazGpsTime11, 8, 2}}}},
+	}
+	for _, test := range tests {
+		if _, err := newLazReader(f, &test.info, header); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,953 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"encoding/binary"
+)
+
+// The LASzip item decoders turn the compressed items of a point back into
+// their raw bytes. Pointwise chunks interleave the items of formats 0 to 5 in
+// one stream, layered chunks of formats 6 to 10 keep each attribute in a layer
+// of its own.
+
+// itemDecoder decompresses the next item of a chunk, context is the scanner
+// channel of the point of layered chunks
+type itemDecoder interface {
+	read(item []byte, context int)
+}
+
+// numberReturnMap and numberReturnLevel are the contexts of return r of n
+// of formats 0 to 5
+var numberReturnMap = [8][8]uint32{
+	{15, 14, 13, 12, 11, 10, 9, 8},
+	{14, 0, 1, 3, 6, 10, 10, 9},
+	{13, 1, 2, 4, 7, 11, 11, 10},
+	{12, 3, 4, 5, 8, 12, 12, 11},
+	{11, 6, 7, 8, 9, 13, 13, 12},
+	{10, 10, 11, 12, 13, 14, 14, 13},
+	{9, 10, 11, 12, 13, 14, 15, 14},
+	{8, 9, 10, 11, 12, 13, 14, 15},
+}
+
+var numberReturnLevel = [8][8]uint32{
+	{0, 1, 2, 3, 4, 5, 6, 7},
+	{1, 0, 1, 2, 3, 4, 5, 6},
+	{2, 1, 0, 1, 2, 3, 4, 5},
+	{3, 2, 1, 0, 1, 2, 3, 4},
+	{4, 3, 2, 1, 0, 1, 2, 3},
+	{5, 4, 3, 2, 1, 0, 1, 2},
+	{6, 5, 4, 3, 2, 1, 0, 1},
+	{7, 6, 5, 4, 3, 2, 1, 0},
+}
+
+// numberReturnMap6 and numberReturnLevel8 are the contexts of return r of n
+// of formats 6 to 10
+var numberReturnMap6 = [16][16]uint32{
+	{0, 1, 2, 3, 4, 5, 3, 4, 4, 5, 5, 5, 5, 5, 5, 5},
+	{1, 0, 1, 3, 4, 5, 3, 4, 4, 5, 5, 5, 5, 5, 5, 5},
+	{2, 1, 2, 4, 5, 3, 4, 4, 5, 5, 5, 5, 5, 5, 5, 5},
+	{3, 3, 4, 5, 4, 5, 3, 4, 4, 5, 5, 5, 5, 5, 5, 5},
+	{4, 4, 5, 4, 5, 5, 5, 4, 5, 5, 5, 5, 5, 5, 5, 5},
+	{5, 5, 3, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{3, 3, 4, 3, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{4, 4, 4, 4, 4, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{4, 4, 5, 4, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+}
+
+func numberReturnLevel8(n, r uint32) uint32 {
+	l := int(n) - int(r)
+	if l < 0 {
+		l = -l
+	}
+	if l > 7 {
+		return 7
+	}
+	return uint32(l)
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// evenBits clears the lowest bit of the bit count k of a correction, capped
+// at max, for the contexts of the following coordinates
+func evenBits(k, max uint32) uint32 {
+	if k < max {
+		return k &^ 1
+	}
+	return max
+}
+
+func u8Clamp(v int32) int32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// streamingMedian5 is the median of the last five values
+type streamingMedian5 struct {
+	values [5]int32
+	high   bool
+}
+
+func newStreamingMedians(n int) []streamingMedian5 {
+	m := make([]streamingMedian5, n)
+	for i := range m {
+		m[i].high = true
+	}
+	return m
+}
+
+func (m *streamingMedian5) add(v int32) {
+	s := &m.values
+	if m.high {
+		if v < s[2] {
+			s[4], s[3] = s[3], s[2]
+			if v < s[0] {
+				s[2], s[1], s[0] = s[1], s[0], v
+			} else if v < s[1] {
+				s[2], s[1] = s[1], v
+			} else {
+				s[2] = v
+			}
+		} else {
+			if v < s[3] {
+				s[4], s[3] = s[3], v
+			} else {
+				s[4] = v
+			}
+			m.high = false
+		}
+		return
+	}
+	if s[2] < v {
+		s[0], s[1] = s[1], s[2]
+		if s[4] < v {
+			s[2], s[3], s[4] = s[3], s[4], v
+		} else if s[3] < v {
+			s[2], s[3] = s[3], v
+		} else {
+			s[2] = v
+		}
+	} else {
+		if s[1] < v {
+			s[0], s[1] = s[1], v
+		} else {
+			s[0] = v
+		}
+		m.high = true
+	}
+}
+
+func (m *streamingMedian5) get() int32 {
+	return m.values[2]
+}
+
+// point10Decoder decompresses the 20 bytes shared by formats 0 to 5
+type point10Decoder struct {
+	d               *arithmeticDecoder
+	last            [20]byte
+	lastIntensity   [16]uint16
+	lastXDiff       []streamingMedian5
+	lastYDiff       []streamingMedian5
+	lastHeight      [8]int32
+	mChangedValues  *symbolModel
+	mScanAngleRank  [2]*symbolModel
+	mBitByte        [256]*symbolModel
+	mClassification [256]*symbolModel
+	mUserData       [256]*symbolModel
+	icIntensity     *integerDecoder
+	icPointSourceID *integerDecoder
+	icDx            *integerDecoder
+	icDy            *integerDecoder
+	icZ             *integerDecoder
+}
+
+func newPoint10Decoder(d *arithmeticDecoder, first []byte) *point10Decoder {
+	p := &point10Decoder{
+		d:               d,
+		lastXDiff:       newStreamingMedians(16),
+		lastYDiff:       newStreamingMedians(16),
+		mChangedValues:  newSymbolModel(64),
+		mScanAngleRank:  [2]*symbolModel{newSymbolModel(256), newSymbolModel(256)},
+		icIntensity:     newIntegerDecoder(d, 16, 4),
+		icPointSourceID: newIntegerDecoder(d, 16, 1),
+		icDx:            newIntegerDecoder(d, 32, 2),
+		icDy:            newIntegerDecoder(d, 32, 22),
+		icZ:             newIntegerDecoder(d, 32, 20),
+	}
+	copy(p.last[:], first)
+	return p
+}
+
+// byteModel returns the model of a byte following last, made on first use
+func byteModel(models []*symbolModel, last byte) *symbolModel {
+	if models[last] == nil {
+		models[last] = newSymbolModel(256)
+	}
+	return models[last]
+}
+
+func (p *point10Decoder) read(item []byte, context int) {
+	last := p.last[:]
+	le := binary.LittleEndian
+	changed := p.d.decodeSymbol(p.mChangedValues)
+	if changed&32 != 0 {
+		last[14] = byte(p.d.decodeSymbol(byteModel(p.mBitByte[:], last[14])))
+	}
+	r, n := uint32(last[14]&7), uint32(last[14]>>3&7)
+	m, l := numberReturnMap[n][r], numberReturnLevel[n][r]
+	if changed&16 != 0 {
+		c := m
+		if c > 3 {
+			c = 3
+		}
+		p.lastIntensity[m] = uint16(p.icIntensity.decompress(int32(p.lastIntensity[m]), c))
+	}
+	le.PutUint16(last[12:14], p.lastIntensity[m])
+	if changed&8 != 0 {
+		last[15] = byte(p.d.decodeSymbol(byteModel(p.mClassification[:], last[15])))
+	}
+	if changed&4 != 0 {
+		v := p.d.decodeSymbol(p.mScanAngleRank[last[14]>>6&1])
+		last[16] = byte(v + uint32(last[16]))
+	}
+	if changed&2 != 0 {
+		last[17] = byte(p.d.decodeSymbol(byteModel(p.mUserData[:], last[17])))
+	}
+	if changed&1 != 0 {
+		le.PutUint16(last[18:20], uint16(p.icPointSourceID.decompress(int32(le.Uint16(last[18:20])), 0)))
+	}
+
+	single := b2u(n == 1)
+	diff := p.icDx.decompress(p.lastXDiff[m].get(), single)
+	le.PutUint32(last[0:4], uint32(int32(le.Uint32(last[0:4]))+diff))
+	p.lastXDiff[m].add(diff)
+
+	diff = p.icDy.decompress(p.lastYDiff[m].get(), single+evenBits(p.icDx.k, 20))
+	le.PutUint32(last[4:8], uint32(int32(le.Uint32(last[4:8]))+diff))
+	p.lastYDiff[m].add(diff)
+
+	k := (p.icDx.k + p.icDy.k) / 2
+	p.lastHeight[l] = p.icZ.decompress(p.lastHeight[l], single+evenBits(k, 18))
+	le.PutUint32(last[8:12], uint32(p.lastHeight[l]))
+	copy(item, last)
+}
+
+// the multipliers of the GPS time differences, the codes of layered chunks
+// above gpsMultiUnchanged are one lower as they have no code for it
+const (
+	gpsMulti          = 500
+	gpsMultiMinus     = -10
+	gpsMultiUnchanged = gpsMulti - gpsMultiMinus + 1
+	gpsMultiCodeFull  = gpsMulti - gpsMultiMinus + 2
+	gpsMultiTotal     = gpsMulti - gpsMultiMinus + 6
+)
+
+// gpsTimeDecoder decompresses GPS times as the integers of their bits, as up
+// to four interleaved sequences of times at a regular step
+type gpsTimeDecoder struct {
+	d            *arithmeticDecoder
+	layered      bool
+	last, next   int
+	lastTime     [4]int64
+	lastDiff     [4]int32
+	multiExtreme [4]int32
+	mMulti       *symbolModel
+	m0Diff       *symbolModel
+	ic           *integerDecoder
+}
+
+// newGpsTimeDecoder decompresses the times following first, layered chunks
+// leave out the codes of unchanged times
+func newGpsTimeDecoder(d *arithmeticDecoder, first int64, layered bool) *gpsTimeDecoder {
+	g := &gpsTimeDecoder{d: d, layered: layered, ic: newIntegerDecoder(d, 32, 9)}
+	if layered {
+		g.m0Diff = newSymbolModel(5)
+		g.mMulti = newSymbolModel(gpsMultiTotal - 1)
+	} else {
+		g.m0Diff = newSymbolModel(6)
+		g.mMulti = newSymbolModel(gpsMultiTotal)
+	}
+	g.lastTime[0] = first
+	return g
+}
+
+// full starts a new sequence at a time that is not within 32 bits of the last
+func (g *gpsTimeDecoder) full() {
+	g.next = (g.next + 1) & 3
+	high := g.ic.decompress(int32(uint64(g.lastTime[g.last])>>32), 8)
+	g.lastTime[g.next] = int64(uint64(uint32(high))<<32 | uint64(g.d.readInt()))
+	g.last = g.next
+	g.lastDiff[g.last] = 0
+	g.multiExtreme[g.last] = 0
+}
+
+// extreme keeps a difference far from the multiples of the last after it is
+// seen a few times
+func (g *gpsTimeDecoder) extreme(diff int32) {
+	if g.multiExtreme[g.last]++; g.multiExtreme[g.last] > 3 {
+		g.lastDiff[g.last] = diff
+		g.multiExtreme[g.last] = 0
+	}
+}
+
+func (g *gpsTimeDecoder) read() int64 {
+	for {
+		if g.lastDiff[g.last] == 0 {
+			multi := int32(g.d.decodeSymbol(g.m0Diff))
+			if g.layered {
+				multi++
+			}
+			switch {
+			case multi == 1:
+				g.lastDiff[g.last] = g.ic.decompress(0, 0)
+				g.lastTime[g.last] += int64(g.lastDiff[g.last])
+				g.multiExtreme[g.last] = 0
+			case multi == 2:
+				g.full()
+			case multi > 2:
+				g.last = (g.last + int(multi) - 2) & 3
+				continue
+			}
+			return g.lastTime[g.last]
+		}
+		multi := int32(g.d.decodeSymbol(g.mMulti))
+		if g.layered && multi >= gpsMultiUnchanged {
+			multi++
+		}
+		last := g.lastDiff[g.last]
+		switch {
+		case multi == 1:
+			g.lastTime[g.last] += int64(g.ic.decompress(last, 1))
+			g.multiExtreme[g.last] = 0
+		case multi < gpsMultiUnchanged:
+			var diff int32
+			switch {
+			case multi == 0:
+				diff = g.ic.decompress(0, 7)
+				g.extreme(diff)
+			case multi < gpsMulti:
+				context := uint32(2)
+				if multi >= 10 {
+					context = 3
+				}
+				diff = g.ic.decompress(multi*last, context)
+			case multi == gpsMulti:
+				diff = g.ic.decompress(gpsMulti*last, 4)
+				g.extreme(diff)
+			default:
+				if multi = gpsMulti - multi; multi > gpsMultiMinus {
+					diff = g.ic.decompress(multi*last, 5)
+				} else {
+					diff = g.ic.decompress(gpsMultiMinus*last, 6)
+					g.extreme(diff)
+				}
+			}
+			g.lastTime[g.last] += int64(diff)
+		case multi == gpsMultiCodeFull:
+			g.full()
+		case multi > gpsMultiCodeFull:
+			g.last = (g.last + int(multi) - gpsMultiCodeFull) & 3
+			continue
+		}
+		return g.lastTime[g.last]
+	}
+}
+
+type gpsTime11Decoder struct {
+	*gpsTimeDecoder
+}
+
+func (g gpsTime11Decoder) read(item []byte, context int) {
+	binary.LittleEndian.PutUint64(item, uint64(g.gpsTimeDecoder.read()))
+}
+
+// rgbModels decompress colors by the changes of their bytes, the green and blue
+// bytes are predicted by the change of red
+type rgbModels struct {
+	byteUsed *symbolModel
+	diff     [6]*symbolModel
+}
+
+func newRgbModels() *rgbModels {
+	m := &rgbModels{byteUsed: newSymbolModel(128)}
+	for i := range m.diff {
+		m.diff[i] = newSymbolModel(256)
+	}
+	return m
+}
+
+func (m *rgbModels) read(d *arithmeticDecoder, last [3]uint16) [3]uint16 {
+	var c [3]uint16
+	fold := func(model *symbolModel, v int32) uint16 {
+		return uint16(byte(int32(d.decodeSymbol(model)) + v))
+	}
+	sym := d.decodeSymbol(m.byteUsed)
+	if sym&1 != 0 {
+		c[0] = fold(m.diff[0], int32(last[0]&0xFF))
+	} else {
+		c[0] = last[0] & 0xFF
+	}
+	if sym&2 != 0 {
+		c[0] |= fold(m.diff[1], int32(last[0]>>8)) << 8
+	} else {
+		c[0] |= last[0] & 0xFF00
+	}
+	if sym&64 == 0 {
+		c[1], c[2] = c[0], c[0]
+		return c
+	}
+	diff := int32(c[0]&0xFF) - int32(last[0]&0xFF)
+	if sym&4 != 0 {
+		c[1] = fold(m.diff[2], u8Clamp(diff+int32(last[1]&0xFF)))
+	} else {
+		c[1] = last[1] & 0xFF
+	}
+	if sym&16 != 0 {
+		diff = (diff + int32(c[1]&0xFF) - int32(last[1]&0xFF)) / 2
+		c[2] = fold(m.diff[4], u8Clamp(diff+int32(last[2]&0xFF)))
+	} else {
+		c[2] = last[2] & 0xFF
+	}
+	diff = int32(c[0]>>8) - int32(last[0]>>8)
+	if sym&8 != 0 {
+		c[1] |= fold(m.diff[3], u8Clamp(diff+int32(last[1]>>8))) << 8
+	} else {
+		c[1] |= last[1] & 0xFF00
+	}
+	if sym&32 != 0 {
+		diff = (diff + int32(c[1]>>8) - int32(last[1]>>8)) / 2
+		c[2] |= fold(m.diff[5], u8Clamp(diff+int32(last[2]>>8))) << 8
+	} else {
+		c[2] |= last[2] & 0xFF00
+	}
+	return c
+}
+
+func getRgb(b []byte) [3]uint16 {
+	le := binary.LittleEndian
+	return [3]uint16{le.Uint16(b[0:2]), le.Uint16(b[2:4]), le.Uint16(b[4:6])}
+}
+
+func putRgb(b []byte, c [3]uint16) {
+	for i, v := range c {
+		binary.LittleEndian.PutUint16(b[2*i:], v)
+	}
+}
+
+type rgb12Decoder struct {
+	d    *arithmeticDecoder
+	last [3]uint16
+	m    *rgbModels
+}
+
+func (r *rgb12Decoder) read(item []byte, context int) {
+	r.last = r.m.read(r.d, r.last)
+	putRgb(item, r.last)
+}
+
+// bytesDecoder decompresses extra bytes by their difference to the last
+type bytesDecoder struct {
+	d    *arithmeticDecoder
+	last []byte
+	m    []*symbolModel
+}
+
+func newBytesDecoder(d *arithmeticDecoder, first []byte) *bytesDecoder {
+	b := &bytesDecoder{d: d, last: append([]byte(nil), first...), m: make([]*symbolModel, len(first))}
+	for i := range b.m {
+		b.m[i] = newSymbolModel(256)
+	}
+	return b
+}
+
+func (b *bytesDecoder) read(item []byte, context int) {
+	for i := range b.last {
+		b.last[i] += byte(b.d.decodeSymbol(b.m[i]))
+	}
+	copy(item, b.last)
+}
+
+// wavePacketModels decompress the 29 bytes of a wave packet: descriptor
+// index, offset, size, return point location and the x, y and z of the
+// direction
+type wavePacketModels struct {
+	last          [29]byte
+	lastDiff32    int32
+	symOffsetDiff uint32
+	mPacketIndex  *symbolModel
+	mOffsetDiff   [4]*symbolModel
+	icOffsetDiff  *integerDecoder
+	icPacketSize  *integerDecoder
+	icReturnPoint *integerDecoder
+	icXYZ         *integerDecoder
+}
+
+func newWavePacketModels(d *arithmeticDecoder, first []byte) *wavePacketModels {
+	w := &wavePacketModels{
+		mPacketIndex:  newSymbolModel(256),
+		icOffsetDiff:  newIntegerDecoder(d, 32, 1),
+		icPacketSize:  newIntegerDecoder(d, 32, 1),
+		icReturnPoint: newIntegerDecoder(d, 32, 1),
+		icXYZ:         newIntegerDecoder(d, 32, 3),
+	}
+	for i := range w.mOffsetDiff {
+		w.mOffsetDiff[i] = newSymbolModel(4)
+	}
+	copy(w.last[:], first)
+	return w
+}
+
+func (w *wavePacketModels) read(d *arithmeticDecoder, item []byte) {
+	le := binary.LittleEndian
+	last := w.last[:]
+	item[0] = byte(d.decodeSymbol(w.mPacketIndex))
+	offset, size := le.Uint64(last[1:9]), le.Uint32(last[9:13])
+	w.symOffsetDiff = d.decodeSymbol(w.mOffsetDiff[w.symOffsetDiff])
+	switch w.symOffsetDiff {
+	case 0:
+	case 1:
+		offset += uint64(size)
+	case 2:
+		w.lastDiff32 = w.icOffsetDiff.decompress(w.lastDiff32, 0)
+		offset += uint64(int64(w.lastDiff32))
+	default:
+		offset = d.readInt64()
+	}
+	le.PutUint64(item[1:9], offset)
+	le.PutUint32(item[9:13], uint32(w.icPacketSize.decompress(int32(size), 0)))
+	le.PutUint32(item[13:17], uint32(w.icReturnPoint.decompress(int32(le.Uint32(last[13:17])), 0)))
+	for i := 0; i < 3; i++ {
+		at := 17 + 4*i
+		le.PutUint32(item[at:at+4], uint32(w.icXYZ.decompress(int32(le.Uint32(last[at:at+4])), uint32(i))))
+	}
+	copy(w.last[:], item[:29])
+}
+
+type wavePacket13Decoder struct {
+	d *arithmeticDecoder
+	w *wavePacketModels
+}
+
+func (w *wavePacket13Decoder) read(item []byte, context int) {
+	w.w.read(w.d, item)
+}
+
+// point14 is the unpacked core of the points of formats 6 to 10
+type point14 struct {
+	x, y, z         int32
+	intensity       uint16
+	returnNumber    uint32
+	numberOfReturns uint32
+	flags           uint32 // classification flags
+	scannerChannel  int
+	scanDirection   uint32
+	edge            uint32
+	classification  uint32
+	userData        uint32
+	scanAngle       int16
+	pointSourceID   uint16
+	gpsTime         int64 // the bits of the time
+	gpsTimeChange   bool
+}
+
+func unpackPoint14(b []byte) point14 {
+	le := binary.LittleEndian
+	return point14{
+		x:               int32(le.Uint32(b[0:4])),
+		y:               int32(le.Uint32(b[4:8])),
+		z:               int32(le.Uint32(b[8:12])),
+		intensity:       le.Uint16(b[12:14]),
+		returnNumber:    uint32(b[14] & 0x0F),
+		numberOfReturns: uint32(b[14] >> 4),
+		flags:           uint32(b[15] & 0x0F),
+		scannerChannel:  int(b[15] >> 4 & 3),
+		scanDirection:   uint32(b[15] >> 6 & 1),
+		edge:            uint32(b[15] >> 7),
+		classification:  uint32(b[16]),
+		userData:        uint32(b[17]),
+		scanAngle:       int16(le.Uint16(b[18:20])),
+		pointSourceID:   le.Uint16(b[20:22]),
+		gpsTime:         int64(le.Uint64(b[22:30])),
+	}
+}
+
+func (p *point14) pack(b []byte) {
+	le := binary.LittleEndian
+	le.PutUint32(b[0:4], uint32(p.x))
+	le.PutUint32(b[4:8], uint32(p.y))
+	le.PutUint32(b[8:12], uint32(p.z))
+	le.PutUint16(b[12:14], p.intensity)
+	b[14] = byte(p.returnNumber | p.numberOfReturns<<4)
+	b[15] = byte(p.flags | uint32(p.scannerChannel)<<4 | p.scanDirection<<6 | p.edge<<7)
+	b[16] = byte(p.classification)
+	b[17] = byte(p.userData)
+	le.PutUint16(b[18:20], uint16(p.scanAngle))
+	le.PutUint16(b[20:22], p.pointSourceID)
+	le.PutUint64(b[22:30], uint64(p.gpsTime))
+}
+
+// layer is the decoder of one layer of a layered chunk, changed is false when
+// the attribute is the same for all points of the chunk
+type layer struct {
+	d       *arithmeticDecoder
+	changed bool
+}
+
+func newLayer(data []byte) layer {
+	return layer{d: newArithmeticDecoder(&layerSource{data: data}), changed: len(data) > 0}
+}
+
+// point14Context holds the models of the points of a scanner channel
+type point14Context struct {
+	last                 point14
+	lastIntensity        [8]uint16
+	lastXDiff            []streamingMedian5
+	lastYDiff            []streamingMedian5
+	lastZ                [8]int32
+	mChangedValues       [8]*symbolModel
+	mScannerChannel      *symbolModel
+	mNumberOfReturns     [16]*symbolModel
+	mReturnNumber        [16]*symbolModel
+	mReturnNumberGpsSame *symbolModel
+	mClassification      [64]*symbolModel
+	mFlags               [64]*symbolModel
+	mUserData            [64]*symbolModel
+	icDx                 *integerDecoder
+	icDy                 *integerDecoder
+	icZ                  *integerDecoder
+	icIntensity          *integerDecoder
+	icScanAngle          *integerDecoder
+	icPointSourceID      *integerDecoder
+	gps                  *gpsTimeDecoder
+}
+
+// the layers of the point14 item
+const (
+	lChannelReturnsXY = iota
+	lZ
+	lClassification
+	lFlags
+	lIntensity
+	lScanAngle
+	lUserData
+	lPointSource
+	lGpsTime
+	point14Layers
+)
+
+// point14Decoder decompresses the 30 bytes shared by formats 6 to 10, it sets
+// the scanner channel as the context of the other items
+type point14Decoder struct {
+	layers   [point14Layers]layer
+	contexts [4]*point14Context
+	current  int
+}
+
+func newPoint14Decoder(layers [][]byte, first []byte) *point14Decoder {
+	p := &point14Decoder{}
+	for i, data := range layers {
+		p.layers[i] = newLayer(data)
+	}
+	item := unpackPoint14(first)
+	p.current = item.scannerChannel
+	p.contexts[p.current] = p.newContext(item)
+	return p
+}
+
+func (p *point14Decoder) newContext(item point14) *point14Context {
+	xy, z := p.layers[lChannelReturnsXY].d, p.layers[lZ].d
+	c := &point14Context{
+		last:                 item,
+		lastXDiff:            newStreamingMedians(12),
+		lastYDiff:            newStreamingMedians(12),
+		mScannerChannel:      newSymbolModel(3),
+		mReturnNumberGpsSame: newSymbolModel(13),
+		icDx:                 newIntegerDecoder(xy, 32, 2),
+		icDy:                 newIntegerDecoder(xy, 32, 22),
+		icZ:                  newIntegerDecoder(z, 32, 20),
+		icIntensity:          newIntegerDecoder(p.layers[lIntensity].d, 16, 4),
+		icScanAngle:          newIntegerDecoder(p.layers[lScanAngle].d, 16, 2),
+		icPointSourceID:      newIntegerDecoder(p.layers[lPointSource].d, 16, 1),
+		gps:                  newGpsTimeDecoder(p.layers[lGpsTime].d, item.gpsTime, true),
+	}
+	c.last.gpsTimeChange = false
+	for i := range c.mChangedValues {
+		c.mChangedValues[i] = newSymbolModel(128)
+	}
+	for i := range c.lastZ {
+		c.lastZ[i] = item.z
+		c.lastIntensity[i] = item.intensity
+	}
+	return c
+}
+
+func (p *point14Decoder) read(item []byte) int {
+	xy := p.layers[lChannelReturnsXY].d
+	c := p.contexts[p.current]
+	last := &c.last
+
+	// the context of the last return: first 1, last 2, and whether its time
+	// changed 4
+	lpr := b2u(last.returnNumber == 1) + 2*b2u(last.returnNumber >= last.numberOfReturns)
+	if last.gpsTimeChange {
+		lpr += 4
+	}
+	changed := xy.decodeSymbol(c.mChangedValues[lpr])
+	if changed&(1<<6) != 0 {
+		channel := (p.current + int(xy.decodeSymbol(c.mScannerChannel)) + 1) % 4
+		if p.contexts[channel] == nil {
+			p.contexts[channel] = p.newContext(*last)
+		}
+		p.current = channel
+		c = p.contexts[channel]
+		last = &c.last
+		last.scannerChannel = channel
+	}
+	pointSourceChange := changed&(1<<5) != 0
+	gpsTimeChange := changed&(1<<4) != 0
+	scanAngleChange := changed&(1<<3) != 0
+
+	lastN, lastR := last.numberOfReturns, last.returnNumber
+	n := lastN
+	if changed&(1<<2) != 0 {
+		if c.mNumberOfReturns[lastN] == nil {
+			c.mNumberOfReturns[lastN] = newSymbolModel(16)
+		}
+		n = xy.decodeSymbol(c.mNumberOfReturns[lastN])
+		last.numberOfReturns = n
+	}
+	r := lastR
+	switch changed & 3 {
+	case 1:
+		r = (lastR + 1) % 16
+	case 2:
+		r = (lastR + 15) % 16
+	case 3:
+		if gpsTimeChange {
+			if c.mReturnNumber[lastR] == nil {
+				c.mReturnNumber[lastR] = newSymbolModel(16)
+			}
+			r = xy.decodeSymbol(c.mReturnNumber[lastR])
+		} else {
+			r = (lastR + xy.decodeSymbol(c.mReturnNumberGpsSame) + 2) % 16
+		}
+	}
+	last.returnNumber = r
+
+	m, l := numberReturnMap6[n][r], numberReturnLevel8(n, r)
+	// the context of this return: first 2, last 1
+	cpr := 2*b2u(r == 1) + b2u(r >= n)
+	single := b2u(n == 1)
+	median := m<<1 | b2u(gpsTimeChange)
+
+	diff := c.icDx.decompress(c.lastXDiff[median].get(), single)
+	last.x += diff
+	c.lastXDiff[median].add(diff)
+	diff = c.icDy.decompress(c.lastYDiff[median].get(), single+evenBits(c.icDx.k, 20))
+	last.y += diff
+	c.lastYDiff[median].add(diff)
+
+	if p.layers[lZ].changed {
+		k := (c.icDx.k + c.icDy.k) / 2
+		last.z = c.icZ.decompress(c.lastZ[l], single+evenBits(k, 18))
+		c.lastZ[l] = last.z
+	}
+	if d := p.layers[lClassification]; d.changed {
+		ccc := (last.classification&0x1F)<<1 + b2u(cpr == 3)
+		last.classification = d.d.decodeSymbol(byteModel(c.mClassification[:], byte(ccc)))
+	}
+	if d := p.layers[lFlags]; d.changed {
+		flags := last.edge<<5 | last.scanDirection<<4 | last.flags
+		if c.mFlags[flags] == nil {
+			c.mFlags[flags] = newSymbolModel(64)
+		}
+		flags = d.d.decodeSymbol(c.mFlags[flags])
+		last.edge, last.scanDirection, last.flags = flags>>5&1, flags>>4&1, flags&0x0F
+	}
+	if p.layers[lIntensity].changed {
+		i := cpr<<1 | b2u(gpsTimeChange)
+		c.lastIntensity[i] = uint16(c.icIntensity.decompress(int32(c.lastIntensity[i]), cpr))
+		last.intensity = c.lastIntensity[i]
+	}
+	if p.layers[lScanAngle].changed && scanAngleChange {
+		last.scanAngle = int16(c.icScanAngle.decompress(int32(last.scanAngle), b2u(gpsTimeChange)))
+	}
+	if d := p.layers[lUserData]; d.changed {
+		last.userData = d.d.decodeSymbol(byteModel(c.mUserData[:], byte(last.userData/4)))
+	}
+	if p.layers[lPointSource].changed && pointSourceChange {
+		last.pointSourceID = uint16(c.icPointSourceID.decompress(int32(last.pointSourceID), 0))
+	}
+	if p.layers[lGpsTime].changed && gpsTimeChange {
+		last.gpsTime = c.gps.read()
+	}
+	last.pack(item)
+	last.gpsTimeChange = gpsTimeChange
+	return p.current
+}
+
+// rgb14Decoder decompresses the colors of formats 7, 8 and 10 and the near
+// infrared of 8 and 10 by scanner channel
+type rgb14Decoder struct {
+	rgb      layer
+	nir      *layer
+	contexts [4]*rgb14Context
+	current  int
+}
+
+type rgb14Context struct {
+	last     [3]uint16
+	lastNir  uint16
+	m        *rgbModels
+	mNirUsed *symbolModel
+	mNirDiff [2]*symbolModel
+}
+
+func newRgb14Decoder(layers [][]byte, first []byte, context int) *rgb14Decoder {
+	r := &rgb14Decoder{rgb: newLayer(layers[0]), current: context}
+	if len(layers) > 1 {
+		nir := newLayer(layers[1])
+		r.nir = &nir
+	}
+	r.contexts[context] = newRgb14Context(first)
+	return r
+}
+
+func newRgb14Context(first []byte) *rgb14Context {
+	c := &rgb14Context{last: getRgb(first), m: newRgbModels(), mNirUsed: newSymbolModel(4)}
+	if len(first) >= 8 {
+		c.lastNir = binary.LittleEndian.Uint16(first[6:8])
+	}
+	c.mNirDiff = [2]*symbolModel{newSymbolModel(256), newSymbolModel(256)}
+	return c
+}
+
+func (r *rgb14Decoder) read(item []byte, context int) {
+	c := r.contexts[r.current]
+	if context != r.current {
+		if r.contexts[context] == nil {
+			first := make([]byte, 8)
+			putRgb(first, c.last)
+			binary.LittleEndian.PutUint16(first[6:8], c.lastNir)
+			r.contexts[context] = newRgb14Context(first)
+		}
+		r.current = context
+		c = r.contexts[context]
+	}
+	if r.rgb.changed {
+		c.last = c.m.read(r.rgb.d, c.last)
+	}
+	putRgb(item, c.last)
+	if r.nir == nil {
+		return
+	}
+	if r.nir.changed {
+		d := r.nir.d
+		sym := d.decodeSymbol(c.mNirUsed)
+		low, high := c.lastNir&0xFF, c.lastNir&0xFF00
+		if sym&1 != 0 {
+			low = uint16(byte(d.decodeSymbol(c.mNirDiff[0]) + uint32(low)))
+		}
+		if sym&2 != 0 {
+			high = uint16(byte(d.decodeSymbol(c.mNirDiff[1])+uint32(high>>8))) << 8
+		}
+		c.lastNir = high | low
+	}
+	binary.LittleEndian.PutUint16(item[6:8], c.lastNir)
+}
+
+// wavePacket14Decoder decompresses the wave packets of formats 9 and 10 by
+// scanner channel
+type wavePacket14Decoder struct {
+	layer    layer
+	contexts [4]*wavePacketModels
+	current  int
+}
+
+func newWavePacket14Decoder(data []byte, first []byte, context int) *wavePacket14Decoder {
+	w := &wavePacket14Decoder{layer: newLayer(data), current: context}
+	w.contexts[context] = newWavePacketModels(w.layer.d, first)
+	return w
+}
+
+func (w *wavePacket14Decoder) read(item []byte, context int) {
+	c := w.contexts[w.current]
+	if context != w.current {
+		if w.contexts[context] == nil {
+			w.contexts[context] = newWavePacketModels(w.layer.d, c.last[:])
+		}
+		w.current = context
+		c = w.contexts[context]
+	}
+	if w.layer.changed {
+		c.read(w.layer.d, item)
+		return
+	}
+	copy(item, c.last[:])
+}
+
+// bytes14Decoder decompresses extra bytes in a layer for each byte by scanner
+// channel
+type bytes14Decoder struct {
+	layers   []layer
+	contexts [4]*bytes14Context
+	current  int
+}
+
+type bytes14Context struct {
+	last []byte
+	m    []*symbolModel
+}
+
+func newBytes14Decoder(layers [][]byte, first []byte, context int) *bytes14Decoder {
+	b := &bytes14Decoder{current: context}
+	for _, data := range layers {
+		b.layers = append(b.layers, newLayer(data))
+	}
+	b.contexts[context] = newBytes14Context(first)
+	return b
+}
+
+func newBytes14Context(first []byte) *bytes14Context {
+	c := &bytes14Context{last: append([]byte(nil), first...), m: make([]*symbolModel, len(first))}
+	for i := range c.m {
+		c.m[i] = newSymbolModel(256)
+	}
+	return c
+}
+
+func (b *bytes14Decoder) read(item []byte, context int) {
+	c := b.contexts[b.current]
+	if context != b.current {
+		if b.contexts[context] == nil {
+			b.contexts[context] = newBytes14Context(c.last)
+		}
+		b.current = context
+		c = b.contexts[context]
+	}
+	for i, l := range b.layers {
+		if l.changed {
+			c.last[i] += byte(l.d.decodeSymbol(c.m[i]))
+		}
+	}
+	copy(item, c.last)
+}
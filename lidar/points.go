@@ -6,7 +6,10 @@ package lidar
 
 import (
 	"encoding/binary"
+	"fmt"
 	"math"
+
+	"github.com/geodatalake/lambdas/geotiff"
 )
 
 type PointFormat interface {
@@ -18,6 +21,109 @@ type PointFormat interface {
 	GetReturnNumber() uint8
 	GetTotalReturns() uint8
 	GetIntensity() uint16
+	// GetClassificationFlags returns the synthetic (1), key-point (2),
+	// withheld (4) and, for formats 6 to 10, overlap (8) bits
+	GetClassificationFlags() uint8
+	GetScanDirectionFlag() uint8
+	GetEdgeOfFlightLine() uint8
+	// GetScanAngle returns the scan angle in degrees
+	GetScanAngle() float32
+	GetUserData() uint8
+	GetPointSourceID() uint16
+}
+
+// HasGpsTime is a PointFormat of formats 1 and 3 to 10
+type HasGpsTime interface {
+	GetGpsTime() float64
+}
+
+// HasRGB is a PointFormat of formats 2, 3, 5, 7, 8 and 10
+type HasRGB interface {
+	GetRGB() (red, green, blue uint16)
+}
+
+// HasNIR is a PointFormat of formats 8 and 10
+type HasNIR interface {
+	GetNIR() uint16
+}
+
+// HasScannerChannel is a PointFormat of formats 6 to 10
+type HasScannerChannel interface {
+	GetScannerChannel() uint8
+}
+
+// WavePacket locates the waveform of a point and the direction of its
+// samples
+type WavePacket struct {
+	DescriptorIndex     uint8
+	ByteOffset          uint64
+	Size                uint32
+	ReturnPointLocation float32
+	Xt, Yt, Zt          float32
+}
+
+// HasWaveform is a PointFormat of formats 4, 5, 9 and 10
+type HasWaveform interface {
+	GetWavePacket() WavePacket
+}
+
+// NewPointFormat returns the PointFormat reading the records of format
+func NewPointFormat(format byte) (PointFormat, error) {
+	switch format {
+	case 0:
+		return &Point0{}, nil
+	case 1:
+		return &Point1{}, nil
+	case 2:
+		return &Point2{}, nil
+	case 3:
+		return &Point3{}, nil
+	case 4:
+		return &Point4{}, nil
+	case 5:
+		return &Point5{}, nil
+	case 6:
+		return &Point6{}, nil
+	case 7:
+		return &Point7{}, nil
+	case 8:
+		return &Point8{}, nil
+	case 9:
+		return &Point9{}, nil
+	case 10:
+		return &Point10{}, nil
+	}
+	return nil, geotiff.UnsupportedError(fmt.Sprintf("LAS point format %d", format))
+}
+
+// Point is a decoded point of any format with its coordinates scaled to the
+// units of the CRS. Attributes missing from a format are zero.
+type Point struct {
+	X, Y, Z         float64
+	Intensity       uint16
+	ReturnNumber    uint8
+	NumberOfReturns uint8
+	// ClassificationFlags are the synthetic (1), key-point (2), withheld (4)
+	// and, for formats 6 to 10, overlap (8) bits
+	ClassificationFlags uint8
+	ScannerChannel      uint8
+	ScanDirectionFlag   uint8
+	EdgeOfFlightLine    uint8
+	Classification      uint8
+	ScanAngle           float32 // degrees
+	UserData            uint8
+	PointSourceID       uint16
+	GpsTime             float64
+	Red, Green, Blue    uint16
+	NIR                 uint16
+	// wave packet of formats 4, 5, 9 and 10
+	WavePacketDescriptorIndex   uint8
+	ByteOffsetToWaveformData    uint64
+	WaveformPacketSizeInBytes   uint32
+	ReturnPointWaveformLocation float32
+	Xt, Yt, Zt                  float32
+	// ExtraBytes follow the fields of the format in the record
+	ExtraBytes []byte
 }
 
 type Point0 struct {
@@ -63,7 +169,7 @@ func (p *Point0) GetZ() int32 {
 }
 
 func (p *Point0) GetClassification() int16 {
-	return int16(p.classification)
+	return int16(p.classification & 0x1f)
 }
 
 func (p *Point0) GetReturnNumber() uint8 {
@@ -123,7 +229,7 @@ func (p *Point1) GetZ() int32 {
 }
 
 func (p *Point1) GetClassification() int16 {
-	return int16(p.classification)
+	return int16(p.classification & 0x1f)
 }
 
 func (p *Point1) GetReturnNumber() uint8 {
@@ -187,7 +293,7 @@ func (p *Point2) GetZ() int32 {
 }
 
 func (p *Point2) GetClassification() int16 {
-	return int16(p.classification)
+	return int16(p.classification & 0x1f)
 }
 
 func (p *Point2) GetReturnNumber() uint8 {
@@ -253,7 +359,7 @@ func (p *Point3) GetZ() int32 {
 }
 
 func (p *Point3) GetClassification() int16 {
-	return int16(p.classification)
+	return int16(p.classification & 0x1f)
 }
 
 func (p *Point3) GetReturnNumber() uint8 {
@@ -327,7 +433,7 @@ func (p *Point4) GetZ() int32 {
 }
 
 func (p *Point4) GetClassification() int16 {
-	return int16(p.classification)
+	return int16(p.classification & 0x1f)
 }
 
 func (p *Point4) GetReturnNumber() uint8 {
@@ -407,7 +513,7 @@ func (p *Point5) GetZ() int32 {
 }
 
 func (p *Point5) GetClassification() int16 {
-	return int16(p.classification)
+	return int16(p.classification & 0x1f)
 }
 
 func (p *Point5) GetReturnNumber() uint8 {
@@ -667,7 +773,7 @@ func (p *Point9) GetIntensity() uint16 {
 }
 
 type Point10 struct {
-	Point7
+	Point8
 	wavePacketDescriptorIndex   byte
 	byteOffsetToWaveformData    uint64
 	WaveformPacketSizeInBytes   uint32
@@ -698,13 +804,14 @@ func (p *Point10) ReadPoint(rawPoint []byte) {
 	p.red = binary.LittleEndian.Uint16(rawPoint[30:32])
 	p.green = binary.LittleEndian.Uint16(rawPoint[32:34])
 	p.blue = binary.LittleEndian.Uint16(rawPoint[34:36])
-	p.wavePacketDescriptorIndex = rawPoint[36:37][0]
-	p.byteOffsetToWaveformData = binary.LittleEndian.Uint64(rawPoint[37:45])
-	p.WaveformPacketSizeInBytes = binary.LittleEndian.Uint32(rawPoint[45:49])
-	p.returnPointWaveformLocation = math.Float32frombits(binary.LittleEndian.Uint32(rawPoint[49:53]))
-	p.xt = math.Float32frombits(binary.LittleEndian.Uint32(rawPoint[53:57]))
-	p.yt = math.Float32frombits(binary.LittleEndian.Uint32(rawPoint[57:61]))
-	p.zt = math.Float32frombits(binary.LittleEndian.Uint32(rawPoint[61:65]))
+	p.nir = binary.LittleEndian.Uint16(rawPoint[36:38])
+	p.wavePacketDescriptorIndex = rawPoint[38:39][0]
+	p.byteOffsetToWaveformData = binary.LittleEndian.Uint64(rawPoint[39:47])
+	p.WaveformPacketSizeInBytes = binary.LittleEndian.Uint32(rawPoint[47:51])
+	p.returnPointWaveformLocation = math.Float32frombits(binary.LittleEndian.Uint32(rawPoint[51:55]))
+	p.xt = math.Float32frombits(binary.LittleEndian.Uint32(rawPoint[55:59]))
+	p.yt = math.Float32frombits(binary.LittleEndian.Uint32(rawPoint[59:63]))
+	p.zt = math.Float32frombits(binary.LittleEndian.Uint32(rawPoint[63:67]))
 }
 
 func (p *Point10) GetX() int32 {
@@ -733,3 +840,234 @@ func (p *Point10) GetTotalReturns() uint8 {
 func (p *Point10) GetIntensity() uint16 {
 	return p.intensity
 }
+
+func (p *Point0) GetClassificationFlags() uint8 {
+	return p.classification >> 5
+}
+
+func (p *Point0) GetScanDirectionFlag() uint8 {
+	return p.scanDirectionFlag
+}
+
+func (p *Point0) GetEdgeOfFlightLine() uint8 {
+	return p.edgeOfFlightLine
+}
+
+func (p *Point0) GetScanAngle() float32 {
+	return float32(p.scanAngleRank)
+}
+
+func (p *Point0) GetUserData() uint8 {
+	return p.userData
+}
+
+func (p *Point0) GetPointSourceID() uint16 {
+	return p.pointSourceID
+}
+
+func (p *Point1) GetClassificationFlags() uint8 {
+	return p.classification >> 5
+}
+
+func (p *Point1) GetScanDirectionFlag() uint8 {
+	return p.scanDirectionFlag
+}
+
+func (p *Point1) GetEdgeOfFlightLine() uint8 {
+	return p.edgeOfFlightLine
+}
+
+func (p *Point1) GetScanAngle() float32 {
+	return float32(p.scanAngleRank)
+}
+
+func (p *Point1) GetUserData() uint8 {
+	return p.userData
+}
+
+func (p *Point1) GetPointSourceID() uint16 {
+	return p.pointSourceID
+}
+
+func (p *Point1) GetGpsTime() float64 {
+	return p.gpsTime
+}
+
+func (p *Point2) GetClassificationFlags() uint8 {
+	return p.classification >> 5
+}
+
+func (p *Point2) GetScanDirectionFlag() uint8 {
+	return p.scanDirectionFlag
+}
+
+func (p *Point2) GetEdgeOfFlightLine() uint8 {
+	return p.edgeOfFlightLine
+}
+
+func (p *Point2) GetScanAngle() float32 {
+	return float32(p.scanAngleRank)
+}
+
+func (p *Point2) GetUserData() uint8 {
+	return p.userData
+}
+
+func (p *Point2) GetPointSourceID() uint16 {
+	return p.pointSourceID
+}
+
+func (p *Point2) GetRGB() (uint16, uint16, uint16) {
+	return p.red, p.green, p.blue
+}
+
+func (p *Point3) GetClassificationFlags() uint8 {
+	return p.classification >> 5
+}
+
+func (p *Point3) GetScanDirectionFlag() uint8 {
+	return p.scanDirectionFlag
+}
+
+func (p *Point3) GetEdgeOfFlightLine() uint8 {
+	return p.edgeOfFlightLine
+}
+
+func (p *Point3) GetScanAngle() float32 {
+	return float32(p.scanAngleRank)
+}
+
+func (p *Point3) GetUserData() uint8 {
+	return p.userData
+}
+
+func (p *Point3) GetPointSourceID() uint16 {
+	return p.pointSourceID
+}
+
+func (p *Point3) GetGpsTime() float64 {
+	return p.gpsTime
+}
+
+func (p *Point3) GetRGB() (uint16, uint16, uint16) {
+	return p.red, p.green, p.blue
+}
+
+func (p *Point4) GetClassificationFlags() uint8 {
+	return p.classification >> 5
+}
+
+func (p *Point4) GetScanDirectionFlag() uint8 {
+	return p.scanDirectionFlag
+}
+
+func (p *Point4) GetEdgeOfFlightLine() uint8 {
+	return p.edgeOfFlightLine
+}
+
+func (p *Point4) GetScanAngle() float32 {
+	return float32(p.scanAngleRank)
+}
+
+func (p *Point4) GetUserData() uint8 {
+	return p.userData
+}
+
+func (p *Point4) GetPointSourceID() uint16 {
+	return p.pointSourceID
+}
+
+func (p *Point4) GetGpsTime() float64 {
+	return p.gpsTime
+}
+
+func (p *Point4) GetWavePacket() WavePacket {
+	return WavePacket{p.wavePacketDescriptorIndex, p.byteOffsetToWaveformData, p.WaveformPacketSizeInBytes, p.returnPointWaveformLocation, p.xt, p.yt, p.zt}
+}
+
+func (p *Point5) GetClassificationFlags() uint8 {
+	return p.classification >> 5
+}
+
+func (p *Point5) GetScanDirectionFlag() uint8 {
+	return p.scanDirectionFlag
+}
+
+func (p *Point5) GetEdgeOfFlightLine() uint8 {
+	return p.edgeOfFlightLine
+}
+
+func (p *Point5) GetScanAngle() float32 {
+	return float32(p.scanAngleRank)
+}
+
+func (p *Point5) GetUserData() uint8 {
+	return p.userData
+}
+
+func (p *Point5) GetPointSourceID() uint16 {
+	return p.pointSourceID
+}
+
+func (p *Point5) GetGpsTime() float64 {
+	return p.gpsTime
+}
+
+func (p *Point5) GetRGB() (uint16, uint16, uint16) {
+	return p.red, p.green, p.blue
+}
+
+func (p *Point5) GetWavePacket() WavePacket {
+	return WavePacket{p.wavePacketDescriptorIndex, p.byteOffsetToWaveformData, p.WaveformPacketSizeInBytes, p.returnPointWaveformLocation, p.xt, p.yt, p.zt}
+}
+
+// the attributes of formats 7 to 10 are those of the formats they embed
+
+func (p *Point6) GetClassificationFlags() uint8 {
+	return p.classificationFlags
+}
+
+func (p *Point6) GetScannerChannel() uint8 {
+	return p.scannerChannel
+}
+
+func (p *Point6) GetScanDirectionFlag() uint8 {
+	return p.scanDirectionFlag
+}
+
+func (p *Point6) GetEdgeOfFlightLine() uint8 {
+	return p.edgeOfFlightLine
+}
+
+// GetScanAngle returns the angle of increments of 0.006 degrees
+func (p *Point6) GetScanAngle() float32 {
+	return float32(p.scanAngle) * 0.006
+}
+
+func (p *Point6) GetUserData() uint8 {
+	return p.userData
+}
+
+func (p *Point6) GetPointSourceID() uint16 {
+	return p.pointSourceID
+}
+
+func (p *Point6) GetGpsTime() float64 {
+	return p.gpsTime
+}
+
+func (p *Point7) GetRGB() (uint16, uint16, uint16) {
+	return p.red, p.green, p.blue
+}
+
+func (p *Point8) GetNIR() uint16 {
+	return p.nir
+}
+
+func (p *Point9) GetWavePacket() WavePacket {
+	return WavePacket{p.wavePacketDescriptorIndex, p.byteOffsetToWaveformData, p.WaveformPacketSizeInBytes, p.returnPointWaveformLocation, p.xt, p.yt, p.zt}
+}
+
+func (p *Point10) GetWavePacket() WavePacket {
+	return WavePacket{p.wavePacketDescriptorIndex, p.byteOffsetToWaveformData, p.WaveformPacketSizeInBytes, p.returnPointWaveformLocation, p.xt, p.yt, p.zt}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPointFormats(t *testing.T) {
+	for format := byte(0); format < byte(len(pointRecordLengths)); format++ {
+		pf, err := NewPointFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range samplePoints(20) {
+			e := formatPoint(p, format)
+			record := make([]byte, pointRecordLengths[format])
+			if err := encodePoint(record, p, format, [3]int32{int32(i), -int32(i), 7}); err != nil {
+				t.Fatal(err)
+			}
+			pf.ReadPoint(record)
+			if pf.GetX() != int32(i) || pf.GetY() != -int32(i) || pf.GetZ() != 7 {
+				t.Errorf("format %d: point %d at %d, %d, %d", format, i, pf.GetX(), pf.GetY(), pf.GetZ())
+			}
+			if pf.GetClassification() != int16(e.Classification) || pf.GetClassificationFlags() != e.ClassificationFlags {
+				t.Errorf("format %d: point %d of class %d and flags %d, expected %d and %d", format, i, pf.GetClassification(), pf.GetClassificationFlags(), e.Classification, e.ClassificationFlags)
+			}
+			if pf.GetReturnNumber() != e.ReturnNumber || pf.GetTotalReturns() != e.NumberOfReturns {
+				t.Errorf("format %d: point %d is return %d of %d, expected %d of %d", format, i, pf.GetReturnNumber(), pf.GetTotalReturns(), e.ReturnNumber, e.NumberOfReturns)
+			}
+			if pf.GetIntensity() != e.Intensity || pf.GetUserData() != e.UserData || pf.GetPointSourceID() != e.PointSourceID ||
+				pf.GetScanDirectionFlag() != e.ScanDirectionFlag || pf.GetEdgeOfFlightLine() != e.EdgeOfFlightLine {
+				t.Errorf("format %d: point %d is %+v, expected %+v", format, i, pf, e)
+			}
+			if math.Abs(float64(pf.GetScanAngle()-e.ScanAngle)) > 1e-4 {
+				t.Errorf("format %d: point %d scan angle %v, expected %v", format, i, pf.GetScanAngle(), e.ScanAngle)
+			}
+
+			if g, ok := pf.(HasGpsTime); ok != (format != 0 && format != 2) {
+				t.Errorf("format %d: HasGpsTime %v", format, ok)
+			} else if ok && g.GetGpsTime() != e.GpsTime {
+				t.Errorf("format %d: point %d GPS time %v, expected %v", format, i, g.GetGpsTime(), e.GpsTime)
+			}
+			rgb, ok := pf.(HasRGB)
+			switch format {
+			case 2, 3, 5, 7, 8, 10:
+				if !ok {
+					t.Errorf("format %d: no RGB", format)
+				} else if r, g, b := rgb.GetRGB(); r != e.Red || g != e.Green || b != e.Blue {
+					t.Errorf("format %d: point %d RGB %d, %d, %d, expected %d, %d, %d", format, i, r, g, b, e.Red, e.Green, e.Blue)
+				}
+			default:
+				if ok {
+					t.Errorf("format %d: HasRGB", format)
+				}
+			}
+			if n, ok := pf.(HasNIR); ok != (format == 8 || format == 10) {
+				t.Errorf("format %d: HasNIR %v", format, ok)
+			} else if ok && n.GetNIR() != e.NIR {
+				t.Errorf("format %d: point %d NIR %d, expected %d", format, i, n.GetNIR(), e.NIR)
+			}
+			if c, ok := pf.(HasScannerChannel); ok != (format >= 6) {
+				t.Errorf("format %d: HasScannerChannel %v", format, ok)
+			} else if ok && c.GetScannerChannel() != e.ScannerChannel {
+				t.Errorf("format %d: point %d channel %d, expected %d", format, i, c.GetScannerChannel(), e.ScannerChannel)
+			}
+			w, ok := pf.(HasWaveform)
+			switch format {
+			case 4, 5, 9, 10:
+				expected := WavePacket{e.WavePacketDescriptorIndex, e.ByteOffsetToWaveformData, e.WaveformPacketSizeInBytes, e.ReturnPointWaveformLocation, e.Xt, e.Yt, e.Zt}
+				if !ok {
+					t.Errorf("format %d: no waveform", format)
+				} else if w.GetWavePacket() != expected {
+					t.Errorf("format %d: point %d wave packet %+v, expected %+v", format, i, w.GetWavePacket(), expected)
+				}
+			default:
+				if ok {
+					t.Errorf("format %d: HasWaveform", format)
+				}
+			}
+		}
+	}
+	if _, err := NewPointFormat(11); err == nil {
+		t.Error("point format 11")
+	}
+}
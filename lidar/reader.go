@@ -5,6 +5,7 @@
 package lidar
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -28,12 +29,16 @@ func (s NotaLasFile) Error() string {
 
 type Las interface {
 	Build() (*geotiff.Raster, error)
+	Points(ctx context.Context) PointIterator
 	VariableLengthRecords() []*Vlr
+	ExtraBytes() []*ExtraBytesDescriptor
+	ExtraBytesAttribute(name string) (*ExtraBytesDescriptor, error)
 	Summarize(*Vlr) string
 	GeotiffCrs() *CrsRecordGeoTiff
 	SummarizeGeokey(*geotiff.GeoKey) string
 	WktCrs() *CrsRecordWkt
 	IsWktCrs() bool
+	CRS() (*geotiff.CRS, error)
 	KeyFor(key int) (*geotiff.GeoKey, error)
 	DumpHeader() []string
 	Bounds() *geotiff.Bounds
@@ -52,19 +57,34 @@ type CrsRecordGeoTiff struct {
 	NumberOfKeys        uint16
 }
 
+// CRS builds the coordinate reference system described by the GeoKeys
+func (c *CrsRecordGeoTiff) CRS() (*geotiff.CRS, error) {
+	return geotiff.NewCRS(c.Geokeys, c.Doubles, c.Asciis)
+}
+
 type CrsRecordWkt struct {
 	Wkt string
 }
 
+// CRS parses the WKT of the record
+func (c *CrsRecordWkt) CRS() (*geotiff.CRS, error) {
+	return geotiff.ParseWKT(c.Wkt)
+}
+
 type decoder struct {
-	reader     io.ReaderAt
-	byteOrder  binary.ByteOrder
-	header     HeaderFormat
-	vlrs       []*Vlr
-	evlrs      []*Evlr
-	crsGeotiff *CrsRecordGeoTiff
-	crsWkt     *CrsRecordWkt
-	opt        *ReadOptions
+	reader        io.ReaderAt
+	points        io.ReaderAt // the point records, decompressed from LAZ
+	byteOrder     binary.ByteOrder
+	header        HeaderFormat
+	vlrs          []*Vlr
+	evlrs         []*Evlr
+	crsGeotiff    *CrsRecordGeoTiff
+	crsWkt        *CrsRecordWkt
+	opt           *ReadOptions
+	extraBytes    []*ExtraBytesDescriptor
+	extraBytesErr error                 // of a broken Extra Bytes VLR
+	attribute     *ExtraBytesDescriptor // rasterized instead of Z
+	ranges        []attributeRange
 }
 
 func (d *decoder) Close() bool {
@@ -116,7 +136,7 @@ func (d *decoder) readLegacyHeader(rawHeader []byte) *LasHeaderLegacy {
 	lh.headerSize = uint16(len(rawHeader))
 	lh.offsetDataPoint = d.byteOrder.Uint32(rawHeader[96:100])
 	lh.numVarLengthRecords = d.byteOrder.Uint32(rawHeader[100:104])
-	lh.pointDataRecordFormat = rawHeader[104:105][0]
+	lh.pointDataRecordFormat = rawHeader[104:105][0] & pointFormatMask
 	lh.pointDataRecordLength = d.byteOrder.Uint16(rawHeader[105:107])
 	lh.legacyNumberPointRecords = d.byteOrder.Uint32(rawHeader[107:111])
 	lh.legacyNumberPointsByReturn = make([]uint32, 5)
@@ -152,7 +172,7 @@ func (d *decoder) readLas14Header(rawHeader []byte) *LasHeader14 {
 	lh.headerSize = uint16(len(rawHeader))
 	lh.offsetDataPoint = d.byteOrder.Uint32(rawHeader[96:100])
 	lh.numVarLengthRecords = d.byteOrder.Uint32(rawHeader[100:104])
-	lh.pointDataRecordFormat = rawHeader[104:105][0]
+	lh.pointDataRecordFormat = rawHeader[104:105][0] & pointFormatMask
 	lh.pointDataRecordLength = d.byteOrder.Uint16(rawHeader[105:107])
 	lh.legacyNumberPointRecords = d.byteOrder.Uint32(rawHeader[107:111])
 	lh.legacyNumberPointsByReturn = make([]uint32, 5)
@@ -247,17 +267,30 @@ func (d *decoder) KeyFor(key int) (*geotiff.GeoKey, error) {
 	return nil, geotiff.GeneralIssue(fmt.Sprintf("GeoKey for %v is not present", key))
 }
 
+// parseWktCrs keeps the coordinate system WKT, which LAS 1.4 writers put in
+// a VLR or an EVLR, falling back to a math transform WKT
 func (d *decoder) parseWktCrs() {
-	for _, evlr := range d.evlrs {
-		if evlr.userID == geotiffSignature {
-			switch evlr.recordID {
-			case MathTransformWKT:
-				d.crsWkt = &CrsRecordWkt{Wkt: string(evlr.data)}
-			case CoordinateSystemWKT:
-				d.crsWkt = &CrsRecordWkt{Wkt: string(evlr.data)}
-			}
+	var transform *CrsRecordWkt
+	record := func(userID string, recordID uint16, data []byte) {
+		if userID != geotiffSignature {
+			return
+		}
+		switch recordID {
+		case MathTransformWKT:
+			transform = &CrsRecordWkt{Wkt: string(data)}
+		case CoordinateSystemWKT:
+			d.crsWkt = &CrsRecordWkt{Wkt: string(data)}
 		}
 	}
+	for _, vlr := range d.vlrs {
+		record(vlr.userID, vlr.recordID, vlr.data)
+	}
+	for _, evlr := range d.evlrs {
+		record(evlr.userID, evlr.recordID, evlr.data)
+	}
+	if d.crsWkt == nil {
+		d.crsWkt = transform
+	}
 }
 
 func (d *decoder) parseCrsRecord() {
@@ -276,6 +309,21 @@ func (d *decoder) WktCrs() *CrsRecordWkt {
 	return d.crsWkt
 }
 
+// CRS returns the coordinate reference system of the points, from the WKT
+// records of LAS 1.4 files or from the GeoKeys. Build tags its rasters with it.
+func (d *decoder) CRS() (*geotiff.CRS, error) {
+	if d.header.isWkt() {
+		if d.crsWkt == nil {
+			return nil, geotiff.GeneralIssue("WKT coordinate system record is missing")
+		}
+		return d.crsWkt.CRS()
+	}
+	if d.crsGeotiff == nil {
+		return nil, geotiff.GeneralIssue("GeoKeys are missing")
+	}
+	return d.crsGeotiff.CRS()
+}
+
 func (d *decoder) IsWktCrs() bool {
 	return d.header.isWkt()
 }
@@ -297,6 +345,8 @@ type PointPacket struct {
 	onlyClassifications         bool
 	onlyLastReturns             bool
 	onlyIntensity               bool
+	attribute                   *ExtraBytesDescriptor
+	ranges                      []attributeRange
 }
 
 type PointReturn struct {
@@ -323,34 +373,12 @@ func (p *PointReturn) combine(other *PointReturn) {
 }
 
 func readPoints(chIn chan *PointPacket, chOut chan *PointReturn, header HeaderFormat, waiter *sync.WaitGroup) {
-	var point PointFormat
-	switch header.GetPointFormat() {
-	case 0:
-		point = &Point0{}
-	case 1:
-		point = &Point1{}
-	case 2:
-		point = &Point2{}
-	case 3:
-		point = &Point3{}
-	case 4:
-		point = &Point4{}
-	case 5:
-		point = &Point5{}
-	case 6:
-		point = &Point6{}
-	case 7:
-		point = &Point7{}
-	case 8:
-		point = &Point8{}
-	case 9:
-		point = &Point9{}
-	case 10:
-		point = &Point10{}
-	default:
-		panic(fmt.Sprintf("No point format for %v", header.GetPointFormat()))
+	point, err := NewPointFormat(header.GetPointFormat())
+	if err != nil {
+		panic(err)
 	}
 	pointLength := int64(header.GetPointLength())
+	standardLength := int64(pointRecordLengths[header.GetPointFormat()])
 	for {
 		retval := &PointReturn{
 			c: make([]int64, 257),
@@ -364,7 +392,8 @@ func readPoints(chIn chan *PointPacket, chOut chan *PointReturn, header HeaderFo
 		retval.points = make([]float64, 0, packet.num*3)
 
 		for i := int64(0); i < packet.num; i++ {
-			point.ReadPoint(packet.points[i*pointLength : (i+1)*pointLength])
+			record := packet.points[i*pointLength : (i+1)*pointLength]
+			point.ReadPoint(record)
 			c := int(point.GetClassification())
 			if c >= 256 {
 				c = 256
@@ -380,6 +409,17 @@ func readPoints(chIn chan *PointPacket, chOut chan *PointReturn, header HeaderFo
 			retval.totalX += fx
 			retval.totalY += fy
 			retval.totalZ += fz
+			extra := record[standardLength:]
+			if !inRanges(packet.ranges, extra) {
+				continue
+			}
+			if packet.attribute != nil {
+				v, ok := packet.attribute.Value(extra)
+				if !ok {
+					continue
+				}
+				fz = v
+			}
 			if !filtering || (packet.onlyFirstReturns && point.GetReturnNumber() == 1) || (packet.onlyBareEarthClassification && point.GetClassification() == 2) ||
 				(packet.onlyLastReturns) || (packet.onlyIntensity) {
 
@@ -621,7 +661,8 @@ func (d *decoder) Build() (*geotiff.Raster, error) {
 		} else {
 			numPacketPoints = int64(numPoints - pt)
 		}
-		packet := makePointPacket(d.reader, d.opt, d.header.GetPointLength(), numPacketPoints, format, pointsOffset, pt)
+		packet := makePointPacket(d.points, d.opt, d.header.GetPointLength(), numPacketPoints, format, pointsOffset, pt)
+		packet.attribute, packet.ranges = d.attribute, d.ranges
 		pointsOffset += numPacketPoints * int64(d.header.GetPointLength())
 		input <- packet
 	}
@@ -641,6 +682,8 @@ func (d *decoder) Build() (*geotiff.Raster, error) {
 	grid := make([][]*[]float64, 0, rows)
 	var raster *geotiff.Raster
 	raster = geotiff.NewRaster(cols, rows)
+	// files without a known CRS give untagged rasters
+	raster.CRS, _ = d.CRS()
 	for i := 0; i < rows; i++ {
 		grid = append(grid, make([]*[]float64, cols))
 	}
@@ -652,11 +695,11 @@ func (d *decoder) Build() (*geotiff.Raster, error) {
 	yinc := bounds.Yspan() / imageInfo.height
 	fmt.Println("XSpan:", bounds.Xspan(), "YSpan:", bounds.Yspan(), "xinc", xinc, "yinc", yinc)
 	errPoints := 0
-	for i := 0; i+3 < len(values.points); i += 3 {
+	for i := 0; i+2 < len(values.points); i += 3 {
 		col := int(math.Floor((values.points[i] - imageInfo.minx) / xinc))
 		row := int(math.Floor((imageInfo.maxy - values.points[i+1]) / yinc)) // origin is minX, maxY
 		zval := values.points[i+2]
-		if row >= 0 && row < rows && col >= 0 && col < cols && zval >= imageInfo.minz && zval <= imageInfo.maxz {
+		if row >= 0 && row < rows && col >= 0 && col < cols && (d.attribute != nil || zval >= imageInfo.minz && zval <= imageInfo.maxz) {
 			if grid[row][col] == nil {
 				k := make([]float64, 0, 8)
 				grid[row][col] = &k
@@ -786,16 +829,21 @@ type ReadOptions struct {
 	Intensity             bool
 	FilterCrs             bool
 	AcceptableGeoKeys     map[int]bool
+	// Attribute rasterizes the named extra bytes attribute instead of Z
+	Attribute string
+	// AttributeRanges keep the points whose extra bytes attributes are
+	// within all the ranges
+	AttributeRanges []AttributeRange
 }
 
 func (opt *ReadOptions) String() string {
-	return fmt.Sprintf("ReadOptions: Filtering: %v, FirstReturns: %v, BareEarthClass: %v, LastReturns: %v, Intensity: %v, GatherIngClassifications: %v, FilterCrs: %v, AcceptableGeoKeys: %v",
-		opt.Filtering, opt.FirstReturns, opt.BareEarthClass, opt.LastReturns, opt.Intensity, opt.GatherClassifications, opt.FilterCrs, opt.AcceptableGeoKeys)
+	return fmt.Sprintf("ReadOptions: Filtering: %v, FirstReturns: %v, BareEarthClass: %v, LastReturns: %v, Intensity: %v, GatherIngClassifications: %v, FilterCrs: %v, AcceptableGeoKeys: %v, Attribute: %q, AttributeRanges: %v",
+		opt.Filtering, opt.FirstReturns, opt.BareEarthClass, opt.LastReturns, opt.Intensity, opt.GatherClassifications, opt.FilterCrs, opt.AcceptableGeoKeys, opt.Attribute, opt.AttributeRanges)
 }
 
 func validateOpt(opt *ReadOptions) error {
 	if opt != nil && opt.Filtering {
-		if !opt.GatherClassifications && !opt.FirstReturns && !opt.BareEarthClass && !opt.LastReturns && !opt.Intensity {
+		if !opt.GatherClassifications && !opt.FirstReturns && !opt.BareEarthClass && !opt.LastReturns && !opt.Intensity && opt.Attribute == "" && len(opt.AttributeRanges) == 0 {
 			return fmt.Errorf("Filtering is enabled without specifying any filter option")
 		}
 		if opt.BareEarthClass && opt.FirstReturns {
@@ -819,6 +867,17 @@ func validateOpt(opt *ReadOptions) error {
 		if opt.GatherClassifications && opt.BareEarthClass {
 			return fmt.Errorf("GatherClassifications and BareEarthClass can not both be TRUE")
 		}
+		if opt.Attribute != "" && opt.GatherClassifications {
+			return fmt.Errorf("Attribute and GatherClassifications can not both be set")
+		}
+		if opt.Attribute != "" && opt.Intensity {
+			return fmt.Errorf("Attribute and Intensity can not both be set")
+		}
+		for _, r := range opt.AttributeRanges {
+			if r.Min > r.Max {
+				return fmt.Errorf("AttributeRanges of %s has a minimum %v above its maximum %v", r.Name, r.Min, r.Max)
+			}
+		}
 		if opt.FilterCrs && (opt.AcceptableGeoKeys == nil || len(opt.AcceptableGeoKeys) == 0) {
 			return fmt.Errorf("AcceptableGeoKeys must be specified when FilterCrs is TRUE")
 		}
@@ -841,6 +900,12 @@ func validateOpt(opt *ReadOptions) error {
 		if opt.FilterCrs {
 			return fmt.Errorf("FilterCrs is on without Filtering being enabled")
 		}
+		if opt.Attribute != "" {
+			return fmt.Errorf("Attribute is set without Filtering being enabled")
+		}
+		if len(opt.AttributeRanges) > 0 {
+			return fmt.Errorf("AttributeRanges are set without Filtering being enabled")
+		}
 	}
 	fmt.Println("Processing using", opt)
 	return nil
@@ -888,6 +953,25 @@ func NewFileReader(f *os.File, opt *ReadOptions) (Las, error) {
 			evlrPos += 60 + v.lengthAfterHeader
 		}
 		d.parseCrsRecord()
+		d.parseExtraBytes()
+		if err := d.resolveAttributes(); err != nil {
+			return nil, err
+		}
+		d.points = d.reader
+		for _, v := range d.vlrs {
+			if v.userID != laszipSignature {
+				continue
+			}
+			info, err := parseLaszip(v.data)
+			if err != nil {
+				return nil, err
+			}
+			if info.compressor != lazNone {
+				if d.points, err = newLazReader(f, info, hdr); err != nil {
+					return nil, err
+				}
+			}
+		}
 		return d, nil
 	}
 	return nil, NotaLasFile(signature)
@@ -0,0 +1,8 @@
+#!/bin/sh
+# Compresses the reference LAS files with the laszip tool of LAStools or
+# LASzip, point formats 6 to 8 use the layered compression of LAS 1.4
+set -e
+cd "$(dirname "$0")"
+for las in format*.las; do
+	laszip -i "$las" -o "${las%.las}.laz" -chunk_size 500
+done
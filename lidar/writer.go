@@ -0,0 +1,405 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/geodatalake/lambdas/geotiff"
+)
+
+// NewVlr returns a variable length record to write with a Writer
+func NewVlr(userID string, recordID uint16, description string, data []byte) *Vlr {
+	return &Vlr{userID: userID, recordID: recordID, lengthAfterHeader: uint16(len(data)), description: description, data: data}
+}
+
+// NewEvlr returns an extended variable length record to write after the
+// points of a LAS 1.4 file
+func NewEvlr(userID string, recordID uint16, description string, data []byte) *Evlr {
+	return &Evlr{userID: userID, recordID: recordID, lengthAfterHeader: uint64(len(data)), description: description, data: data}
+}
+
+// WriterOptions describe the file written by a Writer
+type WriterOptions struct {
+	// VersionMinor is the LAS 1.x version: 2, 3 or 4. It defaults to the
+	// oldest version supporting the point format and the records.
+	VersionMinor byte
+	PointFormat  byte
+	// ExtraBytes is the number of bytes after the fields of the format in
+	// each record, taken from Point.ExtraBytes
+	ExtraBytes uint16
+	// Scale and Offset convert coordinates to the integers of the records,
+	// the scale defaults to 0.01
+	Scale  [3]float64
+	Offset [3]float64
+
+	FileSourceID       uint16
+	SystemIdentifier   string
+	GeneratingSoftware string
+	CreationDate       time.Time // today by default
+	// GpsStandardTime marks GPS times as standard GPS time minus 1e9 rather
+	// than seconds of the GPS week
+	GpsStandardTime bool
+
+	// GeoKeys or Wkt set the CRS. Point formats 6 to 10 require WKT, GeoKeys
+	// are converted when given alone.
+	GeoKeys *CrsRecordGeoTiff
+	Wkt     string
+	// Vlrs and Evlrs are copied to the file, except for the CRS records when
+	// a CRS is given and the LASzip record
+	Vlrs  []*Vlr
+	Evlrs []*Evlr
+}
+
+// Writer writes points to a LAS file, the bounds and point counts of the
+// header are computed from the points when the writer is closed
+type Writer struct {
+	ws           io.WriteSeeker
+	w            *bufio.Writer
+	opts         WriterOptions
+	vlrs         []*Vlr
+	evlrs        []*Evlr
+	headerSize   int
+	pointsOffset uint32
+	recordLength uint16
+	record       []byte
+	count        uint64
+	byReturn     [15]uint64
+	min, max     [3]float64
+}
+
+// NewWriter writes the header and VLRs of a LAS file to ws, the points are
+// written with WritePoint
+func NewWriter(ws io.WriteSeeker, opts *WriterOptions) (*Writer, error) {
+	if opts == nil {
+		return nil, geotiff.GeneralIssue("LAS writer: missing options")
+	}
+	o := *opts
+	if int(o.PointFormat) >= len(pointRecordLengths) {
+		return nil, geotiff.UnsupportedError(fmt.Sprintf("LAS point format %d", o.PointFormat))
+	}
+	for i := range o.Scale {
+		if o.Scale[i] == 0 {
+			o.Scale[i] = 0.01
+		}
+	}
+	if o.CreationDate.IsZero() {
+		o.CreationDate = time.Now()
+	}
+	if o.PointFormat >= 6 && o.Wkt == "" && o.GeoKeys != nil {
+		crs, err := o.GeoKeys.CRS()
+		if err != nil {
+			return nil, err
+		}
+		if o.Wkt, err = crs.WKT(); err != nil {
+			return nil, err
+		}
+		o.GeoKeys = nil
+	}
+
+	// the oldest version holding the format and records
+	minor := byte(2)
+	if o.PointFormat >= 4 {
+		minor = 3
+	}
+	if o.PointFormat >= 6 || o.Wkt != "" || len(o.Evlrs) > 0 {
+		minor = 4
+	}
+	if o.VersionMinor == 0 {
+		o.VersionMinor = minor
+	}
+	if o.VersionMinor < minor || o.VersionMinor > 4 {
+		return nil, geotiff.UnsupportedError(fmt.Sprintf("LAS 1.%d with point format %d, WKT %v and %d EVLRs", o.VersionMinor, o.PointFormat, o.Wkt != "", len(o.Evlrs)))
+	}
+	if o.PointFormat >= 6 && o.GeoKeys != nil {
+		return nil, geotiff.GeneralIssue(fmt.Sprintf("LAS point format %d with GeoKeys and WKT", o.PointFormat))
+	}
+
+	w := &Writer{ws: ws, w: bufio.NewWriter(ws), opts: o}
+	w.headerSize = []int{headerSize12, headerSize13, headerSize14}[o.VersionMinor-2]
+	w.recordLength = pointRecordLengths[o.PointFormat] + o.ExtraBytes
+	w.record = make([]byte, w.recordLength)
+	for i := range w.min {
+		w.min[i], w.max[i] = math.Inf(1), math.Inf(-1)
+	}
+	hasCrs := o.GeoKeys != nil || o.Wkt != ""
+	for _, v := range o.Vlrs {
+		if v.userID == laszipSignature || (hasCrs && v.userID == geotiffSignature) {
+			continue
+		}
+		w.vlrs = append(w.vlrs, v)
+	}
+	for _, v := range o.Evlrs {
+		if v.userID == laszipSignature || (hasCrs && v.userID == geotiffSignature) {
+			continue
+		}
+		w.evlrs = append(w.evlrs, v)
+	}
+	if o.GeoKeys != nil {
+		w.vlrs = append(w.vlrs, geoKeyVlrs(o.GeoKeys)...)
+	}
+	if o.Wkt != "" {
+		w.vlrs = append(w.vlrs, NewVlr(geotiffSignature, CoordinateSystemWKT, "OGC coordinate system WKT", append([]byte(o.Wkt), 0)))
+	}
+
+	offset := w.headerSize
+	for _, v := range w.vlrs {
+		if len(v.data) > math.MaxUint16 {
+			return nil, geotiff.GeneralIssue(fmt.Sprintf("VLR %d of %d bytes", v.recordID, len(v.data)))
+		}
+		offset += 54 + len(v.data)
+	}
+	w.pointsOffset = uint32(offset)
+	if _, err := w.w.Write(w.header()); err != nil {
+		return nil, err
+	}
+	for _, v := range w.vlrs {
+		if err := writeRecordHeader(w.w, v.userID, v.recordID, uint64(len(v.data)), v.description, false); err != nil {
+			return nil, err
+		}
+		if _, err := w.w.Write(v.data); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// geoKeyVlrs returns the GeoKey directory, doubles and ASCII records of c
+func geoKeyVlrs(c *CrsRecordGeoTiff) []*Vlr {
+	keys := make(geotiff.ByKey, 0, len(c.Geokeys))
+	for _, k := range c.Geokeys {
+		keys = append(keys, k)
+	}
+	sort.Sort(keys)
+	directory := make([]byte, 8+8*len(keys))
+	for i, v := range []uint16{1, 1, 0, uint16(len(keys))} {
+		binary.LittleEndian.PutUint16(directory[2*i:], v)
+	}
+	for i, k := range keys {
+		for j, v := range []uint16{k.KeyId, k.Location, k.Count, k.Value} {
+			binary.LittleEndian.PutUint16(directory[8+8*i+2*j:], v)
+		}
+	}
+	vlrs := []*Vlr{NewVlr(geotiffSignature, RGeoKeys, "GeoTIFF GeoKeyDirectoryTag", directory)}
+	if len(c.Doubles) > 0 {
+		doubles := make([]byte, 8*len(c.Doubles))
+		for i, v := range c.Doubles {
+			binary.LittleEndian.PutUint64(doubles[8*i:], math.Float64bits(v))
+		}
+		vlrs = append(vlrs, NewVlr(geotiffSignature, RGeoDoubles, "GeoTIFF GeoDoubleParamsTag", doubles))
+	}
+	if len(c.Asciis) > 0 {
+		vlrs = append(vlrs, NewVlr(geotiffSignature, RGeoAscii, "GeoTIFF GeoAsciiParamsTag", c.Asciis))
+	}
+	return vlrs
+}
+
+// writeRecordHeader writes the 54 byte header of a VLR or the 60 byte header
+// of an EVLR
+func writeRecordHeader(w io.Writer, userID string, recordID uint16, length uint64, description string, extended bool) error {
+	size := 54
+	if extended {
+		size = 60
+	}
+	b := make([]byte, size)
+	copy(b[2:18], userID)
+	binary.LittleEndian.PutUint16(b[18:20], recordID)
+	if extended {
+		binary.LittleEndian.PutUint64(b[20:28], length)
+	} else {
+		binary.LittleEndian.PutUint16(b[20:22], uint16(length))
+	}
+	copy(b[size-32:], description)
+	_, err := w.Write(b)
+	return err
+}
+
+// header returns the public header block for the points written so far
+func (w *Writer) header() []byte {
+	o := &w.opts
+	le := binary.LittleEndian
+	b := make([]byte, w.headerSize)
+	copy(b[0:4], "LASF")
+	le.PutUint16(b[4:6], o.FileSourceID)
+	var encoding uint16
+	if o.GpsStandardTime {
+		encoding |= uint16(geGpsStandardOffset)
+	}
+	if o.Wkt != "" {
+		encoding |= geWkt
+	}
+	le.PutUint16(b[6:8], encoding)
+	b[24], b[25] = 1, o.VersionMinor
+	copy(b[26:58], o.SystemIdentifier)
+	copy(b[58:90], o.GeneratingSoftware)
+	le.PutUint16(b[90:92], uint16(o.CreationDate.YearDay()))
+	le.PutUint16(b[92:94], uint16(o.CreationDate.Year()))
+	le.PutUint16(b[94:96], uint16(w.headerSize))
+	le.PutUint32(b[96:100], w.pointsOffset)
+	le.PutUint32(b[100:104], uint32(len(w.vlrs)))
+	b[104] = o.PointFormat
+	le.PutUint16(b[105:107], w.recordLength)
+
+	// the legacy counts are zero when they cannot hold the points, returns
+	// above 5 are only counted by LAS 1.4
+	if o.PointFormat < 6 && w.count <= math.MaxUint32 {
+		le.PutUint32(b[107:111], uint32(w.count))
+		for i := 0; i < 5; i++ {
+			le.PutUint32(b[111+4*i:], uint32(w.byReturn[i]))
+		}
+	}
+	min, max := w.min, w.max
+	if w.count == 0 {
+		min, max = [3]float64{}, [3]float64{}
+	}
+	for i := 0; i < 3; i++ {
+		le.PutUint64(b[131+8*i:], math.Float64bits(o.Scale[i]))
+		le.PutUint64(b[155+8*i:], math.Float64bits(o.Offset[i]))
+		le.PutUint64(b[179+16*i:], math.Float64bits(max[i]))
+		le.PutUint64(b[187+16*i:], math.Float64bits(min[i]))
+	}
+	if o.VersionMinor >= 4 {
+		if len(w.evlrs) > 0 {
+			le.PutUint64(b[235:243], uint64(w.pointsOffset)+w.count*uint64(w.recordLength))
+		}
+		le.PutUint32(b[243:247], uint32(len(w.evlrs)))
+		le.PutUint64(b[247:255], w.count)
+		for i, n := range w.byReturn {
+			le.PutUint64(b[255+8*i:], n)
+		}
+	}
+	return b
+}
+
+// WritePoint appends a point, its coordinates are rounded to the scale of the
+// file
+func (w *Writer) WritePoint(p *Point) error {
+	o := &w.opts
+	var xyz [3]int32
+	for i, v := range []float64{p.X, p.Y, p.Z} {
+		q := math.Floor((v-o.Offset[i])/o.Scale[i] + 0.5)
+		if q < math.MinInt32 || q > math.MaxInt32 || math.IsNaN(q) {
+			return geotiff.GeneralIssue(fmt.Sprintf("coordinate %v does not fit scale %v and offset %v", v, o.Scale[i], o.Offset[i]))
+		}
+		xyz[i] = int32(q)
+	}
+	if o.VersionMinor < 4 && w.count == math.MaxUint32 {
+		return geotiff.GeneralIssue(fmt.Sprintf("LAS 1.%d files hold at most %d points", o.VersionMinor, uint32(math.MaxUint32)))
+	}
+	if err := encodePoint(w.record, p, o.PointFormat, xyz); err != nil {
+		return err
+	}
+	for i := int(pointRecordLengths[o.PointFormat]); i < len(w.record); i++ {
+		w.record[i] = 0
+	}
+	copy(w.record[pointRecordLengths[o.PointFormat]:], p.ExtraBytes)
+	if _, err := w.w.Write(w.record); err != nil {
+		return err
+	}
+
+	w.count++
+	if p.ReturnNumber >= 1 && p.ReturnNumber <= 15 {
+		w.byReturn[p.ReturnNumber-1]++
+	}
+	for i, q := range xyz {
+		v := float64(q)*o.Scale[i] + o.Offset[i]
+		w.min[i], w.max[i] = math.Min(w.min[i], v), math.Max(w.max[i], v)
+	}
+	return nil
+}
+
+// Close writes the EVLRs and the final header, it does not close the
+// underlying writer
+func (w *Writer) Close() error {
+	for _, v := range w.evlrs {
+		if err := writeRecordHeader(w.w, v.userID, v.recordID, uint64(len(v.data)), v.description, true); err != nil {
+			return err
+		}
+		if _, err := w.w.Write(v.data); err != nil {
+			return err
+		}
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.ws.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.ws.Write(w.header()); err != nil {
+		return err
+	}
+	_, err := w.ws.Seek(0, io.SeekEnd)
+	return err
+}
+
+// encodePoint fills the standard fields of a record of format, xyz are the
+// scaled integer coordinates
+func encodePoint(b []byte, p *Point, format byte, xyz [3]int32) error {
+	le := binary.LittleEndian
+	for i, v := range xyz {
+		le.PutUint32(b[4*i:], uint32(v))
+	}
+	le.PutUint16(b[12:14], p.Intensity)
+	pos := 20
+	if format < 6 {
+		if p.ReturnNumber > 7 || p.NumberOfReturns > 7 || p.Classification > 31 {
+			return geotiff.GeneralIssue(fmt.Sprintf("return %d of %d, class %d do not fit point format %d", p.ReturnNumber, p.NumberOfReturns, p.Classification, format))
+		}
+		b[14] = p.ReturnNumber | p.NumberOfReturns<<3 | (p.ScanDirectionFlag&1)<<6 | (p.EdgeOfFlightLine&1)<<7
+		b[15] = p.Classification | (p.ClassificationFlags&7)<<5
+		b[16] = byte(int8(math.Max(-90, math.Min(90, math.Floor(float64(p.ScanAngle)+0.5)))))
+		b[17] = p.UserData
+		le.PutUint16(b[18:20], p.PointSourceID)
+		if format == 1 || format >= 3 {
+			le.PutUint64(b[20:28], math.Float64bits(p.GpsTime))
+			pos = 28
+		}
+		if format == 2 || format == 3 || format == 5 {
+			pos = putRGB(b, pos, p)
+		}
+	} else {
+		if p.ReturnNumber > 15 || p.NumberOfReturns > 15 {
+			return geotiff.GeneralIssue(fmt.Sprintf("return %d of %d do not fit point format %d", p.ReturnNumber, p.NumberOfReturns, format))
+		}
+		b[14] = p.ReturnNumber | p.NumberOfReturns<<4
+		b[15] = p.ClassificationFlags&0x0f | (p.ScannerChannel&3)<<4 | (p.ScanDirectionFlag&1)<<6 | (p.EdgeOfFlightLine&1)<<7
+		b[16] = p.Classification
+		b[17] = p.UserData
+		angle := math.Max(-30000, math.Min(30000, math.Floor(float64(p.ScanAngle)/0.006+0.5)))
+		le.PutUint16(b[18:20], uint16(int16(angle)))
+		le.PutUint16(b[20:22], p.PointSourceID)
+		le.PutUint64(b[22:30], math.Float64bits(p.GpsTime))
+		pos = 30
+		if format == 7 || format == 8 || format == 10 {
+			pos = putRGB(b, pos, p)
+		}
+		if format == 8 || format == 10 {
+			le.PutUint16(b[pos:], p.NIR)
+			pos += 2
+		}
+	}
+	if format == 4 || format == 5 || format == 9 || format == 10 {
+		b[pos] = p.WavePacketDescriptorIndex
+		le.PutUint64(b[pos+1:], p.ByteOffsetToWaveformData)
+		le.PutUint32(b[pos+9:], p.WaveformPacketSizeInBytes)
+		for i, v := range []float32{p.ReturnPointWaveformLocation, p.Xt, p.Yt, p.Zt} {
+			le.PutUint32(b[pos+13+4*i:], math.Float32bits(v))
+		}
+	}
+	return nil
+}
+
+func putRGB(b []byte, pos int, p *Point) int {
+	binary.LittleEndian.PutUint16(b[pos:], p.Red)
+	binary.LittleEndian.PutUint16(b[pos+2:], p.Green)
+	binary.LittleEndian.PutUint16(b[pos+4:], p.Blue)
+	return pos + 6
+}
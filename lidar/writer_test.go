@@ -0,0 +1,280 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lidar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/geodatalake/lambdas/geotiff"
+)
+
+// writeLas writes points to a file of the test and returns its path
+func writeLas(t *testing.T, opts *WriterOptions, points []*Point) string {
+	name := filepath.Join(t.TempDir(), "points.las")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewWriter(f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range points {
+		if err := w.WritePoint(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// openFile opens a file written by writeLas, it is closed with the test
+func openFile(t *testing.T, name string) *os.File {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func openLas(t *testing.T, name string, opt *ReadOptions) Las {
+	las, err := NewFileReader(openFile(t, name), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return las
+}
+
+func countPoints(t *testing.T, las Las) int {
+	n := 0
+	it := las.Points(context.Background())
+	for it.Next() {
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestLegacyCounts(t *testing.T) {
+	var points []*Point
+	for r := byte(1); r <= 6; r++ {
+		points = append(points, &Point{X: float64(r), ReturnNumber: r, NumberOfReturns: 6})
+	}
+	name := writeLas(t, &WriterOptions{PointFormat: 1}, points)
+	raw, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw[25] != 2 {
+		t.Errorf("version 1.%d, expected 1.2", raw[25])
+	}
+	le := binary.LittleEndian
+	if n := le.Uint32(raw[107:]); n != 6 {
+		t.Errorf("legacy count %d, expected 6", n)
+	}
+	for i := 0; i < 5; i++ {
+		if n := le.Uint32(raw[111+4*i:]); n != 1 {
+			t.Errorf("legacy count of return %d: %d, expected 1", i+1, n)
+		}
+	}
+	if n := countPoints(t, openLas(t, name, nil)); n != 6 {
+		t.Errorf("read %d points, expected 6", n)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "full.las"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewWriter(f, &WriterOptions{PointFormat: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.count = math.MaxUint32
+	if err := w.WritePoint(&Point{}); err == nil {
+		t.Error("point beyond the legacy count of LAS 1.2")
+	}
+}
+
+// formatPoint returns the fields of p held by records of format
+func formatPoint(p *Point, format byte) Point {
+	q := *p
+	if format < 6 {
+		q.ScannerChannel = 0
+		q.ClassificationFlags &= 7
+	}
+	if format == 0 || format == 2 {
+		q.GpsTime = 0
+	}
+	switch format {
+	case 2, 3, 5, 7, 8, 10:
+	default:
+		q.Red, q.Green, q.Blue = 0, 0, 0
+	}
+	if format != 8 && format != 10 {
+		q.NIR = 0
+	}
+	switch format {
+	case 4, 5, 9, 10:
+	default:
+		q.WavePacketDescriptorIndex, q.ByteOffsetToWaveformData, q.WaveformPacketSizeInBytes = 0, 0, 0
+		q.ReturnPointWaveformLocation, q.Xt, q.Yt, q.Zt = 0, 0, 0, 0
+	}
+	return q
+}
+
+// samplePoints returns points setting every field, with coordinates and scan
+// angles on the grids of the records
+func samplePoints(n int) []*Point {
+	var points []*Point
+	for i := 0; i < n; i++ {
+		points = append(points, &Point{X: 500000 + float64(i)/4, Y: 4000000 - float64(i%50), Z: float64(i%7) - 2,
+			Intensity: uint16(i), ReturnNumber: byte(i%3 + 1), NumberOfReturns: 3, ClassificationFlags: byte(i % 8),
+			ScannerChannel: byte(i % 4), ScanDirectionFlag: byte(i % 2), EdgeOfFlightLine: byte(i / 2 % 2),
+			Classification: byte(i % 20), ScanAngle: float32(i%5*3) - 6, UserData: byte(i), PointSourceID: 7,
+			GpsTime: 1e8 + float64(i)/8, Red: uint16(i), Green: 2, Blue: uint16(3 * i), NIR: uint16(i * 5),
+			WavePacketDescriptorIndex: 1, ByteOffsetToWaveformData: uint64(i * 60), WaveformPacketSizeInBytes: 60,
+			ReturnPointWaveformLocation: 1.5, Xt: 0.25, Yt: -0.5, Zt: 1, ExtraBytes: []byte{byte(i), 9}})
+	}
+	return points
+}
+
+// equalPoints compares points up to the rounding of the coordinates and scan
+// angles
+func equalPoints(p, q Point) bool {
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+	if !near(p.X, q.X) || !near(p.Y, q.Y) || !near(p.Z, q.Z) || !near(float64(p.ScanAngle), float64(q.ScanAngle)) {
+		return false
+	}
+	extra := bytes.Equal(p.ExtraBytes, q.ExtraBytes)
+	p.X, p.Y, p.Z, p.ScanAngle, p.ExtraBytes = 0, 0, 0, 0, nil
+	q.X, q.Y, q.Z, q.ScanAngle, q.ExtraBytes = 0, 0, 0, 0, nil
+	return extra && reflect.DeepEqual(p, q)
+}
+
+func TestWriterFormats(t *testing.T) {
+	utm := &CrsRecordGeoTiff{Geokeys: map[uint16]*geotiff.GeoKey{
+		1024: {KeyId: 1024, Count: 1, Value: 1},
+		3072: {KeyId: 3072, Count: 1, Value: 32615},
+	}}
+	stale := geoKeyVlrs(&CrsRecordGeoTiff{Geokeys: map[uint16]*geotiff.GeoKey{
+		1024: {KeyId: 1024, Count: 1, Value: 2},
+		2048: {KeyId: 2048, Count: 1, Value: 4269},
+	}})
+	laszip := NewVlr(laszipSignature, 22204, "by laszip of LAStools", make([]byte, 52))
+	kept := NewVlr("example\x00\x00\x00\x00\x00\x00\x00\x00\x00", 7, "kept", []byte("data"))
+	points := samplePoints(40)
+
+	for format := byte(0); format < byte(len(pointRecordLengths)); format++ {
+		opts := &WriterOptions{PointFormat: format, ExtraBytes: 2, GeoKeys: utm, Vlrs: append([]*Vlr{kept, laszip}, stale...)}
+		minor := byte(2)
+		if format >= 4 {
+			minor = 3
+		}
+		if format >= 6 {
+			minor = 4
+			opts.Evlrs = []*Evlr{
+				NewEvlr(kept.userID, 8, "kept", []byte("extended")),
+				NewEvlr(laszipSignature, 22204, "by laszip of LAStools", make([]byte, 52)),
+				NewEvlr(geotiffSignature, CoordinateSystemWKT, "stale", []byte("GEOGCS[]\x00")),
+			}
+		}
+		name := writeLas(t, opts, points)
+		raw, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw[25] != minor {
+			t.Errorf("format %d: version 1.%d, expected 1.%d", format, raw[25], minor)
+		}
+		le := binary.LittleEndian
+		n := uint32(len(points))
+		if format >= 6 {
+			n = 0
+		}
+		if le.Uint32(raw[107:]) != n {
+			t.Errorf("format %d: legacy count %d, expected %d", format, le.Uint32(raw[107:]), n)
+		}
+		recordLength := uint64(pointRecordLengths[format]) + 2
+		if minor == 4 {
+			if count := le.Uint64(raw[247:]); count != uint64(len(points)) {
+				t.Errorf("format %d: count %d, expected %d", format, count, len(points))
+			}
+			for r := 0; r < 15; r++ {
+				expected := uint64(0)
+				if r < 3 {
+					expected = uint64(len(points)+2-r) / 3
+				}
+				if count := le.Uint64(raw[255+8*r:]); count != expected {
+					t.Errorf("format %d: count of return %d: %d, expected %d", format, r+1, count, expected)
+				}
+			}
+			offset := uint64(le.Uint32(raw[96:])) + uint64(len(points))*recordLength
+			if le.Uint64(raw[235:]) != offset || le.Uint32(raw[243:]) != 1 {
+				t.Errorf("format %d: %d EVLRs at %d, expected 1 at %d", format, le.Uint32(raw[243:]), le.Uint64(raw[235:]), offset)
+			}
+		}
+
+		las := openLas(t, name, nil)
+		d := las.(*decoder)
+		for _, v := range d.vlrs {
+			if v.userID == laszipSignature {
+				t.Errorf("format %d: LASzip VLR written", format)
+			}
+			if v.userID == geotiffSignature && v.recordID == RGeoKeys && format >= 6 {
+				t.Errorf("format %d: GeoKeys written along WKT", format)
+			}
+		}
+		if len(d.vlrs) == 0 || d.vlrs[0].userID != kept.userID || string(d.vlrs[0].data) != "data" {
+			t.Errorf("format %d: VLR %v not kept", format, kept)
+		}
+		if format >= 6 && (len(d.evlrs) != 1 || string(d.evlrs[0].data) != "extended") {
+			t.Errorf("format %d: EVLRs %v, expected the extended example", format, d.evlrs)
+		}
+		if las.IsWktCrs() != (format >= 6) {
+			t.Errorf("format %d: WKT %v", format, las.IsWktCrs())
+		}
+		crs, err := las.CRS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crs.EPSG != 32615 {
+			t.Errorf("format %d: CRS %v, expected EPSG 32615", format, crs)
+		}
+		if r, err := las.Build(); err != nil {
+			t.Fatal(err)
+		} else if r.CRS == nil || !r.CRS.Equal(crs) {
+			t.Errorf("format %d: raster CRS %v, expected %v", format, r.CRS, crs)
+		}
+
+		it := las.Points(context.Background())
+		i := 0
+		for ; it.Next(); i++ {
+			if expected := formatPoint(points[i], format); !equalPoints(*it.Point(), expected) {
+				t.Fatalf("format %d: point %d is %+v, expected %+v", format, i, *it.Point(), expected)
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if i != len(points) {
+			t.Errorf("format %d: read %d points, expected %d", format, i, len(points))
+		}
+	}
+}
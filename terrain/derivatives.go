@@ -0,0 +1,176 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package terrain
+
+import (
+	"math"
+
+	"github.com/geodatalake/lambdas/geotiff"
+)
+
+const degree = math.Pi / 180
+
+// SlopeUnit is the unit of the slope rasters
+type SlopeUnit int
+
+const (
+	Degrees SlopeUnit = iota
+	Percent
+)
+
+// CurvatureKind selects the curvature computed
+type CurvatureKind int
+
+const (
+	// TotalCurvature is the curvature of the surface, positive on convex
+	// surfaces
+	TotalCurvature CurvatureKind = iota
+	// ProfileCurvature is the curvature along the slope, positive where the
+	// slope steepens downhill and the flow accelerates
+	ProfileCurvature
+	// PlanCurvature is the curvature across the slope, positive where the
+	// flow diverges. Profile and plan curvatures add up to the total.
+	PlanCurvature
+)
+
+// Slope returns the steepest slope of every cell
+func (s *Surface) Slope(unit SlopeUnit) *geotiff.Raster {
+	return s.apply(func(z [9]float64) float64 {
+		p, q := s.gradient(z)
+		rise := math.Hypot(p, q)
+		if unit == Percent {
+			return 100 * rise
+		}
+		return math.Atan(rise) / degree
+	})
+}
+
+// Aspect returns the direction the slope of every cell faces, in degrees
+// clockwise from north. Flat cells are -1.
+func (s *Surface) Aspect() *geotiff.Raster {
+	return s.apply(func(z [9]float64) float64 {
+		p, q := s.gradient(z)
+		if p == 0 && q == 0 {
+			return -1
+		}
+		return aspect(p, q)
+	})
+}
+
+// aspect returns the downslope direction of a gradient in degrees clockwise
+// from north
+func aspect(p, q float64) float64 {
+	a := math.Atan2(-p, -q) / degree
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+// shade returns the illumination, from 0 to 1, of a cell of gradient p, q lit
+// from azimuth and altitude, in degrees
+func shade(p, q, azimuth, altitude float64) float64 {
+	// the dot product of the surface normal and the direction of the light
+	lx, ly := math.Sin(azimuth*degree)*math.Cos(altitude*degree), math.Cos(azimuth*degree)*math.Cos(altitude*degree)
+	lz := math.Sin(altitude * degree)
+	return math.Max(0, (lz-p*lx-q*ly)/math.Sqrt(1+p*p+q*q))
+}
+
+// Hillshade returns the illumination, from 0 to 255, of every cell by a light
+// from azimuth, in degrees clockwise from north, and altitude, in degrees above
+// the horizon. The usual light is from 315 and 45.
+func (s *Surface) Hillshade(azimuth, altitude float64) *geotiff.Raster {
+	return s.apply(func(z [9]float64) float64 {
+		p, q := s.gradient(z)
+		return 255 * shade(p, q, azimuth, altitude)
+	})
+}
+
+// MultidirectionalHillshade combines lights from 225, 270, 315 and 360
+// degrees at altitude, each weighted by the square of the slope along its
+// direction (Mark, 1992, as in gdaldem -multidirectional). The result is from
+// 0 to 255.
+func (s *Surface) MultidirectionalHillshade(altitude float64) *geotiff.Raster {
+	return s.apply(func(z [9]float64) float64 {
+		p, q := s.gradient(z)
+		if p == 0 && q == 0 {
+			return 255 * math.Sin(altitude*degree)
+		}
+		var sum float64
+		for _, azimuth := range []float64{225, 270, 315, 360} {
+			along := p*math.Sin(azimuth*degree) + q*math.Cos(azimuth*degree)
+			sum += along * along * shade(p, q, azimuth, altitude)
+		}
+		// the weights of the four directions add up to twice the squared
+		// gradient
+		return 255 * sum / (2 * (p*p + q*q))
+	})
+}
+
+// TRI returns the terrain ruggedness index of Riley et al. (1999), the root
+// of the summed squared differences between a cell and its eight neighbors
+func (s *Surface) TRI() *geotiff.Raster {
+	return s.apply(func(z [9]float64) float64 {
+		var sum float64
+		for i, v := range z {
+			if i != 4 {
+				sum += (v - z[4]) * (v - z[4])
+			}
+		}
+		return math.Sqrt(sum)
+	})
+}
+
+// TPI returns the topographic position index, the elevation of a cell minus
+// the mean of its eight neighbors: positive on ridges and negative in valleys
+func (s *Surface) TPI() *geotiff.Raster {
+	return s.apply(func(z [9]float64) float64 {
+		var sum float64
+		for i, v := range z {
+			if i != 4 {
+				sum += v
+			}
+		}
+		return z[4] - sum/8
+	})
+}
+
+// Roughness returns the largest difference of elevation within the 3x3
+// window of every cell
+func (s *Surface) Roughness() *geotiff.Raster {
+	return s.apply(func(z [9]float64) float64 {
+		lo, hi := z[0], z[0]
+		for _, v := range z[1:] {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		return hi - lo
+	})
+}
+
+// Curvature returns the curvature of every cell from the quadratic surface
+// of Zevenbergen and Thorne (1987), whatever the kernel of s, in hundredths of
+// the inverse of the z units
+func (s *Surface) Curvature(kind CurvatureKind) *geotiff.Raster {
+	lx, ly := s.CellX, s.CellY
+	return s.apply(func(z [9]float64) float64 {
+		d := ((z[3]+z[5])/2 - z[4]) / (lx * lx)
+		e := ((z[1]+z[7])/2 - z[4]) / (ly * ly)
+		f := (-z[0] + z[2] + z[6] - z[8]) / (4 * lx * ly)
+		g := (z[5] - z[3]) / (2 * lx)
+		h := (z[1] - z[7]) / (2 * ly)
+		switch kind {
+		case ProfileCurvature, PlanCurvature:
+			gg := g*g + h*h
+			if gg == 0 {
+				return 0
+			}
+			if kind == ProfileCurvature {
+				return -200 * (d*g*g + e*h*h + f*g*h) / gg
+			}
+			return -200 * (d*h*h + e*g*g - f*g*h) / gg
+		}
+		return -200 * (d + e)
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package terrain
+
+import (
+	"math"
+	"testing"
+
+	"github.com/geodatalake/lambdas/geotiff"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-4
+}
+
+// plane returns a surface of 10m cells rising by rise per meter to the east
+func plane(rise float64) *Surface {
+	dem := geotiff.NewRaster(5, 5)
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 5; col++ {
+			dem.SetValue(row, col, float32(100+10*rise*float64(col)))
+		}
+	}
+	s, _ := NewSurface(dem, 10, 10)
+	return s
+}
+
+func TestSlopeAspect(t *testing.T) {
+	for _, kernel := range []Kernel{Horn, ZevenbergenThorne} {
+		s := plane(1)
+		s.Kernel = kernel
+		checks := []struct {
+			name     string
+			r        *geotiff.Raster
+			expected float64
+		}{
+			{"slope", s.Slope(Degrees), 45},
+			{"percent", s.Slope(Percent), 100},
+			{"aspect", s.Aspect(), 270},
+			{"hillshade", s.Hillshade(270, 45), 255},
+			{"shadow", s.Hillshade(90, 30), 0},
+			{"tpi", s.TPI(), 0},
+			{"roughness", s.Roughness(), 20},
+			{"tri", s.TRI(), math.Sqrt(6 * 100)},
+			{"curvature", s.Curvature(TotalCurvature), 0},
+		}
+		for _, c := range checks {
+			if v := float64(c.r.ValueAt(2, 2)); !near(v, c.expected) {
+				t.Errorf("kernel %d %s: %v, expected %v", kernel, c.name, v, c.expected)
+			}
+			if v := c.r.ValueAt(0, 2); v != NoData {
+				t.Errorf("kernel %d %s: edge %v", kernel, c.name, v)
+			}
+		}
+	}
+
+	// a flat surface faces nowhere and is lit by its altitude alone
+	s := plane(0)
+	if v := s.Aspect().ValueAt(2, 2); v != -1 {
+		t.Errorf("flat aspect %v", v)
+	}
+	if v := float64(s.MultidirectionalHillshade(30).ValueAt(2, 2)); !near(v, 127.5) {
+		t.Errorf("flat hillshade %v", v)
+	}
+}
+
+func TestDerivativeCRS(t *testing.T) {
+	s := plane(1)
+	s.DEM.CRS, _ = geotiff.LookupEPSG(26915)
+	for _, r := range []*geotiff.Raster{s.Slope(Degrees), s.Hillshade(315, 45), s.Curvature(ProfileCurvature)} {
+		if r.CRS != s.DEM.CRS {
+			t.Errorf("derivative CRS %v, expected %v", r.CRS, s.DEM.CRS)
+		}
+	}
+}
+
+func TestEdges(t *testing.T) {
+	s := plane(0.5)
+	s.DEM.SetValue(2, 3, NoData)
+	if v := s.Slope(Degrees).ValueAt(2, 2); v != NoData {
+		t.Errorf("slope next to nodata %v", v)
+	}
+	s.ComputeEdges = true
+	slope := s.Slope(Percent)
+	if v := slope.ValueAt(0, 0); v == NoData || v <= 0 {
+		t.Errorf("corner slope %v", v)
+	}
+	if v := slope.ValueAt(2, 3); v != NoData {
+		t.Errorf("nodata cell slope %v", v)
+	}
+}
+
+func TestCurvature(t *testing.T) {
+	// a bowl, z = x^2 + y^2 with 1m cells, is concave
+	dem := geotiff.NewRaster(5, 5)
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 5; col++ {
+			dem.SetValue(row, col, float32((row-2)*(row-2)+(col-2)*(col-2)))
+		}
+	}
+	s, _ := NewSurface(dem, 1, 1)
+	if v := s.Curvature(TotalCurvature).ValueAt(2, 2); v != -400 {
+		t.Errorf("total curvature %v", v)
+	}
+	// on the side of the bowl the slope eases downhill and the flow
+	// converges
+	profile := s.Curvature(ProfileCurvature).ValueAt(2, 3)
+	plan := s.Curvature(PlanCurvature).ValueAt(2, 3)
+	if profile >= 0 || plan >= 0 || profile+plan != s.Curvature(TotalCurvature).ValueAt(2, 3) {
+		t.Errorf("profile curvature %v, plan curvature %v", profile, plan)
+	}
+	if v := s.TPI().ValueAt(2, 2); v >= 0 {
+		t.Errorf("tpi of the bottom %v", v)
+	}
+}
+
+func TestCellSize(t *testing.T) {
+	b := &geotiff.Bounds{MinX: 10, MaxX: 11, MinY: 59.5, MaxY: 60.5}
+	dx, dy := CellSize(b, 100, 100, nil)
+	if math.Abs(dy-1113.2) > 1 || math.Abs(dx-dy/2) > 1 {
+		t.Errorf("cell of %v x %v meters", dx, dy)
+	}
+	utm, _ := geotiff.LookupEPSG(32632)
+	if dx, dy := CellSize(b, 4, 2, utm); dx != 0.25 || dy != 0.5 {
+		t.Errorf("projected cell of %v x %v", dx, dy)
+	}
+}
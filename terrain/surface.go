@@ -0,0 +1,142 @@
+// Copyright 2017 Blacksky. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package terrain derives slope, aspect, hillshade and other surface
+// parameters from elevation rasters built by the geotiff and lidar packages
+package terrain
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/geodatalake/lambdas/geotiff"
+)
+
+// NoData marks cells without an elevation or whose parameter is undefined
+const NoData = -9999
+
+// Kernel selects the finite differences estimating the gradient of a cell
+type Kernel int
+
+const (
+	// Horn weighs the eight neighbors, smoothing rough surfaces
+	Horn Kernel = iota
+	// ZevenbergenThorne uses the four direct neighbors, suited to smooth
+	// surfaces
+	ZevenbergenThorne
+)
+
+// Surface is an elevation raster with the ground size of its cells
+type Surface struct {
+	DEM   *geotiff.Raster
+	CellX float64 // width of a cell in meters
+	CellY float64 // height of a cell in meters
+	// ZFactor converts elevations to the units of the cell size, 1 by default
+	ZFactor float64
+	Kernel  Kernel
+	// ComputeEdges derives the cells along edges and nodata by substituting
+	// the center elevation for missing neighbors, otherwise they are NoData
+	ComputeEdges bool
+}
+
+// NewSurface returns the surface of dem with cells of cellX by cellY meters
+func NewSurface(dem *geotiff.Raster, cellX, cellY float64) (*Surface, error) {
+	if dem == nil || dem.Width() < 1 || dem.Height() < 1 {
+		return nil, geotiff.GeneralIssue("terrain: empty elevation raster")
+	}
+	if !(cellX > 0 && cellY > 0) {
+		return nil, geotiff.GeneralIssue(fmt.Sprintf("terrain: cell size %v x %v", cellX, cellY))
+	}
+	return &Surface{DEM: dem, CellX: cellX, CellY: cellY, ZFactor: 1}, nil
+}
+
+// CellSize returns the ground size in meters of the cells of a width x height
+// raster covering bounds in crs. Geographic bounds, and bounds without a crs,
+// are measured at their center latitude.
+func CellSize(bounds *geotiff.Bounds, width, height int, crs *geotiff.CRS) (float64, float64) {
+	dx := (bounds.MaxX - bounds.MinX) / float64(width)
+	dy := (bounds.MaxY - bounds.MinY) / float64(height)
+	if crs != nil && crs.IsProjected() {
+		unit := crs.LinearUnitSize
+		if unit == 0 {
+			unit = 1
+		}
+		return dx * unit, dy * unit
+	}
+	radians := math.Pi / 180 * geotiff.Wgs84SemiMajorAxis
+	lat := (bounds.MinY + bounds.MaxY) / 2 * math.Pi / 180
+	return dx * radians * math.Cos(lat), dy * radians
+}
+
+// SurfaceOf reads the elevations of t, the cell size is the ground size of
+// the image divided by its pixel counts
+func SurfaceOf(t geotiff.Tiff) (*Surface, error) {
+	dem, _, _, err := t.Points()
+	if err != nil {
+		return nil, err
+	}
+	w, h, err := t.DimensionMeters()
+	if err != nil {
+		return nil, err
+	}
+	return NewSurface(dem, w/float64(dem.Width()), h/float64(dem.Height()))
+}
+
+// window returns the 3x3 elevations around a cell, row by row from the north
+// west, scaled by the z factor. ok is false when the center is nodata, or when
+// a neighbor is missing and edges are not computed.
+func (s *Surface) window(row, col int) (z [9]float64, ok bool) {
+	w, h := s.DEM.Width(), s.DEM.Height()
+	center := s.DEM.ValueAt(row, col)
+	if center == NoData || math.IsNaN(float64(center)) {
+		return z, false
+	}
+	factor := s.ZFactor
+	if factor == 0 {
+		factor = 1
+	}
+	for i := 0; i < 9; i++ {
+		r, c := row+i/3-1, col+i%3-1
+		v := center
+		if r >= 0 && r < h && c >= 0 && c < w {
+			v = s.DEM.ValueAt(r, c)
+		}
+		if r < 0 || r >= h || c < 0 || c >= w || v == NoData || math.IsNaN(float64(v)) {
+			if !s.ComputeEdges {
+				return z, false
+			}
+			v = center
+		}
+		z[i] = float64(v) * factor
+	}
+	return z, true
+}
+
+// gradient returns the rise of the surface toward the east and toward the
+// north
+func (s *Surface) gradient(z [9]float64) (float64, float64) {
+	if s.Kernel == ZevenbergenThorne {
+		return (z[5] - z[3]) / (2 * s.CellX), (z[1] - z[7]) / (2 * s.CellY)
+	}
+	return ((z[2] + 2*z[5] + z[8]) - (z[0] + 2*z[3] + z[6])) / (8 * s.CellX),
+		((z[0] + 2*z[1] + z[2]) - (z[6] + 2*z[7] + z[8])) / (8 * s.CellY)
+}
+
+// apply computes f over the window of every cell, the output has the CRS of
+// the DEM
+func (s *Surface) apply(f func(z [9]float64) float64) *geotiff.Raster {
+	w, h := s.DEM.Width(), s.DEM.Height()
+	out := geotiff.NewRaster(w, h)
+	out.CRS = s.DEM.CRS
+	for row := 0; row < h; row++ {
+		for col := 0; col < w; col++ {
+			v := float64(NoData)
+			if z, ok := s.window(row, col); ok {
+				v = f(z)
+			}
+			out.SetValue(row, col, float32(v))
+		}
+	}
+	return out
+}